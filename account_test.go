@@ -0,0 +1,445 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-auth-microservice/pkg/config"
+	"github.com/go-auth-microservice/pkg/controller"
+	auditmodel "github.com/go-auth-microservice/pkg/model/auditModel"
+	usermodel "github.com/go-auth-microservice/pkg/model/userModel"
+	"github.com/go-auth-microservice/pkg/utils/db"
+	"github.com/go-auth-microservice/pkg/utils/mailer"
+	randomtoken "github.com/go-auth-microservice/pkg/utils/randomToken"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestUserExport tests the export of the data stored about a user
+func TestUserExport(t *testing.T) {
+	testRouter := setupTestRouter()
+	protectedRouter := setupProtectedTestRouter()
+
+	user := TestUser{Email: "export@example.com", Password: "password123"}
+	assert.Equal(t, http.StatusOK, signupTestUser(testRouter, user).Code)
+	assert.Equal(t, http.StatusUnauthorized, loginTestUser(testRouter, TestUser{Email: user.Email, Password: "wrongpassword"}).Code)
+	tokens := loginTokens(t, testRouter, user)
+	stored, err := usermodel.FindUserByEmail(user.Email)
+	assert.NoError(t, err)
+	challengeToken, code, err := stored.CreateLoginChallenge("password", false)
+	assert.NoError(t, err)
+
+	rr := protectedRequest(protectedRouter, "GET", "/api/v1/user/export", tokens.AccessToken, nil)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Header().Get("Content-Disposition"), "attachment")
+	assert.NotContains(t, rr.Body.String(), "$argon2id$", "Password hashes should not be exported")
+	assert.NotContains(t, rr.Body.String(), randomtoken.Hash(challengeToken), "Token hashes should not be exported")
+	assert.NotContains(t, rr.Body.String(), randomtoken.Hash(code), "Code hashes should not be exported")
+
+	var export struct {
+		User struct {
+			Email string `json:"email"`
+		} `json:"user"`
+		PasswordChanges []time.Time `json:"passwordChanges"`
+		LoginChallenges []struct {
+			Method string `json:"method"`
+		} `json:"loginChallenges"`
+		ReactivationRequests []interface{} `json:"reactivationRequests"`
+		SignupVerifications  []interface{} `json:"signupVerifications"`
+		Invitations          []interface{} `json:"invitations"`
+		SCIMLinks            []interface{} `json:"scimLinks"`
+		SCIMGroups           []interface{} `json:"scimGroups"`
+		AuditEvents          []struct {
+			Action string `json:"action"`
+		} `json:"auditEvents"`
+	}
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &export))
+	assert.Equal(t, user.Email, export.User.Email)
+	assert.Len(t, export.PasswordChanges, 1)
+	if assert.Len(t, export.LoginChallenges, 1) {
+		assert.Equal(t, "password", export.LoginChallenges[0].Method)
+	}
+	// everything is exported even if there is nothing stored
+	assert.NotNil(t, export.ReactivationRequests)
+	assert.NotNil(t, export.SignupVerifications)
+	assert.NotNil(t, export.Invitations)
+	assert.NotNil(t, export.SCIMLinks)
+	assert.NotNil(t, export.SCIMGroups)
+	var actions []string
+	for _, event := range export.AuditEvents {
+		actions = append(actions, event.Action)
+	}
+	// the tests run with risk based authentication
+	assert.Equal(t, []string{auditmodel.ActionLoginFailed, auditmodel.ActionLoginRiskAssessed, auditmodel.ActionLoginSucceeded, auditmodel.ActionDataExported}, actions)
+}
+
+// TestUserDeletion tests scheduling the deletion of a user and erasing it
+func TestUserDeletion(t *testing.T) {
+	testRouter := setupTestRouter()
+	protectedRouter := setupProtectedTestRouter()
+
+	user := TestUser{Email: "delete@example.com", Password: "password123"}
+	assert.Equal(t, http.StatusOK, signupTestUser(testRouter, user).Code)
+	tokens := loginTokens(t, testRouter, user)
+	stored, err := usermodel.FindUserByEmail(user.Email)
+	assert.NoError(t, err)
+	userId := stored.Id
+
+	t.Run("Current password is required", func(t *testing.T) {
+		rr := protectedRequest(protectedRouter, "DELETE", "/api/v1/user", tokens.AccessToken, map[string]string{"currentPassword": "wrongpassword"})
+		assert.Equal(t, http.StatusUnauthorized, rr.Code)
+	})
+
+	t.Run("Deletion is scheduled", func(t *testing.T) {
+		rr := protectedRequest(protectedRouter, "DELETE", "/api/v1/user", tokens.AccessToken, map[string]string{"currentPassword": user.Password})
+		assert.Equal(t, http.StatusAccepted, rr.Code)
+		var response struct {
+			DeletionScheduledAt time.Time `json:"deletionScheduledAt"`
+		}
+		assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+		assert.WithinDuration(t, time.Now().Add(30*24*time.Hour), response.DeletionScheduledAt, time.Minute)
+	})
+
+	t.Run("Tokens are revoked", func(t *testing.T) {
+		rr := protectedRequest(protectedRouter, "GET", "/api/v1/me", tokens.AccessToken, nil)
+		assert.Equal(t, http.StatusUnauthorized, rr.Code)
+
+		req, _ := http.NewRequest("GET", "/api/v1/auth/token", nil)
+		req.Header.Set("RefreshToken", tokens.RefreshToken)
+		refreshRR := httptest.NewRecorder()
+		testRouter.ServeHTTP(refreshRR, req)
+		assert.Equal(t, http.StatusForbidden, refreshRR.Code)
+		assert.Contains(t, refreshRR.Body.String(), "account_deleted")
+
+		assert.Equal(t, http.StatusForbidden, loginTestUser(testRouter, user).Code, "Login should fail during the grace period")
+	})
+
+	t.Run("User is kept during the grace period", func(t *testing.T) {
+		_, err := usermodel.PurgeDeletedUsers(time.Now())
+		assert.NoError(t, err)
+		_, err = usermodel.FindUserByID(userId)
+		assert.NoError(t, err)
+	})
+
+	t.Run("User is erased after the grace period", func(t *testing.T) {
+		purged, err := usermodel.PurgeDeletedUsers(time.Now().Add(31 * 24 * time.Hour))
+		assert.NoError(t, err)
+		assert.GreaterOrEqual(t, purged, 1)
+		_, err = usermodel.FindUserByID(userId)
+		assert.Error(t, err)
+
+		events, err := auditmodel.FindEventsByUserID(userId)
+		assert.NoError(t, err)
+		assert.Empty(t, events, "Audit events should be detached from the user")
+	})
+}
+
+// TestReactivation tests reactivating a self-deactivated user
+func TestReactivation(t *testing.T) {
+	testRouter := setupTestRouter()
+	testRouter.Post("/api/v1/auth/reactivate", controller.Reactivate)
+	testRouter.Post("/api/v1/auth/reactivate/confirm", controller.ConfirmReactivation)
+	protectedRouter := setupProtectedTestRouter()
+
+	reactivate := func(user TestUser) int {
+		return protectedRequest(testRouter, "POST", "/api/v1/auth/reactivate", "", user).Code
+	}
+
+	user := TestUser{Email: "comeback@example.com", Password: "password123"}
+	assert.Equal(t, http.StatusOK, signupTestUser(testRouter, user).Code)
+	tokens := loginTokens(t, testRouter, user)
+	rr := protectedRequest(protectedRouter, "PATCH", "/api/v1/deactivate", tokens.AccessToken, map[string]string{"currentPassword": user.Password})
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	t.Run("Login reports the deactivation", func(t *testing.T) {
+		rr := loginTestUser(testRouter, user)
+		assert.Equal(t, http.StatusForbidden, rr.Code)
+		assert.Contains(t, rr.Body.String(), "account_deactivated")
+		assert.Equal(t, http.StatusUnauthorized, loginTestUser(testRouter, TestUser{Email: user.Email, Password: "wrongpassword"}).Code, "Wrong password should not reveal the state")
+	})
+
+	t.Run("Wrong password is rejected", func(t *testing.T) {
+		assert.Equal(t, http.StatusUnauthorized, reactivate(TestUser{Email: user.Email, Password: "wrongpassword"}))
+	})
+
+	t.Run("Reactivate", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, reactivate(user))
+		loginTokens(t, testRouter, user)
+		assert.Equal(t, http.StatusBadRequest, reactivate(user), "Active user should not be reactivated")
+	})
+
+	t.Run("Reactivation cancels a scheduled deletion", func(t *testing.T) {
+		tokens := loginTokens(t, testRouter, user)
+		rr := protectedRequest(protectedRouter, "DELETE", "/api/v1/user", tokens.AccessToken, map[string]string{"currentPassword": user.Password})
+		assert.Equal(t, http.StatusAccepted, rr.Code)
+		assert.Equal(t, http.StatusOK, reactivate(user))
+		stored, err := usermodel.FindUserByEmail(user.Email)
+		assert.NoError(t, err)
+		assert.Nil(t, stored.DeletionScheduledAt)
+	})
+
+	t.Run("Admin suspension is not self-reversible", func(t *testing.T) {
+		suspended := TestUser{Email: "suspended@example.com", Password: "password123"}
+		assert.Equal(t, http.StatusOK, signupTestUser(testRouter, suspended).Code)
+		stored, err := usermodel.FindUserByEmail(suspended.Email)
+		assert.NoError(t, err)
+		assert.NoError(t, stored.Suspend("spam", nil))
+		assert.NoError(t, stored.Save())
+
+		assert.Equal(t, http.StatusForbidden, loginTestUser(testRouter, suspended).Code)
+		assert.Equal(t, http.StatusForbidden, reactivate(suspended))
+		_, err = stored.RequestReactivation()
+		assert.ErrorIs(t, err, usermodel.ErrNotSelfDeactivated)
+
+		// deleting the suspended account does not lift the suspension either
+		assert.NoError(t, stored.ScheduleDeletion(time.Hour))
+		assert.Equal(t, http.StatusForbidden, reactivate(suspended))
+		_, err = stored.RequestReactivation()
+		assert.ErrorIs(t, err, usermodel.ErrNotSelfDeactivated)
+	})
+
+	t.Run("Confirm reactivation by email", func(t *testing.T) {
+		tokens := loginTokens(t, testRouter, user)
+		rr := protectedRequest(protectedRouter, "PATCH", "/api/v1/deactivate", tokens.AccessToken, map[string]string{"currentPassword": user.Password})
+		assert.Equal(t, http.StatusOK, rr.Code)
+		stored, err := usermodel.FindUserByEmail(user.Email)
+		assert.NoError(t, err)
+		token, err := stored.RequestReactivation()
+		assert.NoError(t, err)
+
+		postToken := func(token string) int {
+			return protectedRequest(testRouter, "POST", "/api/v1/auth/reactivate/confirm", "", map[string]string{"token": token}).Code
+		}
+		assert.Equal(t, http.StatusBadRequest, postToken("invalid"))
+		assert.Equal(t, http.StatusOK, postToken(token))
+		assert.Equal(t, http.StatusBadRequest, postToken(token), "Token should be single use")
+		loginTokens(t, testRouter, user)
+	})
+}
+
+// TestAccountStatus tests suspensions by an admin and the enforcement of the account status
+func TestAccountStatus(t *testing.T) {
+	testRouter := setupTestRouter()
+	protectedRouter := setupProtectedTestRouter()
+
+	admin := TestUser{Email: "status-admin@example.com", Password: "password123"}
+	assert.Equal(t, http.StatusOK, signupTestUser(testRouter, admin).Code)
+	adminData, err := usermodel.FindUserByEmail(admin.Email)
+	assert.NoError(t, err)
+	adminData.Role = usermodel.RoleAdmin
+	assert.NoError(t, adminData.Save())
+	adminTokens := loginTokens(t, testRouter, admin)
+
+	user := TestUser{Email: "status@example.com", Password: "password123"}
+	assert.Equal(t, http.StatusOK, signupTestUser(testRouter, user).Code)
+	stored, err := usermodel.FindUserByEmail(user.Email)
+	assert.NoError(t, err)
+	assert.Equal(t, usermodel.StatusActive, stored.Status)
+	tokens := loginTokens(t, testRouter, user)
+
+	suspend := func(body interface{}) *httptest.ResponseRecorder {
+		return protectedRequest(testRouter, "POST", fmt.Sprintf("/api/v1/admin/users/%d/suspend", stored.Id), adminTokens.AccessToken, body)
+	}
+	errorCode := func(rr *httptest.ResponseRecorder) string {
+		var response map[string]interface{}
+		_ = json.Unmarshal(rr.Body.Bytes(), &response)
+		code, _ := response["error"].(string)
+		return code
+	}
+
+	t.Run("Reason is required", func(t *testing.T) {
+		assert.Equal(t, http.StatusBadRequest, suspend(map[string]string{}).Code)
+		assert.Equal(t, http.StatusBadRequest, suspend(map[string]interface{}{"reason": "spam", "until": time.Now().Add(-time.Hour)}).Code)
+	})
+
+	t.Run("Suspend", func(t *testing.T) {
+		rr := suspend(map[string]interface{}{"reason": "spam"})
+		assert.Equal(t, http.StatusOK, rr.Code)
+
+		rr = protectedRequest(protectedRouter, "GET", "/api/v1/me", tokens.AccessToken, nil)
+		assert.Equal(t, http.StatusUnauthorized, rr.Code, "Tokens should be revoked")
+
+		rr = loginTestUser(testRouter, user)
+		assert.Equal(t, http.StatusForbidden, rr.Code)
+		assert.Equal(t, "account_suspended", errorCode(rr))
+		assert.Contains(t, rr.Body.String(), "spam")
+
+		rr = protectedRequest(testRouter, "POST", "/api/v1/auth/reactivate", "", user)
+		assert.NotEqual(t, http.StatusOK, rr.Code, "Suspension should not be self-reversible")
+
+		assert.Equal(t, http.StatusConflict, suspend(map[string]interface{}{"reason": "again"}).Code, "Suspended user cannot be suspended again")
+	})
+
+	t.Run("Unsuspend", func(t *testing.T) {
+		path := fmt.Sprintf("/api/v1/admin/users/%d/unsuspend", stored.Id)
+		assert.Equal(t, http.StatusOK, protectedRequest(testRouter, "POST", path, adminTokens.AccessToken, nil).Code)
+		assert.Equal(t, http.StatusConflict, protectedRequest(testRouter, "POST", path, adminTokens.AccessToken, nil).Code)
+		tokens = loginTokens(t, testRouter, user)
+	})
+
+	t.Run("Middleware enforces the status", func(t *testing.T) {
+		stored, err := usermodel.FindUserByEmail(user.Email)
+		assert.NoError(t, err)
+		assert.NoError(t, stored.Suspend("investigation", nil))
+		assert.NoError(t, stored.Save())
+
+		rr := protectedRequest(protectedRouter, "GET", "/api/v1/me", tokens.AccessToken, nil)
+		assert.Equal(t, http.StatusForbidden, rr.Code)
+		assert.Equal(t, "account_suspended", errorCode(rr))
+
+		req, _ := http.NewRequest("GET", "/api/v1/auth/token", nil)
+		req.Header.Set("RefreshToken", tokens.RefreshToken)
+		refreshRR := httptest.NewRecorder()
+		testRouter.ServeHTTP(refreshRR, req)
+		assert.Equal(t, http.StatusForbidden, refreshRR.Code)
+		assert.Equal(t, "account_suspended", errorCode(refreshRR))
+	})
+
+	t.Run("Suspension expires", func(t *testing.T) {
+		stored, err := usermodel.FindUserByEmail(user.Email)
+		assert.NoError(t, err)
+		assert.NoError(t, stored.Unsuspend())
+		until := time.Now().Add(time.Second)
+		assert.NoError(t, stored.Suspend("cool down", &until))
+		assert.NoError(t, stored.Save())
+		assert.Equal(t, http.StatusForbidden, loginTestUser(testRouter, user).Code)
+		time.Sleep(time.Until(until))
+		loginTokens(t, testRouter, user)
+	})
+
+	t.Run("Lockout locks the account", func(t *testing.T) {
+		for i := 0; i < 3; i++ {
+			loginTestUser(testRouter, TestUser{Email: user.Email, Password: "wrongpassword"})
+		}
+		stored, err := usermodel.FindUserByEmail(user.Email)
+		assert.NoError(t, err)
+		assert.Equal(t, usermodel.StatusLocked, stored.GetAccountState().Status)
+
+		path := fmt.Sprintf("/api/v1/admin/users/%d/unlock", stored.Id)
+		assert.Equal(t, http.StatusOK, protectedRequest(testRouter, "POST", path, adminTokens.AccessToken, nil).Code)
+		stored, err = usermodel.FindUserByEmail(user.Email)
+		assert.NoError(t, err)
+		assert.Equal(t, usermodel.StatusActive, stored.GetAccountState().Status)
+	})
+
+	t.Run("Concurrent status change is not overwritten", func(t *testing.T) {
+		concurrent := TestUser{Email: "status-concurrent@example.com", Password: "password123"}
+		assert.Equal(t, http.StatusOK, signupTestUser(testRouter, concurrent).Code)
+		for i := 0; i < 3; i++ {
+			loginTestUser(testRouter, TestUser{Email: concurrent.Email, Password: "wrongpassword"})
+		}
+		stale, err := usermodel.FindUserByEmail(concurrent.Email)
+		assert.NoError(t, err)
+		assert.Equal(t, usermodel.StatusLocked, stale.Status)
+
+		suspended, err := usermodel.FindUserByEmail(concurrent.Email)
+		assert.NoError(t, err)
+		assert.NoError(t, suspended.Suspend("spam", nil))
+		assert.NoError(t, suspended.Save())
+
+		assert.ErrorIs(t, stale.Unlock(), usermodel.ErrStatusChanged)
+		stored, err := usermodel.FindUserByEmail(concurrent.Email)
+		assert.NoError(t, err)
+		assert.Equal(t, usermodel.StatusSuspended, stored.Status, "Suspension should be kept")
+		assert.Nil(t, stored.LockedUntil, "Lockout counters should be saved anyway")
+	})
+
+	t.Run("Invalid transitions are rejected", func(t *testing.T) {
+		pending := &usermodel.UserData{Status: usermodel.StatusPending}
+		assert.ErrorIs(t, pending.ChangeStatus(usermodel.StatusDeactivated, "", nil), usermodel.ErrInvalidStatusTransition)
+		assert.ErrorIs(t, pending.ChangeStatus(usermodel.StatusLocked, "", nil), usermodel.ErrInvalidStatusTransition)
+		assert.NoError(t, pending.ChangeStatus(usermodel.StatusActive, "", nil))
+
+		suspended := &usermodel.UserData{Status: usermodel.StatusSuspended}
+		assert.ErrorIs(t, suspended.Deactivate(), usermodel.ErrInvalidStatusTransition)
+	})
+
+	t.Run("Cached state is forgotten once the change is saved", func(t *testing.T) {
+		cached := TestUser{Email: "status-cached@example.com", Password: "password123"}
+		assert.Equal(t, http.StatusOK, signupTestUser(testRouter, cached).Code)
+		cachedData, err := usermodel.FindUserByEmail(cached.Email)
+		assert.NoError(t, err)
+		assert.NoError(t, cachedData.Suspend("spam", nil))
+
+		// a request reading the state before the change is saved caches the old one
+		state, err := usermodel.GetAccountStateByID(cachedData.Id)
+		assert.NoError(t, err)
+		assert.Equal(t, usermodel.StatusActive, state.Status)
+
+		assert.NoError(t, cachedData.Save())
+		state, err = usermodel.GetAccountStateByID(cachedData.Id)
+		assert.NoError(t, err)
+		assert.Equal(t, usermodel.StatusSuspended, state.Status)
+	})
+
+	t.Run("Accounts without a status are not active", func(t *testing.T) {
+		unmigrated := &usermodel.UserData{}
+		assert.Equal(t, usermodel.StatusSuspended, unmigrated.GetAccountState().Status)
+		assert.False(t, unmigrated.GetAccountState().AllowsLogin())
+		assert.False(t, unmigrated.GetAccountState().AllowsSessions())
+	})
+}
+
+// TestSignupVerification tests that users stay pending until they have verified their email
+func TestSignupVerification(t *testing.T) {
+	testRouter := setupTestRouter()
+	m := &testMailer{}
+	mailer.SetMailer(m)
+	config.GetConfig().SetSignupRequireVerification(true)
+	defer config.GetConfig().SetSignupRequireVerification(false)
+
+	user := TestUser{Email: "pending@example.com", Password: "password123"}
+	verify := func(token string) int {
+		return protectedRequest(testRouter, "POST", "/api/v1/auth/signup/verify", "", map[string]string{"token": token}).Code
+	}
+
+	t.Run("Signup is pending", func(t *testing.T) {
+		assert.Equal(t, http.StatusAccepted, signupTestUser(testRouter, user).Code)
+		stored, err := usermodel.FindUserByEmail(user.Email)
+		assert.NoError(t, err)
+		assert.Equal(t, usermodel.StatusPending, stored.Status)
+		assert.Nil(t, stored.EmailVerifiedAt)
+		assert.NotEmpty(t, mailToken(m.lastTo(user.Email)))
+
+		rr := loginTestUser(testRouter, user)
+		assert.Equal(t, http.StatusForbidden, rr.Code)
+		assert.Contains(t, rr.Body.String(), "account_pending")
+	})
+
+	t.Run("Login sends a new link", func(t *testing.T) {
+		oldToken := mailToken(m.lastTo(user.Email))
+		assert.Equal(t, http.StatusForbidden, loginTestUser(testRouter, user).Code)
+		newToken := mailToken(m.lastTo(user.Email))
+		assert.NotEqual(t, oldToken, newToken)
+		assert.Equal(t, http.StatusBadRequest, verify(oldToken), "Earlier links should expire")
+		assert.Equal(t, http.StatusUnauthorized, loginTestUser(testRouter, TestUser{Email: user.Email, Password: "wrongpassword"}).Code)
+		assert.Equal(t, newToken, mailToken(m.lastTo(user.Email)), "Wrong password should not send a link")
+	})
+
+	t.Run("Verify the email", func(t *testing.T) {
+		token := mailToken(m.lastTo(user.Email))
+		assert.Equal(t, http.StatusBadRequest, verify("invalid"))
+		assert.Equal(t, http.StatusOK, verify(token))
+		assert.Equal(t, http.StatusBadRequest, verify(token), "Token should be single use")
+
+		stored, err := usermodel.FindUserByEmail(user.Email)
+		assert.NoError(t, err)
+		assert.Equal(t, usermodel.StatusActive, stored.Status)
+		assert.NotNil(t, stored.EmailVerifiedAt)
+		loginTokens(t, testRouter, user)
+	})
+
+	t.Run("Verifications are erased with the user", func(t *testing.T) {
+		stored, err := usermodel.FindUserByEmail(user.Email)
+		assert.NoError(t, err)
+		assert.NoError(t, stored.ScheduleDeletion(0))
+		_, err = usermodel.PurgeDeletedUsers(time.Now().Add(time.Second))
+		assert.NoError(t, err)
+		var verifications int64
+		assert.NoError(t, db.GetDBConn().GetDB().Model(&usermodel.SignupVerification{}).Where("user_id = ?", stored.Id).Count(&verifications).Error)
+		assert.Zero(t, verifications)
+	})
+}
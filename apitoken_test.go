@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	usermodel "github.com/go-auth-microservice/pkg/model/userModel"
+	"github.com/stretchr/testify/assert"
+)
+
+type apiTokenResponse struct {
+	Token    string             `json:"token"`
+	APIToken usermodel.APIToken `json:"apiToken"`
+}
+
+// TestAPITokens tests personal access tokens
+func TestAPITokens(t *testing.T) {
+	testRouter := setupTestRouter()
+	protectedRouter := setupProtectedTestRouter()
+
+	user := TestUser{Email: "ci@example.com", Password: "password123"}
+	assert.Equal(t, http.StatusOK, signupTestUser(testRouter, user).Code)
+	tokens := loginTokens(t, testRouter, user)
+
+	createToken := func(t *testing.T, body map[string]interface{}) apiTokenResponse {
+		rr := protectedRequest(protectedRouter, "POST", "/api/v1/user/tokens", tokens.AccessToken, body)
+		assert.Equal(t, http.StatusCreated, rr.Code)
+		var res apiTokenResponse
+		assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &res))
+		return res
+	}
+
+	readToken := createToken(t, map[string]interface{}{"name": "ci", "scopes": []string{usermodel.ScopeUserRead}})
+
+	t.Run("Token is shown once and stored hashed", func(t *testing.T) {
+		assert.True(t, usermodel.IsAPIToken(readToken.Token))
+		assert.Equal(t, readToken.Token[:len(readToken.APIToken.Prefix)], readToken.APIToken.Prefix)
+		rr := protectedRequest(protectedRouter, "GET", "/api/v1/user/tokens", tokens.AccessToken, nil)
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.NotContains(t, rr.Body.String(), readToken.Token)
+		var list []usermodel.APIToken
+		assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &list))
+		assert.Len(t, list, 1)
+		assert.Equal(t, "ci", list[0].Name)
+	})
+
+	t.Run("Invalid requests", func(t *testing.T) {
+		for _, body := range []map[string]interface{}{
+			{"scopes": []string{usermodel.ScopeUserRead}},
+			{"name": "ci", "scopes": []string{}},
+			{"name": "ci", "scopes": []string{"everything"}},
+			{"name": "ci", "scopes": []string{usermodel.ScopeUserRead}, "expiresAt": time.Now().Add(-time.Hour)},
+		} {
+			assert.Equal(t, http.StatusBadRequest, protectedRequest(protectedRouter, "POST", "/api/v1/user/tokens", tokens.AccessToken, body).Code)
+		}
+		body := map[string]interface{}{"name": "ci", "scopes": []string{usermodel.ScopeAdmin}}
+		assert.Equal(t, http.StatusForbidden, protectedRequest(protectedRouter, "POST", "/api/v1/user/tokens", tokens.AccessToken, body).Code)
+	})
+
+	t.Run("Authenticate with the token", func(t *testing.T) {
+		rr := protectedRequest(protectedRouter, "GET", "/api/v1/user", "Bearer "+readToken.Token, nil)
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.Contains(t, rr.Body.String(), user.Email)
+		list, err := findTestUserAPITokens(user.Email)
+		assert.NoError(t, err)
+		assert.NotNil(t, list[0].LastUsedAt)
+	})
+
+	t.Run("Scopes are enforced", func(t *testing.T) {
+		rr := protectedRequest(protectedRouter, "PATCH", "/api/v1/user", "Bearer "+readToken.Token, map[string]string{"name": "CI"})
+		assert.Equal(t, http.StatusForbidden, rr.Code)
+		writeToken := createToken(t, map[string]interface{}{"name": "profile", "scopes": []string{usermodel.ScopeUserWrite}})
+		assert.Equal(t, http.StatusForbidden, protectedRequest(protectedRouter, "GET", "/api/v1/user", "Bearer "+writeToken.Token, nil).Code)
+	})
+
+	t.Run("Sensitive operations are denied", func(t *testing.T) {
+		body := map[string]string{"password": "newpassword123", "currentPassword": user.Password}
+		assert.Equal(t, http.StatusForbidden, protectedRequest(protectedRouter, "PATCH", "/api/v1/changePassword", "Bearer "+readToken.Token, body).Code)
+		assert.Equal(t, http.StatusForbidden, protectedRequest(protectedRouter, "POST", "/api/v1/reauthenticate", "Bearer "+readToken.Token, map[string]string{"password": user.Password}).Code)
+		assert.Equal(t, http.StatusForbidden, protectedRequest(protectedRouter, "GET", "/api/v1/user/tokens", "Bearer "+readToken.Token, nil).Code)
+		create := map[string]interface{}{"name": "more", "scopes": []string{usermodel.ScopeUserRead}}
+		assert.Equal(t, http.StatusForbidden, protectedRequest(protectedRouter, "POST", "/api/v1/user/tokens", "Bearer "+readToken.Token, create).Code)
+	})
+
+	t.Run("Admin scope", func(t *testing.T) {
+		admin := TestUser{Email: "ci-admin@example.com", Password: "password123"}
+		assert.Equal(t, http.StatusOK, signupTestUser(testRouter, admin).Code)
+		adminData, err := usermodel.FindUserByEmail(admin.Email)
+		assert.NoError(t, err)
+		adminData.Role = usermodel.RoleAdmin
+		assert.NoError(t, adminData.Save())
+		adminTokens := loginTokens(t, testRouter, admin)
+
+		userData, err := usermodel.FindUserByEmail(user.Email)
+		assert.NoError(t, err)
+		unlock := fmt.Sprintf("/api/v1/admin/users/%d/unlock", userData.Id)
+		_, token, err := adminData.CreateAPIToken("admin", []string{usermodel.ScopeAdmin}, nil)
+		assert.NoError(t, err)
+		_, readOnly, err := adminData.CreateAPIToken("read", []string{usermodel.ScopeUserRead}, nil)
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusForbidden, protectedRequest(testRouter, "POST", unlock, "Bearer "+readOnly, nil).Code)
+		assert.Equal(t, http.StatusOK, protectedRequest(testRouter, "POST", unlock, "Bearer "+token, nil).Code)
+		assert.Equal(t, http.StatusOK, protectedRequest(testRouter, "POST", unlock, adminTokens.AccessToken, nil).Code)
+	})
+
+	t.Run("Expired token", func(t *testing.T) {
+		userData, err := usermodel.FindUserByEmail(user.Email)
+		assert.NoError(t, err)
+		expiresAt := time.Now().Add(time.Second)
+		_, token, err := userData.CreateAPIToken("short", []string{usermodel.ScopeUserRead}, &expiresAt)
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, protectedRequest(protectedRouter, "GET", "/api/v1/user", "Bearer "+token, nil).Code)
+		time.Sleep(1500 * time.Millisecond)
+		assert.Equal(t, http.StatusUnauthorized, protectedRequest(protectedRouter, "GET", "/api/v1/user", "Bearer "+token, nil).Code)
+	})
+
+	t.Run("Revoke token", func(t *testing.T) {
+		path := fmt.Sprintf("/api/v1/user/tokens/%d", readToken.APIToken.Id)
+		assert.Equal(t, http.StatusNoContent, protectedRequest(protectedRouter, "DELETE", path, tokens.AccessToken, nil).Code)
+		assert.Equal(t, http.StatusUnauthorized, protectedRequest(protectedRouter, "GET", "/api/v1/user", "Bearer "+readToken.Token, nil).Code)
+		assert.Equal(t, http.StatusNotFound, protectedRequest(protectedRouter, "DELETE", path, tokens.AccessToken, nil).Code)
+	})
+
+	t.Run("Tokens of other users can not be revoked", func(t *testing.T) {
+		other := TestUser{Email: "ci-other@example.com", Password: "password123"}
+		assert.Equal(t, http.StatusOK, signupTestUser(testRouter, other).Code)
+		otherTokens := loginTokens(t, testRouter, other)
+		token := createToken(t, map[string]interface{}{"name": "mine", "scopes": []string{usermodel.ScopeUserRead}})
+		path := fmt.Sprintf("/api/v1/user/tokens/%d", token.APIToken.Id)
+		assert.Equal(t, http.StatusNotFound, protectedRequest(protectedRouter, "DELETE", path, otherTokens.AccessToken, nil).Code)
+		assert.Equal(t, http.StatusOK, protectedRequest(protectedRouter, "GET", "/api/v1/user", "Bearer "+token.Token, nil).Code)
+	})
+}
+
+func findTestUserAPITokens(email string) ([]usermodel.APIToken, error) {
+	user, err := usermodel.FindUserByEmail(email)
+	if err != nil {
+		return nil, err
+	}
+	return user.FindAPITokens()
+}
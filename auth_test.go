@@ -2,17 +2,29 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"log"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/go-auth-microservice/pkg/controller"
+	authMiddleware "github.com/go-auth-microservice/pkg/middleware/auth"
+	ipPolicyMiddleware "github.com/go-auth-microservice/pkg/middleware/ipPolicy"
+	tokencache "github.com/go-auth-microservice/pkg/model/tokenCache"
+	usermodel "github.com/go-auth-microservice/pkg/model/userModel"
+	jwtauth "github.com/go-auth-microservice/pkg/utils/jwtAuth"
 	"github.com/go-auth-microservice/pkg/utils/logger"
+	passwordhash "github.com/go-auth-microservice/pkg/utils/passwordHash"
 	"github.com/go-chi/chi/v5"
+	"github.com/golang-jwt/jwt/v5"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -34,8 +46,37 @@ func setupTestRouter() *chi.Mux {
 
 	// Auth routes
 	router.Post("/api/v1/auth/signup", controller.Signup)
+	router.Post("/api/v1/auth/signup/verify", controller.VerifySignup)
 	router.Post("/api/v1/auth/login", controller.Login)
+	router.Post("/api/v1/auth/login/challenge", controller.CompleteLoginChallenge)
 	router.Get("/api/v1/auth/token", controller.RefreshAccessToken)
+	router.With(authMiddleware.CSRFProtect).Post("/api/v1/auth/logout", controller.Logout)
+	router.Get("/api/v1/auth/verify", controller.VerifyForwardAuth)
+	router.Get("/api/v1/auth/federation", controller.GetFederationProviders)
+	router.Get("/api/v1/auth/federation/{provider}", controller.StartFederatedLogin)
+	router.Get("/api/v1/auth/federation/{provider}/callback", controller.FederatedLoginCallback)
+	router.Get("/api/v1/auth/saml", controller.GetSAMLTenants)
+	router.Get("/api/v1/auth/saml/{tenant}/metadata", controller.GetSAMLMetadata)
+	router.Get("/api/v1/auth/saml/{tenant}", controller.StartSAMLLogin)
+	router.Post("/api/v1/auth/saml/{tenant}/acs", controller.SAMLAssertionConsumer)
+
+	// SCIM routes authenticate the tenant by its bearer token
+	router.Route("/api/v1/scim/v2", func(r chi.Router) {
+		r.Use(authMiddleware.SCIMTenantVerify)
+		r.Get("/ServiceProviderConfig", controller.GetSCIMServiceProviderConfig)
+		r.Get("/Users", controller.GetSCIMUsers)
+		r.Post("/Users", controller.CreateSCIMUser)
+		r.Get("/Users/{id}", controller.GetSCIMUser)
+		r.Put("/Users/{id}", controller.ReplaceSCIMUser)
+		r.Patch("/Users/{id}", controller.PatchSCIMUser)
+		r.Delete("/Users/{id}", controller.DeleteSCIMUser)
+		r.Get("/Groups", controller.GetSCIMGroups)
+		r.Post("/Groups", controller.CreateSCIMGroup)
+		r.Get("/Groups/{id}", controller.GetSCIMGroup)
+		r.Put("/Groups/{id}", controller.ReplaceSCIMGroup)
+		r.Patch("/Groups/{id}", controller.PatchSCIMGroup)
+		r.Delete("/Groups/{id}", controller.DeleteSCIMGroup)
+	})
 
 	// Protected routes - these will be tested separately with proper auth
 	router.Group(func(r chi.Router) {
@@ -46,26 +87,225 @@ func setupTestRouter() *chi.Mux {
 		r.Patch("/api/v1/changePassword", controller.ChangePassword)
 	})
 
+	// Admin routes use the real auth middleware
+	router.Route("/api/v1/admin", func(r chi.Router) {
+		r.Use(authMiddleware.CSRFProtect)
+		r.Use(authMiddleware.AccessTokenVerify)
+		r.Use(ipPolicyMiddleware.RolePolicy)
+		r.Use(authMiddleware.RequireRole(usermodel.RoleAdmin))
+		r.Use(authMiddleware.RequireScope(usermodel.ScopeAdmin))
+		r.Post("/users/{id}/unlock", controller.UnlockUser)
+		r.Post("/users/{id}/suspend", controller.SuspendUser)
+		r.Post("/users/{id}/unsuspend", controller.UnsuspendUser)
+		r.Get("/users/{id}/login-history", controller.GetUserLoginHistory)
+		r.Post("/invitations", controller.CreateInvitation)
+		r.Post("/users/{id}/impersonate", controller.ImpersonateUser)
+		r.Get("/ip-rules", controller.GetIPRules)
+		r.Post("/ip-rules", controller.CreateIPRule)
+		r.Delete("/ip-rules/{id}", controller.DeleteIPRule)
+	})
+
+	return router
+}
+
+// setupProtectedTestRouter sets up the protected routes behind the real auth middleware
+func setupProtectedTestRouter() *chi.Mux {
+	router := chi.NewRouter()
+	router.Route("/api/v1", func(r chi.Router) {
+		r.Use(authMiddleware.CSRFProtect)
+		r.Use(authMiddleware.AccessTokenVerify)
+		r.Use(ipPolicyMiddleware.RolePolicy)
+		r.With(authMiddleware.RequireScope(usermodel.ScopeUserRead)).Get("/me", controller.CheckIfSessionValid)
+		r.With(authMiddleware.RequireScope(usermodel.ScopeUserRead)).Get("/user", controller.GetUserData)
+		r.With(authMiddleware.RequireScope(usermodel.ScopeUserWrite)).Patch("/user", controller.UpdateUserProfile)
+		r.With(authMiddleware.RequireScope(usermodel.ScopeUserRead)).Get("/user/export", controller.ExportUserData)
+		r.With(authMiddleware.RequireScope(usermodel.ScopeUserRead)).Get("/user/login-history", controller.GetLoginHistory)
+		r.With(authMiddleware.RequireScope(usermodel.ScopeUserRead)).Get("/user/identities", controller.GetIdentities)
+		r.Group(func(r chi.Router) {
+			r.Use(authMiddleware.DenyAPIToken)
+			r.With(authMiddleware.DenyImpersonation).Post("/reauthenticate", controller.Reauthenticate)
+			r.Group(func(r chi.Router) {
+				r.Use(authMiddleware.DenyImpersonation)
+				r.Use(authMiddleware.RequireRecentAuth(15 * time.Minute))
+				r.Patch("/deactivate", controller.DeActivateUser)
+				r.Patch("/changePassword", controller.ChangePassword)
+				r.Post("/user/email", controller.RequestEmailChange)
+				r.Delete("/user", controller.DeleteUser)
+				r.Get("/user/tokens", controller.GetAPITokens)
+				r.Post("/user/tokens", controller.CreateAPIToken)
+				r.Delete("/user/tokens/{id}", controller.RevokeAPIToken)
+				r.Post("/user/identities/{provider}", controller.LinkIdentity)
+				r.Delete("/user/identities/{id}", controller.UnlinkIdentity)
+			})
+		})
+	})
 	return router
 }
 
+// protectedRequest sends a request with the given access token to the protected router
+func protectedRequest(router http.Handler, method string, path string, token string, body interface{}) *httptest.ResponseRecorder {
+	var bodyBytes []byte
+	if body != nil {
+		bodyBytes, _ = json.Marshal(body)
+	}
+	req, _ := http.NewRequest(method, path, bytes.NewBuffer(bodyBytes))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", token)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	return rr
+}
+
 // TestMain sets up and tears down test environment
 func TestMain(m *testing.M) {
 	// Set environment variables for testing
 	if err := os.Setenv("DB_TYPE", "sqlite"); err != nil {
 		log.Print("unable to set DB variable")
 	}
+	if err := os.Setenv("LOGIN_MAX_FAILURES", "3"); err != nil {
+		log.Print("unable to set lockout variable")
+	}
+	if err := os.Setenv("COOKIE_ACCESS_TOKEN", "true"); err != nil {
+		log.Print("unable to set cookie variable")
+	}
+	// Cheap hashing parameters keep the tests fast
+	if err := os.Setenv("ARGON2_MEMORY", "8192"); err != nil {
+		log.Print("unable to set argon2 variable")
+	}
+	if err := os.Setenv("ARGON2_ITERATIONS", "1"); err != nil {
+		log.Print("unable to set argon2 variable")
+	}
+	// Hash parameters of the Firebase scrypt example project
+	if err := os.Setenv("FIREBASE_SIGNER_KEY", "jxspr8Ki0RYycVU8zykbdLGjFQ3McFUH0uiiTvC8pVMXAn210wjLNmdZJzxUECKbm0QsEmYUSDzZvpjeJ9WmXA=="); err != nil {
+		log.Print("unable to set firebase variable")
+	}
+	if err := os.Setenv("FIREBASE_SALT_SEPARATOR", "Bw=="); err != nil {
+		log.Print("unable to set firebase variable")
+	}
 
 	// Initialize logger for testing
 	logger.InitializeAppLogger()
 
+	// Local breached password corpus in the HIBP range file layout
+	breachedDir, err := os.MkdirTemp("", "breached")
+	if err != nil {
+		log.Print("unable to create breached password directory")
+	}
+	if err := os.WriteFile(filepath.Join(breachedDir, "5B907"), []byte("C57578C800CCE1B171D1287263C3A6F6478:42\r\n"), 0600); err != nil {
+		log.Print("unable to write breached password corpus")
+	}
+	if err := os.Setenv("BREACHED_PASSWORDS_DIR", breachedDir); err != nil {
+		log.Print("unable to set breached password variable")
+	}
+
+	// Risk based authentication with a GeoIP test database and reputation list
+	riskDir, err := os.MkdirTemp("", "risk")
+	if err != nil {
+		log.Print("unable to create risk directory")
+	}
+	if err := writeTestGeoIPDatabase(filepath.Join(riskDir, "city.mmdb"), testGeoIPNetworks); err != nil {
+		log.Print("unable to write GeoIP database ", err)
+	}
+	if err := os.WriteFile(filepath.Join(riskDir, "reputation.txt"), []byte("# test list\n198.51.100.0/24\n192.0.2.66 ; single address\n"), 0600); err != nil {
+		log.Print("unable to write IP reputation list")
+	}
+	for key, value := range map[string]string{
+		"RISK_BASED_AUTH":     "true",
+		"GEOIP_DATABASE":      filepath.Join(riskDir, "city.mmdb"),
+		"IP_REPUTATION_LISTS": filepath.Join(riskDir, "reputation.txt"),
+	} {
+		if err := os.Setenv(key, value); err != nil {
+			log.Print("unable to set risk variable")
+		}
+	}
+	if err := os.Setenv("TRUSTED_PROXIES", testProxyNetwork); err != nil {
+		log.Print("unable to set trusted proxy variable")
+	}
+
+	// Social login against a local mock identity provider
+	testIdP, err = newMockIdP()
+	if err != nil {
+		log.Print("unable to start mock identity provider ", err)
+	}
+	if err := testIdP.writeProviders(filepath.Join(riskDir, "providers.json")); err != nil {
+		log.Print("unable to write identity providers")
+	}
+	for key, value := range map[string]string{
+		"FEDERATION_PROVIDERS":   filepath.Join(riskDir, "providers.json"),
+		"MOCK_IDP_CLIENT_SECRET": "mock-secret",
+	} {
+		if err := os.Setenv(key, value); err != nil {
+			log.Print("unable to set federation variable")
+		}
+	}
+
+	// Directory logins against a local mock LDAP server
+	testLDAP, err = newMockLDAP()
+	if err != nil {
+		log.Print("unable to start mock LDAP server ", err)
+	}
+	for key, value := range map[string]string{
+		"CREDENTIAL_VERIFIERS": "ldap,database",
+		"LDAP_URL":             testLDAP.URL(),
+		"LDAP_BIND_DN":         testLDAPServiceDN,
+		"LDAP_BIND_PASSWORD":   testLDAPServicePassword,
+		"LDAP_BASE_DN":         testLDAPBaseDN,
+		"LDAP_GROUP_ROLES":     "admin=" + testLDAPAdminGroup,
+	} {
+		if err := os.Setenv(key, value); err != nil {
+			log.Print("unable to set ldap variable")
+		}
+	}
+
+	// SAML logins against a mock identity provider with local keys
+	if err := writeSAMLServiceProviderKeys(filepath.Join(riskDir, "sp.crt"), filepath.Join(riskDir, "sp.key")); err != nil {
+		log.Print("unable to write saml service provider keys ", err)
+	}
+	testSAMLIdP, err = newMockSAMLIdP()
+	if err != nil {
+		log.Print("unable to create mock saml identity provider ", err)
+	}
+	if err := testSAMLIdP.writeTenants(filepath.Join(riskDir, "idp-metadata.xml"), filepath.Join(riskDir, "saml.json")); err != nil {
+		log.Print("unable to write saml tenants ", err)
+	}
+	for key, value := range map[string]string{
+		"SAML_TENANTS":        filepath.Join(riskDir, "saml.json"),
+		"SAML_SP_CERTIFICATE": filepath.Join(riskDir, "sp.crt"),
+		"SAML_SP_KEY":         filepath.Join(riskDir, "sp.key"),
+	} {
+		if err := os.Setenv(key, value); err != nil {
+			log.Print("unable to set saml variable")
+		}
+	}
+
+	// SCIM provisioning by two tenants
+	if err := writeSCIMTenants(filepath.Join(riskDir, "scim.json")); err != nil {
+		log.Print("unable to write scim tenants ", err)
+	}
+	for key, value := range map[string]string{
+		"SCIM_TENANTS":      filepath.Join(riskDir, "scim.json"),
+		"ACME_SCIM_TOKEN":   testSCIMToken,
+		"GLOBEX_SCIM_TOKEN": testSCIMOtherToken,
+	} {
+		if err := os.Setenv(key, value); err != nil {
+			log.Print("unable to set scim variable")
+		}
+	}
+
 	// Clear the database before running tests
 	clearDatabase()
+	if err := usermodel.Migrate(); err != nil {
+		log.Fatalf("unable to migrate the user table %v", err)
+	}
 
 	// Run tests
 	code := m.Run()
 
 	// Cleanup
+	_ = os.RemoveAll(breachedDir)
+	_ = os.RemoveAll(riskDir)
+	testIdP.server.Close()
+	testLDAP.Close()
 	os.Exit(code)
 }
 
@@ -82,6 +322,35 @@ func clearDatabase() {
 	}
 }
 
+// signupTestUser registers a user through the signup endpoint
+func signupTestUser(router http.Handler, user TestUser) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(user)
+	req, _ := http.NewRequest("POST", "/api/v1/auth/signup", bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	return rr
+}
+
+// loginTestUser logs a user in through the login endpoint
+func loginTestUser(router http.Handler, user TestUser) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(user)
+	req, _ := http.NewRequest("POST", "/api/v1/auth/login", bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	return rr
+}
+
+// loginTokens logs a user in and returns the issued tokens
+func loginTokens(t *testing.T, router http.Handler, user TestUser) TestResponse {
+	var tokens TestResponse
+	rr := loginTestUser(router, user)
+	assert.Equal(t, http.StatusOK, rr.Code, "Login should succeed")
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &tokens))
+	return tokens
+}
+
 // TestSignup tests user registration functionality
 func TestSignup(t *testing.T) {
 	testRouter := setupTestRouter()
@@ -484,3 +753,330 @@ func TestEdgeCases(t *testing.T) {
 		})
 	}
 }
+
+// TestAccountLockout tests that repeated failed logins lock the account
+func TestAccountLockout(t *testing.T) {
+	testRouter := setupTestRouter()
+
+	user := TestUser{Email: "lockout@example.com", Password: "password123"}
+	wrongPassword := TestUser{Email: user.Email, Password: "wrongpassword"}
+	admin := TestUser{Email: "admin@example.com", Password: "password123"}
+	assert.Equal(t, http.StatusOK, signupTestUser(testRouter, user).Code)
+	assert.Equal(t, http.StatusOK, signupTestUser(testRouter, admin).Code)
+
+	adminData, err := usermodel.FindUserByEmail(admin.Email)
+	assert.NoError(t, err)
+	adminData.Role = usermodel.RoleAdmin
+	assert.NoError(t, adminData.Save())
+
+	unknownRR := loginTestUser(testRouter, TestUser{Email: "unknown@example.com", Password: "password123"})
+
+	t.Run("Account gets locked after max failures", func(t *testing.T) {
+		for i := 0; i < 3; i++ {
+			rr := loginTestUser(testRouter, wrongPassword)
+			assert.Equal(t, http.StatusUnauthorized, rr.Code)
+		}
+		rr := loginTestUser(testRouter, user)
+		assert.Equal(t, http.StatusUnauthorized, rr.Code, "Locked account should reject correct password")
+		assert.Equal(t, unknownRR.Body.String(), rr.Body.String(), "Locked account should not be distinguishable from unknown account")
+	})
+
+	t.Run("Non admin cannot unlock", func(t *testing.T) {
+		other := TestUser{Email: "lockout-other@example.com", Password: "password123"}
+		assert.Equal(t, http.StatusOK, signupTestUser(testRouter, other).Code)
+		tokens := loginTokens(t, testRouter, other)
+		assert.NotEmpty(t, tokens.AccessToken)
+		lockedUser, err := usermodel.FindUserByEmail(user.Email)
+		assert.NoError(t, err)
+		req, _ := http.NewRequest("POST", fmt.Sprintf("/api/v1/admin/users/%d/unlock", lockedUser.Id), nil)
+		req.Header.Set("Authorization", tokens.AccessToken)
+		rr := httptest.NewRecorder()
+		testRouter.ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusForbidden, rr.Code)
+		assert.Contains(t, rr.Body.String(), "insufficient permissions")
+		lockedUser, err = usermodel.FindUserByEmail(user.Email)
+		assert.NoError(t, err)
+		assert.True(t, lockedUser.IsLocked(), "Account should stay locked")
+	})
+
+	t.Run("Admin unlocks account", func(t *testing.T) {
+		var tokens TestResponse
+		assert.NoError(t, json.Unmarshal(loginTestUser(testRouter, admin).Body.Bytes(), &tokens))
+		lockedUser, err := usermodel.FindUserByEmail(user.Email)
+		assert.NoError(t, err)
+		assert.True(t, lockedUser.IsLocked())
+
+		req, _ := http.NewRequest("POST", fmt.Sprintf("/api/v1/admin/users/%d/unlock", lockedUser.Id), nil)
+		req.Header.Set("Authorization", tokens.AccessToken)
+		rr := httptest.NewRecorder()
+		testRouter.ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusOK, rr.Code)
+
+		assert.Equal(t, http.StatusOK, loginTestUser(testRouter, user).Code, "Unlocked account should accept correct password")
+	})
+
+	t.Run("Parallel failed logins are all counted", func(t *testing.T) {
+		parallel := TestUser{Email: "lockout-parallel@example.com", Password: "password123"}
+		assert.Equal(t, http.StatusOK, signupTestUser(testRouter, parallel).Code)
+		var wg sync.WaitGroup
+		for i := 0; i < 3; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				loginTestUser(testRouter, TestUser{Email: parallel.Email, Password: "wrongpassword"})
+			}()
+		}
+		wg.Wait()
+		stored, err := usermodel.FindUserByEmail(parallel.Email)
+		assert.NoError(t, err)
+		assert.True(t, stored.IsLocked(), "Parallel failures should lock the account")
+		assert.Equal(t, 1, stored.LockoutCount)
+	})
+}
+
+// TestPasswordHashingPool tests that password hashing honours request cancellation
+func TestPasswordHashingPool(t *testing.T) {
+	user := usermodel.CreateUser("hashing@example.com")
+	assert.NoError(t, user.SetPassword(context.Background(), "password123"))
+	assert.NoError(t, user.ValidatePassword(context.Background(), "password123"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := user.ValidatePassword(ctx, "password123")
+	assert.ErrorIs(t, err, usermodel.ErrHashingUnavailable, "Cancelled requests should not be hashed")
+
+	stats := usermodel.GetHashPoolStats()
+	assert.Greater(t, stats.Workers, 0)
+	assert.GreaterOrEqual(t, stats.Completed, uint64(2))
+
+	t.Run("Login answers 503 when hashing is unavailable", func(t *testing.T) {
+		testRouter := setupTestRouter()
+		loginUser := TestUser{Email: "hashing-login@example.com", Password: "password123"}
+		assert.Equal(t, http.StatusOK, signupTestUser(testRouter, loginUser).Code)
+		body, _ := json.Marshal(loginUser)
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		req, _ := http.NewRequestWithContext(ctx, "POST", "/api/v1/auth/login", bytes.NewBuffer(body))
+		req.Header.Set("Content-Type", "application/json")
+		rr := httptest.NewRecorder()
+		testRouter.ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusServiceUnavailable, rr.Code)
+		assert.Equal(t, "1", rr.Header().Get("Retry-After"))
+	})
+}
+
+// TestPasswordRehash tests that outdated password hashes are upgraded on login
+func TestPasswordRehash(t *testing.T) {
+	testRouter := setupTestRouter()
+
+	user := TestUser{Email: "rehash@example.com", Password: "password123"}
+	legacyHash, err := passwordhash.NewBcryptHasher(4).Hash(user.Password)
+	assert.NoError(t, err)
+	userData := usermodel.CreateUser(user.Email)
+	userData.Password = legacyHash
+	assert.NoError(t, userData.Save())
+	assert.True(t, passwordhash.NeedsRehash(legacyHash))
+
+	assert.Equal(t, http.StatusOK, loginTestUser(testRouter, user).Code)
+
+	upgraded, err := usermodel.FindUserByEmail(user.Email)
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(upgraded.Password, "$argon2id$v=19$m=8192,t=1,p=2$"), "Hash should be upgraded to argon2id")
+	assert.False(t, passwordhash.NeedsRehash(upgraded.Password))
+	assert.Equal(t, userData.UpdatedAt.Unix(), upgraded.UpdatedAt.Unix(), "Rehash should not invalidate sessions")
+
+	assert.Equal(t, http.StatusOK, loginTestUser(testRouter, user).Code)
+	assert.Equal(t, http.StatusUnauthorized, loginTestUser(testRouter, TestUser{Email: user.Email, Password: "wrongpassword"}).Code)
+}
+
+// TestArgon2HashBounds tests that stored argon2id hashes with unsafe parameters are rejected
+func TestArgon2HashBounds(t *testing.T) {
+	valid, err := passwordhash.Hash("password123")
+	assert.NoError(t, err)
+	parts := strings.Split(valid, "$")
+	for _, params := range []string{"m=8192,t=1,p=0", "m=8192,t=1,p=256", "m=8192,t=0,p=1", "m=8192,t=100000,p=1", "m=4294967295,t=1,p=1"} {
+		hash := strings.Join([]string{"", "argon2id", parts[2], params, parts[4], parts[5]}, "$")
+		assert.NotPanics(t, func() {
+			assert.Error(t, passwordhash.Verify(hash, "password123"), params)
+		})
+		assert.True(t, passwordhash.NeedsRehash(hash), params)
+	}
+	assert.NoError(t, passwordhash.Verify(valid, "password123"))
+}
+
+// TestPasswordPolicy tests the password policy on signup and password change
+func TestPasswordPolicy(t *testing.T) {
+	testRouter := setupTestRouter()
+	protectedRouter := setupProtectedTestRouter()
+
+	violationCodes := func(rr *httptest.ResponseRecorder) []string {
+		var response struct {
+			Violations []struct {
+				Code string `json:"code"`
+			} `json:"violations"`
+		}
+		assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response), "Response should be valid JSON")
+		codes := []string{}
+		for _, violation := range response.Violations {
+			codes = append(codes, violation.Code)
+		}
+		return codes
+	}
+
+	t.Run("Signup with breached password", func(t *testing.T) {
+		rr := signupTestUser(testRouter, TestUser{Email: "leak@example.com", Password: "breachedpassword1"})
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+		assert.Equal(t, []string{"breached"}, violationCodes(rr))
+	})
+
+	t.Run("Signup with email in password", func(t *testing.T) {
+		rr := signupTestUser(testRouter, TestUser{Email: "policy@example.com", Password: "mypolicy123"})
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+		assert.Equal(t, []string{"contains_email"}, violationCodes(rr))
+	})
+
+	t.Run("Signup with short password", func(t *testing.T) {
+		rr := signupTestUser(testRouter, TestUser{Email: "policy@example.com", Password: "short"})
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+		assert.Equal(t, []string{"too_short"}, violationCodes(rr))
+	})
+
+	user := TestUser{Email: "policy@example.com", Password: "password123"}
+	assert.Equal(t, http.StatusOK, signupTestUser(testRouter, user).Code)
+
+	changePassword := func(password string) *httptest.ResponseRecorder {
+		var tokens TestResponse
+		assert.NoError(t, json.Unmarshal(loginTestUser(testRouter, user).Body.Bytes(), &tokens))
+		body := map[string]string{"password": password, "currentPassword": user.Password}
+		return protectedRequest(protectedRouter, "PATCH", "/api/v1/changePassword", tokens.AccessToken, body)
+	}
+
+	t.Run("Change password", func(t *testing.T) {
+		rr := changePassword("newpassword456")
+		assert.Equal(t, http.StatusOK, rr.Code)
+		user.Password = "newpassword456"
+	})
+
+	t.Run("Change password to a recently used one", func(t *testing.T) {
+		rr := changePassword("password123")
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+		assert.Equal(t, []string{"recently_used"}, violationCodes(rr))
+	})
+}
+
+// TestTokenExpiry tests that refresh tokens outlive the access tokens issued with them
+func TestTokenExpiry(t *testing.T) {
+	testRouter := setupTestRouter()
+	protectedRouter := setupProtectedTestRouter()
+
+	user := TestUser{Email: "expiry@example.com", Password: "password123"}
+	assert.Equal(t, http.StatusOK, signupTestUser(testRouter, user).Code)
+	assertExpiry := func(t *testing.T, tokens TestResponse) {
+		access, err := jwtauth.GetAccessTokenHandler().VerifyToken(tokens.AccessToken)
+		assert.NoError(t, err)
+		refresh, err := jwtauth.GetRefreshTokenHandler().VerifyToken(tokens.RefreshToken)
+		assert.NoError(t, err)
+		assert.Greater(t, refresh["exp"], access["exp"], "Refresh token should expire after the access token")
+	}
+
+	tokens := loginTokens(t, testRouter, user)
+	t.Run("Login", func(t *testing.T) {
+		assertExpiry(t, tokens)
+	})
+
+	t.Run("Reauthenticate", func(t *testing.T) {
+		rr := protectedRequest(protectedRouter, "POST", "/api/v1/reauthenticate", tokens.AccessToken, map[string]string{"password": user.Password})
+		assert.Equal(t, http.StatusOK, rr.Code)
+		var freshTokens TestResponse
+		assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &freshTokens))
+		assertExpiry(t, freshTokens)
+	})
+}
+
+// TestRevocationRightAfterIssuing tests that a revocation applies to tokens
+// issued in the same second, but not to the ones issued after it
+func TestRevocationRightAfterIssuing(t *testing.T) {
+	testRouter := setupTestRouter()
+	protectedRouter := setupProtectedTestRouter()
+
+	user := TestUser{Email: "revoked-now@example.com", Password: "password123"}
+	assert.Equal(t, http.StatusOK, signupTestUser(testRouter, user).Code)
+	stored, err := usermodel.FindUserByEmail(user.Email)
+	assert.NoError(t, err)
+
+	tokens := loginTokens(t, testRouter, user)
+	claims, err := jwtauth.GetAccessTokenHandler().VerifyToken(tokens.AccessToken)
+	assert.NoError(t, err)
+	issuedAt := jwtauth.IssuedAt(claims)
+	assert.WithinDuration(t, time.Now(), issuedAt, time.Second)
+
+	var revokedUsers tokencache.RevokedUsers = tokencache.GetRevokedUserTokens()
+	revokedUsers.Revoke(stored.Id, time.Now())
+	assert.Equal(t, http.StatusUnauthorized, protectedRequest(protectedRouter, "GET", "/api/v1/me", tokens.AccessToken, nil).Code)
+
+	tokens = loginTokens(t, testRouter, user)
+	assert.Equal(t, http.StatusOK, protectedRequest(protectedRouter, "GET", "/api/v1/me", tokens.AccessToken, nil).Code)
+}
+
+// TestSensitiveChanges tests that sensitive changes require the current password and a recent login
+func TestSensitiveChanges(t *testing.T) {
+	testRouter := setupTestRouter()
+	protectedRouter := setupProtectedTestRouter()
+
+	user := TestUser{Email: "sensitive@example.com", Password: "password123"}
+	assert.Equal(t, http.StatusOK, signupTestUser(testRouter, user).Code)
+	var tokens TestResponse
+	assert.NoError(t, json.Unmarshal(loginTestUser(testRouter, user).Body.Bytes(), &tokens))
+
+	t.Run("Change password without current password", func(t *testing.T) {
+		rr := protectedRequest(protectedRouter, "PATCH", "/api/v1/changePassword", tokens.AccessToken, map[string]string{"password": "newpassword456"})
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+	})
+
+	t.Run("Change password with wrong current password", func(t *testing.T) {
+		body := map[string]string{"password": "newpassword456", "currentPassword": "wrongpassword"}
+		rr := protectedRequest(protectedRouter, "PATCH", "/api/v1/changePassword", tokens.AccessToken, body)
+		assert.Equal(t, http.StatusUnauthorized, rr.Code)
+	})
+
+	t.Run("Change password revokes every session", func(t *testing.T) {
+		other := loginTokens(t, testRouter, user)
+		body := map[string]string{"password": "newpassword456", "currentPassword": user.Password}
+		rr := protectedRequest(protectedRouter, "PATCH", "/api/v1/changePassword", tokens.AccessToken, body)
+		assert.Equal(t, http.StatusOK, rr.Code)
+		user.Password = "newpassword456"
+		for _, session := range []TestResponse{tokens, other} {
+			assert.Equal(t, http.StatusUnauthorized, protectedRequest(protectedRouter, "GET", "/api/v1/me", session.AccessToken, nil).Code)
+			req, _ := http.NewRequest("GET", "/api/v1/auth/token", nil)
+			req.Header.Set("RefreshToken", session.RefreshToken)
+			refresh := httptest.NewRecorder()
+			testRouter.ServeHTTP(refresh, req)
+			assert.Equal(t, http.StatusUnauthorized, refresh.Code)
+		}
+	})
+
+	userData, err := usermodel.FindUserByEmail(user.Email)
+	assert.NoError(t, err)
+	staleClaims := jwt.MapClaims{}
+	staleClaims["userId"] = userData.Id
+	staleClaims["role"] = userData.Role
+	staleClaims["auth_time"] = time.Now().Add(-time.Hour).Unix()
+	staleToken, err := jwtauth.GetAccessTokenHandler().CreateToken(staleClaims)
+	assert.NoError(t, err)
+
+	t.Run("Deactivate with stale authentication", func(t *testing.T) {
+		rr := protectedRequest(protectedRouter, "PATCH", "/api/v1/deactivate", staleToken, map[string]string{"currentPassword": user.Password})
+		assert.Equal(t, http.StatusUnauthorized, rr.Code)
+		assert.Contains(t, rr.Body.String(), "recent authentication required")
+	})
+
+	t.Run("Deactivate after reauthentication", func(t *testing.T) {
+		rr := protectedRequest(protectedRouter, "POST", "/api/v1/reauthenticate", staleToken, map[string]string{"password": user.Password})
+		assert.Equal(t, http.StatusOK, rr.Code)
+		var freshTokens TestResponse
+		assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &freshTokens))
+
+		rr = protectedRequest(protectedRouter, "PATCH", "/api/v1/deactivate", freshTokens.AccessToken, map[string]string{"currentPassword": user.Password})
+		assert.Equal(t, http.StatusOK, rr.Code)
+	})
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	usermodel "github.com/go-auth-microservice/pkg/model/userModel"
+	"github.com/joho/godotenv"
+)
+
+// importUsers reads users with their password hashes exported from another
+// system and creates them in the database.
+//
+//	go run ./cmd/importUsers -file users.csv
+//	go run ./cmd/importUsers -file firebase-export.json
+func main() {
+	file := flag.String("file", "", "CSV or JSON file with the users to import")
+	format := flag.String("format", "", "file format, csv or json (defaults to the file extension)")
+	flag.Parse()
+
+	if strings.ToUpper(os.Getenv("APP_ENV")) != "PRODUCTION" {
+		if err := godotenv.Load(); err != nil {
+			log.Print("⚠️ No .env file found, using system environment variables")
+		}
+	}
+	if *file == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if *format == "" {
+		*format = strings.TrimPrefix(strings.ToLower(filepath.Ext(*file)), ".")
+	}
+
+	input, err := os.Open(*file)
+	if err != nil {
+		log.Fatalf("unable to open %s: %v", *file, err)
+	}
+	records, err := usermodel.ReadImportRecords(input, *format)
+	_ = input.Close()
+	if err != nil {
+		log.Fatalf("unable to read %s: %v", *file, err)
+	}
+
+	result := usermodel.ImportUsers(records)
+	for _, row := range slices.Sorted(maps.Keys(result.Failed)) {
+		if row == 0 {
+			fmt.Printf("failed: %v\n", result.Failed[row])
+			continue
+		}
+		fmt.Printf("failed record %d %s: %v\n", row, records[row-1].Email, result.Failed[row])
+	}
+	fmt.Printf("imported %d, skipped %d existing, failed %d\n", result.Imported, result.Skipped, len(result.Failed))
+	if len(result.Failed) > 0 {
+		os.Exit(1)
+	}
+}
@@ -2,11 +2,15 @@ package main
 
 import (
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"strings"
 	"time"
 
+	"github.com/go-auth-microservice/pkg/config"
+	extauthz "github.com/go-auth-microservice/pkg/extAuthz"
+	usermodel "github.com/go-auth-microservice/pkg/model/userModel"
 	router "github.com/go-auth-microservice/pkg/routes"
 	"github.com/go-auth-microservice/pkg/utils/db"
 	"github.com/go-auth-microservice/pkg/utils/logger"
@@ -27,6 +31,14 @@ func main() {
 	}
 	log := logger.InitializeAppLogger()
 	_ = db.GetDBConn()
+	if err := usermodel.Migrate(); err != nil {
+		log.Fatalf("unable to migrate the user table %v", err)
+	}
+	go purgeDeletedUsers()
+	go purgeLoginHistory()
+	if addr := config.GetConfig().GetExtAuthzAddr(); addr != "" {
+		go serveExtAuthz(addr)
+	}
 	httpServer := &http.Server{
 		Addr:              ":" + port,
 		Handler:           router,
@@ -40,3 +52,54 @@ func main() {
 		log.Fatalf("unable to start server on port %d ", port, err)
 	}
 }
+
+// serveExtAuthz answers the ext_authz checks of Envoy on addr.
+func serveExtAuthz(addr string) {
+	log := logger.InitializeAppLogger()
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		log.Fatalf("unable to listen for ext_authz on %s %v", addr, err)
+	}
+	log.Info("Starting ext_authz gRPC Server on ", addr)
+	if err := extauthz.NewServer().Serve(listener); err != nil {
+		log.Fatalf("unable to serve ext_authz on %s %v", addr, err)
+	}
+}
+
+// purgeDeletedUsers erases the users whose deletion grace period is over,
+// once at startup and then every hour.
+func purgeDeletedUsers() {
+	log := logger.InitializeAppLogger()
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+	for {
+		purged, err := usermodel.PurgeDeletedUsers(time.Now())
+		if err != nil {
+			log.Error("unable to purge deleted users ", err)
+		} else if purged > 0 {
+			log.Infof("%d deleted users have been erased", purged)
+		}
+		<-ticker.C
+	}
+}
+
+// purgeLoginHistory deletes the login events older than
+// LOGIN_HISTORY_RETENTION days, once at startup and then every hour.
+func purgeLoginHistory() {
+	log := logger.InitializeAppLogger()
+	days := config.GetConfig().GetLoginHistoryRetention()
+	if days == 0 {
+		return
+	}
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+	for {
+		purged, err := usermodel.PurgeLoginEvents(time.Now().AddDate(0, 0, -days))
+		if err != nil {
+			log.Error("unable to purge the login history ", err)
+		} else if purged > 0 {
+			log.Infof("%d login events have been deleted", purged)
+		}
+		<-ticker.C
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"net/http"
+	"regexp"
+	"sync"
+	"testing"
+
+	"github.com/go-auth-microservice/pkg/controller"
+	usermodel "github.com/go-auth-microservice/pkg/model/userModel"
+	"github.com/go-auth-microservice/pkg/utils/db"
+	"github.com/go-auth-microservice/pkg/utils/mailer"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+// testMail is an email captured by testMailer
+type testMail struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// testMailer records the emails instead of sending them
+type testMailer struct {
+	mu    sync.Mutex
+	mails []testMail
+}
+
+func (m *testMailer) Send(to string, subject string, body string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.mails = append(m.mails, testMail{To: to, Subject: subject, Body: body})
+	return nil
+}
+
+// lastTo returns the last email sent to the address
+func (m *testMailer) lastTo(to string) testMail {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := len(m.mails) - 1; i >= 0; i-- {
+		if m.mails[i].To == to {
+			return m.mails[i]
+		}
+	}
+	return testMail{}
+}
+
+var mailTokenPattern = regexp.MustCompile(`token=([A-Za-z0-9_-]+)`)
+
+// mailToken extracts the token of the link in an email
+func mailToken(mail testMail) string {
+	match := mailTokenPattern.FindStringSubmatch(mail.Body)
+	if match == nil {
+		return ""
+	}
+	return match[1]
+}
+
+// TestEmailChange tests changing the email with confirmation of both addresses
+func TestEmailChange(t *testing.T) {
+	testRouter := setupTestRouter()
+	testRouter.Post("/api/v1/auth/email/confirm", controller.ConfirmEmailChange)
+	testRouter.Post("/api/v1/auth/email/cancel", controller.CancelEmailChange)
+	protectedRouter := setupProtectedTestRouter()
+	m := &testMailer{}
+	mailer.SetMailer(m)
+
+	user := TestUser{Email: "old@example.com", Password: "password123"}
+	assert.Equal(t, http.StatusOK, signupTestUser(testRouter, user).Code)
+	assert.Equal(t, http.StatusOK, signupTestUser(testRouter, TestUser{Email: "taken@example.com", Password: "password123"}).Code)
+	tokens := loginTokens(t, testRouter, user)
+
+	requestChange := func(newEmail string, currentPassword string) int {
+		body := map[string]string{"newEmail": newEmail, "currentPassword": currentPassword}
+		return protectedRequest(protectedRouter, "POST", "/api/v1/user/email", tokens.AccessToken, body).Code
+	}
+	postToken := func(path string, token string) int {
+		return protectedRequest(testRouter, "POST", path, "", map[string]string{"token": token}).Code
+	}
+
+	t.Run("Wrong current password", func(t *testing.T) {
+		assert.Equal(t, http.StatusUnauthorized, requestChange("new@example.com", "wrongpassword"))
+	})
+
+	t.Run("Email already in use", func(t *testing.T) {
+		assert.Equal(t, http.StatusConflict, requestChange("taken@example.com", user.Password))
+	})
+
+	t.Run("Taken email is reported as a duplicate key", func(t *testing.T) {
+		// ConfirmEmailChange relies on it to tell a concurrent signup from other errors
+		stored, err := usermodel.FindUserByEmail(user.Email)
+		assert.NoError(t, err)
+		stored.Email = "taken@example.com"
+		assert.ErrorIs(t, db.GetDBConn().GetDB().Save(stored).Error, gorm.ErrDuplicatedKey)
+	})
+
+	t.Run("Cancel from the old address", func(t *testing.T) {
+		assert.Equal(t, http.StatusAccepted, requestChange("new@example.com", user.Password))
+		confirmToken := mailToken(m.lastTo("new@example.com"))
+		cancelToken := mailToken(m.lastTo("old@example.com"))
+		assert.NotEmpty(t, confirmToken)
+		assert.NotEmpty(t, cancelToken)
+
+		assert.Equal(t, http.StatusOK, postToken("/api/v1/auth/email/cancel", cancelToken))
+		assert.Equal(t, http.StatusBadRequest, postToken("/api/v1/auth/email/confirm", confirmToken), "Cancelled change should not be confirmable")
+	})
+
+	t.Run("Confirm from the new address", func(t *testing.T) {
+		assert.Equal(t, http.StatusAccepted, requestChange("new@example.com", user.Password))
+		confirmToken := mailToken(m.lastTo("new@example.com"))
+		assert.Equal(t, http.StatusOK, postToken("/api/v1/auth/email/confirm", confirmToken))
+		assert.Equal(t, http.StatusBadRequest, postToken("/api/v1/auth/email/confirm", confirmToken), "Token should be single use")
+
+		assert.Equal(t, http.StatusUnauthorized, protectedRequest(protectedRouter, "GET", "/api/v1/me", tokens.AccessToken, nil).Code, "Old tokens should be revoked")
+		assert.Equal(t, http.StatusUnauthorized, loginTestUser(testRouter, user).Code)
+		assert.Equal(t, http.StatusOK, loginTestUser(testRouter, TestUser{Email: "new@example.com", Password: user.Password}).Code)
+	})
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	typev3 "github.com/envoyproxy/go-control-plane/envoy/type/v3"
+	extauthz "github.com/go-auth-microservice/pkg/extAuthz"
+	authMiddleware "github.com/go-auth-microservice/pkg/middleware/auth"
+	usermodel "github.com/go-auth-microservice/pkg/model/userModel"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// startExtAuthz serves the ext_authz server in process and returns a client of it
+func startExtAuthz(t *testing.T) authv3.AuthorizationClient {
+	listener := bufconn.Listen(1024 * 1024)
+	server := extauthz.NewServer()
+	go func() {
+		_ = server.Serve(listener)
+	}()
+	t.Cleanup(server.Stop)
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		_ = conn.Close()
+	})
+	return authv3.NewAuthorizationClient(conn)
+}
+
+// extAuthzCheck asks the ext_authz server about a request with the given headers
+func extAuthzCheck(t *testing.T, client authv3.AuthorizationClient, headers map[string]string) *authv3.CheckResponse {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	res, err := client.Check(ctx, &authv3.CheckRequest{
+		Attributes: &authv3.AttributeContext{
+			Source: &authv3.AttributeContext_Peer{
+				Address: &corev3.Address{Address: &corev3.Address_SocketAddress{
+					SocketAddress: &corev3.SocketAddress{Address: "203.0.113.7", PortSpecifier: &corev3.SocketAddress_PortValue{PortValue: 44321}},
+				}},
+			},
+			Request: &authv3.AttributeContext_Request{
+				Http: &authv3.AttributeContext_HttpRequest{Method: "GET", Host: "app.example.com", Path: "/orders", Headers: headers},
+			},
+		},
+	})
+	assert.NoError(t, err)
+	return res
+}
+
+// okHeaders returns the headers an allowed check adds to the request
+func okHeaders(res *authv3.CheckResponse) map[string]string {
+	headers := map[string]string{}
+	for _, option := range res.GetOkResponse().GetHeaders() {
+		headers[option.GetHeader().GetKey()] = option.GetHeader().GetValue()
+	}
+	return headers
+}
+
+// TestExtAuthz tests the Envoy external authorization gRPC server
+func TestExtAuthz(t *testing.T) {
+	testRouter := setupTestRouter()
+	client := startExtAuthz(t)
+
+	admin := TestUser{Email: "extauthz-admin@example.com", Password: "password123"}
+	assert.Equal(t, http.StatusOK, signupTestUser(testRouter, admin).Code)
+	adminData, err := usermodel.FindUserByEmail(admin.Email)
+	assert.NoError(t, err)
+	adminData.Role = usermodel.RoleAdmin
+	assert.NoError(t, adminData.Save())
+	adminTokens := loginTokens(t, testRouter, admin)
+
+	user := TestUser{Email: "extauthz@example.com", Password: "password123"}
+	assert.Equal(t, http.StatusOK, signupTestUser(testRouter, user).Code)
+	stored, err := usermodel.FindUserByEmail(user.Email)
+	assert.NoError(t, err)
+	tokens := loginTokens(t, testRouter, user)
+
+	assertDenied := func(t *testing.T, res *authv3.CheckResponse) {
+		assert.Equal(t, int32(codes.Unauthenticated), res.GetStatus().GetCode())
+		if assert.NotNil(t, res.GetDeniedResponse()) {
+			assert.Equal(t, typev3.StatusCode_Unauthorized, res.GetDeniedResponse().GetStatus().GetCode())
+			assert.NotEmpty(t, res.GetDeniedResponse().GetBody())
+		}
+		assert.Nil(t, res.GetOkResponse())
+	}
+
+	t.Run("Missing or invalid token is denied", func(t *testing.T) {
+		assertDenied(t, extAuthzCheck(t, client, map[string]string{}))
+		assertDenied(t, extAuthzCheck(t, client, map[string]string{"authorization": "Bearer invalid"}))
+	})
+
+	t.Run("Valid token is allowed with identity headers", func(t *testing.T) {
+		res := extAuthzCheck(t, client, map[string]string{"authorization": tokens.AccessToken, "x-user-id": "1"})
+		assert.Equal(t, int32(codes.OK), res.GetStatus().GetCode())
+		headers := okHeaders(res)
+		assert.Equal(t, strconv.FormatUint(stored.Id, 10), headers["x-user-id"])
+		assert.Equal(t, user.Email, headers["x-user-email"])
+		assert.Equal(t, usermodel.RoleUser, headers["x-user-roles"])
+		for _, option := range res.GetOkResponse().GetHeaders() {
+			assert.Equal(t, corev3.HeaderValueOption_OVERWRITE_IF_EXISTS_OR_ADD, option.GetAppendAction())
+		}
+	})
+
+	t.Run("Session cookie is allowed", func(t *testing.T) {
+		cookie := "session=other; " + authMiddleware.AccessTokenCookie + "=" + tokens.AccessToken[len("Bearer "):]
+		res := extAuthzCheck(t, client, map[string]string{"cookie": cookie})
+		assert.Equal(t, int32(codes.OK), res.GetStatus().GetCode())
+		assert.Equal(t, user.Email, okHeaders(res)["x-user-email"])
+	})
+
+	t.Run("Concurrent checks", func(t *testing.T) {
+		sessions := make([]TestResponse, 8)
+		for i := range sessions {
+			sessions[i] = loginTokens(t, testRouter, user)
+		}
+		var wg sync.WaitGroup
+		for i, session := range sessions {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for range 5 {
+					res := extAuthzCheck(t, client, map[string]string{"authorization": session.AccessToken})
+					assert.Equal(t, int32(codes.OK), res.GetStatus().GetCode())
+				}
+				if i%2 == 0 {
+					req, _ := http.NewRequest("POST", "/api/v1/auth/logout", nil)
+					req.Header.Set("Authorization", session.AccessToken)
+					testRouter.ServeHTTP(httptest.NewRecorder(), req)
+					assertDenied(t, extAuthzCheck(t, client, map[string]string{"authorization": session.AccessToken}))
+				}
+			}()
+		}
+		wg.Wait()
+	})
+
+	t.Run("Suspended user is denied", func(t *testing.T) {
+		path := fmt.Sprintf("/api/v1/admin/users/%d/suspend", stored.Id)
+		assert.Equal(t, http.StatusOK, protectedRequest(testRouter, "POST", path, adminTokens.AccessToken, map[string]string{"reason": "spam"}).Code)
+		assertDenied(t, extAuthzCheck(t, client, map[string]string{"authorization": tokens.AccessToken}))
+	})
+}
@@ -0,0 +1,361 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	authMiddleware "github.com/go-auth-microservice/pkg/middleware/auth"
+	auditmodel "github.com/go-auth-microservice/pkg/model/auditModel"
+	usermodel "github.com/go-auth-microservice/pkg/model/userModel"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+)
+
+const testIdPClientID = "test-client"
+
+// testIdP is the mock identity provider of the tests, started by TestMain
+var testIdP *mockIdP
+
+// mockIdentity is a user of the mock identity provider
+type mockIdentity struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+	// Nonce replaces the nonce of the login in the ID token if set
+	Nonce string
+}
+
+type mockAuthorization struct {
+	identity  mockIdentity
+	nonce     string
+	challenge string
+}
+
+// mockIdP is an OpenID Connect provider with discovery, JWKS and a token
+// endpoint which checks the PKCE verifier. Users are signed in by authorize.
+type mockIdP struct {
+	server *httptest.Server
+	key    *rsa.PrivateKey
+	lock   sync.Mutex
+	codes  map[string]mockAuthorization
+}
+
+func newMockIdP() (*mockIdP, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+	idp := &mockIdP{key: key, codes: map[string]mockAuthorization{}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", idp.discovery)
+	mux.HandleFunc("/jwks", idp.jwks)
+	mux.HandleFunc("/token", idp.token)
+	idp.server = httptest.NewServer(mux)
+	return idp, nil
+}
+
+// writeProviders writes a FEDERATION_PROVIDERS file with the mock provider,
+// its secret comes from the environment
+func (idp *mockIdP) writeProviders(path string) error {
+	providers := fmt.Sprintf(`[{"name": "mock", "type": "oidc", "issuer": %q, "clientId": %q, "clientSecret": "${MOCK_IDP_CLIENT_SECRET}"}]`,
+		idp.server.URL, testIdPClientID)
+	return os.WriteFile(path, []byte(providers), 0600)
+}
+
+func (idp *mockIdP) writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func (idp *mockIdP) discovery(w http.ResponseWriter, r *http.Request) {
+	idp.writeJSON(w, map[string]interface{}{
+		"issuer":                                idp.server.URL,
+		"authorization_endpoint":                idp.server.URL + "/authorize",
+		"token_endpoint":                        idp.server.URL + "/token",
+		"jwks_uri":                              idp.server.URL + "/jwks",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+	})
+}
+
+func (idp *mockIdP) jwks(w http.ResponseWriter, r *http.Request) {
+	idp.writeJSON(w, map[string]interface{}{"keys": []map[string]string{{
+		"kty": "RSA",
+		"kid": "test",
+		"use": "sig",
+		"alg": "RS256",
+		"n":   base64.RawURLEncoding.EncodeToString(idp.key.N.Bytes()),
+		"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(idp.key.E)).Bytes()),
+	}}})
+}
+
+func (idp *mockIdP) token(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+	clientId, secret, ok := r.BasicAuth()
+	if !ok {
+		clientId, secret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	}
+	if clientId != testIdPClientID || secret != os.Getenv("MOCK_IDP_CLIENT_SECRET") {
+		http.Error(w, `{"error":"invalid_client"}`, http.StatusUnauthorized)
+		return
+	}
+	idp.lock.Lock()
+	authorization, ok := idp.codes[r.PostForm.Get("code")]
+	delete(idp.codes, r.PostForm.Get("code"))
+	idp.lock.Unlock()
+	verifier := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if !ok || base64.RawURLEncoding.EncodeToString(verifier[:]) != authorization.challenge {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"error":"invalid_grant"}`))
+		return
+	}
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"iss":            idp.server.URL,
+		"sub":            authorization.identity.Subject,
+		"aud":            testIdPClientID,
+		"iat":            now.Unix(),
+		"exp":            now.Add(time.Minute).Unix(),
+		"nonce":          authorization.nonce,
+		"email":          authorization.identity.Email,
+		"email_verified": authorization.identity.EmailVerified,
+		"name":           authorization.identity.Name,
+	}
+	idToken := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	idToken.Header["kid"] = "test"
+	signed, err := idToken.SignedString(idp.key)
+	if err != nil {
+		http.Error(w, "unable to sign id token", http.StatusInternalServerError)
+		return
+	}
+	idp.writeJSON(w, map[string]interface{}{
+		"access_token": "mock-access-token",
+		"token_type":   "Bearer",
+		"expires_in":   60,
+		"id_token":     signed,
+	})
+}
+
+// authorize signs the identity in at the authorization URL and returns the
+// query of the redirect back to the callback
+func (idp *mockIdP) authorize(t *testing.T, authURL string, identity mockIdentity) string {
+	parsed, err := url.Parse(authURL)
+	assert.NoError(t, err)
+	assert.Equal(t, idp.server.URL+"/authorize", parsed.Scheme+"://"+parsed.Host+parsed.Path)
+	query := parsed.Query()
+	assert.Equal(t, testIdPClientID, query.Get("client_id"))
+	assert.Equal(t, "S256", query.Get("code_challenge_method"))
+	assert.NotEmpty(t, query.Get("nonce"))
+	nonce := query.Get("nonce")
+	if identity.Nonce != "" {
+		nonce = identity.Nonce
+	}
+	code := fmt.Sprintf("code-%d", time.Now().UnixNano())
+	idp.lock.Lock()
+	idp.codes[code] = mockAuthorization{identity: identity, nonce: nonce, challenge: query.Get("code_challenge")}
+	idp.lock.Unlock()
+	return url.Values{"code": {code}, "state": {query.Get("state")}}.Encode()
+}
+
+// startSocialLogin starts a social login and returns the authorization URL
+// and the state cookie
+func startSocialLogin(t *testing.T, router http.Handler, query string) (string, *http.Cookie) {
+	req := httptest.NewRequest("GET", "/api/v1/auth/federation/mock"+query, nil)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusFound, rr.Code)
+	return rr.Header().Get("Location"), findCookie(rr.Result().Cookies(), authMiddleware.FederationStateCookie)
+}
+
+// socialCallback sends the redirect of the identity provider with the state cookie
+func socialCallback(router http.Handler, callbackQuery string, stateCookie *http.Cookie) *httptest.ResponseRecorder {
+	var cookies []*http.Cookie
+	if stateCookie != nil {
+		cookies = append(cookies, stateCookie)
+	}
+	return cookieRequest(router, "GET", "/api/v1/auth/federation/mock/callback?"+callbackQuery, cookies, "", nil)
+}
+
+// socialLogin logs the identity in through the mock identity provider
+func socialLogin(t *testing.T, router http.Handler, identity mockIdentity) *httptest.ResponseRecorder {
+	authURL, stateCookie := startSocialLogin(t, router, "")
+	return socialCallback(router, testIdP.authorize(t, authURL, identity), stateCookie)
+}
+
+// TestSocialLogin tests logging in through an upstream OpenID Connect provider
+func TestSocialLogin(t *testing.T) {
+	testRouter := setupTestRouter()
+	protectedRouter := setupProtectedTestRouter()
+	alice := mockIdentity{Subject: "alice-1", Email: "alice.social@example.com", EmailVerified: true, Name: "Alice Social"}
+
+	t.Run("List providers", func(t *testing.T) {
+		rr := protectedRequest(testRouter, "GET", "/api/v1/auth/federation", "", nil)
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.JSONEq(t, `{"providers": ["mock"]}`, rr.Body.String())
+		assert.Equal(t, http.StatusNotFound, protectedRequest(testRouter, "GET", "/api/v1/auth/federation/unknown", "", nil).Code)
+	})
+
+	var aliceTokens TestResponse
+	t.Run("First login creates the user", func(t *testing.T) {
+		rr := socialLogin(t, testRouter, alice)
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &aliceTokens))
+		assert.NotEmpty(t, aliceTokens.AccessToken)
+
+		user, err := usermodel.FindUserByEmail(alice.Email)
+		assert.NoError(t, err)
+		assert.NotNil(t, user.EmailVerifiedAt)
+		assert.Equal(t, "Alice Social", user.DisplayName)
+		assert.False(t, user.HasPassword())
+
+		history, err := user.FindLoginHistory(1, 1)
+		assert.NoError(t, err)
+		assert.Equal(t, "federated:mock", history.Events[0].Method)
+		events, err := auditmodel.FindEventsByUserID(user.Id)
+		assert.NoError(t, err)
+		assert.Equal(t, auditmodel.ActionIdentityLinked, events[0].Action)
+		assert.Equal(t, true, events[0].Details["createdUser"])
+
+		assert.Equal(t, http.StatusUnauthorized, loginTestUser(testRouter, TestUser{Email: alice.Email, Password: "password123"}).Code,
+			"a user created by a social login has no password")
+	})
+
+	t.Run("Next login finds the identity", func(t *testing.T) {
+		renamed := alice
+		renamed.Email = "alice.renamed@example.com"
+		assert.Equal(t, http.StatusOK, socialLogin(t, testRouter, renamed).Code)
+		_, err := usermodel.FindUserByEmail(renamed.Email)
+		assert.Error(t, err, "a changed email at the provider does not create another user")
+		assert.Equal(t, http.StatusOK, socialLogin(t, testRouter, alice).Code)
+	})
+
+	t.Run("Login in cookie mode", func(t *testing.T) {
+		authURL, stateCookie := startSocialLogin(t, testRouter, "?cookies=true")
+		assert.Equal(t, http.SameSiteLaxMode, stateCookie.SameSite)
+		assert.True(t, stateCookie.HttpOnly)
+		rr := socialCallback(testRouter, testIdP.authorize(t, authURL, alice), stateCookie)
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.NotNil(t, findCookie(rr.Result().Cookies(), authMiddleware.RefreshTokenCookie))
+		assert.NotContains(t, rr.Body.String(), "refreshtoken")
+	})
+
+	t.Run("State is bound to the browser and used once", func(t *testing.T) {
+		authURL, stateCookie := startSocialLogin(t, testRouter, "")
+		callbackQuery := testIdP.authorize(t, authURL, alice)
+		rr := socialCallback(testRouter, callbackQuery, nil)
+		assert.Equal(t, http.StatusUnauthorized, rr.Code)
+		assert.Contains(t, rr.Body.String(), "invalid_state")
+
+		assert.Equal(t, http.StatusOK, socialCallback(testRouter, callbackQuery, stateCookie).Code)
+		rr = socialCallback(testRouter, callbackQuery, stateCookie)
+		assert.Equal(t, http.StatusUnauthorized, rr.Code)
+		assert.Contains(t, rr.Body.String(), "invalid_state")
+	})
+
+	t.Run("ID token with another nonce is rejected", func(t *testing.T) {
+		replayed := alice
+		replayed.Nonce = "replayed-nonce"
+		rr := socialLogin(t, testRouter, replayed)
+		assert.Equal(t, http.StatusUnauthorized, rr.Code)
+		assert.Contains(t, rr.Body.String(), "provider_error")
+	})
+
+	t.Run("Unverified emails are not trusted", func(t *testing.T) {
+		rr := socialLogin(t, testRouter, mockIdentity{Subject: "mallory-1", Email: "mallory@example.com"})
+		assert.Equal(t, http.StatusForbidden, rr.Code)
+		assert.Contains(t, rr.Body.String(), "email_not_verified")
+	})
+
+	bob := TestUser{Email: "bob.social@example.com", Password: "password123"}
+	bobIdentity := mockIdentity{Subject: "bob-1", Email: bob.Email, EmailVerified: true}
+	t.Run("Existing account with unverified email is not linked", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, signupTestUser(testRouter, bob).Code)
+		rr := socialLogin(t, testRouter, bobIdentity)
+		assert.Equal(t, http.StatusConflict, rr.Code)
+		assert.Contains(t, rr.Body.String(), "account_exists")
+	})
+
+	var bobToken string
+	t.Run("Logged in user links an identity", func(t *testing.T) {
+		bobToken = loginTokens(t, testRouter, bob).AccessToken
+		rr := protectedRequest(protectedRouter, "POST", "/api/v1/user/identities/mock", bobToken, nil)
+		assert.Equal(t, http.StatusOK, rr.Code)
+		var res map[string]string
+		assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &res))
+		stateCookie := findCookie(rr.Result().Cookies(), authMiddleware.FederationStateCookie)
+		rr = socialCallback(testRouter, testIdP.authorize(t, res["authorizationUrl"], bobIdentity), stateCookie)
+		assert.Equal(t, http.StatusCreated, rr.Code)
+
+		rr = socialLogin(t, testRouter, bobIdentity)
+		assert.Equal(t, http.StatusOK, rr.Code)
+		var tokens TestResponse
+		assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &tokens))
+		rr = protectedRequest(protectedRouter, "GET", "/api/v1/me", tokens.AccessToken, nil)
+		assert.Equal(t, http.StatusOK, rr.Code)
+	})
+
+	t.Run("Identity of another user can not be linked", func(t *testing.T) {
+		rr := protectedRequest(protectedRouter, "POST", "/api/v1/user/identities/mock", bobToken, nil)
+		var res map[string]string
+		assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &res))
+		stateCookie := findCookie(rr.Result().Cookies(), authMiddleware.FederationStateCookie)
+		rr = socialCallback(testRouter, testIdP.authorize(t, res["authorizationUrl"], alice), stateCookie)
+		assert.Equal(t, http.StatusConflict, rr.Code)
+		assert.Contains(t, rr.Body.String(), "identity_taken")
+	})
+
+	t.Run("Unlink identities", func(t *testing.T) {
+		var res struct {
+			Identities  []usermodel.UserIdentity `json:"identities"`
+			HasPassword bool                     `json:"hasPassword"`
+		}
+		rr := protectedRequest(protectedRouter, "GET", "/api/v1/user/identities", aliceTokens.AccessToken, nil)
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &res))
+		assert.False(t, res.HasPassword)
+		if assert.Len(t, res.Identities, 1) {
+			assert.Equal(t, alice.Email, res.Identities[0].Email)
+			rr = protectedRequest(protectedRouter, "DELETE", fmt.Sprintf("/api/v1/user/identities/%d", res.Identities[0].Id), aliceTokens.AccessToken, nil)
+			assert.Equal(t, http.StatusConflict, rr.Code, "the only way to log in is kept")
+		}
+
+		rr = protectedRequest(protectedRouter, "GET", "/api/v1/user/identities", bobToken, nil)
+		assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &res))
+		assert.True(t, res.HasPassword)
+		if assert.Len(t, res.Identities, 1) {
+			rr = protectedRequest(protectedRouter, "DELETE", fmt.Sprintf("/api/v1/user/identities/%d", res.Identities[0].Id), bobToken, nil)
+			assert.Equal(t, http.StatusNoContent, rr.Code)
+		}
+		assert.Equal(t, http.StatusConflict, socialLogin(t, testRouter, bobIdentity).Code)
+	})
+
+	t.Run("User without password sets a first password", func(t *testing.T) {
+		rr := protectedRequest(protectedRouter, "POST", "/api/v1/reauthenticate", aliceTokens.AccessToken, map[string]string{"password": ""})
+		assert.Equal(t, http.StatusConflict, rr.Code, "a recent login can only be renewed with the identity provider")
+		rr = protectedRequest(protectedRouter, "PATCH", "/api/v1/changePassword", aliceTokens.AccessToken, map[string]string{"password": "alicepassword1"})
+		assert.Equal(t, http.StatusOK, rr.Code)
+
+		user, err := usermodel.FindUserByEmail(alice.Email)
+		assert.NoError(t, err)
+		assert.True(t, user.HasPassword())
+		loginTokens(t, testRouter, TestUser{Email: alice.Email, Password: "alicepassword1"})
+	})
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	authMiddleware "github.com/go-auth-microservice/pkg/middleware/auth"
+	tokencache "github.com/go-auth-microservice/pkg/model/tokenCache"
+	usermodel "github.com/go-auth-microservice/pkg/model/userModel"
+	randomtoken "github.com/go-auth-microservice/pkg/utils/randomToken"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestForwardAuth tests the endpoint reverse proxies verify requests with
+func TestForwardAuth(t *testing.T) {
+	testRouter := setupTestRouter()
+	protectedRouter := setupProtectedTestRouter()
+
+	admin := TestUser{Email: "forward-admin@example.com", Password: "password123"}
+	assert.Equal(t, http.StatusOK, signupTestUser(testRouter, admin).Code)
+	adminData, err := usermodel.FindUserByEmail(admin.Email)
+	assert.NoError(t, err)
+	adminData.Role = usermodel.RoleAdmin
+	assert.NoError(t, adminData.Save())
+	adminTokens := loginTokens(t, testRouter, admin)
+
+	user := TestUser{Email: "forward@example.com", Password: "password123"}
+	assert.Equal(t, http.StatusOK, signupTestUser(testRouter, user).Code)
+	stored, err := usermodel.FindUserByEmail(user.Email)
+	assert.NoError(t, err)
+
+	verify := func(token string) *httptest.ResponseRecorder {
+		return protectedRequest(testRouter, "GET", "/api/v1/auth/verify", token, nil)
+	}
+	assertIdentity := func(t *testing.T, rr *httptest.ResponseRecorder) {
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.Equal(t, strconv.FormatUint(stored.Id, 10), rr.Header().Get("X-User-Id"))
+		assert.Equal(t, user.Email, rr.Header().Get("X-User-Email"))
+		assert.Equal(t, usermodel.RoleUser, rr.Header().Get("X-User-Roles"))
+	}
+
+	t.Run("Missing or invalid token", func(t *testing.T) {
+		for _, token := range []string{"", "invalid", "Bearer invalid", "Bearer gam_invalid"} {
+			rr := verify(token)
+			assert.Equal(t, http.StatusUnauthorized, rr.Code, token)
+			assert.Empty(t, rr.Header().Get("X-User-Id"))
+		}
+	})
+
+	t.Run("Bearer token is verified and cached", func(t *testing.T) {
+		tokens := loginTokens(t, testRouter, user)
+		assertIdentity(t, verify(tokens.AccessToken))
+		cached, ok := tokencache.GetVerifiedTokens().Get(randomtoken.Hash(tokens.AccessToken))
+		if assert.True(t, ok) {
+			assert.Equal(t, stored.Id, cached.UserId)
+		}
+		assertIdentity(t, verify(tokens.AccessToken))
+	})
+
+	t.Run("Session cookie", func(t *testing.T) {
+		body, _ := json.Marshal(map[string]interface{}{"email": user.Email, "password": user.Password, "cookies": true})
+		req, _ := http.NewRequest("POST", "/api/v1/auth/login", bytes.NewBuffer(body))
+		req.Header.Set("Content-Type", "application/json")
+		rr := httptest.NewRecorder()
+		testRouter.ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusOK, rr.Code)
+		cookie := findCookie(rr.Result().Cookies(), authMiddleware.AccessTokenCookie)
+		if assert.NotNil(t, cookie) {
+			assertIdentity(t, cookieRequest(testRouter, "GET", "/api/v1/auth/verify", []*http.Cookie{cookie}, "", nil))
+		}
+	})
+
+	t.Run("API token", func(t *testing.T) {
+		tokens := loginTokens(t, testRouter, user)
+		rr := protectedRequest(protectedRouter, "POST", "/api/v1/user/tokens", tokens.AccessToken, map[string]interface{}{"name": "proxy", "scopes": []string{usermodel.ScopeUserRead}})
+		assert.Equal(t, http.StatusCreated, rr.Code)
+		var res apiTokenResponse
+		assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &res))
+		assertIdentity(t, verify("Bearer "+res.Token))
+
+		// revoking the token evicts it from the cache
+		path := fmt.Sprintf("/api/v1/user/tokens/%d", res.APIToken.Id)
+		assert.Equal(t, http.StatusNoContent, protectedRequest(protectedRouter, "DELETE", path, tokens.AccessToken, nil).Code)
+		_, ok := tokencache.GetVerifiedTokens().Get(randomtoken.Hash("Bearer " + res.Token))
+		assert.False(t, ok)
+		assert.Equal(t, http.StatusUnauthorized, verify("Bearer "+res.Token).Code)
+	})
+
+	t.Run("Cached tokens are not accepted beyond their expiry", func(t *testing.T) {
+		verifiedTokens := tokencache.GetVerifiedTokens()
+		verifiedTokens.Set("expired", tokencache.VerifiedToken{UserId: stored.Id, ExpiresAt: time.Now().Add(-time.Second)}, time.Minute)
+		_, ok := verifiedTokens.Get("expired")
+		assert.False(t, ok)
+	})
+
+	t.Run("Impersonation token is denied once the admin is suspended", func(t *testing.T) {
+		rr := protectedRequest(testRouter, "POST", fmt.Sprintf("/api/v1/admin/users/%d/impersonate", stored.Id), adminTokens.AccessToken, nil)
+		assert.Equal(t, http.StatusOK, rr.Code)
+		var impersonation struct {
+			AccessToken string `json:"accesstoken"`
+		}
+		assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &impersonation))
+		assertIdentity(t, verify(impersonation.AccessToken))
+
+		admin, err := usermodel.FindUserByEmail(admin.Email)
+		assert.NoError(t, err)
+		assert.NoError(t, admin.Suspend("compromised", nil))
+		assert.NoError(t, admin.Save())
+		assert.Equal(t, http.StatusUnauthorized, verify(impersonation.AccessToken).Code)
+
+		assert.NoError(t, admin.Unsuspend())
+		assert.NoError(t, admin.Save())
+	})
+
+	t.Run("Logged out token is denied despite the cache", func(t *testing.T) {
+		tokens := loginTokens(t, testRouter, user)
+		assertIdentity(t, verify(tokens.AccessToken))
+		req, _ := http.NewRequest("POST", "/api/v1/auth/logout", nil)
+		req.Header.Set("Authorization", tokens.AccessToken)
+		rr := httptest.NewRecorder()
+		testRouter.ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusNoContent, rr.Code)
+		assert.Equal(t, http.StatusUnauthorized, verify(tokens.AccessToken).Code)
+	})
+
+	t.Run("Concurrent requests", func(t *testing.T) {
+		sessions := make([]TestResponse, 8)
+		for i := range sessions {
+			sessions[i] = loginTokens(t, testRouter, user)
+		}
+		codes := make([]int, len(sessions))
+		var wg sync.WaitGroup
+		for i, session := range sessions {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for range 5 {
+					verify(session.AccessToken)
+				}
+				req, _ := http.NewRequest("POST", "/api/v1/auth/logout", nil)
+				req.Header.Set("Authorization", session.AccessToken)
+				testRouter.ServeHTTP(httptest.NewRecorder(), req)
+				codes[i] = verify(session.AccessToken).Code
+			}()
+		}
+		wg.Wait()
+		for _, code := range codes {
+			assert.Equal(t, http.StatusUnauthorized, code)
+		}
+	})
+
+	t.Run("Suspended user is denied with 401", func(t *testing.T) {
+		tokens := loginTokens(t, testRouter, user)
+		assertIdentity(t, verify(tokens.AccessToken))
+		path := fmt.Sprintf("/api/v1/admin/users/%d/suspend", stored.Id)
+		assert.Equal(t, http.StatusOK, protectedRequest(testRouter, "POST", path, adminTokens.AccessToken, map[string]string{"reason": "spam"}).Code)
+		rr := verify(tokens.AccessToken)
+		assert.Equal(t, http.StatusUnauthorized, rr.Code)
+		assert.Empty(t, rr.Header().Get("X-User-Id"))
+	})
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	auditmodel "github.com/go-auth-microservice/pkg/model/auditModel"
+	usermodel "github.com/go-auth-microservice/pkg/model/userModel"
+	jwtauth "github.com/go-auth-microservice/pkg/utils/jwtAuth"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestImpersonation tests admins acting as a user
+func TestImpersonation(t *testing.T) {
+	testRouter := setupTestRouter()
+	protectedRouter := setupProtectedTestRouter()
+
+	admin := TestUser{Email: "support@example.com", Password: "password123"}
+	assert.Equal(t, http.StatusOK, signupTestUser(testRouter, admin).Code)
+	adminData, err := usermodel.FindUserByEmail(admin.Email)
+	assert.NoError(t, err)
+	adminData.Role = usermodel.RoleAdmin
+	assert.NoError(t, adminData.Save())
+	adminTokens := loginTokens(t, testRouter, admin)
+
+	user := TestUser{Email: "impersonated@example.com", Password: "password123"}
+	assert.Equal(t, http.StatusOK, signupTestUser(testRouter, user).Code)
+	userData, err := usermodel.FindUserByEmail(user.Email)
+	assert.NoError(t, err)
+
+	impersonate := func(token string, userId uint64) *httptest.ResponseRecorder {
+		return protectedRequest(testRouter, "POST", fmt.Sprintf("/api/v1/admin/users/%d/impersonate", userId), token, nil)
+	}
+
+	t.Run("Only admins can impersonate", func(t *testing.T) {
+		userTokens := loginTokens(t, testRouter, user)
+		assert.Equal(t, http.StatusForbidden, impersonate(userTokens.AccessToken, adminData.Id).Code)
+	})
+
+	t.Run("Admins can not be impersonated", func(t *testing.T) {
+		other := TestUser{Email: "other-admin@example.com", Password: "password123"}
+		assert.Equal(t, http.StatusOK, signupTestUser(testRouter, other).Code)
+		otherData, err := usermodel.FindUserByEmail(other.Email)
+		assert.NoError(t, err)
+		otherData.Role = usermodel.RoleAdmin
+		assert.NoError(t, otherData.Save())
+		assert.Equal(t, http.StatusForbidden, impersonate(adminTokens.AccessToken, otherData.Id).Code)
+	})
+
+	res := impersonate(adminTokens.AccessToken, userData.Id)
+	assert.Equal(t, http.StatusOK, res.Code)
+	var impersonation struct {
+		AccessToken string    `json:"accesstoken"`
+		ExpiresAt   time.Time `json:"expiresAt"`
+	}
+	assert.NoError(t, json.Unmarshal(res.Body.Bytes(), &impersonation))
+
+	t.Run("Token carries the act claim", func(t *testing.T) {
+		claims, err := jwtauth.GetAccessTokenHandler().VerifyToken(impersonation.AccessToken)
+		assert.NoError(t, err)
+		assert.Equal(t, float64(userData.Id), claims["userId"])
+		assert.Equal(t, map[string]interface{}{"sub": fmt.Sprint(adminData.Id)}, claims["act"])
+		assert.WithinDuration(t, time.Now().Add(15*time.Minute), time.Unix(int64(claims["exp"].(float64)), 0), time.Minute)
+	})
+
+	t.Run("Act as the user", func(t *testing.T) {
+		rr := protectedRequest(protectedRouter, "GET", "/api/v1/user", impersonation.AccessToken, nil)
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.Contains(t, rr.Body.String(), user.Email)
+	})
+
+	t.Run("Sensitive operations are blocked", func(t *testing.T) {
+		body := map[string]string{"password": "newpassword123", "currentPassword": user.Password}
+		assert.Equal(t, http.StatusForbidden, protectedRequest(protectedRouter, "PATCH", "/api/v1/changePassword", impersonation.AccessToken, body).Code)
+		assert.Equal(t, http.StatusForbidden, protectedRequest(protectedRouter, "DELETE", "/api/v1/user", impersonation.AccessToken, map[string]string{"currentPassword": user.Password}).Code)
+		assert.Equal(t, http.StatusForbidden, protectedRequest(protectedRouter, "POST", "/api/v1/reauthenticate", impersonation.AccessToken, map[string]string{"password": user.Password}).Code)
+		assert.Equal(t, http.StatusForbidden, protectedRequest(protectedRouter, "POST", "/api/v1/user/email", impersonation.AccessToken, map[string]string{"newEmail": "x@example.com", "currentPassword": user.Password}).Code)
+		assert.Equal(t, http.StatusForbidden, protectedRequest(protectedRouter, "GET", "/api/v1/user/tokens", impersonation.AccessToken, nil).Code)
+		assert.Equal(t, http.StatusForbidden, protectedRequest(protectedRouter, "DELETE", "/api/v1/user/tokens/1", impersonation.AccessToken, nil).Code)
+	})
+
+	t.Run("Actions are audited", func(t *testing.T) {
+		events, err := auditmodel.FindEventsByUserID(userData.Id)
+		assert.NoError(t, err)
+		var impersonated []auditmodel.AuditEvent
+		for _, event := range events {
+			if event.ActorId != nil && *event.ActorId == adminData.Id {
+				impersonated = append(impersonated, event)
+			}
+		}
+		assert.Len(t, impersonated, 8)
+		assert.Equal(t, auditmodel.ActionImpersonationStarted, impersonated[0].Action)
+		assert.Equal(t, auditmodel.ActionImpersonatedRequest, impersonated[1].Action)
+		assert.Equal(t, "/api/v1/user", impersonated[1].Details["path"])
+		assert.Equal(t, float64(http.StatusForbidden), impersonated[2].Details["status"])
+	})
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	usermodel "github.com/go-auth-microservice/pkg/model/userModel"
+	passwordhash "github.com/go-auth-microservice/pkg/utils/passwordHash"
+	"github.com/stretchr/testify/assert"
+)
+
+const importCSV = `email,password_hash
+django@example.com,pbkdf2_sha256$1000$somesalt$9uGciTK0YsFs8IX66F2YGyx+F/21bBVCbHT6pTGREzA=
+md5crypt@example.com,$1$saltsalt$4WS.Uhxmahm1YZiMsUNcc0
+php@example.com,$2y$04$agAIeHpPKtaCOV0qf9XFzu4NmwAQcKiWOP2.Q0UmrhI/eSD/na52.
+unknown-hash@example.com,sha1$abc$def
+unknown-hash@example.com,sha1$abc$ghi
+costly@example.com,pbkdf2_sha256$100000000$somesalt$9uGciTK0YsFs8IX66F2YGyx+F/21bBVCbHT6pTGREzA=
+`
+
+const importFirebaseJSON = `{"users": [{
+	"localId": "a1",
+	"email": "firebase@example.com",
+	"passwordHash": "lSrfV15cpx95/sZS2W9c9Kp6i/LVgQNDNC/qzrCnh1SAyZvqmZqAjTdn3aoItz+VHjoZilo78198JAdRuid5lQ==",
+	"salt": "42xEC+ixf3L2lw=="
+}]}`
+
+// TestImportUsers tests importing users with foreign password hashes
+func TestImportUsers(t *testing.T) {
+	testRouter := setupTestRouter()
+
+	csvRecords, err := usermodel.ReadImportRecords(strings.NewReader(importCSV), "csv")
+	assert.NoError(t, err)
+	assert.Len(t, csvRecords, 6)
+	jsonRecords, err := usermodel.ReadImportRecords(strings.NewReader(importFirebaseJSON), "json")
+	assert.NoError(t, err)
+	assert.Len(t, jsonRecords, 1)
+
+	result := usermodel.ImportUsers(append(csvRecords, jsonRecords...))
+	assert.Equal(t, 4, result.Imported)
+	assert.Len(t, result.Failed, 3)
+	for _, row := range []int{4, 5, 6} {
+		assert.ErrorIs(t, result.Failed[row], passwordhash.ErrUnknownHash, "Row %d should fail", row)
+	}
+
+	result = usermodel.ImportUsers(jsonRecords)
+	assert.Equal(t, 1, result.Skipped, "Existing users should be skipped")
+
+	users := []TestUser{
+		{Email: "django@example.com", Password: "password123"},
+		{Email: "md5crypt@example.com", Password: "password123"},
+		{Email: "php@example.com", Password: "password123"},
+		{Email: "firebase@example.com", Password: "user1password"},
+	}
+	for _, user := range users {
+		t.Run(user.Email, func(t *testing.T) {
+			wrongPassword := TestUser{Email: user.Email, Password: "wrongpassword"}
+			assert.Equal(t, http.StatusUnauthorized, loginTestUser(testRouter, wrongPassword).Code)
+			assert.Equal(t, http.StatusOK, loginTestUser(testRouter, user).Code)
+
+			userData, err := usermodel.FindUserByEmail(user.Email)
+			assert.NoError(t, err)
+			assert.True(t, strings.HasPrefix(userData.Password, "$argon2id$"), "Hash should be upgraded on first login")
+			assert.Equal(t, http.StatusOK, loginTestUser(testRouter, user).Code)
+		})
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/go-auth-microservice/pkg/config"
+	"github.com/go-auth-microservice/pkg/controller"
+	usermodel "github.com/go-auth-microservice/pkg/model/userModel"
+	"github.com/go-auth-microservice/pkg/utils/mailer"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestInvitations tests onboarding users invited by an admin
+func TestInvitations(t *testing.T) {
+	testRouter := setupTestRouter()
+	testRouter.Post("/api/v1/auth/invitations/accept", controller.AcceptInvitation)
+	m := &testMailer{}
+	mailer.SetMailer(m)
+
+	admin := TestUser{Email: "inviter@example.com", Password: "password123"}
+	assert.Equal(t, http.StatusOK, signupTestUser(testRouter, admin).Code)
+	adminData, err := usermodel.FindUserByEmail(admin.Email)
+	assert.NoError(t, err)
+	adminData.Role = usermodel.RoleAdmin
+	assert.NoError(t, adminData.Save())
+	adminTokens := loginTokens(t, testRouter, admin)
+
+	invite := func(body interface{}) int {
+		return protectedRequest(testRouter, "POST", "/api/v1/admin/invitations", adminTokens.AccessToken, body).Code
+	}
+	accept := func(token string, password string) int {
+		return protectedRequest(testRouter, "POST", "/api/v1/auth/invitations/accept", "", map[string]string{"token": token, "password": password}).Code
+	}
+
+	t.Run("Only admins can invite", func(t *testing.T) {
+		user := TestUser{Email: "not-inviter@example.com", Password: "password123"}
+		assert.Equal(t, http.StatusOK, signupTestUser(testRouter, user).Code)
+		tokens := loginTokens(t, testRouter, user)
+		rr := protectedRequest(testRouter, "POST", "/api/v1/admin/invitations", tokens.AccessToken, map[string]string{"email": "someone@example.com"})
+		assert.Equal(t, http.StatusForbidden, rr.Code)
+	})
+
+	t.Run("Invalid invitations are rejected", func(t *testing.T) {
+		assert.Equal(t, http.StatusBadRequest, invite(map[string]string{"email": "not an email"}))
+		assert.Equal(t, http.StatusBadRequest, invite(map[string]string{"email": "someone@example.com", "role": "owner"}))
+		assert.Equal(t, http.StatusConflict, invite(map[string]string{"email": admin.Email}))
+	})
+
+	t.Run("Accept invitation", func(t *testing.T) {
+		assert.Equal(t, http.StatusCreated, invite(map[string]string{"email": "invited@example.com", "role": "admin"}))
+		token := mailToken(m.lastTo("invited@example.com"))
+		assert.NotEmpty(t, token)
+
+		assert.Equal(t, http.StatusBadRequest, accept(token, "short"), "Password policy should apply")
+		assert.Equal(t, http.StatusBadRequest, accept("invalid", "password123"))
+		assert.Equal(t, http.StatusCreated, accept(token, "password123"))
+		assert.Equal(t, http.StatusBadRequest, accept(token, "password123"), "Token should be single use")
+
+		stored, err := usermodel.FindUserByEmail("invited@example.com")
+		assert.NoError(t, err)
+		assert.Equal(t, usermodel.RoleAdmin, stored.Role)
+		assert.NotNil(t, stored.EmailVerifiedAt)
+		loginTokens(t, testRouter, TestUser{Email: "invited@example.com", Password: "password123"})
+	})
+
+	t.Run("New invitation supersedes the old one", func(t *testing.T) {
+		assert.Equal(t, http.StatusCreated, invite(map[string]string{"email": "twice@example.com"}))
+		oldToken := mailToken(m.lastTo("twice@example.com"))
+		rr := protectedRequest(testRouter, "POST", "/api/v1/admin/invitations", adminTokens.AccessToken, map[string]string{"email": "twice@example.com"})
+		assert.Equal(t, http.StatusCreated, rr.Code)
+		var invitation map[string]interface{}
+		assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &invitation))
+		assert.Equal(t, usermodel.RoleUser, invitation["role"])
+		assert.NotContains(t, rr.Body.String(), "token", "Token should only be sent by email")
+
+		assert.Equal(t, http.StatusBadRequest, accept(oldToken, "password123"))
+		assert.Equal(t, http.StatusCreated, accept(mailToken(m.lastTo("twice@example.com")), "password123"))
+	})
+
+	t.Run("Invitation only signup", func(t *testing.T) {
+		config.GetConfig().SetOpenSignup(false)
+		defer config.GetConfig().SetOpenSignup(true)
+
+		rr := signupTestUser(testRouter, TestUser{Email: "uninvited@example.com", Password: "password123"})
+		assert.Equal(t, http.StatusForbidden, rr.Code)
+		assert.Contains(t, rr.Body.String(), "invitation only")
+		_, err := usermodel.FindUserByEmail("uninvited@example.com")
+		assert.Error(t, err, "User should not be created")
+
+		assert.Equal(t, http.StatusCreated, invite(map[string]string{"email": "closed@example.com"}))
+		assert.Equal(t, http.StatusCreated, accept(mailToken(m.lastTo("closed@example.com")), "password123"))
+		loginTokens(t, testRouter, TestUser{Email: "closed@example.com", Password: "password123"})
+	})
+}
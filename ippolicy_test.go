@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	ipPolicyMiddleware "github.com/go-auth-microservice/pkg/middleware/ipPolicy"
+	auditmodel "github.com/go-auth-microservice/pkg/model/auditModel"
+	ippolicymodel "github.com/go-auth-microservice/pkg/model/ipPolicyModel"
+	usermodel "github.com/go-auth-microservice/pkg/model/userModel"
+	clientip "github.com/go-auth-microservice/pkg/utils/clientIP"
+	"github.com/stretchr/testify/assert"
+)
+
+// testProxyNetwork are the trusted proxies of the tests
+const testProxyNetwork = "10.99.0.0/24"
+
+// requestFrom sends a request with the access token from the remote address,
+// through the given X-Forwarded-For chain if it is not empty
+func requestFrom(router http.Handler, method string, path string, token string, remoteAddr string, forwardedFor string, body interface{}) *httptest.ResponseRecorder {
+	var bodyBytes []byte
+	if body != nil {
+		bodyBytes, _ = json.Marshal(body)
+	}
+	req := httptest.NewRequest(method, path, bytes.NewBuffer(bodyBytes))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", token)
+	req.RemoteAddr = remoteAddr + ":40000"
+	if forwardedFor != "" {
+		req.Header.Set("X-Forwarded-For", forwardedFor)
+	}
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	return rr
+}
+
+// TestIPPolicy tests the global and per role IP rules and their admin API
+func TestIPPolicy(t *testing.T) {
+	testRouter := setupTestRouter()
+	protectedRouter := setupProtectedTestRouter()
+	router := ipPolicyMiddleware.GlobalPolicy(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/api/v1/admin/") {
+			testRouter.ServeHTTP(w, r)
+			return
+		}
+		protectedRouter.ServeHTTP(w, r)
+	}))
+
+	admin := TestUser{Email: "ipadmin@example.com", Password: "password123"}
+	user := TestUser{Email: "ipuser@example.com", Password: "password123"}
+	assert.Equal(t, http.StatusOK, signupTestUser(testRouter, admin).Code)
+	assert.Equal(t, http.StatusOK, signupTestUser(testRouter, user).Code)
+	adminData, err := usermodel.FindUserByEmail(admin.Email)
+	assert.NoError(t, err)
+	adminData.Role = usermodel.RoleAdmin
+	assert.NoError(t, adminData.Save())
+	adminToken := loginTokens(t, testRouter, admin).AccessToken
+	userToken := loginTokens(t, testRouter, user).AccessToken
+	t.Cleanup(func() {
+		rules, _ := ippolicymodel.FindRules()
+		for _, rule := range rules {
+			_ = ippolicymodel.DeleteRule(rule.Id)
+		}
+	})
+
+	createRule := func(remoteAddr string, action string, cidr string, role string) *httptest.ResponseRecorder {
+		body := map[string]string{"action": action, "cidr": cidr, "role": role}
+		return requestFrom(router, "POST", "/api/v1/admin/ip-rules", adminToken, remoteAddr, "", body)
+	}
+
+	t.Run("Client IP behind trusted proxies", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = "10.99.0.1:40000"
+		req.Header.Set("X-Forwarded-For", "1.1.1.1, 203.0.113.9, 10.99.0.2")
+		assert.Equal(t, "203.0.113.9", clientip.FromRequest(req), "the first untrusted hop from the right is the client")
+		req.RemoteAddr = "192.0.2.1:40000"
+		assert.Equal(t, "192.0.2.1", clientip.FromRequest(req), "the header of an untrusted client is ignored")
+	})
+
+	var denyRule ippolicymodel.IPRule
+	t.Run("Global deny rule", func(t *testing.T) {
+		rr := createRule("192.0.2.1", "deny", "203.0.113.0/24", "")
+		assert.Equal(t, http.StatusCreated, rr.Code)
+		assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &denyRule))
+		assert.Equal(t, "203.0.113.0/24", denyRule.CIDR)
+
+		assert.Equal(t, http.StatusForbidden, requestFrom(router, "GET", "/api/v1/me", userToken, "10.99.0.1", "203.0.113.9", nil).Code)
+		assert.Equal(t, http.StatusOK, requestFrom(router, "GET", "/api/v1/me", userToken, "10.99.0.1", "198.18.0.5", nil).Code)
+		assert.Equal(t, http.StatusOK, requestFrom(router, "GET", "/api/v1/me", userToken, "192.0.2.1", "203.0.113.9", nil).Code,
+			"a forged header of an untrusted client is ignored")
+	})
+
+	var officeRule ippolicymodel.IPRule
+	t.Run("Admin allow list", func(t *testing.T) {
+		rr := createRule("192.0.2.1", "allow", "192.0.2.0/24", usermodel.RoleAdmin)
+		assert.Equal(t, http.StatusCreated, rr.Code)
+		assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &officeRule))
+
+		assert.Equal(t, http.StatusOK, requestFrom(router, "GET", "/api/v1/admin/ip-rules", adminToken, "192.0.2.1", "", nil).Code)
+		assert.Equal(t, http.StatusForbidden, requestFrom(router, "GET", "/api/v1/admin/ip-rules", adminToken, "10.99.0.1", "198.18.0.5", nil).Code)
+		assert.Equal(t, http.StatusOK, requestFrom(router, "GET", "/api/v1/me", userToken, "10.99.0.1", "198.18.0.5", nil).Code,
+			"rules of the admin role do not apply to users")
+	})
+
+	t.Run("More specific rules win", func(t *testing.T) {
+		assert.Equal(t, http.StatusCreated, createRule("192.0.2.1", "deny", "192.0.2.128/25", usermodel.RoleAdmin).Code)
+		assert.Equal(t, http.StatusOK, requestFrom(router, "GET", "/api/v1/admin/ip-rules", adminToken, "10.99.0.1", "192.0.2.100", nil).Code)
+		assert.Equal(t, http.StatusForbidden, requestFrom(router, "GET", "/api/v1/admin/ip-rules", adminToken, "10.99.0.1", "192.0.2.200", nil).Code)
+	})
+
+	t.Run("Rules locking out the admin are refused", func(t *testing.T) {
+		assert.Equal(t, http.StatusConflict, createRule("192.0.2.1", "deny", "192.0.2.1", "").Code)
+		assert.Equal(t, http.StatusConflict, createRule("192.0.2.1", "deny", "192.0.2.0/28", usermodel.RoleAdmin).Code)
+		assert.Equal(t, http.StatusConflict, createRule("192.0.2.1", "allow", "203.0.113.9", "").Code,
+			"a global allow list without the own ip address")
+		assert.Equal(t, http.StatusCreated, createRule("192.0.2.1", "allow", "10.50.0.0/16", usermodel.RoleAdmin).Code)
+		rr := requestFrom(router, "DELETE", fmt.Sprintf("/api/v1/admin/ip-rules/%d", officeRule.Id), adminToken, "192.0.2.1", "", nil)
+		assert.Equal(t, http.StatusConflict, rr.Code)
+	})
+
+	t.Run("Invalid rules", func(t *testing.T) {
+		assert.Equal(t, http.StatusBadRequest, createRule("192.0.2.1", "block", "203.0.113.0/24", "").Code)
+		assert.Equal(t, http.StatusBadRequest, createRule("192.0.2.1", "deny", "203.0.113.0/33", "").Code)
+		rr := requestFrom(router, "DELETE", "/api/v1/admin/ip-rules/999999", adminToken, "192.0.2.1", "", nil)
+		assert.Equal(t, http.StatusNotFound, rr.Code)
+	})
+
+	t.Run("Deleted rules stop applying", func(t *testing.T) {
+		rr := requestFrom(router, "DELETE", fmt.Sprintf("/api/v1/admin/ip-rules/%d", denyRule.Id), adminToken, "192.0.2.1", "", nil)
+		assert.Equal(t, http.StatusNoContent, rr.Code)
+		assert.Equal(t, http.StatusOK, requestFrom(router, "GET", "/api/v1/me", userToken, "10.99.0.1", "203.0.113.10", nil).Code)
+
+		var rules []ippolicymodel.IPRule
+		rr = requestFrom(router, "GET", "/api/v1/admin/ip-rules", adminToken, "192.0.2.1", "", nil)
+		assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &rules))
+		assert.Len(t, rules, 3)
+	})
+
+	t.Run("Changes are audited", func(t *testing.T) {
+		events, err := auditmodel.FindEventsByUserID(adminData.Id)
+		assert.NoError(t, err)
+		var actions []string
+		for _, event := range events {
+			if event.Action == auditmodel.ActionIPRuleCreated || event.Action == auditmodel.ActionIPRuleDeleted {
+				actions = append(actions, event.Action)
+			}
+		}
+		assert.Equal(t, []string{
+			auditmodel.ActionIPRuleCreated, auditmodel.ActionIPRuleCreated, auditmodel.ActionIPRuleCreated,
+			auditmodel.ActionIPRuleCreated, auditmodel.ActionIPRuleDeleted,
+		}, actions)
+	})
+}
@@ -0,0 +1,306 @@
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	usermodel "github.com/go-auth-microservice/pkg/model/userModel"
+	jwtauth "github.com/go-auth-microservice/pkg/utils/jwtAuth"
+	"github.com/go-ldap/ldap/v3"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	testLDAPBaseDN          = "dc=example,dc=com"
+	testLDAPServiceDN       = "cn=service,dc=example,dc=com"
+	testLDAPServicePassword = "service-secret"
+	testLDAPAdminGroup      = "cn=auth-admins,ou=groups,dc=example,dc=com"
+)
+
+// testLDAP is the directory server of the tests, started by TestMain
+var testLDAP *mockLDAP
+
+// mockLDAPEntry is a user of the mock directory
+type mockLDAPEntry struct {
+	DN          string
+	Mail        string
+	DisplayName string
+	Password    string
+	MemberOf    []string
+}
+
+// mockLDAP is an in-process LDAP server answering simple binds and searches
+// for users by mail, enough for the bind verifier.
+type mockLDAP struct {
+	listener net.Listener
+	lock     sync.Mutex
+	entries  []mockLDAPEntry
+}
+
+func newMockLDAP() (*mockLDAP, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	directory := &mockLDAP{listener: listener}
+	go directory.serve()
+	return directory, nil
+}
+
+func (d *mockLDAP) URL() string {
+	return "ldap://" + d.listener.Addr().String()
+}
+
+func (d *mockLDAP) Close() {
+	_ = d.listener.Close()
+}
+
+// put adds the entry or replaces the entry with the same DN
+func (d *mockLDAP) put(entry mockLDAPEntry) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	for i := range d.entries {
+		if d.entries[i].DN == entry.DN {
+			d.entries[i] = entry
+			return
+		}
+	}
+	d.entries = append(d.entries, entry)
+}
+
+func (d *mockLDAP) serve() {
+	for {
+		conn, err := d.listener.Accept()
+		if err != nil {
+			return
+		}
+		go d.handle(conn)
+	}
+}
+
+func (d *mockLDAP) handle(conn net.Conn) {
+	defer conn.Close()
+	bound := ""
+	for {
+		packet, err := ber.ReadPacket(conn)
+		if err != nil {
+			return
+		}
+		if len(packet.Children) < 2 {
+			return
+		}
+		messageID := packet.Children[0].Value.(int64)
+		op := packet.Children[1]
+		switch op.Tag {
+		case ldap.ApplicationBindRequest:
+			dn := op.Children[1].Value.(string)
+			password := op.Children[2].Data.String()
+			code := uint16(ldap.LDAPResultInvalidCredentials)
+			if d.checkBind(dn, password) {
+				code = ldap.LDAPResultSuccess
+				bound = dn
+			}
+			d.reply(conn, messageID, ldap.ApplicationBindResponse, code)
+		case ldap.ApplicationSearchRequest:
+			if bound != testLDAPServiceDN {
+				d.reply(conn, messageID, ldap.ApplicationSearchResultDone, ldap.LDAPResultInsufficientAccessRights)
+				continue
+			}
+			filter, err := ldap.DecompileFilter(op.Children[6])
+			if err != nil {
+				d.reply(conn, messageID, ldap.ApplicationSearchResultDone, ldap.LDAPResultProtocolError)
+				continue
+			}
+			for _, entry := range d.search(filter) {
+				d.sendEntry(conn, messageID, entry)
+			}
+			d.reply(conn, messageID, ldap.ApplicationSearchResultDone, ldap.LDAPResultSuccess)
+		case ldap.ApplicationUnbindRequest:
+			return
+		default:
+			return
+		}
+	}
+}
+
+func (d *mockLDAP) checkBind(dn string, password string) bool {
+	if password == "" {
+		return false
+	}
+	if dn == testLDAPServiceDN {
+		return password == testLDAPServicePassword
+	}
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	for _, entry := range d.entries {
+		if strings.EqualFold(entry.DN, dn) {
+			return entry.Password == password
+		}
+	}
+	return false
+}
+
+// search returns the entries whose mail the filter asks for
+func (d *mockLDAP) search(filter string) []mockLDAPEntry {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	found := []mockLDAPEntry{}
+	for _, entry := range d.entries {
+		if strings.Contains(strings.ToLower(filter), "(mail="+strings.ToLower(ldap.EscapeFilter(entry.Mail))+")") {
+			found = append(found, entry)
+		}
+	}
+	return found
+}
+
+func (d *mockLDAP) envelope(messageID int64, op *ber.Packet) *ber.Packet {
+	packet := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "LDAP Response")
+	packet.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, messageID, "MessageID"))
+	packet.AppendChild(op)
+	return packet
+}
+
+func (d *mockLDAP) reply(conn net.Conn, messageID int64, tag ber.Tag, code uint16) {
+	op := ber.Encode(ber.ClassApplication, ber.TypeConstructed, tag, nil, "Response")
+	op.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, int64(code), "resultCode"))
+	op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "matchedDN"))
+	op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "diagnosticMessage"))
+	_, _ = conn.Write(d.envelope(messageID, op).Bytes())
+}
+
+func (d *mockLDAP) sendEntry(conn net.Conn, messageID int64, entry mockLDAPEntry) {
+	op := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldap.ApplicationSearchResultEntry, nil, "Search Result Entry")
+	op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, entry.DN, "objectName"))
+	attributes := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "attributes")
+	for name, values := range map[string][]string{
+		"mail":        {entry.Mail},
+		"displayName": {entry.DisplayName},
+		"memberOf":    entry.MemberOf,
+	} {
+		attribute := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "attribute")
+		attribute.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, name, "type"))
+		set := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "vals")
+		for _, value := range values {
+			set.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, value, "value"))
+		}
+		attribute.AppendChild(set)
+		attributes.AppendChild(attribute)
+	}
+	op.AppendChild(attributes)
+	_, _ = conn.Write(d.envelope(messageID, op).Bytes())
+}
+
+// tokenRole returns the role claim of an access token
+func tokenRole(t *testing.T, accessToken string) string {
+	claims, err := jwtauth.GetAccessTokenHandler().VerifyToken(accessToken)
+	assert.NoError(t, err)
+	role, _ := claims["role"].(string)
+	return role
+}
+
+func TestLDAPLogin(t *testing.T) {
+	testRouter := setupTestRouter()
+	protectedRouter := setupProtectedTestRouter()
+
+	carol := mockLDAPEntry{
+		DN:          "uid=carol,ou=people,dc=example,dc=com",
+		Mail:        "carol@corp.example.com",
+		DisplayName: "Carol Directory",
+		Password:    "directory-secret",
+		MemberOf:    []string{"cn=staff,ou=groups,dc=example,dc=com", "CN=Auth-Admins,OU=Groups,DC=example,DC=com"},
+	}
+	testLDAP.put(carol)
+	directoryUser := TestUser{Email: carol.Mail, Password: carol.Password}
+
+	t.Run("Directory user is provisioned on first login", func(t *testing.T) {
+		_, err := usermodel.FindUserByEmail(carol.Mail)
+		assert.Error(t, err)
+
+		tokens := loginTokens(t, testRouter, directoryUser)
+		assert.NotEmpty(t, tokens.RefreshToken)
+		assert.Equal(t, usermodel.RoleAdmin, tokenRole(t, tokens.AccessToken))
+
+		rr := protectedRequest(protectedRouter, "GET", "/api/v1/user", tokens.AccessToken, nil)
+		assert.Equal(t, http.StatusOK, rr.Code)
+		var profile map[string]interface{}
+		assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &profile))
+		assert.Equal(t, usermodel.AuthSourceLDAP, profile["authSource"])
+		assert.Equal(t, carol.DisplayName, profile["displayName"])
+		assert.NotEmpty(t, profile["emailVerifiedAt"])
+
+		stored, err := usermodel.FindUserByEmail(carol.Mail)
+		assert.NoError(t, err)
+		assert.Empty(t, stored.Password)
+	})
+
+	t.Run("Wrong directory password", func(t *testing.T) {
+		rr := loginTestUser(testRouter, TestUser{Email: carol.Mail, Password: "wrong-password"})
+		assert.Equal(t, http.StatusUnauthorized, rr.Code)
+		assert.Contains(t, rr.Body.String(), "invalid email or password")
+
+		stored, err := usermodel.FindUserByEmail(carol.Mail)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, stored.FailedLoginCount)
+	})
+
+	t.Run("Role follows the directory groups", func(t *testing.T) {
+		demoted := carol
+		demoted.MemberOf = []string{"cn=staff,ou=groups,dc=example,dc=com"}
+		testLDAP.put(demoted)
+		defer testLDAP.put(carol)
+
+		tokens := loginTokens(t, testRouter, directoryUser)
+		assert.Equal(t, usermodel.RoleUser, tokenRole(t, tokens.AccessToken))
+		stored, err := usermodel.FindUserByEmail(carol.Mail)
+		assert.NoError(t, err)
+		assert.Equal(t, usermodel.RoleUser, stored.Role)
+		assert.Equal(t, 0, stored.FailedLoginCount)
+	})
+
+	t.Run("Directory password is used to reauthenticate", func(t *testing.T) {
+		tokens := loginTokens(t, testRouter, directoryUser)
+		rr := protectedRequest(protectedRouter, "POST", "/api/v1/reauthenticate", tokens.AccessToken, map[string]string{"password": carol.Password})
+		assert.Equal(t, http.StatusOK, rr.Code)
+
+		rr = protectedRequest(protectedRouter, "POST", "/api/v1/reauthenticate", tokens.AccessToken, map[string]string{"password": "wrong-password"})
+		assert.Equal(t, http.StatusUnauthorized, rr.Code)
+	})
+
+	t.Run("Password and email of directory users can not be changed here", func(t *testing.T) {
+		tokens := loginTokens(t, testRouter, directoryUser)
+		body := map[string]string{"password": "new-local-password", "currentPassword": carol.Password}
+		rr := protectedRequest(protectedRouter, "PATCH", "/api/v1/changePassword", tokens.AccessToken, body)
+		assert.Equal(t, http.StatusConflict, rr.Code)
+
+		body = map[string]string{"newEmail": "carol@elsewhere.example.com", "currentPassword": carol.Password}
+		rr = protectedRequest(protectedRouter, "POST", "/api/v1/user/email", tokens.AccessToken, body)
+		assert.Equal(t, http.StatusConflict, rr.Code)
+	})
+
+	t.Run("Directory does not take over local accounts", func(t *testing.T) {
+		local := TestUser{Email: "dave@corp.example.com", Password: "local-password"}
+		assert.Equal(t, http.StatusOK, signupTestUser(testRouter, local).Code)
+		testLDAP.put(mockLDAPEntry{
+			DN:       "uid=dave,ou=people,dc=example,dc=com",
+			Mail:     local.Email,
+			Password: "directory-password",
+			MemberOf: []string{testLDAPAdminGroup},
+		})
+
+		rr := loginTestUser(testRouter, TestUser{Email: local.Email, Password: "directory-password"})
+		assert.Equal(t, http.StatusUnauthorized, rr.Code)
+
+		tokens := loginTokens(t, testRouter, local)
+		assert.Equal(t, usermodel.RoleUser, tokenRole(t, tokens.AccessToken))
+	})
+
+	t.Run("Unknown to database and directory", func(t *testing.T) {
+		rr := loginTestUser(testRouter, TestUser{Email: "nobody@corp.example.com", Password: "password123"})
+		assert.Equal(t, http.StatusUnauthorized, rr.Code)
+	})
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	usermodel "github.com/go-auth-microservice/pkg/model/userModel"
+	"github.com/go-auth-microservice/pkg/utils/db"
+	"github.com/go-auth-microservice/pkg/utils/notifier"
+	"github.com/stretchr/testify/assert"
+)
+
+// testNotifier records the notifications instead of sending them
+type testNotifier struct {
+	mu            sync.Mutex
+	notifications []notifier.Notification
+}
+
+func (n *testNotifier) Notify(notification notifier.Notification) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.notifications = append(n.notifications, notification)
+	return nil
+}
+
+func (n *testNotifier) count() int {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return len(n.notifications)
+}
+
+// loginFrom logs a user in from the given IP and user agent
+func loginFrom(router http.Handler, user TestUser, ip string, userAgent string) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(user)
+	req, _ := http.NewRequest("POST", "/api/v1/auth/login", bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", userAgent)
+	req.RemoteAddr = ip + ":40000"
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	return rr
+}
+
+// TestLoginHistory tests the login history and new device notifications
+func TestLoginHistory(t *testing.T) {
+	testRouter := setupTestRouter()
+	protectedRouter := setupProtectedTestRouter()
+	notifications := &testNotifier{}
+	notifier.SetNotifier(notifications)
+	defer notifier.SetNotifier(nil)
+
+	user := TestUser{Email: "history@example.com", Password: "password123"}
+	assert.Equal(t, http.StatusOK, signupTestUser(testRouter, user).Code)
+
+	t.Run("First login is not a new device", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, loginFrom(testRouter, user, "10.0.0.1", "Firefox").Code)
+		assert.Equal(t, http.StatusOK, loginFrom(testRouter, user, "10.0.0.1", "Firefox").Code)
+		assert.Equal(t, 0, notifications.count())
+	})
+
+	t.Run("Failed logins do not notify", func(t *testing.T) {
+		wrong := TestUser{Email: user.Email, Password: "wrongpassword"}
+		assert.Equal(t, http.StatusUnauthorized, loginFrom(testRouter, wrong, "10.0.0.9", "curl").Code)
+		assert.Equal(t, 0, notifications.count())
+	})
+
+	t.Run("New device is notified", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, loginFrom(testRouter, user, "10.0.0.2", "Firefox").Code)
+		assert.Eventually(t, func() bool { return notifications.count() == 1 }, time.Second, time.Millisecond, "Notification should be sent in the background")
+		notification := notifications.notifications[0]
+		assert.Equal(t, notifier.EventNewDeviceLogin, notification.Event)
+		assert.Equal(t, user.Email, notification.Email)
+		assert.Contains(t, notification.Body, "10.0.0.2")
+		assert.Equal(t, http.StatusOK, loginFrom(testRouter, user, "10.0.0.2", "Firefox").Code)
+		assert.Equal(t, 1, notifications.count())
+	})
+
+	tokens := loginTokens(t, testRouter, user)
+
+	t.Run("List the login history", func(t *testing.T) {
+		rr := protectedRequest(protectedRouter, "GET", "/api/v1/user/login-history", tokens.AccessToken, nil)
+		assert.Equal(t, http.StatusOK, rr.Code)
+		var history usermodel.LoginHistory
+		assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &history))
+		assert.Equal(t, int64(6), history.Total)
+		assert.Equal(t, 1, history.Page)
+		assert.Len(t, history.Events, 6)
+		assert.Equal(t, usermodel.LoginSucceeded, history.Events[1].Outcome)
+		assert.Equal(t, "10.0.0.2", history.Events[1].IP)
+		assert.Equal(t, "Firefox", history.Events[1].UserAgent)
+		assert.Equal(t, usermodel.LoginMethodPassword, history.Events[1].Method)
+		assert.Equal(t, usermodel.LoginFailed, history.Events[3].Outcome)
+		assert.Equal(t, "10.0.0.9", history.Events[3].IP)
+	})
+
+	t.Run("Paginate the login history", func(t *testing.T) {
+		rr := protectedRequest(protectedRouter, "GET", "/api/v1/user/login-history?page=2&perPage=4", tokens.AccessToken, nil)
+		assert.Equal(t, http.StatusOK, rr.Code)
+		var history usermodel.LoginHistory
+		assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &history))
+		assert.Equal(t, int64(6), history.Total)
+		assert.Len(t, history.Events, 2)
+		assert.Equal(t, "10.0.0.1", history.Events[1].IP)
+		for _, query := range []string{"page=0", "page=abc", "perPage=0", "perPage=101"} {
+			assert.Equal(t, http.StatusBadRequest, protectedRequest(protectedRouter, "GET", "/api/v1/user/login-history?"+query, tokens.AccessToken, nil).Code)
+		}
+	})
+
+	t.Run("Admins can see the login history", func(t *testing.T) {
+		userData, err := usermodel.FindUserByEmail(user.Email)
+		assert.NoError(t, err)
+		path := fmt.Sprintf("/api/v1/admin/users/%d/login-history", userData.Id)
+		assert.Equal(t, http.StatusForbidden, protectedRequest(testRouter, "GET", path, tokens.AccessToken, nil).Code)
+
+		admin := TestUser{Email: "history-admin@example.com", Password: "password123"}
+		assert.Equal(t, http.StatusOK, signupTestUser(testRouter, admin).Code)
+		adminData, err := usermodel.FindUserByEmail(admin.Email)
+		assert.NoError(t, err)
+		adminData.Role = usermodel.RoleAdmin
+		assert.NoError(t, adminData.Save())
+		adminTokens := loginTokens(t, testRouter, admin)
+		rr := protectedRequest(testRouter, "GET", path, adminTokens.AccessToken, nil)
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.Contains(t, rr.Body.String(), "10.0.0.9")
+	})
+
+	t.Run("Long user agents are truncated", func(t *testing.T) {
+		userAgent := strings.Repeat("a", 1000)
+		notified := notifications.count()
+		assert.Equal(t, http.StatusOK, loginFrom(testRouter, user, "10.0.0.3", userAgent).Code)
+		assert.Equal(t, http.StatusOK, loginFrom(testRouter, user, "10.0.0.3", userAgent[:600]).Code)
+		rr := protectedRequest(protectedRouter, "GET", "/api/v1/user/login-history?perPage=2", tokens.AccessToken, nil)
+		var history usermodel.LoginHistory
+		assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &history))
+		if assert.Len(t, history.Events, 2) {
+			assert.Equal(t, userAgent[:512], history.Events[0].UserAgent)
+		}
+		assert.Eventually(t, func() bool { return notifications.count() == notified+1 }, time.Second, time.Millisecond)
+		assert.Never(t, func() bool { return notifications.count() > notified+1 }, 50*time.Millisecond, time.Millisecond, "Agents which only differ after the limit are the same device")
+	})
+
+	t.Run("Old login events are purged", func(t *testing.T) {
+		userData, err := usermodel.FindUserByEmail(user.Email)
+		assert.NoError(t, err)
+		old := time.Now().AddDate(0, 0, -100)
+		assert.NoError(t, db.GetDBConn().GetDB().Model(&usermodel.LoginEvent{}).Where("user_id = ? AND ip <> ?", userData.Id, "10.0.0.3").Update("created_at", old).Error)
+		purged, err := usermodel.PurgeLoginEvents(time.Now().AddDate(0, 0, -90))
+		assert.NoError(t, err)
+		assert.Equal(t, int64(6), purged)
+		history, err := userData.FindLoginHistory(1, 20)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(2), history.Total, "Recent events should be kept")
+	})
+}
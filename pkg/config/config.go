@@ -1,15 +1,108 @@
 package config
 
 import (
+	"math"
 	"os"
+	"runtime"
 	"strconv"
+	"strings"
 )
 
 type Config struct {
-	accessTokenSecret  []byte
-	accessTokenExpiry  int
-	refreshTokenSecret []byte
-	refreshTokenExpiry int
+	accessTokenSecret    []byte
+	accessTokenExpiry    int
+	refreshTokenSecret   []byte
+	refreshTokenExpiry   int
+	loginMaxFailures     int
+	loginFailureWindow   int
+	loginLockoutDuration int
+	loginLockoutMax      int
+	rateLimitStore       string
+	hashPoolWorkers      int
+	hashPoolQueueDepth   int
+	passwordHashAlgo     string
+	bcryptCost           int
+	argon2Memory         int
+	argon2Iterations     int
+	argon2Parallelism    int
+	argon2MaxMemory      int
+	firebaseSignerKey    string
+	firebaseSaltSep      string
+	firebaseRounds       int
+	firebaseMemCost      int
+	passwordPolicy       passwordPolicyConfig
+	recentAuthMaxAge     int
+	appBaseURL           string
+	emailChangeExpiry    int
+	deletionGracePeriod  int
+	reactivationEmail    bool
+	reactivationExpiry   int
+	signupVerification   bool
+	verificationExpiry   int
+	accountStatusTTL     int
+	openSignup           bool
+	invitationExpiry     int
+	impersonationExpiry  int
+	cookieSecure         bool
+	cookieSameSite       string
+	cookieDomain         string
+	cookieAccessToken    bool
+	newDeviceNotify      bool
+	loginHistoryDays     int
+	notificationWebhook  string
+	riskBasedAuth        bool
+	riskChallengeScore   int
+	riskBlockScore       int
+	riskMaxTravelSpeed   int
+	geoIPDatabase        string
+	ipReputationLists    string
+	loginChallengeExpiry int
+	trustedProxies       string
+	ipPolicyReload       int
+	federationProviders  string
+	federationCallback   string
+	federationExpiry     int
+	credentialVerifiers  string
+	samlTenants          string
+	samlSPCertificate    string
+	samlSPKey            string
+	samlBaseURL          string
+	scimTenants          string
+	forwardAuthCacheTTL  int
+	extAuthzAddr         string
+	ldap                 ldapConfig
+	smtpHost             string
+	smtpPort             string
+	smtpUser             string
+	smtpPassword         string
+	mailFrom             string
+}
+
+type ldapConfig struct {
+	url            string
+	startTLS       bool
+	bindDN         string
+	bindPassword   string
+	baseDN         string
+	userFilter     string
+	emailAttribute string
+	nameAttribute  string
+	groupAttribute string
+	groupRoles     string
+	timeout        int
+}
+
+type passwordPolicyConfig struct {
+	minLength        int
+	maxLength        int
+	requireUpper     bool
+	requireLower     bool
+	requireDigit     bool
+	requireSymbol    bool
+	disallowEmail    bool
+	historySize      int
+	breachedDir      string
+	breachedMinCount int
 }
 
 func (c *Config) GetAccessTokenSecret() []byte {
@@ -25,8 +118,465 @@ func (c *Config) GetRefreshTokenExpiry() int {
 	return c.refreshTokenExpiry
 }
 
+// GetLoginMaxFailures returns the number of failed logins within the failure
+// window after which an account gets locked.
+func (c *Config) GetLoginMaxFailures() int {
+	return c.loginMaxFailures
+}
+
+// GetLoginFailureWindow returns the window in minutes in which failed logins are counted.
+func (c *Config) GetLoginFailureWindow() int {
+	return c.loginFailureWindow
+}
+
+// GetLoginLockoutDuration returns the first lockout duration in minutes,
+// every following lockout doubles it.
+func (c *Config) GetLoginLockoutDuration() int {
+	return c.loginLockoutDuration
+}
+
+// GetLoginLockoutMax returns the upper bound in minutes of a progressive lockout.
+func (c *Config) GetLoginLockoutMax() int {
+	return c.loginLockoutMax
+}
+
+// GetRateLimitStore returns where rate limit buckets are kept, either
+// "memory" for a single instance or "database" to share them between instances.
+func (c *Config) GetRateLimitStore() string {
+	return c.rateLimitStore
+}
+
+// GetHashPoolWorkers returns how many passwords can be hashed in parallel,
+// at least 1, without workers every hashing request would wait until it is
+// cancelled.
+func (c *Config) GetHashPoolWorkers() int {
+	return max(c.hashPoolWorkers, 1)
+}
+
+// GetHashPoolQueueDepth returns how many hashing requests may wait for a
+// worker before new ones are rejected, 0 rejects all requests which do not
+// find an idle worker.
+func (c *Config) GetHashPoolQueueDepth() int {
+	return max(c.hashPoolQueueDepth, 0)
+}
+
+// GetPasswordHashAlgorithm returns the algorithm new passwords are hashed
+// with, either "argon2id" or "bcrypt".
+func (c *Config) GetPasswordHashAlgorithm() string {
+	return c.passwordHashAlgo
+}
+func (c *Config) GetBcryptCost() int {
+	return c.bcryptCost
+}
+
+// GetArgon2Memory returns the argon2id memory parameter in KiB, at least 8.
+func (c *Config) GetArgon2Memory() int {
+	return max(c.argon2Memory, 8)
+}
+func (c *Config) GetArgon2Iterations() int {
+	return max(c.argon2Iterations, 1)
+}
+
+// GetArgon2Parallelism returns the argon2id parallelism between 1 and 255.
+func (c *Config) GetArgon2Parallelism() int {
+	return min(max(c.argon2Parallelism, 1), math.MaxUint8)
+}
+
+// GetArgon2MaxMemory returns in KiB how much memory the stored argon2id hash
+// of a password may demand to be verified, hashes asking for more are
+// rejected.
+func (c *Config) GetArgon2MaxMemory() int {
+	return max(c.argon2MaxMemory, 8)
+}
+
+// GetFirebaseSignerKey returns the base64 signer key of the hash parameters
+// of the Firebase project users are imported from.
+func (c *Config) GetFirebaseSignerKey() string {
+	return c.firebaseSignerKey
+}
+func (c *Config) GetFirebaseSaltSeparator() string {
+	return c.firebaseSaltSep
+}
+func (c *Config) GetFirebaseRounds() int {
+	return c.firebaseRounds
+}
+func (c *Config) GetFirebaseMemCost() int {
+	return c.firebaseMemCost
+}
+
+func (c *Config) GetPasswordMinLength() int {
+	return c.passwordPolicy.minLength
+}
+func (c *Config) GetPasswordMaxLength() int {
+	return c.passwordPolicy.maxLength
+}
+func (c *Config) GetPasswordRequireUpper() bool {
+	return c.passwordPolicy.requireUpper
+}
+func (c *Config) GetPasswordRequireLower() bool {
+	return c.passwordPolicy.requireLower
+}
+func (c *Config) GetPasswordRequireDigit() bool {
+	return c.passwordPolicy.requireDigit
+}
+func (c *Config) GetPasswordRequireSymbol() bool {
+	return c.passwordPolicy.requireSymbol
+}
+func (c *Config) GetPasswordDisallowEmail() bool {
+	return c.passwordPolicy.disallowEmail
+}
+
+// GetPasswordHistorySize returns how many previous passwords can not be reused.
+func (c *Config) GetPasswordHistorySize() int {
+	return c.passwordPolicy.historySize
+}
+
+// GetBreachedPasswordsDir returns the directory of the local HIBP range
+// files, the breached password check is disabled when it is empty.
+func (c *Config) GetBreachedPasswordsDir() string {
+	return c.passwordPolicy.breachedDir
+}
+
+// GetBreachedPasswordsMinCount returns how often a password must have been
+// seen in breaches before it is rejected.
+func (c *Config) GetBreachedPasswordsMinCount() int {
+	return c.passwordPolicy.breachedMinCount
+}
+
+// GetRecentAuthMaxAge returns in minutes how long ago a user may have entered
+// their credentials to still be allowed to do sensitive changes.
+func (c *Config) GetRecentAuthMaxAge() int {
+	return c.recentAuthMaxAge
+}
+
+// GetAppBaseURL returns the public URL links in emails are built from.
+func (c *Config) GetAppBaseURL() string {
+	return c.appBaseURL
+}
+
+// GetEmailChangeExpiry returns in minutes how long an email change can be confirmed.
+func (c *Config) GetEmailChangeExpiry() int {
+	return c.emailChangeExpiry
+}
+
+// GetDeletionGracePeriod returns in days how long a deleted account is kept
+// before it is erased for good.
+func (c *Config) GetDeletionGracePeriod() int {
+	return c.deletionGracePeriod
+}
+
+// GetReactivationRequireEmail tells if a reactivation has to be confirmed from the email of the user.
+func (c *Config) GetReactivationRequireEmail() bool {
+	return c.reactivationEmail
+}
+
+// GetReactivationExpiry returns in minutes how long a reactivation can be confirmed.
+func (c *Config) GetReactivationExpiry() int {
+	return c.reactivationExpiry
+}
+
+// GetSignupRequireVerification tells if users who sign up stay pending until
+// they have verified their email.
+func (c *Config) GetSignupRequireVerification() bool {
+	return c.signupVerification
+}
+
+// SetSignupRequireVerification overrides SIGNUP_REQUIRE_VERIFICATION, e.g. in tests.
+func (c *Config) SetSignupRequireVerification(require bool) {
+	c.signupVerification = require
+}
+
+// GetSignupVerificationExpiry returns in minutes how long the email of a
+// signup can be verified.
+func (c *Config) GetSignupVerificationExpiry() int {
+	return c.verificationExpiry
+}
+
+// GetAccountStatusCacheTTL returns in seconds how long the auth middleware
+// caches the account status of a user.
+func (c *Config) GetAccountStatusCacheTTL() int {
+	return c.accountStatusTTL
+}
+
+// GetOpenSignup tells if anyone can sign up, otherwise users have to be invited.
+func (c *Config) GetOpenSignup() bool {
+	return c.openSignup
+}
+
+// SetOpenSignup overrides OPEN_SIGNUP, e.g. in tests.
+func (c *Config) SetOpenSignup(open bool) {
+	c.openSignup = open
+}
+
+// GetInvitationExpiry returns in minutes how long an invitation can be accepted.
+func (c *Config) GetInvitationExpiry() int {
+	return c.invitationExpiry
+}
+
+// GetImpersonationExpiry returns in minutes how long an impersonation token is valid.
+func (c *Config) GetImpersonationExpiry() int {
+	return c.impersonationExpiry
+}
+
+// GetCookieSecure tells if session cookies are only sent over HTTPS.
+func (c *Config) GetCookieSecure() bool {
+	return c.cookieSecure
+}
+
+// GetCookieSameSite returns the SameSite mode of session cookies, strict, lax or none.
+func (c *Config) GetCookieSameSite() string {
+	return c.cookieSameSite
+}
+
+// GetCookieDomain returns the domain session cookies are set for, empty for
+// the host of the request.
+func (c *Config) GetCookieDomain() string {
+	return c.cookieDomain
+}
+
+// GetCookieAccessToken tells if the access token of a cookie session is set
+// in a cookie too instead of being returned in the response body.
+func (c *Config) GetCookieAccessToken() bool {
+	return c.cookieAccessToken
+}
+
+// GetNewDeviceNotification tells if users are notified of logins from a new device.
+func (c *Config) GetNewDeviceNotification() bool {
+	return c.newDeviceNotify
+}
+
+// GetLoginHistoryRetention returns in days how long login events are kept,
+// 0 keeps them forever.
+func (c *Config) GetLoginHistoryRetention() int {
+	return max(c.loginHistoryDays, 0)
+}
+
+// GetNotificationWebhookURL returns the URL notifications are posted to
+// instead of being emailed to the user, empty for email.
+func (c *Config) GetNotificationWebhookURL() string {
+	return c.notificationWebhook
+}
+
+// GetRiskBasedAuth tells if logins are scored and suspicious ones are
+// challenged or blocked.
+func (c *Config) GetRiskBasedAuth() bool {
+	return c.riskBasedAuth
+}
+
+// GetRiskChallengeScore returns the risk score from which a login has to be
+// confirmed with a code sent by email.
+func (c *Config) GetRiskChallengeScore() int {
+	return c.riskChallengeScore
+}
+
+// GetRiskBlockScore returns the risk score from which a login is blocked.
+func (c *Config) GetRiskBlockScore() int {
+	return c.riskBlockScore
+}
+
+// GetRiskMaxTravelSpeed returns in km/h how fast a user can plausibly travel
+// between two logins.
+func (c *Config) GetRiskMaxTravelSpeed() int {
+	return c.riskMaxTravelSpeed
+}
+
+// GetGeoIPDatabase returns the path of the MaxMind format GeoIP database,
+// empty if there is none.
+func (c *Config) GetGeoIPDatabase() string {
+	return c.geoIPDatabase
+}
+
+// GetIPReputationLists returns the comma separated paths of the lists of
+// IP addresses and networks known for abuse.
+func (c *Config) GetIPReputationLists() string {
+	return c.ipReputationLists
+}
+
+// GetLoginChallengeExpiry returns in minutes how long the code of a
+// challenged login is valid.
+func (c *Config) GetLoginChallengeExpiry() int {
+	return c.loginChallengeExpiry
+}
+
+// GetTrustedProxies returns the comma separated IP addresses and networks of
+// the proxies whose X-Forwarded-For header is trusted.
+func (c *Config) GetTrustedProxies() string {
+	return c.trustedProxies
+}
+
+// GetIPPolicyReloadInterval returns in seconds how often the IP rules are
+// reloaded, so that changes made by other instances take effect.
+func (c *Config) GetIPPolicyReloadInterval() int {
+	return c.ipPolicyReload
+}
+
+// GetFederationProviders returns the path of the JSON file configuring the
+// upstream identity providers of social login.
+func (c *Config) GetFederationProviders() string {
+	return c.federationProviders
+}
+
+// GetFederationCallbackURL returns the URL the identity providers redirect
+// back to, the name of the provider and /callback are appended. It defaults
+// to the federation routes under APP_BASE_URL.
+func (c *Config) GetFederationCallbackURL() string {
+	if c.federationCallback == "" {
+		return strings.TrimSuffix(c.appBaseURL, "/") + "/api/v1/auth/federation"
+	}
+	return c.federationCallback
+}
+
+// GetFederationStateExpiry returns in minutes how long a social login can
+// take at the identity provider.
+func (c *Config) GetFederationStateExpiry() int {
+	return c.federationExpiry
+}
+
+// GetCredentialVerifiers returns the comma separated verifiers which check
+// the passwords of logins in order, database and ldap.
+func (c *Config) GetCredentialVerifiers() string {
+	return c.credentialVerifiers
+}
+
+// GetLDAPURL returns the ldap:// or ldaps:// URL of the directory server.
+func (c *Config) GetLDAPURL() string {
+	return c.ldap.url
+}
+
+// GetLDAPStartTLS tells if ldap:// connections are upgraded with StartTLS.
+func (c *Config) GetLDAPStartTLS() bool {
+	return c.ldap.startTLS
+}
+
+// GetLDAPBindDN returns the DN of the service account searching for users.
+func (c *Config) GetLDAPBindDN() string {
+	return c.ldap.bindDN
+}
+func (c *Config) GetLDAPBindPassword() string {
+	return c.ldap.bindPassword
+}
+
+// GetLDAPBaseDN returns the DN under which users are searched.
+func (c *Config) GetLDAPBaseDN() string {
+	return c.ldap.baseDN
+}
+
+// GetLDAPUserFilter returns the search filter of a user, %s is replaced by
+// the escaped email of the login.
+func (c *Config) GetLDAPUserFilter() string {
+	return c.ldap.userFilter
+}
+func (c *Config) GetLDAPEmailAttribute() string {
+	return c.ldap.emailAttribute
+}
+func (c *Config) GetLDAPNameAttribute() string {
+	return c.ldap.nameAttribute
+}
+
+// GetLDAPGroupAttribute returns the attribute of a user listing the DNs of
+// its groups, memberOf in Active Directory.
+func (c *Config) GetLDAPGroupAttribute() string {
+	return c.ldap.groupAttribute
+}
+
+// GetLDAPGroupRoles returns the semicolon separated role=group DN pairs
+// mapping directory groups to roles.
+func (c *Config) GetLDAPGroupRoles() string {
+	return c.ldap.groupRoles
+}
+
+// GetLDAPTimeout returns in seconds how long a request to the directory may take.
+func (c *Config) GetLDAPTimeout() int {
+	return c.ldap.timeout
+}
+
+// GetSAMLTenants returns the path of the JSON file configuring the SAML
+// identity providers of the tenants.
+func (c *Config) GetSAMLTenants() string {
+	return c.samlTenants
+}
+
+// GetSAMLSPCertificate returns the path of the PEM certificate of the
+// service provider, published in its metadata.
+func (c *Config) GetSAMLSPCertificate() string {
+	return c.samlSPCertificate
+}
+
+// GetSAMLSPKey returns the path of the PEM private key which signs the
+// AuthnRequests and decrypts encrypted assertions.
+func (c *Config) GetSAMLSPKey() string {
+	return c.samlSPKey
+}
+
+// GetSAMLBaseURL returns the URL of the SAML routes, the name of the tenant
+// and /metadata or /acs are appended. It defaults to the SAML routes under
+// APP_BASE_URL.
+func (c *Config) GetSAMLBaseURL() string {
+	if c.samlBaseURL == "" {
+		return strings.TrimSuffix(c.appBaseURL, "/") + "/api/v1/auth/saml"
+	}
+	return c.samlBaseURL
+}
+
+// GetSCIMTenants returns the path of the JSON file configuring the tenants
+// which provision users through SCIM and their bearer tokens.
+func (c *Config) GetSCIMTenants() string {
+	return c.scimTenants
+}
+
+// GetForwardAuthCacheTTL returns in seconds how long the forward auth
+// endpoint remembers an accepted token, 0 verifies every request.
+func (c *Config) GetForwardAuthCacheTTL() int {
+	return c.forwardAuthCacheTTL
+}
+
+// GetExtAuthzAddr returns the address the Envoy ext_authz gRPC server listens
+// on, e.g. :9191, empty disables it.
+func (c *Config) GetExtAuthzAddr() string {
+	return c.extAuthzAddr
+}
+func (c *Config) GetSMTPHost() string {
+	return c.smtpHost
+}
+func (c *Config) GetSMTPPort() string {
+	return c.smtpPort
+}
+func (c *Config) GetSMTPUser() string {
+	return c.smtpUser
+}
+func (c *Config) GetSMTPPassword() string {
+	return c.smtpPassword
+}
+func (c *Config) GetMailFrom() string {
+	return c.mailFrom
+}
+
 var config *Config
 
+func getEnvInt(key string, defaultValue int) int {
+	value, err := strconv.Atoi(os.Getenv(key))
+	if err != nil {
+		return defaultValue
+	}
+	return value
+}
+
+func getEnvBool(key string, defaultValue bool) bool {
+	value, err := strconv.ParseBool(os.Getenv(key))
+	if err != nil {
+		return defaultValue
+	}
+	return value
+}
+
+func getEnvString(key string, defaultValue string) string {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	return value
+}
+
 func GetConfig() *Config {
 	if config != nil {
 		return config
@@ -41,10 +591,96 @@ func GetConfig() *Config {
 	}
 
 	config = &Config{
-		accessTokenSecret:  []byte(os.Getenv("ACCESS_TKN_SECRET")),
-		refreshTokenSecret: []byte(os.Getenv("REFRESH_TKN_SECRET")),
-		accessTokenExpiry:  accessTknExp,
-		refreshTokenExpiry: refreshTknExp,
+		accessTokenSecret:    []byte(os.Getenv("ACCESS_TKN_SECRET")),
+		refreshTokenSecret:   []byte(os.Getenv("REFRESH_TKN_SECRET")),
+		accessTokenExpiry:    accessTknExp,
+		refreshTokenExpiry:   refreshTknExp,
+		loginMaxFailures:     getEnvInt("LOGIN_MAX_FAILURES", 5),
+		loginFailureWindow:   getEnvInt("LOGIN_FAILURE_WINDOW", 15),
+		loginLockoutDuration: getEnvInt("LOGIN_LOCKOUT_DURATION", 15),
+		loginLockoutMax:      getEnvInt("LOGIN_LOCKOUT_MAX", 1440),
+		rateLimitStore:       os.Getenv("RATE_LIMIT_STORE"),
+		hashPoolWorkers:      getEnvInt("HASH_POOL_WORKERS", runtime.NumCPU()),
+		hashPoolQueueDepth:   getEnvInt("HASH_POOL_QUEUE_DEPTH", 64),
+		passwordHashAlgo:     os.Getenv("PASSWORD_HASH_ALGORITHM"),
+		bcryptCost:           getEnvInt("BCRYPT_COST", 10),
+		argon2Memory:         getEnvInt("ARGON2_MEMORY", 64*1024),
+		argon2Iterations:     getEnvInt("ARGON2_ITERATIONS", 3),
+		argon2Parallelism:    getEnvInt("ARGON2_PARALLELISM", 2),
+		argon2MaxMemory:      getEnvInt("ARGON2_MAX_MEMORY", 256*1024),
+		firebaseSignerKey:    os.Getenv("FIREBASE_SIGNER_KEY"),
+		firebaseSaltSep:      os.Getenv("FIREBASE_SALT_SEPARATOR"),
+		firebaseRounds:       getEnvInt("FIREBASE_ROUNDS", 8),
+		firebaseMemCost:      getEnvInt("FIREBASE_MEM_COST", 14),
+		recentAuthMaxAge:     getEnvInt("RECENT_AUTH_MAX_AGE", 15),
+		appBaseURL:           getEnvString("APP_BASE_URL", "http://localhost:8080"),
+		emailChangeExpiry:    getEnvInt("EMAIL_CHANGE_EXPIRY", 1440),
+		deletionGracePeriod:  getEnvInt("ACCOUNT_DELETION_GRACE_PERIOD", 30),
+		reactivationEmail:    getEnvBool("REACTIVATION_REQUIRE_EMAIL", false),
+		reactivationExpiry:   getEnvInt("REACTIVATION_EXPIRY", 60),
+		signupVerification:   getEnvBool("SIGNUP_REQUIRE_VERIFICATION", false),
+		verificationExpiry:   getEnvInt("SIGNUP_VERIFICATION_EXPIRY", 1440),
+		accountStatusTTL:     getEnvInt("ACCOUNT_STATUS_CACHE_TTL", 30),
+		openSignup:           getEnvBool("OPEN_SIGNUP", true),
+		invitationExpiry:     getEnvInt("INVITATION_EXPIRY", 10080),
+		impersonationExpiry:  getEnvInt("IMPERSONATION_EXPIRY", 15),
+		cookieSecure:         getEnvBool("COOKIE_SECURE", true),
+		cookieSameSite:       getEnvString("COOKIE_SAMESITE", "strict"),
+		cookieDomain:         os.Getenv("COOKIE_DOMAIN"),
+		cookieAccessToken:    getEnvBool("COOKIE_ACCESS_TOKEN", false),
+		newDeviceNotify:      getEnvBool("NEW_DEVICE_NOTIFICATION", true),
+		loginHistoryDays:     getEnvInt("LOGIN_HISTORY_RETENTION", 90),
+		notificationWebhook:  os.Getenv("NOTIFICATION_WEBHOOK_URL"),
+		riskBasedAuth:        getEnvBool("RISK_BASED_AUTH", false),
+		riskChallengeScore:   getEnvInt("RISK_CHALLENGE_SCORE", 40),
+		riskBlockScore:       getEnvInt("RISK_BLOCK_SCORE", 80),
+		riskMaxTravelSpeed:   getEnvInt("RISK_MAX_TRAVEL_SPEED", 1000),
+		geoIPDatabase:        os.Getenv("GEOIP_DATABASE"),
+		ipReputationLists:    os.Getenv("IP_REPUTATION_LISTS"),
+		loginChallengeExpiry: getEnvInt("LOGIN_CHALLENGE_EXPIRY", 10),
+		trustedProxies:       os.Getenv("TRUSTED_PROXIES"),
+		ipPolicyReload:       getEnvInt("IP_POLICY_RELOAD_INTERVAL", 30),
+		federationProviders:  os.Getenv("FEDERATION_PROVIDERS"),
+		federationCallback:   os.Getenv("FEDERATION_CALLBACK_URL"),
+		federationExpiry:     getEnvInt("FEDERATION_STATE_EXPIRY", 10),
+		credentialVerifiers:  getEnvString("CREDENTIAL_VERIFIERS", "database"),
+		samlTenants:          os.Getenv("SAML_TENANTS"),
+		samlSPCertificate:    os.Getenv("SAML_SP_CERTIFICATE"),
+		samlSPKey:            os.Getenv("SAML_SP_KEY"),
+		samlBaseURL:          os.Getenv("SAML_BASE_URL"),
+		scimTenants:          os.Getenv("SCIM_TENANTS"),
+		forwardAuthCacheTTL:  getEnvInt("FORWARD_AUTH_CACHE_TTL", 10),
+		extAuthzAddr:         os.Getenv("EXT_AUTHZ_ADDR"),
+		smtpHost:             os.Getenv("SMTP_HOST"),
+		smtpPort:             getEnvString("SMTP_PORT", "587"),
+		smtpUser:             os.Getenv("SMTP_USER"),
+		smtpPassword:         os.Getenv("SMTP_PASSWORD"),
+		mailFrom:             getEnvString("MAIL_FROM", "no-reply@localhost"),
+		ldap: ldapConfig{
+			url:            os.Getenv("LDAP_URL"),
+			startTLS:       getEnvBool("LDAP_START_TLS", false),
+			bindDN:         os.Getenv("LDAP_BIND_DN"),
+			bindPassword:   os.Getenv("LDAP_BIND_PASSWORD"),
+			baseDN:         os.Getenv("LDAP_BASE_DN"),
+			userFilter:     getEnvString("LDAP_USER_FILTER", "(&(objectClass=person)(mail=%s))"),
+			emailAttribute: getEnvString("LDAP_EMAIL_ATTRIBUTE", "mail"),
+			nameAttribute:  getEnvString("LDAP_NAME_ATTRIBUTE", "displayName"),
+			groupAttribute: getEnvString("LDAP_GROUP_ATTRIBUTE", "memberOf"),
+			groupRoles:     os.Getenv("LDAP_GROUP_ROLES"),
+			timeout:        getEnvInt("LDAP_TIMEOUT", 5),
+		},
+		passwordPolicy: passwordPolicyConfig{
+			minLength:        getEnvInt("PASSWORD_MIN_LENGTH", 8),
+			maxLength:        getEnvInt("PASSWORD_MAX_LENGTH", 128),
+			requireUpper:     getEnvBool("PASSWORD_REQUIRE_UPPER", false),
+			requireLower:     getEnvBool("PASSWORD_REQUIRE_LOWER", false),
+			requireDigit:     getEnvBool("PASSWORD_REQUIRE_DIGIT", false),
+			requireSymbol:    getEnvBool("PASSWORD_REQUIRE_SYMBOL", false),
+			disallowEmail:    getEnvBool("PASSWORD_DISALLOW_EMAIL", true),
+			historySize:      getEnvInt("PASSWORD_HISTORY_SIZE", 5),
+			breachedDir:      os.Getenv("BREACHED_PASSWORDS_DIR"),
+			breachedMinCount: getEnvInt("BREACHED_PASSWORDS_MIN_COUNT", 1),
+		},
 	}
 	return config
 }
@@ -0,0 +1,203 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-auth-microservice/pkg/config"
+	authMiddleware "github.com/go-auth-microservice/pkg/middleware/auth"
+	auditmodel "github.com/go-auth-microservice/pkg/model/auditModel"
+	tokencache "github.com/go-auth-microservice/pkg/model/tokenCache"
+	usermodel "github.com/go-auth-microservice/pkg/model/userModel"
+	jwtauth "github.com/go-auth-microservice/pkg/utils/jwtAuth"
+	"github.com/go-auth-microservice/pkg/utils/logger"
+	"github.com/go-auth-microservice/pkg/utils/validation"
+	"github.com/go-chi/chi/v5"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func UnlockUser(w http.ResponseWriter, r *http.Request) {
+	log := logger.InitializeAuditLogger()
+	adminId := authMiddleware.GetUserID(r.Context())
+	userId, err := strconv.ParseUint(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid user id", http.StatusBadRequest)
+		return
+	}
+	var userData usermodel.UserLockout
+	userData, err = usermodel.FindUserByID(userId)
+	if err != nil {
+		http.Error(w, "user not found", http.StatusNotFound)
+		log.Errorf("unable to find user with ID %v %v", userId, err)
+		return
+	}
+	err = userData.Unlock()
+	if errors.Is(err, usermodel.ErrStatusChanged) {
+		http.Error(w, "account status has been changed in the meantime, try again", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, "unable to unlock user", http.StatusInternalServerError)
+		log.Errorf("unable to unlock user %v %v", userId, err)
+		return
+	}
+	recordActorAuditEvent(r, userId, adminId, auditmodel.ActionAccountUnlocked, nil)
+	if _, err := w.Write([]byte("user has been unlocked")); err != nil {
+		log.Errorf("unable to write response %s", err)
+	}
+	log.Infof("user %v has been unlocked by admin %v", userId, adminId)
+}
+
+type suspension struct {
+	Reason string     `json:"reason" validate:"required,max=500"`
+	Until  *time.Time `json:"until"`
+}
+
+// SuspendUser disables a user until the suspension expires or is lifted, the
+// tokens of the user are revoked. Suspended users can not reactivate themselves.
+func SuspendUser(w http.ResponseWriter, r *http.Request) {
+	log := logger.InitializeAuditLogger()
+	adminId := authMiddleware.GetUserID(r.Context())
+	userId, err := strconv.ParseUint(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid user id", http.StatusBadRequest)
+		return
+	}
+	var data suspension
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	if err := validation.Validator.Struct(data); err != nil {
+		http.Error(w, "a reason is required", http.StatusBadRequest)
+		return
+	}
+	if data.Until != nil && !data.Until.After(time.Now()) {
+		http.Error(w, "until must be in the future", http.StatusBadRequest)
+		return
+	}
+	if userId == adminId {
+		http.Error(w, "admins can not suspend themselves", http.StatusBadRequest)
+		return
+	}
+	user, err := usermodel.FindUserByID(userId)
+	if err != nil {
+		http.Error(w, "user not found", http.StatusNotFound)
+		log.Errorf("unable to find user with ID %v %v", userId, err)
+		return
+	}
+	var userData usermodel.UserStatus = user
+	if err := userData.Suspend(data.Reason, data.Until); err != nil {
+		http.Error(w, "user can not be suspended", http.StatusConflict)
+		log.Errorf("unable to suspend user %v with account status %s", userId, userData.GetAccountState().Status)
+		return
+	}
+	if err := userData.Save(); err != nil {
+		http.Error(w, "unable to suspend user", http.StatusInternalServerError)
+		log.Errorf("unable to suspend user %v %v", userId, err)
+		return
+	}
+	var revokedUsers tokencache.RevokedUsers = tokencache.GetRevokedUserTokens()
+	revokedUsers.Revoke(userId, time.Now())
+	recordActorAuditEvent(r, userId, adminId, auditmodel.ActionAccountSuspended, map[string]interface{}{"reason": data.Reason, "until": data.Until})
+	if err := json.NewEncoder(w).Encode(userData.GetAccountState()); err != nil {
+		log.Errorf("unable to encode json response %s", err)
+	}
+	log.Infof("user %v has been suspended by admin %v", userId, adminId)
+}
+
+// UnsuspendUser lifts the suspension of a user.
+func UnsuspendUser(w http.ResponseWriter, r *http.Request) {
+	log := logger.InitializeAuditLogger()
+	adminId := authMiddleware.GetUserID(r.Context())
+	userId, err := strconv.ParseUint(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid user id", http.StatusBadRequest)
+		return
+	}
+	user, err := usermodel.FindUserByID(userId)
+	if err != nil {
+		http.Error(w, "user not found", http.StatusNotFound)
+		log.Errorf("unable to find user with ID %v %v", userId, err)
+		return
+	}
+	var userData usermodel.UserStatus = user
+	if err := userData.Unsuspend(); err != nil {
+		http.Error(w, "user is not suspended", http.StatusConflict)
+		return
+	}
+	if err := userData.Save(); err != nil {
+		http.Error(w, "unable to unsuspend user", http.StatusInternalServerError)
+		log.Errorf("unable to unsuspend user %v %v", userId, err)
+		return
+	}
+	recordActorAuditEvent(r, userId, adminId, auditmodel.ActionAccountUnsuspended, nil)
+	if err := json.NewEncoder(w).Encode(userData.GetAccountState()); err != nil {
+		log.Errorf("unable to encode json response %s", err)
+	}
+	log.Infof("user %v has been unsuspended by admin %v", userId, adminId)
+}
+
+// ImpersonateUser issues a short lived access token for the user to an admin
+// reproducing an issue of the user. The act claim carries the id of the admin,
+// requests made with it are audited and sensitive routes are denied. Other
+// admins can not be impersonated.
+func ImpersonateUser(w http.ResponseWriter, r *http.Request) {
+	log := logger.InitializeAuditLogger()
+	adminId := authMiddleware.GetUserID(r.Context())
+	userId, err := strconv.ParseUint(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid user id", http.StatusBadRequest)
+		return
+	}
+	if userId == adminId {
+		http.Error(w, "admins can not impersonate themselves", http.StatusBadRequest)
+		return
+	}
+	var userData usermodel.UserLogin
+	userData, err = usermodel.FindUserByID(userId)
+	if err != nil {
+		http.Error(w, "user not found", http.StatusNotFound)
+		log.Errorf("unable to find user with ID %v %v", userId, err)
+		return
+	}
+	if userData.GetUserRole() == usermodel.RoleAdmin {
+		http.Error(w, "admins can not be impersonated", http.StatusForbidden)
+		log.Errorf("admin %v denied to impersonate admin %v", adminId, userId)
+		return
+	}
+	if state := userData.GetAccountState(); !state.AllowsSessions() {
+		http.Error(w, "user account is "+string(state.Status), http.StatusConflict)
+		return
+	}
+	expiresAt := time.Now().Add(time.Minute * time.Duration(config.GetConfig().GetImpersonationExpiry()))
+	claims := jwt.MapClaims{}
+	claims["userId"] = userData.GetUserID()
+	claims["role"] = userData.GetUserRole()
+	claims["auth_time"] = authMiddleware.GetAuthTime(r.Context()).Unix()
+	claims["act"] = map[string]interface{}{"sub": strconv.FormatUint(adminId, 10)}
+	accessToken, err := jwtauth.GetAccessTokenHandler().CreateTokenExpiringAt(claims, expiresAt)
+	if err != nil {
+		http.Error(w, "failed to generate token", http.StatusInternalServerError)
+		log.Error("error creating token ", err)
+		return
+	}
+	recordActorAuditEvent(r, userId, adminId, auditmodel.ActionImpersonationStarted, map[string]interface{}{"expiresAt": expiresAt})
+	res := map[string]interface{}{}
+	res["accesstoken"] = accessToken
+	res["expiresAt"] = expiresAt
+	if err := json.NewEncoder(w).Encode(res); err != nil {
+		log.Errorf("unable to encode json response %s", err)
+	}
+	log.Infof("admin %v started impersonating user %v", adminId, userId)
+}
+
+func GetHashPoolStats(w http.ResponseWriter, r *http.Request) {
+	log := logger.InitializeAuditLogger()
+	if err := json.NewEncoder(w).Encode(usermodel.GetHashPoolStats()); err != nil {
+		log.Errorf("unable to encode json response %s", err)
+	}
+}
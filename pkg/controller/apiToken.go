@@ -0,0 +1,123 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	authMiddleware "github.com/go-auth-microservice/pkg/middleware/auth"
+	auditmodel "github.com/go-auth-microservice/pkg/model/auditModel"
+	tokencache "github.com/go-auth-microservice/pkg/model/tokenCache"
+	usermodel "github.com/go-auth-microservice/pkg/model/userModel"
+	"github.com/go-auth-microservice/pkg/utils/logger"
+	"github.com/go-auth-microservice/pkg/utils/validation"
+	"github.com/go-chi/chi/v5"
+)
+
+type apiTokenRequest struct {
+	Name      string     `json:"name" validate:"required,max=100"`
+	Scopes    []string   `json:"scopes" validate:"required,min=1,dive,oneof=user:read user:write admin"`
+	ExpiresAt *time.Time `json:"expiresAt"`
+}
+
+// CreateAPIToken creates a personal access token of the user. The token is
+// only part of this response.
+func CreateAPIToken(w http.ResponseWriter, r *http.Request) {
+	log := logger.InitializeAuditLogger()
+	userId := authMiddleware.GetUserID(r.Context())
+	var data apiTokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	if err := validation.Validator.Struct(data); err != nil {
+		http.Error(w, "a name and valid scopes are required", http.StatusBadRequest)
+		return
+	}
+	if data.ExpiresAt != nil && !data.ExpiresAt.After(time.Now()) {
+		http.Error(w, "expiresAt must be in the future", http.StatusBadRequest)
+		return
+	}
+	user, err := usermodel.FindUserByID(userId)
+	if err != nil {
+		log.Errorf("unable to find user with ID %v %v", userId, err)
+		http.Error(w, "user not found", http.StatusUnauthorized)
+		return
+	}
+	apiToken, token, err := user.CreateAPIToken(data.Name, data.Scopes, data.ExpiresAt)
+	if errors.Is(err, usermodel.ErrInvalidScope) {
+		http.Error(w, "scope not allowed for the user", http.StatusForbidden)
+		return
+	}
+	if err != nil {
+		http.Error(w, "unable to create api token", http.StatusInternalServerError)
+		log.Errorf("unable to create api token for user %v %v", userId, err)
+		return
+	}
+	recordAuditEvent(r, userId, auditmodel.ActionAPITokenCreated, map[string]interface{}{"tokenId": apiToken.Id, "scopes": apiToken.Scopes})
+	res := map[string]interface{}{}
+	res["token"] = token
+	res["apiToken"] = apiToken
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(res); err != nil {
+		log.Errorf("unable to encode json response %s", err)
+	}
+	log.Infof("user %v created api token %v", userId, apiToken.Id)
+}
+
+// GetAPITokens lists the personal access tokens of the user which have not
+// been revoked.
+func GetAPITokens(w http.ResponseWriter, r *http.Request) {
+	log := logger.InitializeAuditLogger()
+	userId := authMiddleware.GetUserID(r.Context())
+	user, err := usermodel.FindUserByID(userId)
+	if err != nil {
+		log.Errorf("unable to find user with ID %v %v", userId, err)
+		http.Error(w, "user not found", http.StatusUnauthorized)
+		return
+	}
+	tokens, err := user.FindAPITokens()
+	if err != nil {
+		http.Error(w, "unable to find api tokens", http.StatusInternalServerError)
+		log.Errorf("unable to find api tokens of user %v %v", userId, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(tokens); err != nil {
+		log.Errorf("unable to encode json response %s", err)
+	}
+}
+
+// RevokeAPIToken revokes a personal access token of the user.
+func RevokeAPIToken(w http.ResponseWriter, r *http.Request) {
+	log := logger.InitializeAuditLogger()
+	userId := authMiddleware.GetUserID(r.Context())
+	tokenId, err := strconv.ParseUint(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid token id", http.StatusBadRequest)
+		return
+	}
+	user, err := usermodel.FindUserByID(userId)
+	if err != nil {
+		log.Errorf("unable to find user with ID %v %v", userId, err)
+		http.Error(w, "user not found", http.StatusUnauthorized)
+		return
+	}
+	err = user.RevokeAPIToken(tokenId)
+	if errors.Is(err, usermodel.ErrAPITokenNotFound) {
+		http.Error(w, "api token not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "unable to revoke api token", http.StatusInternalServerError)
+		log.Errorf("unable to revoke api token %v of user %v %v", tokenId, userId, err)
+		return
+	}
+	tokencache.GetVerifiedTokens().RemoveAPIToken(tokenId)
+	recordAuditEvent(r, userId, auditmodel.ActionAPITokenRevoked, map[string]interface{}{"tokenId": tokenId})
+	w.WriteHeader(http.StatusNoContent)
+	log.Infof("user %v revoked api token %v", userId, tokenId)
+}
@@ -0,0 +1,35 @@
+package controller
+
+import (
+	"net/http"
+
+	auditmodel "github.com/go-auth-microservice/pkg/model/auditModel"
+	clientip "github.com/go-auth-microservice/pkg/utils/clientIP"
+	"github.com/go-auth-microservice/pkg/utils/logger"
+)
+
+// recordAuditEvent stores an audit event about the user together with the
+// client of the request. A failure is only logged, it must not fail the request.
+func recordAuditEvent(r *http.Request, userId uint64, action string, details map[string]interface{}) {
+	recordActorAuditEvent(r, userId, 0, action, details)
+}
+
+// recordActorAuditEvent is recordAuditEvent for actions done by someone other
+// than the user, e.g. an admin.
+func recordActorAuditEvent(r *http.Request, userId uint64, actorId uint64, action string, details map[string]interface{}) {
+	event := &auditmodel.AuditEvent{
+		Action:    action,
+		IP:        clientip.FromRequest(r),
+		UserAgent: r.UserAgent(),
+		Details:   details,
+	}
+	if userId != 0 {
+		event.UserId = &userId
+	}
+	if actorId != 0 {
+		event.ActorId = &actorId
+	}
+	if err := auditmodel.Record(event); err != nil {
+		logger.InitializeAuditLogger().Errorf("unable to record audit event %v for user %v %v", action, userId, err)
+	}
+}
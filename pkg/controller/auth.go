@@ -2,24 +2,76 @@ package controller
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
 	"strconv"
+	"time"
 
+	"github.com/go-auth-microservice/pkg/config"
+	authMiddleware "github.com/go-auth-microservice/pkg/middleware/auth"
+	auditmodel "github.com/go-auth-microservice/pkg/model/auditModel"
+	tokencache "github.com/go-auth-microservice/pkg/model/tokenCache"
 	usermodel "github.com/go-auth-microservice/pkg/model/userModel"
 	jwtauth "github.com/go-auth-microservice/pkg/utils/jwtAuth"
 	"github.com/go-auth-microservice/pkg/utils/logger"
+	passwordpolicy "github.com/go-auth-microservice/pkg/utils/passwordPolicy"
 	"github.com/go-auth-microservice/pkg/utils/validation"
 	"github.com/golang-jwt/jwt/v5"
 )
 
 type userSignup struct {
 	Email    string `json:"email" validate:"required,email"`
-	Password string `json:"password" validate:"required,min=8"`
+	Password string `json:"password" validate:"required"`
+}
+
+type userLogin struct {
+	userSignup
+	Cookies bool `json:"cookies"`
+}
+
+// setSessionCookies hands the tokens of a browser session out in HttpOnly
+// cookies instead of the response body, together with a new CSRF token.
+func setSessionCookies(w http.ResponseWriter, res map[string]interface{}, accessToken string, refreshToken string) error {
+	authMiddleware.SetRefreshTokenCookie(w, refreshToken)
+	if config.GetConfig().GetCookieAccessToken() {
+		authMiddleware.SetAccessTokenCookie(w, accessToken)
+	} else {
+		res["accesstoken"] = accessToken
+	}
+	csrfToken, err := authMiddleware.SetCSRFTokenCookie(w)
+	if err != nil {
+		return err
+	}
+	res["csrftoken"] = csrfToken
+	return nil
+}
+
+// hashingUnavailable tells the client to back off while password hashing is overloaded
+func hashingUnavailable(w http.ResponseWriter) {
+	w.Header().Set("Retry-After", "1")
+	http.Error(w, "service is busy, please retry later", http.StatusServiceUnavailable)
+}
+
+// passwordPolicyViolated answers with the reasons why a password was rejected
+func passwordPolicyViolated(w http.ResponseWriter, violations []passwordpolicy.Violation) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	res := map[string]interface{}{}
+	res["error"] = "password does not meet the password policy"
+	res["violations"] = violations
+	if err := json.NewEncoder(w).Encode(res); err != nil {
+		logger.InitializeAuditLogger().Errorf("unable to encode json response %s", err)
+	}
 }
 
 func Signup(w http.ResponseWriter, r *http.Request) {
 	var user userSignup
 	log := logger.InitializeAuditLogger()
+	if !config.GetConfig().GetOpenSignup() {
+		http.Error(w, "signup is by invitation only", http.StatusForbidden)
+		log.Error("signup attempt while open signup is disabled")
+		return
+	}
 	if err := json.NewDecoder(r.Body).Decode(&user); err != nil {
 		log.Error("invalid request body")
 		http.Error(w, "invalid request", http.StatusBadRequest)
@@ -30,8 +82,33 @@ func Signup(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Invalid request", http.StatusBadRequest)
 		return
 	}
-	var userData usermodel.UserSignUp = usermodel.CreateUser(user.Email)
-	if err := userData.SetPassword(user.Password); err != nil {
+	created := usermodel.CreateUser(user.Email)
+	pending := config.GetConfig().GetSignupRequireVerification()
+	if pending {
+		created.Status = usermodel.StatusPending
+	}
+	var userData usermodel.UserSignUp = created
+	violations, err := userData.CheckPasswordPolicy(r.Context(), user.Password)
+	if err != nil {
+		log.Error("password policy check failed ", err)
+		if errors.Is(err, usermodel.ErrHashingUnavailable) {
+			hashingUnavailable(w)
+			return
+		}
+		http.Error(w, "unable to craete user", http.StatusInternalServerError)
+		return
+	}
+	if len(violations) > 0 {
+		log.Error("password policy violated on signup")
+		passwordPolicyViolated(w, violations)
+		return
+	}
+	if err := userData.SetPassword(r.Context(), user.Password); err != nil {
+		if errors.Is(err, usermodel.ErrHashingUnavailable) {
+			log.Error("password encryption rejected ", err)
+			hashingUnavailable(w)
+			return
+		}
 		log.Error("password encryption failed")
 		http.Error(w, "unable to craete user", http.StatusBadRequest)
 		return
@@ -41,6 +118,16 @@ func Signup(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "email already exist", http.StatusConflict)
 		return
 	}
+	if err := userData.SavePasswordHistory(); err != nil {
+		log.Error("unable to save password history ", err)
+	}
+	if pending {
+		// a failed mail is sent again on the next login
+		if err := sendSignupVerification(created); err != nil {
+			log.Errorf("unable to send signup verification for user %v %v", created.Id, err)
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}
 	if err := json.NewEncoder(w).Encode(userData); err != nil {
 		log.Errorf("unable to encode json response %s", err)
 	}
@@ -48,7 +135,7 @@ func Signup(w http.ResponseWriter, r *http.Request) {
 }
 
 func Login(w http.ResponseWriter, r *http.Request) {
-	var user userSignup
+	var user userLogin
 	log := logger.InitializeAuditLogger()
 	if err := json.NewDecoder(r.Body).Decode(&user); err != nil {
 		log.Error("invalid request body")
@@ -60,26 +147,85 @@ func Login(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Invalid request", http.StatusBadRequest)
 		return
 	}
-	var userData usermodel.UserLogin
-	userData, err := usermodel.FindUserByEmail(user.Email)
+	// the local user is looked up for the lockout, directory users log in
+	// before they have one
+	stored, err := usermodel.FindUserByEmail(user.Email)
 	if err != nil {
-		log.Error(`user with email "%v" not found on DB`, user.Email, err)
+		stored = nil
+	}
+	// a locked account answers exactly like a wrong password so that the
+	// response does not reveal whether the account exists or is locked
+	if stored != nil && stored.IsLocked() {
+		usermodel.CompareDummyPassword(r.Context(), user.Password)
+		recordLogin(r, stored, usermodel.LoginMethodPassword, usermodel.LoginFailed)
+		log.Errorf("login attempt for locked user %v", stored.GetUserID())
 		http.Error(w, "invalid email or password", http.StatusUnauthorized)
 		return
 	}
-	err = userData.ValidatePassword(user.Password)
-	if err != nil {
-		log.Error("invalid login for user %v", user.Email)
+	verified, err := usermodel.VerifyCredentials(r.Context(), user.Email, user.Password, stored)
+	if errors.Is(err, usermodel.ErrHashingUnavailable) {
+		log.Errorf("password validation rejected for email %v %v", user.Email, err)
+		hashingUnavailable(w)
+		return
+	}
+	if errors.Is(err, usermodel.ErrUnknownCredentials) {
+		usermodel.CompareDummyPassword(r.Context(), user.Password)
+		log.Errorf(`user with email "%v" not found`, user.Email)
 		http.Error(w, "invalid email or password", http.StatusUnauthorized)
 		return
 	}
-	if !userData.GetUserStatus() {
-		http.Error(w, "user has been disabled plase contact admin", http.StatusUnauthorized)
-		log.Errorf("user %d has been disabled plase contact admin ", userData.GetUserID())
+	if errors.Is(err, usermodel.ErrInvalidCredentials) {
+		if stored != nil {
+			var userData usermodel.UserLogin = stored
+			if err := userData.RegisterFailedLogin(); err != nil {
+				log.Errorf("unable to record failed login for user %v %v", userData.GetUserID(), err)
+			}
+			recordAuditEvent(r, userData.GetUserID(), auditmodel.ActionLoginFailed, nil)
+			recordLogin(r, userData, usermodel.LoginMethodPassword, usermodel.LoginFailed)
+		}
+		log.Errorf("invalid login for user %v", user.Email)
+		http.Error(w, "invalid email or password", http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		log.Errorf("unable to verify credentials of %v %v", user.Email, err)
+		http.Error(w, "unable to verify credentials", http.StatusServiceUnavailable)
+		return
+	}
+	var userData usermodel.UserLogin = verified
+	if state := userData.GetAccountState(); !state.AllowsLogin() {
+		if state.Status == usermodel.StatusPending {
+			if err := sendSignupVerification(verified); err != nil {
+				log.Errorf("unable to send signup verification for user %v %v", userData.GetUserID(), err)
+			}
+		}
+		recordLogin(r, userData, usermodel.LoginMethodPassword, usermodel.LoginDenied)
+		authMiddleware.AccountUnavailable(w, state)
+		log.Errorf("login attempt for user %d with account status %s", userData.GetUserID(), state.Status)
+		return
+	}
+	if riskStopsLogin(w, r, userData, usermodel.LoginMethodPassword, user.Cookies) {
 		return
 	}
+	if userData.GetAuthSource() == usermodel.AuthSourceLocal {
+		if err := userData.RehashPassword(r.Context(), user.Password); err != nil {
+			log.Errorf("unable to rehash password for user %v %v", userData.GetUserID(), err)
+		}
+	}
+	completeLogin(w, r, userData, usermodel.LoginMethodPassword, user.Cookies)
+}
+
+// completeLogin issues the tokens of a user whose login has succeeded, in
+// cookies for a cookie session and in the response body otherwise.
+func completeLogin(w http.ResponseWriter, r *http.Request, userData usermodel.UserLogin, method string, cookies bool) {
+	log := logger.InitializeAuditLogger()
+	if err := userData.ResetFailedLogins(); err != nil {
+		log.Errorf("unable to reset failed logins for user %v %v", userData.GetUserID(), err)
+	}
 	claims := jwt.MapClaims{}
 	claims["userId"] = userData.GetUserID()
+	claims["role"] = userData.GetUserRole()
+	claims["auth_time"] = time.Now().Unix()
 	accessToken, err := jwtauth.GetAccessTokenHandler().CreateToken(claims)
 	if err != nil {
 		log.Error("error creating token ", err)
@@ -91,24 +237,45 @@ func Login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	res := map[string]interface{}{}
-	res["accesstoken"] = accessToken
-	res["refreshtoken"] = refreshToken
+	if cookies {
+		if err := setSessionCookies(w, res, accessToken, refreshToken); err != nil {
+			http.Error(w, "failed to generate token", http.StatusInternalServerError)
+			log.Error("error creating csrf token ", err)
+			return
+		}
+	} else {
+		res["accesstoken"] = accessToken
+		res["refreshtoken"] = refreshToken
+	}
+	recordAuditEvent(r, userData.GetUserID(), auditmodel.ActionLoginSucceeded, map[string]interface{}{"method": method})
+	recordLogin(r, userData, method, usermodel.LoginSucceeded)
 	if err := json.NewEncoder(w).Encode(res); err != nil {
 		log.Errorf("unable to encode json response %s", err)
 	}
-	log.Infof("user with ID %v and Email %v has loggedIn successfully", userData.GetUserID(), user.Email)
+	log.Infof("user with ID %v and Email %v has loggedIn successfully", userData.GetUserID(), userData.GetUserEmail())
 }
 
 func RefreshAccessToken(w http.ResponseWriter, r *http.Request) {
 	log := logger.InitializeAuditLogger()
-	refreshToken := r.Header.Get("RefreshToken")
+	refreshToken := authMiddleware.GetRefreshToken(r)
 	claim, err := jwtauth.GetRefreshTokenHandler().VerifyToken(refreshToken)
 	if err != nil {
 		http.Error(w, "invalid refresh token", http.StatusUnauthorized)
 		log.Error("invalid Refresh Token", err)
 		return
 	}
-	tokenCreatedAt, _ := claim["iat"].(float64)
+	var blackListedToken tokencache.BlackListedToken = tokencache.GetBlacklistTokenCache()
+	if blackListedToken.IsPresent(refreshToken) {
+		http.Error(w, "invalid refresh token", http.StatusUnauthorized)
+		log.Error("refresh token has been logged out")
+		return
+	}
+	tokenCreatedAt := jwtauth.IssuedAt(claim)
+	// refresh tokens issued before auth_time existed were created at login
+	authTime, ok := claim["auth_time"].(float64)
+	if !ok {
+		authTime = float64(tokenCreatedAt.Unix())
+	}
 	userId, ok := claim["userId"].(float64)
 	if !ok {
 		http.Error(w, "invalid refresh token", http.StatusUnauthorized)
@@ -122,35 +289,177 @@ func RefreshAccessToken(w http.ResponseWriter, r *http.Request) {
 		log.Error("refresh token denied")
 		return
 	}
-	if !userData.GetUserStatus() {
-		http.Error(w, "user has been disabled plase contact admin", http.StatusUnauthorized)
-		log.Errorf("user %d has been disabled plase contact admin ", userData.GetUserID())
+	if state := userData.GetAccountState(); !state.AllowsSessions() {
+		authMiddleware.AccountUnavailable(w, state)
+		log.Errorf("refresh token denied for user %d with account status %s", userData.GetUserID(), state.Status)
 		return
 	}
-	if int64(tokenCreatedAt) < userData.GetUserLastUpdated().Unix() {
+	if tokenCreatedAt.Before(userData.GetTokensValidAfter()) {
 		http.Error(w, "user has been updated please relogin", http.StatusUnauthorized)
 		log.Errorf("user %d has been updated please relogin", userData.GetUserID())
 		return
 	}
 	claims := jwt.MapClaims{}
 	claims["userId"] = userData.GetUserID()
+	claims["role"] = userData.GetUserRole()
+	claims["auth_time"] = int64(authTime)
 	accessToken, err := jwtauth.GetAccessTokenHandler().CreateToken(claims)
 	if err != nil {
 		http.Error(w, "failed to generate token", http.StatusInternalServerError)
 		log.Error("failed to generate token", err)
 	}
 	res := map[string]interface{}{}
-	res["accesstoken"] = accessToken
+	if authMiddleware.UsesSessionCookies(r) && config.GetConfig().GetCookieAccessToken() {
+		authMiddleware.SetAccessTokenCookie(w, accessToken)
+	} else {
+		res["accesstoken"] = accessToken
+	}
 	if err = json.NewEncoder(w).Encode(res); err != nil {
 		log.Errorf("unable to encode json response %s", err)
 	}
 	log.Info("refresh Token has been generated for user ID %v", userData.GetUserID())
 }
 
+// verifyCurrentPassword makes sure that the caller of a sensitive route knows
+// the password of the account and not only holds a token. Wrong passwords
+// count towards the account lockout like failed logins. Users without a
+// password, who log in with an identity provider, prove themselves by a
+// recent login instead.
+func verifyCurrentPassword(w http.ResponseWriter, r *http.Request, userData usermodel.UserLogin, password string) bool {
+	log := logger.InitializeAuditLogger()
+	if !userData.HasPassword() {
+		maxAge := time.Minute * time.Duration(config.GetConfig().GetRecentAuthMaxAge())
+		if time.Since(authMiddleware.GetAuthTime(r.Context())) > maxAge {
+			http.Error(w, "recent login with the identity provider required", http.StatusUnauthorized)
+			log.Errorf("user %v without password denied, authentication is older than %v", userData.GetUserID(), maxAge)
+			return false
+		}
+		return true
+	}
+	if password == "" {
+		http.Error(w, "current password is required", http.StatusBadRequest)
+		log.Errorf("current password missing for user %v", userData.GetUserID())
+		return false
+	}
+	if userData.IsLocked() {
+		usermodel.CompareDummyPassword(r.Context(), password)
+		http.Error(w, "invalid current password", http.StatusUnauthorized)
+		log.Errorf("current password check for locked user %v", userData.GetUserID())
+		return false
+	}
+	err := userData.VerifyPassword(r.Context(), password)
+	if errors.Is(err, usermodel.ErrHashingUnavailable) {
+		log.Errorf("password validation rejected for user %v %v", userData.GetUserID(), err)
+		hashingUnavailable(w)
+		return false
+	}
+	if err != nil && !errors.Is(err, usermodel.ErrInvalidCredentials) {
+		http.Error(w, "unable to verify password", http.StatusServiceUnavailable)
+		log.Errorf("unable to verify password of user %v %v", userData.GetUserID(), err)
+		return false
+	}
+	if err != nil {
+		if err := userData.RegisterFailedLogin(); err != nil {
+			log.Errorf("unable to record failed login for user %v %v", userData.GetUserID(), err)
+		}
+		http.Error(w, "invalid current password", http.StatusUnauthorized)
+		log.Errorf("invalid current password for user %v", userData.GetUserID())
+		return false
+	}
+	return true
+}
+
+// Reauthenticate issues new tokens with a fresh auth_time to a logged in user
+// who enters their password again, so that they can do sensitive changes.
+func Reauthenticate(w http.ResponseWriter, r *http.Request) {
+	log := logger.InitializeAuditLogger()
+	userId := authMiddleware.GetUserID(r.Context())
+	var data struct {
+		Password string `json:"password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	var userData usermodel.UserLogin
+	userData, err := usermodel.FindUserByID(userId)
+	if err != nil {
+		http.Error(w, "user not found", http.StatusUnauthorized)
+		log.Errorf("unable to find user with ID %v %v", userId, err)
+		return
+	}
+	// a recent login would otherwise renew itself without the identity provider
+	if !userData.HasPassword() {
+		http.Error(w, "user has no password, log in with the identity provider again", http.StatusConflict)
+		log.Errorf("reauthentication of user %v without password", userId)
+		return
+	}
+	if !verifyCurrentPassword(w, r, userData, data.Password) {
+		return
+	}
+	if err := userData.ResetFailedLogins(); err != nil {
+		log.Errorf("unable to reset failed logins for user %v %v", userId, err)
+	}
+	claims := jwt.MapClaims{}
+	claims["userId"] = userData.GetUserID()
+	claims["role"] = userData.GetUserRole()
+	claims["auth_time"] = time.Now().Unix()
+	accessToken, err := jwtauth.GetAccessTokenHandler().CreateToken(claims)
+	if err != nil {
+		http.Error(w, "failed to generate token", http.StatusInternalServerError)
+		log.Error("error creating token ", err)
+		return
+	}
+	refreshToken, err := jwtauth.GetRefreshTokenHandler().CreateToken(claims)
+	if err != nil {
+		http.Error(w, "failed to generate token", http.StatusInternalServerError)
+		log.Error("error creating token ", err)
+		return
+	}
+	res := map[string]interface{}{}
+	if authMiddleware.UsesSessionCookies(r) {
+		if err := setSessionCookies(w, res, accessToken, refreshToken); err != nil {
+			http.Error(w, "failed to generate token", http.StatusInternalServerError)
+			log.Error("error creating csrf token ", err)
+			return
+		}
+	} else {
+		res["accesstoken"] = accessToken
+		res["refreshtoken"] = refreshToken
+	}
+	if err := json.NewEncoder(w).Encode(res); err != nil {
+		log.Errorf("unable to encode json response %s", err)
+	}
+	log.Infof("user with ID %v has reauthenticated", userId)
+}
+
+// Logout ends a browser session by removing its cookies. The valid access and
+// refresh tokens of the request are blacklisted until they expire.
+func Logout(w http.ResponseWriter, r *http.Request) {
+	log := logger.InitializeAuditLogger()
+	var blackListedToken tokencache.BlackListedToken = tokencache.GetBlacklistTokenCache()
+	if accessToken := authMiddleware.GetAccessToken(r); accessToken != "" {
+		if claims, err := jwtauth.GetAccessTokenHandler().VerifyToken(accessToken); err == nil {
+			exp, _ := claims["exp"].(float64)
+			blackListedToken.Set(accessToken, int64(exp))
+			userId, _ := claims["userId"].(float64)
+			log.Infof("user with ID %v has logged out", uint64(userId))
+		}
+	}
+	if refreshToken := authMiddleware.GetRefreshToken(r); refreshToken != "" {
+		if claims, err := jwtauth.GetRefreshTokenHandler().VerifyToken(refreshToken); err == nil {
+			exp, _ := claims["exp"].(float64)
+			blackListedToken.Set(refreshToken, int64(exp))
+		}
+	}
+	authMiddleware.ClearSessionCookies(w)
+	w.WriteHeader(http.StatusNoContent)
+}
+
 func CheckIfSessionValid(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	log := logger.InitializeAuditLogger()
-	userId, _ := ctx.Value("userId").(uint64)
+	userId := authMiddleware.GetUserID(ctx)
 	if _, err := w.Write([]byte("user auth is valid for ID " + strconv.FormatUint(userId, 10))); err != nil {
 		log.Errorf("unable to write response %s", err)
 	}
@@ -0,0 +1,159 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/go-auth-microservice/pkg/config"
+	authMiddleware "github.com/go-auth-microservice/pkg/middleware/auth"
+	auditmodel "github.com/go-auth-microservice/pkg/model/auditModel"
+	tokencache "github.com/go-auth-microservice/pkg/model/tokenCache"
+	usermodel "github.com/go-auth-microservice/pkg/model/userModel"
+	"github.com/go-auth-microservice/pkg/utils/logger"
+	"github.com/go-auth-microservice/pkg/utils/mailer"
+	"github.com/go-auth-microservice/pkg/utils/validation"
+)
+
+type emailChangeRequest struct {
+	NewEmail        string `json:"newEmail" validate:"required,email"`
+	CurrentPassword string `json:"currentPassword"`
+}
+
+type emailToken struct {
+	Token string `json:"token" validate:"required"`
+}
+
+// emailLink builds a link to the frontend, which posts the token back to the API.
+func emailLink(path string, token string) string {
+	return strings.TrimSuffix(config.GetConfig().GetAppBaseURL(), "/") + path + "?token=" + url.QueryEscape(token)
+}
+
+func RequestEmailChange(w http.ResponseWriter, r *http.Request) {
+	log := logger.InitializeAuditLogger()
+	userId := authMiddleware.GetUserID(r.Context())
+	var data emailChangeRequest
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	if err := validation.Validator.Struct(data); err != nil {
+		http.Error(w, "invalid email", http.StatusBadRequest)
+		return
+	}
+	user, err := usermodel.FindUserByID(userId)
+	if err != nil {
+		log.Errorf("unable to find user with ID %v %v", userId, err)
+		http.Error(w, "user not found", http.StatusUnauthorized)
+		return
+	}
+	// directory users are provisioned by their email in the directory
+	if user.GetAuthSource() != usermodel.AuthSourceLocal {
+		http.Error(w, "email is managed by the directory", http.StatusConflict)
+		log.Errorf("email change for directory user %v", userId)
+		return
+	}
+	if strings.EqualFold(user.Email, data.NewEmail) {
+		http.Error(w, "new email is the current email", http.StatusBadRequest)
+		return
+	}
+	if !verifyCurrentPassword(w, r, user, data.CurrentPassword) {
+		return
+	}
+	confirmToken, cancelToken, err := user.RequestEmailChange(data.NewEmail)
+	if errors.Is(err, usermodel.ErrEmailTaken) {
+		http.Error(w, "email already exist", http.StatusConflict)
+		log.Errorf("user %v requested email change to an existing email", userId)
+		return
+	}
+	if err != nil {
+		http.Error(w, "unable to change email", http.StatusInternalServerError)
+		log.Errorf("unable to record email change for user %v %v", userId, err)
+		return
+	}
+	m := mailer.GetMailer()
+	confirmBody := fmt.Sprintf("Please confirm your new email address by opening the following link:\n\n%s\n", emailLink("/email/confirm", confirmToken))
+	if err := m.Send(data.NewEmail, "Confirm your new email address", confirmBody); err != nil {
+		http.Error(w, "unable to send confirmation email", http.StatusInternalServerError)
+		log.Errorf("unable to send email change confirmation for user %v %v", userId, err)
+		return
+	}
+	noticeBody := fmt.Sprintf("A change of the email address of your account to %s has been requested.\n\nIf this was not you, cancel the change by opening the following link and change your password:\n\n%s\n", data.NewEmail, emailLink("/email/cancel", cancelToken))
+	if err := m.Send(user.Email, "Your email address is about to change", noticeBody); err != nil {
+		log.Errorf("unable to send email change notice for user %v %v", userId, err)
+	}
+	recordAuditEvent(r, userId, auditmodel.ActionEmailChangeRequested, map[string]interface{}{"newEmail": data.NewEmail})
+	w.WriteHeader(http.StatusAccepted)
+	if _, err := w.Write([]byte("confirmation email has been sent to the new address")); err != nil {
+		log.Errorf("unable to write response %s", err)
+	}
+	log.Infof("user %v requested an email change", userId)
+}
+
+func ConfirmEmailChange(w http.ResponseWriter, r *http.Request) {
+	log := logger.InitializeAuditLogger()
+	var data emailToken
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	if err := validation.Validator.Struct(data); err != nil {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+	user, change, err := usermodel.ConfirmEmailChange(data.Token)
+	if errors.Is(err, usermodel.ErrEmailChangeNotFound) {
+		http.Error(w, "invalid or expired token", http.StatusBadRequest)
+		log.Error("email change confirmation with invalid token")
+		return
+	}
+	if errors.Is(err, usermodel.ErrEmailTaken) {
+		http.Error(w, "email already exist", http.StatusConflict)
+		log.Error("email change confirmation for an email which is in use by now")
+		return
+	}
+	if err != nil {
+		http.Error(w, "unable to change email", http.StatusInternalServerError)
+		log.Error("unable to confirm email change ", err)
+		return
+	}
+	var revokedUsers tokencache.RevokedUsers = tokencache.GetRevokedUserTokens()
+	revokedUsers.Revoke(user.Id, time.Now())
+	recordAuditEvent(r, user.Id, auditmodel.ActionEmailChanged, map[string]interface{}{"oldEmail": change.OldEmail, "newEmail": change.NewEmail})
+	if _, err := w.Write([]byte("email has been changed, please login again")); err != nil {
+		log.Errorf("unable to write response %s", err)
+	}
+	log.Infof("user %v changed email from %v to %v", user.Id, change.OldEmail, change.NewEmail)
+}
+
+func CancelEmailChange(w http.ResponseWriter, r *http.Request) {
+	log := logger.InitializeAuditLogger()
+	var data emailToken
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	if err := validation.Validator.Struct(data); err != nil {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+	change, err := usermodel.CancelEmailChange(data.Token)
+	if errors.Is(err, usermodel.ErrEmailChangeNotFound) {
+		http.Error(w, "invalid or expired token", http.StatusBadRequest)
+		log.Error("email change cancellation with invalid token")
+		return
+	}
+	if err != nil {
+		http.Error(w, "unable to cancel email change", http.StatusInternalServerError)
+		log.Error("unable to cancel email change ", err)
+		return
+	}
+	if _, err := w.Write([]byte("email change has been cancelled")); err != nil {
+		log.Errorf("unable to write response %s", err)
+	}
+	log.Infof("user %v cancelled email change to %v", change.UserId, change.NewEmail)
+}
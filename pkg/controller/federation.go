@@ -0,0 +1,295 @@
+package controller
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/go-auth-microservice/pkg/config"
+	authMiddleware "github.com/go-auth-microservice/pkg/middleware/auth"
+	auditmodel "github.com/go-auth-microservice/pkg/model/auditModel"
+	usermodel "github.com/go-auth-microservice/pkg/model/userModel"
+	"github.com/go-auth-microservice/pkg/utils/federation"
+	"github.com/go-auth-microservice/pkg/utils/logger"
+	"github.com/go-chi/chi/v5"
+)
+
+// federationError answers a failed social login with an error code the
+// frontend can show a message for.
+func federationError(w http.ResponseWriter, status int, code string, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	res := map[string]interface{}{}
+	res["error"] = code
+	res["message"] = message
+	if err := json.NewEncoder(w).Encode(res); err != nil {
+		logger.InitializeAuditLogger().Errorf("unable to encode json response %s", err)
+	}
+}
+
+// startFederation creates the state of a social login or identity link and
+// binds it to the browser. It returns the URL of the identity provider.
+func startFederation(w http.ResponseWriter, r *http.Request, userId *uint64, cookies bool) (string, bool) {
+	log := logger.InitializeAuditLogger()
+	name := chi.URLParam(r, "provider")
+	provider, err := federation.GetProvider(name)
+	if err != nil {
+		http.Error(w, "identity provider not found", http.StatusNotFound)
+		return "", false
+	}
+	token, state, err := usermodel.CreateFederationState(name, userId, cookies)
+	if err != nil {
+		http.Error(w, "unable to start login", http.StatusInternalServerError)
+		log.Errorf("unable to create federation state for %s %v", name, err)
+		return "", false
+	}
+	authURL, err := provider.AuthCodeURL(r.Context(), token, state.Nonce, state.CodeVerifier)
+	if err != nil {
+		http.Error(w, "identity provider is unavailable", http.StatusBadGateway)
+		log.Errorf("unable to reach identity provider %s %v", name, err)
+		return "", false
+	}
+	authMiddleware.SetFederationStateCookie(w, token, state.ExpiresAt)
+	return authURL, true
+}
+
+// GetFederationProviders lists the identity providers users can log in with.
+func GetFederationProviders(w http.ResponseWriter, r *http.Request) {
+	res := map[string]interface{}{}
+	res["providers"] = federation.GetProviderNames()
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(res); err != nil {
+		logger.InitializeAuditLogger().Errorf("unable to encode json response %s", err)
+	}
+}
+
+// StartFederatedLogin redirects the browser to the identity provider. With
+// ?cookies=true the login ends in a cookie session.
+func StartFederatedLogin(w http.ResponseWriter, r *http.Request) {
+	authURL, ok := startFederation(w, r, nil, r.URL.Query().Get("cookies") == "true")
+	if !ok {
+		return
+	}
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// LinkIdentity starts linking an identity of the provider to the logged in
+// user. The frontend sends the browser to the returned URL.
+func LinkIdentity(w http.ResponseWriter, r *http.Request) {
+	log := logger.InitializeAuditLogger()
+	userId := authMiddleware.GetUserID(r.Context())
+	authURL, ok := startFederation(w, r, &userId, false)
+	if !ok {
+		return
+	}
+	res := map[string]interface{}{}
+	res["authorizationUrl"] = authURL
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(res); err != nil {
+		log.Errorf("unable to encode json response %s", err)
+	}
+}
+
+// FederatedLoginCallback completes a social login, or links the identity if
+// a logged in user started it. A new identity logs in as the user with the
+// same verified email or as a new user, see usermodel.FederateIdentity.
+func FederatedLoginCallback(w http.ResponseWriter, r *http.Request) {
+	log := logger.InitializeAuditLogger()
+	name := chi.URLParam(r, "provider")
+	provider, err := federation.GetProvider(name)
+	if err != nil {
+		http.Error(w, "identity provider not found", http.StatusNotFound)
+		return
+	}
+	query := r.URL.Query()
+	if providerError := query.Get("error"); providerError != "" {
+		federationError(w, http.StatusUnauthorized, "provider_error", "login at the identity provider failed")
+		log.Errorf("identity provider %s answered with error %s", name, providerError)
+		return
+	}
+	stateToken := query.Get("state")
+	if stateToken == "" || query.Get("code") == "" {
+		http.Error(w, "state and code are required", http.StatusBadRequest)
+		return
+	}
+	// the state has to come back to the browser which started the login,
+	// otherwise an attacker could log the victim into the attacker's account
+	cookie, err := r.Cookie(authMiddleware.FederationStateCookie)
+	if err != nil || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(stateToken)) != 1 {
+		federationError(w, http.StatusUnauthorized, "invalid_state", "login has not been started in this browser")
+		log.Errorf("federation state of %s does not match the state cookie", name)
+		return
+	}
+	authMiddleware.ClearFederationStateCookie(w)
+	state, err := usermodel.ConsumeFederationState(stateToken, name)
+	if errors.Is(err, usermodel.ErrFederationStateNotFound) {
+		federationError(w, http.StatusUnauthorized, "invalid_state", "login expired please try again")
+		return
+	}
+	if err != nil {
+		http.Error(w, "unable to complete login", http.StatusInternalServerError)
+		log.Errorf("unable to load federation state of %s %v", name, err)
+		return
+	}
+	identity, err := provider.Identify(r.Context(), query.Get("code"), state.Nonce, state.CodeVerifier)
+	if err != nil {
+		federationError(w, http.StatusUnauthorized, "provider_error", "unable to verify the login at the identity provider")
+		log.Errorf("unable to identify user at identity provider %s %v", name, err)
+		return
+	}
+	external := usermodel.ExternalIdentity{
+		Provider:      name,
+		Subject:       identity.Subject,
+		Email:         identity.Email,
+		EmailVerified: identity.EmailVerified,
+		Name:          identity.Name,
+	}
+	if state.UserId != nil {
+		completeIdentityLink(w, r, *state.UserId, external)
+		return
+	}
+	user, ok := federateIdentity(w, r, external, config.GetConfig().GetOpenSignup())
+	if !ok {
+		return
+	}
+	completeFederatedLogin(w, r, user, usermodel.FederatedLoginMethod(name), state.Cookies)
+}
+
+// federateIdentity resolves the external identity of a social or SAML login
+// to its user, see usermodel.FederateIdentity, and answers if it can not.
+func federateIdentity(w http.ResponseWriter, r *http.Request, identity usermodel.ExternalIdentity, createUsers bool) (*usermodel.UserData, bool) {
+	log := logger.InitializeAuditLogger()
+	user, outcome, err := usermodel.FederateIdentity(identity, createUsers)
+	switch {
+	case errors.Is(err, usermodel.ErrIdentityEmailUnverified):
+		federationError(w, http.StatusForbidden, "email_not_verified", "the identity provider has not verified your email")
+		return nil, false
+	case errors.Is(err, usermodel.ErrIdentityAccountExists), errors.Is(err, usermodel.ErrEmailTaken):
+		federationError(w, http.StatusConflict, "account_exists", "log in with your password and link the identity from your account")
+		return nil, false
+	case errors.Is(err, usermodel.ErrIdentitySignupClosed):
+		federationError(w, http.StatusForbidden, "signup_closed", "signup is by invitation only")
+		return nil, false
+	case err != nil:
+		http.Error(w, "unable to complete login", http.StatusInternalServerError)
+		log.Errorf("unable to resolve identity of %s %v", identity.Provider, err)
+		return nil, false
+	}
+	if outcome != usermodel.IdentityKnown {
+		recordAuditEvent(r, user.Id, auditmodel.ActionIdentityLinked, map[string]interface{}{
+			"provider": identity.Provider, "subject": identity.Subject, "createdUser": outcome == usermodel.IdentityCreated,
+		})
+		log.Infof("identity of %s %s %s to user %v", identity.Provider, identity.Subject, outcome, user.Id)
+	}
+	return user, true
+}
+
+// completeFederatedLogin logs in the user of a social or SAML login after
+// the account status and risk checks.
+func completeFederatedLogin(w http.ResponseWriter, r *http.Request, user *usermodel.UserData, method string, cookies bool) {
+	log := logger.InitializeAuditLogger()
+	var userData usermodel.UserLogin = user
+	if accountState := userData.GetAccountState(); !accountState.AllowsLogin() {
+		recordLogin(r, userData, method, usermodel.LoginDenied)
+		authMiddleware.AccountUnavailable(w, accountState)
+		log.Errorf("%s login of user %d with account status %s", method, userData.GetUserID(), accountState.Status)
+		return
+	}
+	if riskStopsLogin(w, r, userData, method, cookies) {
+		return
+	}
+	completeLogin(w, r, userData, method, cookies)
+}
+
+// completeIdentityLink links the identity to the user who started linking.
+func completeIdentityLink(w http.ResponseWriter, r *http.Request, userId uint64, identity usermodel.ExternalIdentity) {
+	log := logger.InitializeAuditLogger()
+	var userData usermodel.UserFederation
+	userData, err := usermodel.FindUserByID(userId)
+	if err != nil {
+		http.Error(w, "user not found", http.StatusUnauthorized)
+		log.Errorf("unable to find user with ID %v %v", userId, err)
+		return
+	}
+	linked, err := userData.LinkIdentity(identity)
+	if errors.Is(err, usermodel.ErrIdentityTaken) {
+		federationError(w, http.StatusConflict, "identity_taken", "the identity is linked to another account")
+		return
+	}
+	if err != nil {
+		http.Error(w, "unable to link identity", http.StatusInternalServerError)
+		log.Errorf("unable to link identity of %s to user %v %v", identity.Provider, userId, err)
+		return
+	}
+	recordAuditEvent(r, userId, auditmodel.ActionIdentityLinked, map[string]interface{}{"provider": identity.Provider, "subject": identity.Subject})
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(linked); err != nil {
+		log.Errorf("unable to encode json response %s", err)
+	}
+	log.Infof("user %v linked identity %v of %s", userId, linked.Id, identity.Provider)
+}
+
+// GetIdentities lists the identities of social login linked to the user.
+func GetIdentities(w http.ResponseWriter, r *http.Request) {
+	log := logger.InitializeAuditLogger()
+	userId := authMiddleware.GetUserID(r.Context())
+	var userData usermodel.UserFederation
+	userData, err := usermodel.FindUserByID(userId)
+	if err != nil {
+		http.Error(w, "user not found", http.StatusUnauthorized)
+		log.Errorf("unable to find user with ID %v %v", userId, err)
+		return
+	}
+	identities, err := userData.FindIdentities()
+	if err != nil {
+		http.Error(w, "unable to find identities", http.StatusInternalServerError)
+		log.Errorf("unable to find identities of user %v %v", userId, err)
+		return
+	}
+	res := map[string]interface{}{}
+	res["identities"] = identities
+	res["hasPassword"] = userData.HasPassword()
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(res); err != nil {
+		log.Errorf("unable to encode json response %s", err)
+	}
+}
+
+// UnlinkIdentity removes an identity of social login from the user. Users
+// without a password keep at least one identity to log in with.
+func UnlinkIdentity(w http.ResponseWriter, r *http.Request) {
+	log := logger.InitializeAuditLogger()
+	userId := authMiddleware.GetUserID(r.Context())
+	identityId, err := strconv.ParseUint(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid identity id", http.StatusBadRequest)
+		return
+	}
+	var userData usermodel.UserFederation
+	userData, err = usermodel.FindUserByID(userId)
+	if err != nil {
+		http.Error(w, "user not found", http.StatusUnauthorized)
+		log.Errorf("unable to find user with ID %v %v", userId, err)
+		return
+	}
+	identity, err := userData.UnlinkIdentity(identityId)
+	if errors.Is(err, usermodel.ErrIdentityNotFound) {
+		http.Error(w, "identity not found", http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, usermodel.ErrLastCredential) {
+		http.Error(w, "the only way to log in can not be removed", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, "unable to unlink identity", http.StatusInternalServerError)
+		log.Errorf("unable to unlink identity %v of user %v %v", identityId, userId, err)
+		return
+	}
+	recordAuditEvent(r, userId, auditmodel.ActionIdentityUnlinked, map[string]interface{}{"provider": identity.Provider, "subject": identity.Subject})
+	w.WriteHeader(http.StatusNoContent)
+	log.Infof("user %v unlinked identity %v", userId, identityId)
+}
@@ -0,0 +1,105 @@
+package controller
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-auth-microservice/pkg/config"
+	authMiddleware "github.com/go-auth-microservice/pkg/middleware/auth"
+	tokencache "github.com/go-auth-microservice/pkg/model/tokenCache"
+	usermodel "github.com/go-auth-microservice/pkg/model/userModel"
+	"github.com/go-auth-microservice/pkg/utils/logger"
+	randomtoken "github.com/go-auth-microservice/pkg/utils/randomToken"
+)
+
+// forwardAuthWriter answers every denied forward auth request with 401, the
+// status reverse proxies turn into a denied request, e.g. for a suspended
+// account which AccessTokenVerify answers with 403.
+type forwardAuthWriter struct {
+	http.ResponseWriter
+}
+
+func (w *forwardAuthWriter) WriteHeader(status int) {
+	if status == http.StatusForbidden {
+		status = http.StatusUnauthorized
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// writeForwardAuthIdentity accepts a forward auth request and hands the
+// identity of the user to the upstream through response headers.
+func writeForwardAuthIdentity(w http.ResponseWriter, token tokencache.VerifiedToken) {
+	w.Header().Set("X-User-Id", strconv.FormatUint(token.UserId, 10))
+	w.Header().Set("X-User-Email", token.Email)
+	w.Header().Set("X-User-Roles", token.Role)
+	w.WriteHeader(http.StatusOK)
+}
+
+// VerifyForwardAuth answers the subrequests of nginx auth_request or Traefik
+// ForwardAuth with 200 and the identity of the user, or 401. The token is
+// verified by AccessTokenVerify and remembered for FORWARD_AUTH_CACHE_TTL
+// seconds, at most until it expires. Within that time logouts, revoked user
+// tokens and the account status of the user and an impersonating admin are
+// still checked, revoked personal access tokens are forgotten right away.
+func VerifyForwardAuth(w http.ResponseWriter, r *http.Request) {
+	log := logger.InitializeAuditLogger()
+	accessToken := authMiddleware.GetAccessToken(r)
+	key := randomtoken.Hash(accessToken)
+	verifiedTokens := tokencache.GetVerifiedTokens()
+	if token, ok := verifiedTokens.Get(key); ok {
+		if cachedTokenAllowed(accessToken, token) {
+			writeForwardAuthIdentity(w, token)
+			return
+		}
+		verifiedTokens.Remove(key)
+	}
+	verify := authMiddleware.AccessTokenVerify(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userId := authMiddleware.GetUserID(r.Context())
+		user, err := usermodel.FindUserByID(userId)
+		if err != nil {
+			http.Error(w, "unable to verify user", http.StatusInternalServerError)
+			log.Errorf("unable to find user with ID %v %v", userId, err)
+			return
+		}
+		token := tokencache.VerifiedToken{
+			UserId:    userId,
+			Email:     user.Email,
+			Role:      authMiddleware.GetUserRole(r.Context()),
+			ActorId:   authMiddleware.GetActorID(r.Context()),
+			IssuedAt:  authMiddleware.GetIssuedAt(r.Context()),
+			ExpiresAt: authMiddleware.GetExpiry(r.Context()),
+		}
+		if apiToken := authMiddleware.GetAPIToken(r.Context()); apiToken != nil {
+			token.APITokenId = apiToken.Id
+		}
+		if ttl := config.GetConfig().GetForwardAuthCacheTTL(); ttl > 0 {
+			verifiedTokens.Set(key, token, time.Second*time.Duration(ttl))
+		}
+		writeForwardAuthIdentity(w, token)
+	}))
+	verify.ServeHTTP(&forwardAuthWriter{ResponseWriter: w}, r)
+}
+
+// cachedTokenAllowed tells if a remembered token may still be accepted. Account
+// states are cached by the user model, so this does not hit the database on
+// every request.
+func cachedTokenAllowed(accessToken string, token tokencache.VerifiedToken) bool {
+	var blackListedToken tokencache.BlackListedToken = tokencache.GetBlacklistTokenCache()
+	var revokedUsers tokencache.RevokedUsers = tokencache.GetRevokedUserTokens()
+	// like AccessTokenVerify, revocations of the user only apply to JWTs
+	if blackListedToken.IsPresent(accessToken) || (token.APITokenId == 0 && revokedUsers.IsRevoked(token.UserId, token.IssuedAt)) {
+		return false
+	}
+	state, err := usermodel.GetAccountStateByID(token.UserId)
+	if err != nil || !state.AllowsSessions() {
+		return false
+	}
+	if token.ActorId != 0 {
+		actorState, err := usermodel.GetAccountStateByID(token.ActorId)
+		if err != nil || !actorState.AllowsSessions() {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,141 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	authMiddleware "github.com/go-auth-microservice/pkg/middleware/auth"
+	auditmodel "github.com/go-auth-microservice/pkg/model/auditModel"
+	usermodel "github.com/go-auth-microservice/pkg/model/userModel"
+	"github.com/go-auth-microservice/pkg/utils/logger"
+	"github.com/go-auth-microservice/pkg/utils/mailer"
+	"github.com/go-auth-microservice/pkg/utils/validation"
+)
+
+type invitationRequest struct {
+	Email string `json:"email" validate:"required,email"`
+	Role  string `json:"role" validate:"omitempty,oneof=user admin"`
+}
+
+type invitationAccept struct {
+	Token    string `json:"token" validate:"required"`
+	Password string `json:"password" validate:"required"`
+}
+
+// CreateInvitation invites a user by email with the given role, which
+// defaults to user.
+func CreateInvitation(w http.ResponseWriter, r *http.Request) {
+	log := logger.InitializeAuditLogger()
+	adminId := authMiddleware.GetUserID(r.Context())
+	var data invitationRequest
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	if err := validation.Validator.Struct(data); err != nil {
+		http.Error(w, "invalid email or role", http.StatusBadRequest)
+		return
+	}
+	if data.Role == "" {
+		data.Role = usermodel.RoleUser
+	}
+	invitation, token, err := usermodel.CreateInvitation(data.Email, data.Role, adminId)
+	if errors.Is(err, usermodel.ErrEmailTaken) {
+		http.Error(w, "email already exist", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, "unable to create invitation", http.StatusInternalServerError)
+		log.Errorf("unable to create invitation by admin %v %v", adminId, err)
+		return
+	}
+	body := fmt.Sprintf("You have been invited to create an account. Choose your password by opening the following link:\n\n%s\n\nThe invitation expires at %s.\n", emailLink("/invitations/accept", token), invitation.ExpiresAt.Format("2006-01-02 15:04 MST"))
+	if err := mailer.GetMailer().Send(invitation.Email, "You have been invited", body); err != nil {
+		http.Error(w, "unable to send invitation email", http.StatusInternalServerError)
+		log.Errorf("unable to send invitation %v %v", invitation.Id, err)
+		return
+	}
+	recordActorAuditEvent(r, 0, adminId, auditmodel.ActionInvitationCreated, map[string]interface{}{"invitationId": invitation.Id, "role": invitation.Role})
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(invitation); err != nil {
+		log.Errorf("unable to encode json response %s", err)
+	}
+	log.Infof("admin %v invited %v as %v", adminId, invitation.Email, invitation.Role)
+}
+
+// AcceptInvitation creates the invited user with the chosen password.
+func AcceptInvitation(w http.ResponseWriter, r *http.Request) {
+	log := logger.InitializeAuditLogger()
+	var data invitationAccept
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	if err := validation.Validator.Struct(data); err != nil {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+	invitation, err := usermodel.FindPendingInvitation(data.Token)
+	if errors.Is(err, usermodel.ErrInvitationNotFound) {
+		http.Error(w, "invalid or expired token", http.StatusBadRequest)
+		log.Error("invitation accepted with invalid token")
+		return
+	}
+	if err != nil {
+		http.Error(w, "unable to accept invitation", http.StatusInternalServerError)
+		log.Error("unable to find invitation ", err)
+		return
+	}
+	user := invitation.NewUser()
+	var userData usermodel.UserSignUp = user
+	violations, err := userData.CheckPasswordPolicy(r.Context(), data.Password)
+	if err != nil {
+		log.Error("password policy check failed ", err)
+		if errors.Is(err, usermodel.ErrHashingUnavailable) {
+			hashingUnavailable(w)
+			return
+		}
+		http.Error(w, "unable to accept invitation", http.StatusInternalServerError)
+		return
+	}
+	if len(violations) > 0 {
+		passwordPolicyViolated(w, violations)
+		return
+	}
+	if err := userData.SetPassword(r.Context(), data.Password); err != nil {
+		if errors.Is(err, usermodel.ErrHashingUnavailable) {
+			hashingUnavailable(w)
+			return
+		}
+		http.Error(w, "unable to accept invitation", http.StatusInternalServerError)
+		log.Error("password encryption failed ", err)
+		return
+	}
+	err = invitation.Accept(user)
+	if errors.Is(err, usermodel.ErrEmailTaken) {
+		http.Error(w, "email already exist", http.StatusConflict)
+		return
+	}
+	if errors.Is(err, usermodel.ErrInvitationNotFound) {
+		http.Error(w, "invalid or expired token", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, "unable to accept invitation", http.StatusInternalServerError)
+		log.Errorf("unable to accept invitation %v %v", invitation.Id, err)
+		return
+	}
+	if err := userData.SavePasswordHistory(); err != nil {
+		log.Error("unable to save password history ", err)
+	}
+	recordActorAuditEvent(r, user.Id, invitation.InviterId, auditmodel.ActionInvitationAccepted, map[string]interface{}{"invitationId": invitation.Id})
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(user); err != nil {
+		log.Errorf("unable to encode json response %s", err)
+	}
+	log.Infof("user %v has been created from invitation %v", user.Id, invitation.Id)
+}
@@ -0,0 +1,152 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	authMiddleware "github.com/go-auth-microservice/pkg/middleware/auth"
+	auditmodel "github.com/go-auth-microservice/pkg/model/auditModel"
+	ippolicymodel "github.com/go-auth-microservice/pkg/model/ipPolicyModel"
+	clientip "github.com/go-auth-microservice/pkg/utils/clientIP"
+	"github.com/go-auth-microservice/pkg/utils/logger"
+	"github.com/go-auth-microservice/pkg/utils/validation"
+	"github.com/go-chi/chi/v5"
+)
+
+type ipRuleRequest struct {
+	Action      string `json:"action" validate:"required,oneof=allow deny"`
+	CIDR        string `json:"cidr" validate:"required"`
+	Role        string `json:"role" validate:"max=50"`
+	Description string `json:"description" validate:"max=500"`
+}
+
+// locksOutAdmin tells if the rules would deny the admin sending the request
+// access to the admin API.
+func locksOutAdmin(r *http.Request, rules []ippolicymodel.IPRule) (bool, error) {
+	policy, err := ippolicymodel.NewPolicy(rules)
+	if err != nil {
+		return false, err
+	}
+	ip := clientip.FromRequest(r)
+	return !policy.Allows(ip, "") || !policy.Allows(ip, authMiddleware.GetUserRole(r.Context())), nil
+}
+
+// GetIPRules lists the IP rules.
+func GetIPRules(w http.ResponseWriter, r *http.Request) {
+	log := logger.InitializeAuditLogger()
+	rules, err := ippolicymodel.FindRules()
+	if err != nil {
+		http.Error(w, "unable to find ip rules", http.StatusInternalServerError)
+		log.Errorf("unable to find ip rules %v", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(rules); err != nil {
+		log.Errorf("unable to encode json response %s", err)
+	}
+}
+
+// CreateIPRule adds an IP rule. A rule which would lock the admin out of the
+// admin API is refused.
+func CreateIPRule(w http.ResponseWriter, r *http.Request) {
+	log := logger.InitializeAuditLogger()
+	adminId := authMiddleware.GetUserID(r.Context())
+	var data ipRuleRequest
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	if err := validation.Validator.Struct(data); err != nil {
+		http.Error(w, "an action of allow or deny and a cidr are required", http.StatusBadRequest)
+		return
+	}
+	rule := &ippolicymodel.IPRule{Action: data.Action, CIDR: data.CIDR, Role: data.Role, Description: data.Description, CreatedBy: &adminId}
+	if err := ippolicymodel.NormalizeRule(rule); err != nil {
+		http.Error(w, "invalid cidr", http.StatusBadRequest)
+		return
+	}
+	rules, err := ippolicymodel.FindRules()
+	if err != nil {
+		http.Error(w, "unable to create ip rule", http.StatusInternalServerError)
+		log.Errorf("unable to find ip rules %v", err)
+		return
+	}
+	lockout, err := locksOutAdmin(r, append(rules, *rule))
+	if err != nil {
+		http.Error(w, "unable to create ip rule", http.StatusInternalServerError)
+		log.Errorf("unable to compile ip rules %v", err)
+		return
+	}
+	if lockout {
+		http.Error(w, "the rule would deny your own ip address", http.StatusConflict)
+		return
+	}
+	if err := ippolicymodel.CreateRule(rule); err != nil {
+		http.Error(w, "unable to create ip rule", http.StatusInternalServerError)
+		log.Errorf("unable to create ip rule %v", err)
+		return
+	}
+	recordAuditEvent(r, adminId, auditmodel.ActionIPRuleCreated, map[string]interface{}{"ruleId": rule.Id, "action": rule.Action, "cidr": rule.CIDR, "role": rule.Role})
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(rule); err != nil {
+		log.Errorf("unable to encode json response %s", err)
+	}
+	log.Infof("admin %v created ip rule %v to %s %s", adminId, rule.Id, rule.Action, rule.CIDR)
+}
+
+// DeleteIPRule removes an IP rule. Removing a rule which would lock the admin
+// out of the admin API, e.g. the allow rule of their network, is refused.
+func DeleteIPRule(w http.ResponseWriter, r *http.Request) {
+	log := logger.InitializeAuditLogger()
+	adminId := authMiddleware.GetUserID(r.Context())
+	ruleId, err := strconv.ParseUint(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid rule id", http.StatusBadRequest)
+		return
+	}
+	rules, err := ippolicymodel.FindRules()
+	if err != nil {
+		http.Error(w, "unable to delete ip rule", http.StatusInternalServerError)
+		log.Errorf("unable to find ip rules %v", err)
+		return
+	}
+	var deleted *ippolicymodel.IPRule
+	remaining := []ippolicymodel.IPRule{}
+	for i, rule := range rules {
+		if rule.Id == ruleId {
+			deleted = &rules[i]
+			continue
+		}
+		remaining = append(remaining, rule)
+	}
+	if deleted == nil {
+		http.Error(w, "ip rule not found", http.StatusNotFound)
+		return
+	}
+	lockout, err := locksOutAdmin(r, remaining)
+	if err != nil {
+		http.Error(w, "unable to delete ip rule", http.StatusInternalServerError)
+		log.Errorf("unable to compile ip rules %v", err)
+		return
+	}
+	if lockout {
+		http.Error(w, "deleting the rule would deny your own ip address", http.StatusConflict)
+		return
+	}
+	err = ippolicymodel.DeleteRule(ruleId)
+	if errors.Is(err, ippolicymodel.ErrRuleNotFound) {
+		http.Error(w, "ip rule not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "unable to delete ip rule", http.StatusInternalServerError)
+		log.Errorf("unable to delete ip rule %v %v", ruleId, err)
+		return
+	}
+	recordAuditEvent(r, adminId, auditmodel.ActionIPRuleDeleted, map[string]interface{}{"ruleId": ruleId, "action": deleted.Action, "cidr": deleted.CIDR, "role": deleted.Role})
+	w.WriteHeader(http.StatusNoContent)
+	log.Infof("admin %v deleted ip rule %v", adminId, ruleId)
+}
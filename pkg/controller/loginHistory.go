@@ -0,0 +1,106 @@
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-auth-microservice/pkg/config"
+	authMiddleware "github.com/go-auth-microservice/pkg/middleware/auth"
+	usermodel "github.com/go-auth-microservice/pkg/model/userModel"
+	clientip "github.com/go-auth-microservice/pkg/utils/clientIP"
+	"github.com/go-auth-microservice/pkg/utils/logger"
+	"github.com/go-auth-microservice/pkg/utils/notifier"
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	loginHistoryPerPage    = 20
+	loginHistoryMaxPerPage = 100
+)
+
+// recordLogin stores a login attempt in the login history of the user and
+// notifies the user of a successful login from a new device in the
+// background. A failure is only logged, it must not fail the login.
+func recordLogin(r *http.Request, userData usermodel.UserLogin, method string, outcome string) {
+	log := logger.InitializeAuditLogger()
+	ip := clientip.FromRequest(r)
+	newDevice, err := userData.RecordLogin(ip, r.UserAgent(), method, outcome)
+	if err != nil {
+		log.Errorf("unable to record login of user %v %v", userData.GetUserID(), err)
+		return
+	}
+	if !newDevice || !config.GetConfig().GetNewDeviceNotification() {
+		return
+	}
+	body := fmt.Sprintf("Your account has been logged in from a new device.\n\nTime: %s\nIP address: %s\nDevice: %s\n\n"+
+		"If this was not you, change your password right away.", time.Now().UTC().Format(time.RFC1123), ip, r.UserAgent())
+	notification := notifier.Notification{
+		Event:   notifier.EventNewDeviceLogin,
+		UserId:  userData.GetUserID(),
+		Email:   userData.GetUserEmail(),
+		Subject: "New login to your account",
+		Body:    body,
+		Data:    map[string]interface{}{"ip": ip, "userAgent": r.UserAgent(), "method": method},
+	}
+	if !notifier.Enqueue(notification) {
+		log.Errorf("unable to notify user %v of a new device login, too many notifications are waiting", userData.GetUserID())
+	}
+}
+
+// writeLoginHistory answers with the page of the login history requested by
+// the page and perPage query parameters.
+func writeLoginHistory(w http.ResponseWriter, r *http.Request, userId uint64) {
+	log := logger.InitializeAuditLogger()
+	page, perPage := 1, loginHistoryPerPage
+	if value := r.URL.Query().Get("page"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 {
+			http.Error(w, "page must be a positive number", http.StatusBadRequest)
+			return
+		}
+		page = parsed
+	}
+	if value := r.URL.Query().Get("perPage"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 || parsed > loginHistoryMaxPerPage {
+			http.Error(w, fmt.Sprintf("perPage must be between 1 and %d", loginHistoryMaxPerPage), http.StatusBadRequest)
+			return
+		}
+		perPage = parsed
+	}
+	user, err := usermodel.FindUserByID(userId)
+	if err != nil {
+		http.Error(w, "user not found", http.StatusNotFound)
+		log.Errorf("unable to find user with ID %v %v", userId, err)
+		return
+	}
+	var userData usermodel.UserLoginHistory = user
+	history, err := userData.FindLoginHistory(page, perPage)
+	if err != nil {
+		http.Error(w, "unable to find login history", http.StatusInternalServerError)
+		log.Errorf("unable to find login history of user %v %v", userId, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(history); err != nil {
+		log.Errorf("unable to encode json response %s", err)
+	}
+}
+
+// GetLoginHistory lists the login attempts of the user, newest first.
+func GetLoginHistory(w http.ResponseWriter, r *http.Request) {
+	writeLoginHistory(w, r, authMiddleware.GetUserID(r.Context()))
+}
+
+// GetUserLoginHistory lists the login attempts of a user for support staff.
+func GetUserLoginHistory(w http.ResponseWriter, r *http.Request) {
+	userId, err := strconv.ParseUint(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid user id", http.StatusBadRequest)
+		return
+	}
+	writeLoginHistory(w, r, userId)
+}
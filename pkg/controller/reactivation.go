@@ -0,0 +1,138 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/go-auth-microservice/pkg/config"
+	authMiddleware "github.com/go-auth-microservice/pkg/middleware/auth"
+	auditmodel "github.com/go-auth-microservice/pkg/model/auditModel"
+	usermodel "github.com/go-auth-microservice/pkg/model/userModel"
+	"github.com/go-auth-microservice/pkg/utils/logger"
+	"github.com/go-auth-microservice/pkg/utils/mailer"
+	"github.com/go-auth-microservice/pkg/utils/validation"
+)
+
+// Reactivate activates a deactivated or deleted account again after checking
+// the credentials. Suspended users can not reactivate themselves.
+// With REACTIVATION_REQUIRE_EMAIL the reactivation is confirmed by email.
+func Reactivate(w http.ResponseWriter, r *http.Request) {
+	log := logger.InitializeAuditLogger()
+	var user userSignup
+	if err := json.NewDecoder(r.Body).Decode(&user); err != nil {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+	if err := validation.Validator.Struct(user); err != nil {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+	stored, err := usermodel.FindUserByEmail(user.Email)
+	if err != nil {
+		usermodel.CompareDummyPassword(r.Context(), user.Password)
+		log.Errorf(`reactivation for email "%v" not found on DB %v`, user.Email, err)
+		http.Error(w, "invalid email or password", http.StatusUnauthorized)
+		return
+	}
+	var userData usermodel.UserReactivation = stored
+	if userData.IsLocked() {
+		usermodel.CompareDummyPassword(r.Context(), user.Password)
+		log.Errorf("reactivation attempt for locked user %v", userData.GetUserID())
+		http.Error(w, "invalid email or password", http.StatusUnauthorized)
+		return
+	}
+	err = userData.VerifyPassword(r.Context(), user.Password)
+	if errors.Is(err, usermodel.ErrHashingUnavailable) {
+		log.Errorf("password validation rejected for user %v %v", userData.GetUserID(), err)
+		hashingUnavailable(w)
+		return
+	}
+	if err != nil && !errors.Is(err, usermodel.ErrInvalidCredentials) {
+		log.Errorf("unable to verify password of user %v %v", userData.GetUserID(), err)
+		http.Error(w, "unable to verify credentials", http.StatusServiceUnavailable)
+		return
+	}
+	if err != nil {
+		if err := userData.RegisterFailedLogin(); err != nil {
+			log.Errorf("unable to record failed login for user %v %v", userData.GetUserID(), err)
+		}
+		recordAuditEvent(r, userData.GetUserID(), auditmodel.ActionLoginFailed, nil)
+		log.Errorf("invalid reactivation for user %v", user.Email)
+		http.Error(w, "invalid email or password", http.StatusUnauthorized)
+		return
+	}
+	if err := userData.ResetFailedLogins(); err != nil {
+		log.Errorf("unable to reset failed logins for user %v %v", userData.GetUserID(), err)
+	}
+	state := userData.GetAccountState()
+	if state.Status == usermodel.StatusActive {
+		http.Error(w, "user is already active", http.StatusBadRequest)
+		return
+	}
+	if !userData.CanReactivate() {
+		authMiddleware.AccountUnavailable(w, state)
+		log.Errorf("reactivation attempt for user %d with account status %s", userData.GetUserID(), state.Status)
+		return
+	}
+	if config.GetConfig().GetReactivationRequireEmail() {
+		token, err := userData.RequestReactivation()
+		if err != nil {
+			http.Error(w, "unable to reactivate user", http.StatusInternalServerError)
+			log.Errorf("unable to record reactivation for user %v %v", userData.GetUserID(), err)
+			return
+		}
+		body := fmt.Sprintf("Please confirm the reactivation of your account by opening the following link:\n\n%s\n", emailLink("/reactivate/confirm", token))
+		if err := mailer.GetMailer().Send(stored.Email, "Confirm the reactivation of your account", body); err != nil {
+			http.Error(w, "unable to send confirmation email", http.StatusInternalServerError)
+			log.Errorf("unable to send reactivation confirmation for user %v %v", userData.GetUserID(), err)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+		if _, err := w.Write([]byte("confirmation email has been sent")); err != nil {
+			log.Errorf("unable to write response %s", err)
+		}
+		log.Infof("user %v requested a reactivation", userData.GetUserID())
+		return
+	}
+	if err := userData.Reactivate(); err != nil {
+		http.Error(w, "unable to reactivate user", http.StatusInternalServerError)
+		log.Errorf("unable to reactivate user %v %v", userData.GetUserID(), err)
+		return
+	}
+	recordAuditEvent(r, userData.GetUserID(), auditmodel.ActionAccountReactivated, nil)
+	if _, err := w.Write([]byte("user has been reactivated, please login")); err != nil {
+		log.Errorf("unable to write response %s", err)
+	}
+	log.Infof("user %v has been reactivated", userData.GetUserID())
+}
+
+func ConfirmReactivation(w http.ResponseWriter, r *http.Request) {
+	log := logger.InitializeAuditLogger()
+	var data emailToken
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	if err := validation.Validator.Struct(data); err != nil {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+	user, err := usermodel.ConfirmReactivation(data.Token)
+	if errors.Is(err, usermodel.ErrReactivationNotFound) || errors.Is(err, usermodel.ErrNotSelfDeactivated) {
+		http.Error(w, "invalid or expired token", http.StatusBadRequest)
+		log.Error("reactivation confirmation with invalid token")
+		return
+	}
+	if err != nil {
+		http.Error(w, "unable to reactivate user", http.StatusInternalServerError)
+		log.Error("unable to confirm reactivation ", err)
+		return
+	}
+	recordAuditEvent(r, user.Id, auditmodel.ActionAccountReactivated, nil)
+	if _, err := w.Write([]byte("user has been reactivated, please login")); err != nil {
+		log.Errorf("unable to write response %s", err)
+	}
+	log.Infof("user %v has been reactivated", user.Id)
+}
@@ -0,0 +1,172 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/go-auth-microservice/pkg/config"
+	authMiddleware "github.com/go-auth-microservice/pkg/middleware/auth"
+	auditmodel "github.com/go-auth-microservice/pkg/model/auditModel"
+	usermodel "github.com/go-auth-microservice/pkg/model/userModel"
+	clientip "github.com/go-auth-microservice/pkg/utils/clientIP"
+	"github.com/go-auth-microservice/pkg/utils/logger"
+	"github.com/go-auth-microservice/pkg/utils/mailer"
+	"github.com/go-auth-microservice/pkg/utils/risk"
+	"github.com/go-auth-microservice/pkg/utils/validation"
+)
+
+// assessLoginRisk scores a login with the right password by the reputation of
+// the IP, the travel speed since the last login, recent failed logins and
+// whether the device is new. The assessment is recorded as audit event.
+func assessLoginRisk(r *http.Request, userData usermodel.UserLogin) risk.Assessment {
+	log := logger.InitializeAuditLogger()
+	appConfig := config.GetConfig()
+	ip := clientip.FromRequest(r)
+	signals := risk.Signals{ListedIP: risk.GetReputationList().Contains(ip)}
+	failedSince := time.Now().Add(-time.Minute * time.Duration(appConfig.GetLoginFailureWindow()))
+	loginSignals, err := userData.GetLoginSignals(ip, r.UserAgent(), failedSince)
+	if err != nil {
+		log.Errorf("unable to load login signals of user %v %v", userData.GetUserID(), err)
+	} else {
+		signals.NewDevice = loginSignals.NewDevice
+		signals.RecentFailures = int(loginSignals.RecentFailures)
+		if last := loginSignals.LastLogin; last != nil {
+			locator := risk.GetLocator()
+			if signals.From, err = locator.Locate(last.IP); err != nil {
+				log.Errorf("unable to locate IP %s %v", last.IP, err)
+			}
+			if signals.To, err = locator.Locate(ip); err != nil {
+				log.Errorf("unable to locate IP %s %v", ip, err)
+			}
+			signals.Elapsed = time.Since(last.CreatedAt).Hours()
+		}
+	}
+	assessment := risk.Assess(signals, risk.Policy{
+		ChallengeScore: appConfig.GetRiskChallengeScore(),
+		BlockScore:     appConfig.GetRiskBlockScore(),
+		MaxTravelSpeed: float64(appConfig.GetRiskMaxTravelSpeed()),
+	})
+	details := map[string]interface{}{
+		"score":    assessment.Score,
+		"decision": assessment.Decision,
+		"reasons":  assessment.Reasons,
+	}
+	if signals.To != nil && signals.To.Country != "" {
+		details["country"] = signals.To.Country
+	}
+	recordAuditEvent(r, userData.GetUserID(), auditmodel.ActionLoginRiskAssessed, details)
+	return assessment
+}
+
+// riskStopsLogin assesses a login with valid credentials if risk based
+// authentication is on and answers it if it is blocked or challenged. It tells
+// if the login stops here.
+func riskStopsLogin(w http.ResponseWriter, r *http.Request, userData usermodel.UserLogin, method string, cookies bool) bool {
+	if !config.GetConfig().GetRiskBasedAuth() {
+		return false
+	}
+	log := logger.InitializeAuditLogger()
+	switch assessment := assessLoginRisk(r, userData); assessment.Decision {
+	case risk.Block:
+		recordLogin(r, userData, method, usermodel.LoginBlocked)
+		loginBlocked(w)
+		log.Errorf("risky login of user %v blocked with score %d", userData.GetUserID(), assessment.Score)
+		return true
+	case risk.Challenge:
+		recordLogin(r, userData, method, usermodel.LoginChallenged)
+		challengeLogin(w, userData, method, cookies)
+		log.Infof("risky login of user %v challenged with score %d", userData.GetUserID(), assessment.Score)
+		return true
+	}
+	return false
+}
+
+// loginBlocked answers a login which has been blocked as too risky.
+func loginBlocked(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusForbidden)
+	res := map[string]interface{}{}
+	res["error"] = "login_blocked"
+	res["message"] = "login has been blocked as suspicious please contact admin"
+	if err := json.NewEncoder(w).Encode(res); err != nil {
+		logger.InitializeAuditLogger().Errorf("unable to encode json response %s", err)
+	}
+}
+
+// challengeLogin emails a code to the user which has to be sent to
+// /auth/login/challenge together with the returned challenge token to
+// complete the login.
+func challengeLogin(w http.ResponseWriter, userData usermodel.UserLogin, method string, cookies bool) {
+	log := logger.InitializeAuditLogger()
+	token, code, err := userData.CreateLoginChallenge(method, cookies)
+	if err != nil {
+		http.Error(w, "unable to challenge login", http.StatusInternalServerError)
+		log.Errorf("unable to create login challenge for user %v %v", userData.GetUserID(), err)
+		return
+	}
+	body := fmt.Sprintf("A login to your account needs to be confirmed. Your code is:\n\n%s\n\n"+
+		"It expires in %d minutes. If this was not you, change your password right away.", code, config.GetConfig().GetLoginChallengeExpiry())
+	if err := mailer.GetMailer().Send(userData.GetUserEmail(), "Confirm your login", body); err != nil {
+		http.Error(w, "unable to send login code", http.StatusInternalServerError)
+		log.Errorf("unable to send login code to user %v %v", userData.GetUserID(), err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusUnauthorized)
+	res := map[string]interface{}{}
+	res["error"] = "step_up_required"
+	res["message"] = "enter the code sent to your email to complete the login"
+	res["challengeToken"] = token
+	if err := json.NewEncoder(w).Encode(res); err != nil {
+		log.Errorf("unable to encode json response %s", err)
+	}
+}
+
+// CompleteLoginChallenge completes a challenged login with the code from the
+// email and issues the tokens like Login.
+func CompleteLoginChallenge(w http.ResponseWriter, r *http.Request) {
+	log := logger.InitializeAuditLogger()
+	var data struct {
+		ChallengeToken string `json:"challengeToken" validate:"required"`
+		Code           string `json:"code" validate:"required"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	if err := validation.Validator.Struct(data); err != nil {
+		http.Error(w, "challengeToken and code are required", http.StatusBadRequest)
+		return
+	}
+	challenge, err := usermodel.CompleteLoginChallenge(data.ChallengeToken, data.Code)
+	if errors.Is(err, usermodel.ErrLoginChallengeNotFound) {
+		http.Error(w, "login challenge not found or expired", http.StatusUnauthorized)
+		return
+	}
+	if errors.Is(err, usermodel.ErrLoginChallengeCode) {
+		http.Error(w, "invalid code", http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		http.Error(w, "unable to complete login", http.StatusInternalServerError)
+		log.Error("unable to complete login challenge ", err)
+		return
+	}
+	var userData usermodel.UserLogin
+	userData, err = usermodel.FindUserByID(challenge.UserId)
+	if err != nil {
+		http.Error(w, "login challenge not found or expired", http.StatusUnauthorized)
+		log.Errorf("unable to find user of login challenge %v %v", challenge.Id, err)
+		return
+	}
+	if state := userData.GetAccountState(); !state.AllowsLogin() {
+		recordLogin(r, userData, challenge.Method, usermodel.LoginDenied)
+		authMiddleware.AccountUnavailable(w, state)
+		log.Errorf("login challenge of user %d with account status %s", userData.GetUserID(), state.Status)
+		return
+	}
+	completeLogin(w, r, userData, challenge.Method, challenge.Cookies)
+}
@@ -0,0 +1,147 @@
+package controller
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	authMiddleware "github.com/go-auth-microservice/pkg/middleware/auth"
+	usermodel "github.com/go-auth-microservice/pkg/model/userModel"
+	"github.com/go-auth-microservice/pkg/utils/logger"
+	samlauth "github.com/go-auth-microservice/pkg/utils/samlAuth"
+	"github.com/go-chi/chi/v5"
+)
+
+// samlRequestID returns the id of the AuthnRequest of a SAML login. The
+// nonce of the login state never leaves the server, so only the identity
+// provider the request has been sent to can answer to it.
+func samlRequestID(state *usermodel.FederationState) string {
+	return "id-" + state.Nonce
+}
+
+// GetSAMLTenants lists the tenants users can log in with SAML through.
+func GetSAMLTenants(w http.ResponseWriter, r *http.Request) {
+	res := map[string]interface{}{}
+	res["tenants"] = samlauth.GetTenantNames()
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(res); err != nil {
+		logger.InitializeAuditLogger().Errorf("unable to encode json response %s", err)
+	}
+}
+
+// GetSAMLMetadata answers with the service provider metadata of the tenant
+// to register at its identity provider.
+func GetSAMLMetadata(w http.ResponseWriter, r *http.Request) {
+	log := logger.InitializeAuditLogger()
+	tenant, err := samlauth.GetTenant(chi.URLParam(r, "tenant"))
+	if err != nil {
+		http.Error(w, "tenant not found", http.StatusNotFound)
+		return
+	}
+	metadata, err := tenant.Metadata()
+	if err != nil {
+		http.Error(w, "unable to create metadata", http.StatusInternalServerError)
+		log.Errorf("unable to create saml metadata of tenant %s %v", tenant.Name(), err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/samlmetadata+xml")
+	if _, err := w.Write(metadata); err != nil {
+		log.Errorf("unable to write saml metadata %s", err)
+	}
+}
+
+// StartSAMLLogin redirects the browser to the identity provider of the
+// tenant with a signed AuthnRequest. With ?cookies=true the login ends in a
+// cookie session.
+func StartSAMLLogin(w http.ResponseWriter, r *http.Request) {
+	log := logger.InitializeAuditLogger()
+	tenant, err := samlauth.GetTenant(chi.URLParam(r, "tenant"))
+	if err != nil {
+		http.Error(w, "tenant not found", http.StatusNotFound)
+		return
+	}
+	provider := usermodel.SAMLProvider(tenant.Name())
+	token, state, err := usermodel.CreateFederationState(provider, nil, r.URL.Query().Get("cookies") == "true")
+	if err != nil {
+		http.Error(w, "unable to start login", http.StatusInternalServerError)
+		log.Errorf("unable to create saml state for %s %v", tenant.Name(), err)
+		return
+	}
+	authURL, err := tenant.AuthnRequestURL(r.Context(), samlRequestID(state), token)
+	if err != nil {
+		http.Error(w, "identity provider is unavailable", http.StatusBadGateway)
+		log.Errorf("unable to create saml request for tenant %s %v", tenant.Name(), err)
+		return
+	}
+	authMiddleware.SetSAMLStateCookie(w, token, state.ExpiresAt)
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// SAMLAssertionConsumer completes a SAML login with the response the
+// identity provider posts. The assertion logs in as the user of its NameID,
+// or as the user with the same email if the email is in a domain of the
+// tenant, see usermodel.FederateIdentity.
+func SAMLAssertionConsumer(w http.ResponseWriter, r *http.Request) {
+	log := logger.InitializeAuditLogger()
+	tenant, err := samlauth.GetTenant(chi.URLParam(r, "tenant"))
+	if err != nil {
+		http.Error(w, "tenant not found", http.StatusNotFound)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+	relayState := r.PostForm.Get("RelayState")
+	samlResponse := r.PostForm.Get("SAMLResponse")
+	if relayState == "" || samlResponse == "" {
+		http.Error(w, "SAMLResponse and RelayState are required", http.StatusBadRequest)
+		return
+	}
+	// the response has to come back to the browser which started the login,
+	// IdP initiated logins are not accepted
+	cookie, err := r.Cookie(authMiddleware.SAMLStateCookie)
+	if err != nil || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(relayState)) != 1 {
+		federationError(w, http.StatusUnauthorized, "invalid_state", "login has not been started in this browser")
+		log.Errorf("saml relay state of tenant %s does not match the state cookie", tenant.Name())
+		return
+	}
+	authMiddleware.ClearSAMLStateCookie(w)
+	provider := usermodel.SAMLProvider(tenant.Name())
+	state, err := usermodel.ConsumeFederationState(relayState, provider)
+	if errors.Is(err, usermodel.ErrFederationStateNotFound) {
+		federationError(w, http.StatusUnauthorized, "invalid_state", "login expired please try again")
+		return
+	}
+	if err != nil {
+		http.Error(w, "unable to complete login", http.StatusInternalServerError)
+		log.Errorf("unable to load saml state of tenant %s %v", tenant.Name(), err)
+		return
+	}
+	identity, err := tenant.ParseResponse(r.Context(), samlResponse, samlRequestID(state))
+	if err != nil {
+		federationError(w, http.StatusUnauthorized, "invalid_response", "unable to verify the login at the identity provider")
+		log.Errorf("rejected saml response of tenant %s %v", tenant.Name(), err)
+		return
+	}
+	user, ok := federateIdentity(w, r, usermodel.ExternalIdentity{
+		Provider:      provider,
+		Subject:       identity.Subject,
+		Email:         identity.Email,
+		EmailVerified: identity.EmailVerified,
+		Name:          identity.Name,
+	}, tenant.CreateUsers())
+	if !ok {
+		return
+	}
+	if role, ok := tenant.RoleOf(identity, usermodel.RoleUser); ok {
+		var userData usermodel.UserFederation = user
+		if err := userData.SyncRole(role); err != nil {
+			http.Error(w, "unable to complete login", http.StatusInternalServerError)
+			log.Errorf("unable to sync role of user %v %v", user.Id, err)
+			return
+		}
+	}
+	completeFederatedLogin(w, r, user, provider, state.Cookies)
+}
@@ -0,0 +1,720 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-auth-microservice/pkg/config"
+	authMiddleware "github.com/go-auth-microservice/pkg/middleware/auth"
+	auditmodel "github.com/go-auth-microservice/pkg/model/auditModel"
+	tokencache "github.com/go-auth-microservice/pkg/model/tokenCache"
+	usermodel "github.com/go-auth-microservice/pkg/model/userModel"
+	"github.com/go-auth-microservice/pkg/utils/logger"
+	"github.com/go-auth-microservice/pkg/utils/scim"
+	"github.com/go-auth-microservice/pkg/utils/validation"
+	"github.com/go-chi/chi/v5"
+)
+
+// maxSCIMBodySize limits the body of SCIM requests, group updates can list
+// many members
+const maxSCIMBodySize = 1024 * 1024
+
+// Page sizes of SCIM list responses
+const (
+	scimDefaultCount = 100
+	scimMaxCount     = 200
+)
+
+// How a SCIM update has changed the account status of a user.
+const (
+	scimUnchanged = iota
+	scimDeactivated
+	scimReactivated
+)
+
+func scimError(w http.ResponseWriter, status int, scimType string, detail string) {
+	writeSCIM(w, status, scim.Error{
+		Schemas:  []string{scim.SchemaError},
+		Status:   strconv.Itoa(status),
+		ScimType: scimType,
+		Detail:   detail,
+	})
+}
+
+func writeSCIM(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", scim.ContentType)
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		logger.InitializeAuditLogger().Errorf("unable to encode json response %s", err)
+	}
+}
+
+func decodeSCIM(w http.ResponseWriter, r *http.Request, body interface{}) bool {
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxSCIMBodySize)).Decode(body); err != nil {
+		scimError(w, http.StatusBadRequest, scim.ErrorInvalidSyntax, "invalid request body")
+		return false
+	}
+	return true
+}
+
+// patchSCIMError answers a PATCH request whose operations can not be applied.
+func patchSCIMError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, scim.ErrInvalidPath):
+		scimError(w, http.StatusBadRequest, scim.ErrorInvalidPath, err.Error())
+	case errors.Is(err, scim.ErrNoTarget):
+		scimError(w, http.StatusBadRequest, scim.ErrorNoTarget, err.Error())
+	default:
+		scimError(w, http.StatusBadRequest, scim.ErrorInvalidValue, err.Error())
+	}
+}
+
+func scimBaseURL() string {
+	return strings.TrimSuffix(config.GetConfig().GetAppBaseURL(), "/") + "/api/v1/scim/v2"
+}
+
+func scimTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339)
+}
+
+// scimSuspensionReason is the reason of suspensions and deletions by the
+// tenant, only these suspensions are lifted when the tenant activates the
+// user again.
+func scimSuspensionReason(tenant *scim.Tenant) string {
+	return "deprovisioned by scim tenant " + tenant.Name()
+}
+
+func scimUserResource(user *usermodel.ProvisionedUser) scim.User {
+	id := strconv.FormatUint(user.Id, 10)
+	active := scim.Boolean(user.GetAccountState().AllowsSessions())
+	resource := scim.User{
+		Schemas:     []string{scim.SchemaUser},
+		Id:          id,
+		ExternalId:  user.ExternalId,
+		UserName:    user.Email,
+		DisplayName: user.DisplayName,
+		Locale:      user.Locale,
+		Timezone:    user.Timezone,
+		Active:      &active,
+		Emails:      []scim.MultiValue{{Value: user.Email, Type: "work", Primary: true}},
+		Roles:       []scim.MultiValue{{Value: user.Role}},
+		Meta: &scim.Meta{
+			ResourceType: "User",
+			Created:      scimTime(user.CreatedAt),
+			LastModified: scimTime(user.UpdatedAt),
+			Location:     scimBaseURL() + "/Users/" + id,
+			Version:      "W/" + user.GetETag(),
+		},
+	}
+	if user.GivenName != "" || user.FamilyName != "" {
+		resource.Name = &scim.Name{GivenName: user.GivenName, FamilyName: user.FamilyName}
+	}
+	for _, group := range user.Groups {
+		groupId := strconv.FormatUint(group.Id, 10)
+		resource.Groups = append(resource.Groups, scim.MultiValue{Value: groupId, Display: group.DisplayName, Ref: scimBaseURL() + "/Groups/" + groupId})
+	}
+	return resource
+}
+
+func scimGroupResource(group *usermodel.SCIMGroup) scim.Group {
+	id := strconv.FormatUint(group.Id, 10)
+	resource := scim.Group{
+		Schemas:     []string{scim.SchemaGroup},
+		Id:          id,
+		ExternalId:  group.ExternalId,
+		DisplayName: group.DisplayName,
+		Members:     []scim.MultiValue{},
+		Meta: &scim.Meta{
+			ResourceType: "Group",
+			Created:      scimTime(group.CreatedAt),
+			LastModified: scimTime(group.UpdatedAt),
+			Location:     scimBaseURL() + "/Groups/" + id,
+		},
+	}
+	for _, userId := range group.MemberIds() {
+		memberId := strconv.FormatUint(userId, 10)
+		resource.Members = append(resource.Members, scim.MultiValue{Value: memberId, Ref: scimBaseURL() + "/Users/" + memberId})
+	}
+	return resource
+}
+
+// applySCIMUser replaces the attributes of the user with the ones of the
+// resource. Deactivating the user suspends it, activating it again only lifts
+// suspensions by the tenant, not the ones of an admin. Read only attributes
+// are ignored.
+func applySCIMUser(tenant *scim.Tenant, user *usermodel.ProvisionedUser, resource scim.User) (int, error) {
+	email := strings.TrimSpace(resource.UserName)
+	if err := validation.Validator.Var(email, "required,email"); err != nil {
+		return scimUnchanged, errors.New("userName must be an email")
+	}
+	user.Email = email
+	user.ExternalId = resource.ExternalId
+	profile := user.UserProfile
+	profile.DisplayName = resource.DisplayName
+	profile.GivenName = ""
+	profile.FamilyName = ""
+	if resource.Name != nil {
+		profile.GivenName = resource.Name.GivenName
+		profile.FamilyName = resource.Name.FamilyName
+	}
+	profile.Locale = resource.Locale
+	profile.Timezone = resource.Timezone
+	if err := validation.Validator.Struct(profile); err != nil {
+		return scimUnchanged, err
+	}
+	user.UserProfile = profile
+	if resource.Active == nil {
+		return scimUnchanged, nil
+	}
+	state := user.GetAccountState()
+	if !bool(*resource.Active) && state.AllowsSessions() {
+		if err := user.Suspend(scimSuspensionReason(tenant), nil); err != nil {
+			return scimUnchanged, err
+		}
+		return scimDeactivated, nil
+	}
+	if bool(*resource.Active) && state.Status == usermodel.StatusSuspended && state.Reason == scimSuspensionReason(tenant) {
+		if err := user.Unsuspend(); err != nil {
+			return scimUnchanged, err
+		}
+		return scimReactivated, nil
+	}
+	return scimUnchanged, nil
+}
+
+// saveSCIMUser stores a user changed by the tenant and answers with it. A
+// deactivated user is logged out everywhere.
+func saveSCIMUser(w http.ResponseWriter, r *http.Request, user *usermodel.ProvisionedUser, resource scim.User) {
+	log := logger.InitializeAuditLogger()
+	tenant := authMiddleware.GetSCIMTenant(r.Context())
+	change, err := applySCIMUser(tenant, user, resource)
+	if err != nil {
+		scimError(w, http.StatusBadRequest, scim.ErrorInvalidValue, err.Error())
+		return
+	}
+	err = user.SaveProvisioned()
+	if errors.Is(err, usermodel.ErrEmailTaken) {
+		scimError(w, http.StatusConflict, scim.ErrorUniqueness, "userName is already in use")
+		return
+	}
+	if err != nil {
+		scimError(w, http.StatusInternalServerError, "", "unable to update user")
+		log.Errorf("unable to update user %v of scim tenant %s %v", user.Id, tenant.Name(), err)
+		return
+	}
+	details := map[string]interface{}{"scimTenant": tenant.Name()}
+	switch change {
+	case scimDeactivated:
+		var revokedUsers tokencache.RevokedUsers = tokencache.GetRevokedUserTokens()
+		revokedUsers.Revoke(user.Id, time.Now())
+		recordAuditEvent(r, user.Id, auditmodel.ActionAccountSuspended, details)
+		log.Infof("user %v has been deprovisioned by scim tenant %s", user.Id, tenant.Name())
+	case scimReactivated:
+		recordAuditEvent(r, user.Id, auditmodel.ActionAccountUnsuspended, details)
+		log.Infof("user %v has been reactivated by scim tenant %s", user.Id, tenant.Name())
+	}
+	writeSCIM(w, http.StatusOK, scimUserResource(user))
+}
+
+// scimList is the filter and page of a request listing resources. Pages
+// start at startIndex, which counts from 1.
+type scimList struct {
+	filter     scim.Filter
+	startIndex int
+	count      int
+}
+
+// parseSCIMList reads the filter and page of a request listing resources.
+func parseSCIMList(w http.ResponseWriter, r *http.Request) (*scimList, bool) {
+	query := r.URL.Query()
+	list := &scimList{startIndex: 1, count: scimDefaultCount}
+	if filter := query.Get("filter"); filter != "" {
+		parsed, err := scim.ParseFilter(filter)
+		if err != nil {
+			scimError(w, http.StatusBadRequest, scim.ErrorInvalidFilter, err.Error())
+			return nil, false
+		}
+		list.filter = parsed
+	}
+	var err error
+	if value := query.Get("startIndex"); value != "" {
+		if list.startIndex, err = strconv.Atoi(value); err != nil {
+			scimError(w, http.StatusBadRequest, scim.ErrorInvalidValue, "startIndex must be a number")
+			return nil, false
+		}
+		list.startIndex = max(list.startIndex, 1)
+	}
+	if value := query.Get("count"); value != "" {
+		if list.count, err = strconv.Atoi(value); err != nil {
+			scimError(w, http.StatusBadRequest, scim.ErrorInvalidValue, "count must be a number")
+			return nil, false
+		}
+		list.count = min(max(list.count, 0), scimMaxCount)
+	}
+	return list, true
+}
+
+// query translates the list into a query of the database. The page is pushed
+// down if there is no filter or the filter compares one of the attributes by
+// eq, like the userName eq lookups identity providers make before creating a
+// user. Other filters query all resources, which are filtered and paged in
+// memory afterwards, pushed tells which of both happened.
+func (list *scimList) query(attributes ...string) (query usermodel.SCIMQuery, pushed bool) {
+	page := usermodel.SCIMQuery{Offset: list.startIndex - 1, Limit: list.count}
+	if list.filter == nil {
+		return page, true
+	}
+	for _, attribute := range attributes {
+		value, ok := scim.EqualValue(list.filter, attribute)
+		if !ok || value == "" {
+			continue
+		}
+		switch attribute {
+		case "userName":
+			page.UserName = value
+		case "externalId":
+			page.ExternalId = value
+		case "displayName":
+			page.DisplayName = value
+		}
+		return page, true
+	}
+	return usermodel.SCIMQuery{Limit: -1}, false
+}
+
+// write answers with the page of the resources, total counts the resources on
+// all pages. Resources of a query which has not been pushed down are filtered
+// and paged first.
+func (list *scimList) write(w http.ResponseWriter, r *http.Request, resources []map[string]interface{}, total int, pushed bool) {
+	if !pushed {
+		matching := []map[string]interface{}{}
+		for _, resource := range resources {
+			if list.filter == nil || list.filter.Matches(resource) {
+				matching = append(matching, resource)
+			}
+		}
+		total = len(matching)
+		resources = []map[string]interface{}{}
+		if list.startIndex <= len(matching) {
+			resources = matching[list.startIndex-1 : min(list.startIndex-1+list.count, len(matching))]
+		}
+	}
+	if excluded := r.URL.Query().Get("excludedAttributes"); excluded != "" {
+		for _, resource := range resources {
+			scim.ExcludeAttributes(resource, excluded)
+		}
+	}
+	writeSCIM(w, http.StatusOK, scim.ListResponse{
+		Schemas:      []string{scim.SchemaListResponse},
+		TotalResults: total,
+		StartIndex:   list.startIndex,
+		ItemsPerPage: len(resources),
+		Resources:    resources,
+	})
+}
+
+func scimResourceID(w http.ResponseWriter, r *http.Request) (uint64, bool) {
+	id, err := strconv.ParseUint(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		scimError(w, http.StatusNotFound, "", "resource not found")
+		return 0, false
+	}
+	return id, true
+}
+
+// findSCIMUser returns the user of the request if the tenant has provisioned it.
+func findSCIMUser(w http.ResponseWriter, r *http.Request) (*usermodel.ProvisionedUser, bool) {
+	id, ok := scimResourceID(w, r)
+	if !ok {
+		return nil, false
+	}
+	tenant := authMiddleware.GetSCIMTenant(r.Context())
+	user, err := usermodel.FindProvisionedUser(tenant.Name(), id)
+	if errors.Is(err, usermodel.ErrSCIMResourceNotFound) {
+		scimError(w, http.StatusNotFound, "", "user not found")
+		return nil, false
+	}
+	if err != nil {
+		scimError(w, http.StatusInternalServerError, "", "unable to load user")
+		logger.InitializeAuditLogger().Errorf("unable to find user %v of scim tenant %s %v", id, tenant.Name(), err)
+		return nil, false
+	}
+	return user, true
+}
+
+// GetSCIMServiceProviderConfig tells identity providers which SCIM features
+// are supported.
+func GetSCIMServiceProviderConfig(w http.ResponseWriter, r *http.Request) {
+	writeSCIM(w, http.StatusOK, map[string]interface{}{
+		"schemas":        []string{scim.SchemaServiceProviderConfig},
+		"patch":          map[string]interface{}{"supported": true},
+		"bulk":           map[string]interface{}{"supported": false, "maxOperations": 0, "maxPayloadSize": 0},
+		"filter":         map[string]interface{}{"supported": true, "maxResults": scimMaxCount},
+		"changePassword": map[string]interface{}{"supported": false},
+		"sort":           map[string]interface{}{"supported": false},
+		"etag":           map[string]interface{}{"supported": false},
+		"authenticationSchemes": []map[string]interface{}{{
+			"type":        "oauthbearertoken",
+			"name":        "Bearer token",
+			"description": "The bearer token of the tenant",
+		}},
+		"meta": map[string]interface{}{"resourceType": "ServiceProviderConfig", "location": scimBaseURL() + "/ServiceProviderConfig"},
+	})
+}
+
+// GetSCIMResourceTypes lists the resources which can be provisioned.
+func GetSCIMResourceTypes(w http.ResponseWriter, r *http.Request) {
+	resourceTypes := []map[string]interface{}{}
+	for _, resourceType := range []struct{ name, endpoint, schema string }{
+		{"User", "/Users", scim.SchemaUser},
+		{"Group", "/Groups", scim.SchemaGroup},
+	} {
+		resourceTypes = append(resourceTypes, map[string]interface{}{
+			"schemas":  []string{scim.SchemaResourceType},
+			"id":       resourceType.name,
+			"name":     resourceType.name,
+			"endpoint": resourceType.endpoint,
+			"schema":   resourceType.schema,
+			"meta":     map[string]interface{}{"resourceType": "ResourceType", "location": scimBaseURL() + "/ResourceTypes/" + resourceType.name},
+		})
+	}
+	writeSCIM(w, http.StatusOK, scim.ListResponse{
+		Schemas:      []string{scim.SchemaListResponse},
+		TotalResults: len(resourceTypes),
+		StartIndex:   1,
+		ItemsPerPage: len(resourceTypes),
+		Resources:    resourceTypes,
+	})
+}
+
+// GetSCIMUsers lists the users the tenant has provisioned, e.g. with
+// ?filter=userName eq "alice@example.com" to find a user before creating it.
+func GetSCIMUsers(w http.ResponseWriter, r *http.Request) {
+	log := logger.InitializeAuditLogger()
+	tenant := authMiddleware.GetSCIMTenant(r.Context())
+	list, ok := parseSCIMList(w, r)
+	if !ok {
+		return
+	}
+	query, pushed := list.query("userName", "externalId")
+	users, total, err := usermodel.FindProvisionedUsers(tenant.Name(), query)
+	if err != nil {
+		scimError(w, http.StatusInternalServerError, "", "unable to load users")
+		log.Errorf("unable to find users of scim tenant %s %v", tenant.Name(), err)
+		return
+	}
+	resources := make([]map[string]interface{}, 0, len(users))
+	for i := range users {
+		resource, err := scim.ToMap(scimUserResource(&users[i]))
+		if err != nil {
+			scimError(w, http.StatusInternalServerError, "", "unable to load users")
+			log.Errorf("unable to convert user %v of scim tenant %s %v", users[i].Id, tenant.Name(), err)
+			return
+		}
+		resources = append(resources, resource)
+	}
+	list.write(w, r, resources, int(total), pushed)
+}
+
+func GetSCIMUser(w http.ResponseWriter, r *http.Request) {
+	user, ok := findSCIMUser(w, r)
+	if !ok {
+		return
+	}
+	w.Header().Set("ETag", "W/"+user.GetETag())
+	writeSCIM(w, http.StatusOK, scimUserResource(user))
+}
+
+// CreateSCIMUser provisions a user. The user has no password and logs in
+// through the identity provider of the tenant.
+func CreateSCIMUser(w http.ResponseWriter, r *http.Request) {
+	log := logger.InitializeAuditLogger()
+	tenant := authMiddleware.GetSCIMTenant(r.Context())
+	var resource scim.User
+	if !decodeSCIM(w, r, &resource) {
+		return
+	}
+	user := &usermodel.ProvisionedUser{UserData: usermodel.CreateUser("")}
+	change, err := applySCIMUser(tenant, user, resource)
+	if err != nil {
+		scimError(w, http.StatusBadRequest, scim.ErrorInvalidValue, err.Error())
+		return
+	}
+	provisioned, err := usermodel.ProvisionUser(tenant.Name(), user.UserData, user.ExternalId)
+	if errors.Is(err, usermodel.ErrEmailTaken) {
+		scimError(w, http.StatusConflict, scim.ErrorUniqueness, "userName is already in use")
+		return
+	}
+	if err != nil {
+		scimError(w, http.StatusInternalServerError, "", "unable to create user")
+		log.Errorf("unable to provision user for scim tenant %s %v", tenant.Name(), err)
+		return
+	}
+	details := map[string]interface{}{"scimTenant": tenant.Name()}
+	recordAuditEvent(r, provisioned.Id, auditmodel.ActionAccountProvisioned, details)
+	if change == scimDeactivated {
+		recordAuditEvent(r, provisioned.Id, auditmodel.ActionAccountSuspended, details)
+	}
+	resourceBody := scimUserResource(provisioned)
+	w.Header().Set("Location", resourceBody.Meta.Location)
+	writeSCIM(w, http.StatusCreated, resourceBody)
+	log.Infof("user %v has been provisioned by scim tenant %s", provisioned.Id, tenant.Name())
+}
+
+// ReplaceSCIMUser replaces the attributes of a user.
+func ReplaceSCIMUser(w http.ResponseWriter, r *http.Request) {
+	user, ok := findSCIMUser(w, r)
+	if !ok {
+		return
+	}
+	var resource scim.User
+	if !decodeSCIM(w, r, &resource) {
+		return
+	}
+	saveSCIMUser(w, r, user, resource)
+}
+
+// PatchSCIMUser changes attributes of a user, identity providers deprovision
+// users by replacing active with false.
+func PatchSCIMUser(w http.ResponseWriter, r *http.Request) {
+	user, ok := findSCIMUser(w, r)
+	if !ok {
+		return
+	}
+	var patch scim.PatchRequest
+	if !decodeSCIM(w, r, &patch) {
+		return
+	}
+	resourceMap, err := scim.ToMap(scimUserResource(user))
+	if err != nil {
+		scimError(w, http.StatusInternalServerError, "", "unable to update user")
+		return
+	}
+	if err := scim.ApplyPatch(resourceMap, patch.Operations); err != nil {
+		patchSCIMError(w, err)
+		return
+	}
+	var resource scim.User
+	if err := scim.FromMap(resourceMap, &resource); err != nil {
+		patchSCIMError(w, err)
+		return
+	}
+	saveSCIMUser(w, r, user, resource)
+}
+
+// DeleteSCIMUser schedules the deletion of a user like the user deleting
+// their own account, the tokens of the user are revoked right away.
+func DeleteSCIMUser(w http.ResponseWriter, r *http.Request) {
+	log := logger.InitializeAuditLogger()
+	tenant := authMiddleware.GetSCIMTenant(r.Context())
+	user, ok := findSCIMUser(w, r)
+	if !ok {
+		return
+	}
+	groups := user.Groups
+	gracePeriod := time.Hour * 24 * time.Duration(config.GetConfig().GetDeletionGracePeriod())
+	if err := user.Deprovision(gracePeriod, scimSuspensionReason(tenant)); err != nil {
+		scimError(w, http.StatusInternalServerError, "", "unable to delete user")
+		log.Errorf("unable to deprovision user %v of scim tenant %s %v", user.Id, tenant.Name(), err)
+		return
+	}
+	var revokedUsers tokencache.RevokedUsers = tokencache.GetRevokedUserTokens()
+	revokedUsers.Revoke(user.Id, time.Now())
+	recordAuditEvent(r, user.Id, auditmodel.ActionAccountDeletionScheduled, map[string]interface{}{"scimTenant": tenant.Name()})
+	if len(groups) > 0 {
+		if err := usermodel.SyncProvisionedRoles(tenant, []uint64{user.Id}); err != nil {
+			log.Errorf("unable to sync role of user %v %v", user.Id, err)
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+	log.Infof("user %v has been deleted by scim tenant %s", user.Id, tenant.Name())
+}
+
+// findSCIMGroup returns the group of the request if it belongs to the tenant.
+func findSCIMGroup(w http.ResponseWriter, r *http.Request) (*usermodel.SCIMGroup, bool) {
+	id, ok := scimResourceID(w, r)
+	if !ok {
+		return nil, false
+	}
+	tenant := authMiddleware.GetSCIMTenant(r.Context())
+	group, err := usermodel.FindSCIMGroup(tenant.Name(), id)
+	if errors.Is(err, usermodel.ErrSCIMResourceNotFound) {
+		scimError(w, http.StatusNotFound, "", "group not found")
+		return nil, false
+	}
+	if err != nil {
+		scimError(w, http.StatusInternalServerError, "", "unable to load group")
+		logger.InitializeAuditLogger().Errorf("unable to find group %v of scim tenant %s %v", id, tenant.Name(), err)
+		return nil, false
+	}
+	return group, true
+}
+
+// saveSCIMGroup stores a group created or changed by the tenant, syncs the
+// roles of the users who joined or left it and answers with it.
+func saveSCIMGroup(w http.ResponseWriter, r *http.Request, group *usermodel.SCIMGroup, resource scim.Group, status int) {
+	log := logger.InitializeAuditLogger()
+	tenant := authMiddleware.GetSCIMTenant(r.Context())
+	displayName := strings.TrimSpace(resource.DisplayName)
+	if displayName == "" || len(displayName) > 256 {
+		scimError(w, http.StatusBadRequest, scim.ErrorInvalidValue, "displayName is required")
+		return
+	}
+	group.Tenant = tenant.Name()
+	group.DisplayName = displayName
+	group.ExternalId = resource.ExternalId
+	group.Members = []usermodel.SCIMGroupMember{}
+	for _, member := range resource.Members {
+		userId, err := strconv.ParseUint(member.Value, 10, 64)
+		if err != nil {
+			scimError(w, http.StatusBadRequest, scim.ErrorInvalidValue, "member "+member.Value+" is not a user of the tenant")
+			return
+		}
+		group.Members = append(group.Members, usermodel.SCIMGroupMember{UserId: userId})
+	}
+	affected, err := group.Save()
+	if errors.Is(err, usermodel.ErrSCIMUnknownMember) {
+		scimError(w, http.StatusBadRequest, scim.ErrorInvalidValue, "members must be users of the tenant")
+		return
+	}
+	if errors.Is(err, usermodel.ErrSCIMGroupExists) {
+		scimError(w, http.StatusConflict, scim.ErrorUniqueness, "displayName is already in use")
+		return
+	}
+	if err != nil {
+		scimError(w, http.StatusInternalServerError, "", "unable to save group")
+		log.Errorf("unable to save group of scim tenant %s %v", tenant.Name(), err)
+		return
+	}
+	if err := usermodel.SyncProvisionedRoles(tenant, affected); err != nil {
+		scimError(w, http.StatusInternalServerError, "", "unable to sync the roles of the members")
+		log.Errorf("unable to sync roles of group %v of scim tenant %s %v", group.Id, tenant.Name(), err)
+		return
+	}
+	resourceBody := scimGroupResource(group)
+	if status == http.StatusCreated {
+		w.Header().Set("Location", resourceBody.Meta.Location)
+	}
+	writeSCIM(w, status, resourceBody)
+}
+
+// GetSCIMGroups lists the groups of the tenant, identity providers usually
+// leave out the members with ?excludedAttributes=members.
+func GetSCIMGroups(w http.ResponseWriter, r *http.Request) {
+	log := logger.InitializeAuditLogger()
+	tenant := authMiddleware.GetSCIMTenant(r.Context())
+	list, ok := parseSCIMList(w, r)
+	if !ok {
+		return
+	}
+	query, pushed := list.query("displayName", "externalId")
+	groups, total, err := usermodel.FindSCIMGroups(tenant.Name(), query)
+	if err != nil {
+		scimError(w, http.StatusInternalServerError, "", "unable to load groups")
+		log.Errorf("unable to find groups of scim tenant %s %v", tenant.Name(), err)
+		return
+	}
+	resources := make([]map[string]interface{}, 0, len(groups))
+	for i := range groups {
+		resource, err := scim.ToMap(scimGroupResource(&groups[i]))
+		if err != nil {
+			scimError(w, http.StatusInternalServerError, "", "unable to load groups")
+			log.Errorf("unable to convert group %v of scim tenant %s %v", groups[i].Id, tenant.Name(), err)
+			return
+		}
+		resources = append(resources, resource)
+	}
+	list.write(w, r, resources, int(total), pushed)
+}
+
+func GetSCIMGroup(w http.ResponseWriter, r *http.Request) {
+	group, ok := findSCIMGroup(w, r)
+	if !ok {
+		return
+	}
+	resource, err := scim.ToMap(scimGroupResource(group))
+	if err != nil {
+		scimError(w, http.StatusInternalServerError, "", "unable to load group")
+		return
+	}
+	if excluded := r.URL.Query().Get("excludedAttributes"); excluded != "" {
+		scim.ExcludeAttributes(resource, excluded)
+	}
+	writeSCIM(w, http.StatusOK, resource)
+}
+
+// CreateSCIMGroup creates a group of the tenant. If the tenant maps the group
+// to a role, its members get the role.
+func CreateSCIMGroup(w http.ResponseWriter, r *http.Request) {
+	var resource scim.Group
+	if !decodeSCIM(w, r, &resource) {
+		return
+	}
+	saveSCIMGroup(w, r, &usermodel.SCIMGroup{}, resource, http.StatusCreated)
+}
+
+// ReplaceSCIMGroup replaces the name and the members of a group.
+func ReplaceSCIMGroup(w http.ResponseWriter, r *http.Request) {
+	group, ok := findSCIMGroup(w, r)
+	if !ok {
+		return
+	}
+	var resource scim.Group
+	if !decodeSCIM(w, r, &resource) {
+		return
+	}
+	saveSCIMGroup(w, r, group, resource, http.StatusOK)
+}
+
+// PatchSCIMGroup changes a group, identity providers add and remove members
+// with it.
+func PatchSCIMGroup(w http.ResponseWriter, r *http.Request) {
+	group, ok := findSCIMGroup(w, r)
+	if !ok {
+		return
+	}
+	var patch scim.PatchRequest
+	if !decodeSCIM(w, r, &patch) {
+		return
+	}
+	resourceMap, err := scim.ToMap(scimGroupResource(group))
+	if err != nil {
+		scimError(w, http.StatusInternalServerError, "", "unable to update group")
+		return
+	}
+	if err := scim.ApplyPatch(resourceMap, patch.Operations); err != nil {
+		patchSCIMError(w, err)
+		return
+	}
+	var resource scim.Group
+	if err := scim.FromMap(resourceMap, &resource); err != nil {
+		patchSCIMError(w, err)
+		return
+	}
+	saveSCIMGroup(w, r, group, resource, http.StatusOK)
+}
+
+// DeleteSCIMGroup deletes a group, its former members lose the role the
+// group has given them.
+func DeleteSCIMGroup(w http.ResponseWriter, r *http.Request) {
+	log := logger.InitializeAuditLogger()
+	tenant := authMiddleware.GetSCIMTenant(r.Context())
+	group, ok := findSCIMGroup(w, r)
+	if !ok {
+		return
+	}
+	members, err := group.Delete()
+	if err != nil {
+		scimError(w, http.StatusInternalServerError, "", "unable to delete group")
+		log.Errorf("unable to delete group %v of scim tenant %s %v", group.Id, tenant.Name(), err)
+		return
+	}
+	if err := usermodel.SyncProvisionedRoles(tenant, members); err != nil {
+		log.Errorf("unable to sync roles of the members of group %v %v", group.Id, err)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
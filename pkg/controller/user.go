@@ -2,9 +2,16 @@ package controller
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"net/http"
+	"strings"
 	"time"
 
+	"github.com/go-auth-microservice/pkg/config"
+	authMiddleware "github.com/go-auth-microservice/pkg/middleware/auth"
+	auditmodel "github.com/go-auth-microservice/pkg/model/auditModel"
 	tokencache "github.com/go-auth-microservice/pkg/model/tokenCache"
 	usermodel "github.com/go-auth-microservice/pkg/model/userModel"
 	"github.com/go-auth-microservice/pkg/utils/logger"
@@ -13,28 +20,89 @@ import (
 func GetUserData(w http.ResponseWriter, r *http.Request) {
 	log := logger.InitializeAuditLogger()
 	ctx := r.Context()
-	userId, _ := ctx.Value("userId").(uint64)
+	userId := authMiddleware.GetUserID(ctx)
 	userData, err := usermodel.FindUserByID(userId)
 	if err != nil {
 		log.Errorf("unable to find user with ID %v ", userId, err)
 		return
 	}
+	w.Header().Set("ETag", userData.GetETag())
 	if err := json.NewEncoder(w).Encode(userData); err != nil {
 		log.Errorf("unable to encode json response %s", err)
 	}
 }
 
+// maxProfilePatchSize limits the body of a profile update
+const maxProfilePatchSize = 64 * 1024
+
+func UpdateUserProfile(w http.ResponseWriter, r *http.Request) {
+	log := logger.InitializeAuditLogger()
+	userId := authMiddleware.GetUserID(r.Context())
+	contentType := r.Header.Get("Content-Type")
+	if contentType != "" && !strings.HasPrefix(contentType, "application/merge-patch+json") && !strings.HasPrefix(contentType, "application/json") {
+		http.Error(w, "content type must be application/merge-patch+json", http.StatusUnsupportedMediaType)
+		return
+	}
+	patch, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxProfilePatchSize))
+	if err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	user, err := usermodel.FindUserByID(userId)
+	if err != nil {
+		log.Errorf("unable to find user with ID %v %v", userId, err)
+		http.Error(w, "user not found", http.StatusUnauthorized)
+		return
+	}
+	var userData usermodel.UserProfileUpdate = user
+	ifMatch := r.Header.Get("If-Match")
+	if ifMatch != "" && ifMatch != "*" && ifMatch != userData.GetETag() {
+		http.Error(w, "user has been modified", http.StatusPreconditionFailed)
+		return
+	}
+	if err := userData.ApplyProfilePatch(patch); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		log.Errorf("invalid profile update for user %v %v", userId, err)
+		return
+	}
+	err = userData.SaveProfile(ifMatch)
+	if errors.Is(err, usermodel.ErrProfileModified) {
+		http.Error(w, "user has been modified", http.StatusPreconditionFailed)
+		return
+	}
+	if err != nil {
+		http.Error(w, "unable to update profile", http.StatusInternalServerError)
+		log.Errorf("unable to update profile for user %v %v", userId, err)
+		return
+	}
+	w.Header().Set("ETag", userData.GetETag())
+	if err := json.NewEncoder(w).Encode(user); err != nil {
+		log.Errorf("unable to encode json response %s", err)
+	}
+	log.Infof("user %v updated the profile", userId)
+}
+
 func DeActivateUser(w http.ResponseWriter, r *http.Request) {
 	log := logger.InitializeAuditLogger()
 	ctx := r.Context()
-	userId, _ := ctx.Value("userId").(uint64)
-	var userData usermodel.UserStatus
-	userData, err := usermodel.FindUserByID(userId)
+	userId := authMiddleware.GetUserID(ctx)
+	var data struct {
+		CurrentPassword string `json:"currentPassword"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	user, err := usermodel.FindUserByID(userId)
 	if err != nil {
 		log.Errorf("unable to find user with ID %v ", userId, err)
 		return
 	}
-	err = userData.Disable()
+	if !verifyCurrentPassword(w, r, user, data.CurrentPassword) {
+		return
+	}
+	var userData usermodel.UserStatus = user
+	err = userData.Deactivate()
 	if err != nil {
 		http.Error(w, "user has already been disabled", http.StatusBadRequest)
 		log.Error(err)
@@ -46,41 +114,139 @@ func DeActivateUser(w http.ResponseWriter, r *http.Request) {
 		log.Error("unable to update user status ", err)
 		return
 	}
+	recordAuditEvent(r, userId, auditmodel.ActionAccountDeactivated, nil)
 	var blackListedToken tokencache.BlackListedToken = tokencache.GetBlacklistTokenCache()
-	token := r.Header.Get("Authorization")
+	token := authMiddleware.GetAccessToken(r)
 	blackListedToken.Set(token, time.Now().Add(time.Minute*time.Duration(5)).Unix())
 	if _, err := w.Write([]byte("user has been disabled")); err != nil {
 		log.Errorf("unable to write response %s", err)
 	}
 }
 
+// ExportUserData answers with everything stored about the user as a JSON file.
+func ExportUserData(w http.ResponseWriter, r *http.Request) {
+	log := logger.InitializeAuditLogger()
+	userId := authMiddleware.GetUserID(r.Context())
+	user, err := usermodel.FindUserByID(userId)
+	if err != nil {
+		log.Errorf("unable to find user with ID %v %v", userId, err)
+		http.Error(w, "user not found", http.StatusUnauthorized)
+		return
+	}
+	recordAuditEvent(r, userId, auditmodel.ActionDataExported, nil)
+	var userData usermodel.UserDeletion = user
+	export, err := userData.Export()
+	if err != nil {
+		http.Error(w, "unable to export user data", http.StatusInternalServerError)
+		log.Errorf("unable to export data of user %v %v", userId, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="user-%d-export.json"`, userId))
+	if err := json.NewEncoder(w).Encode(export); err != nil {
+		log.Errorf("unable to encode json response %s", err)
+	}
+	log.Infof("user %v exported the user data", userId)
+}
+
+// DeleteUser disables the account right away and schedules it to be erased
+// after the grace period. All tokens of the user are revoked.
+func DeleteUser(w http.ResponseWriter, r *http.Request) {
+	log := logger.InitializeAuditLogger()
+	userId := authMiddleware.GetUserID(r.Context())
+	var data struct {
+		CurrentPassword string `json:"currentPassword"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	user, err := usermodel.FindUserByID(userId)
+	if err != nil {
+		log.Errorf("unable to find user with ID %v %v", userId, err)
+		http.Error(w, "user not found", http.StatusUnauthorized)
+		return
+	}
+	if !verifyCurrentPassword(w, r, user, data.CurrentPassword) {
+		return
+	}
+	var userData usermodel.UserDeletion = user
+	gracePeriod := time.Hour * 24 * time.Duration(config.GetConfig().GetDeletionGracePeriod())
+	err = userData.ScheduleDeletion(gracePeriod)
+	if errors.Is(err, usermodel.ErrDeletionScheduled) {
+		http.Error(w, "user deletion has already been scheduled", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, "unable to delete user", http.StatusInternalServerError)
+		log.Errorf("unable to schedule deletion of user %v %v", userId, err)
+		return
+	}
+	var revokedUsers tokencache.RevokedUsers = tokencache.GetRevokedUserTokens()
+	revokedUsers.Revoke(userId, time.Now())
+	recordAuditEvent(r, userId, auditmodel.ActionAccountDeletionScheduled, nil)
+	res := map[string]interface{}{}
+	res["message"] = "user has been scheduled for deletion"
+	res["deletionScheduledAt"] = userData.GetDeletionScheduledAt()
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	if err := json.NewEncoder(w).Encode(res); err != nil {
+		log.Errorf("unable to encode json response %s", err)
+	}
+	log.Infof("user %v scheduled the deletion of the account", userId)
+}
+
 func ChangePassword(w http.ResponseWriter, r *http.Request) {
 	log := logger.InitializeAuditLogger()
 	ctx := r.Context()
-	userId, _ := ctx.Value("userId").(uint64)
+	userId := authMiddleware.GetUserID(ctx)
 	var data map[string]interface{}
 	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
 		http.Error(w, "invalid request body", http.StatusBadRequest)
 		return
 	}
 	password, ok := data["password"].(string)
-	if !ok {
+	if !ok || password == "" {
 		log.Error("invalid request to change password for user ID ", userId)
-		http.Error(w, "new password should be 8 character long", http.StatusBadRequest)
+		http.Error(w, "new password is required", http.StatusBadRequest)
 		return
 	}
-	if password == "" || len(password) < 8 {
-		log.Error("invalid request to change password for user ID ", userId)
-		http.Error(w, "new password should be 8 character long", http.StatusBadRequest)
+	user, err := usermodel.FindUserByID(userId)
+	if err != nil {
+		log.Errorf("unable to find user with ID %v ", userId, err)
 		return
 	}
-	var userData usermodel.UserSignUp
-	userData, err := usermodel.FindUserByID(userId)
+	if user.GetAuthSource() != usermodel.AuthSourceLocal {
+		http.Error(w, "password is managed by the directory", http.StatusConflict)
+		log.Errorf("password change for directory user %v", userId)
+		return
+	}
+	currentPassword, _ := data["currentPassword"].(string)
+	if !verifyCurrentPassword(w, r, user, currentPassword) {
+		return
+	}
+	var userData usermodel.UserSignUp = user
+	violations, err := userData.CheckPasswordPolicy(r.Context(), password)
 	if err != nil {
-		log.Errorf("unable to find user with ID %v ", userId, err)
+		log.Error("password policy check failed for ID ", userId, " ", err)
+		if errors.Is(err, usermodel.ErrHashingUnavailable) {
+			hashingUnavailable(w)
+			return
+		}
+		http.Error(w, "unable to change password", http.StatusInternalServerError)
 		return
 	}
-	if err := userData.SetPassword(password); err != nil {
+	if len(violations) > 0 {
+		log.Error("password policy violated on password change for ID ", userId)
+		passwordPolicyViolated(w, violations)
+		return
+	}
+	if err := userData.SetPassword(r.Context(), password); err != nil {
+		if errors.Is(err, usermodel.ErrHashingUnavailable) {
+			log.Error("password encryption rejected for ID ", userId, " ", err)
+			hashingUnavailable(w)
+			return
+		}
 		http.Error(w, "unable to change password", http.StatusInternalServerError)
 		log.Error("unable to change password for ID ", userId, " ", err)
 		return
@@ -91,9 +257,14 @@ func ChangePassword(w http.ResponseWriter, r *http.Request) {
 		log.Error("unable to update user password for ID ", userId, " ", err)
 		return
 	}
-	var blackListedToken tokencache.BlackListedToken = tokencache.GetBlacklistTokenCache()
-	token := r.Header.Get("Authorization")
-	blackListedToken.Set(token, time.Now().Add(time.Minute*time.Duration(5)).Unix())
+	if err := userData.SavePasswordHistory(); err != nil {
+		log.Error("unable to save password history for ID ", userId, " ", err)
+	}
+	// saving has invalidated the refresh tokens, the access tokens of every
+	// session, e.g. a stolen one, are revoked too
+	var revokedUsers tokencache.RevokedUsers = tokencache.GetRevokedUserTokens()
+	revokedUsers.Revoke(userId, time.Now())
+	recordAuditEvent(r, userId, auditmodel.ActionPasswordChanged, nil)
 	if _, err := w.Write([]byte("user password has been changed.")); err != nil {
 		log.Errorf("unable to write response %s", err)
 	}
@@ -0,0 +1,56 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	auditmodel "github.com/go-auth-microservice/pkg/model/auditModel"
+	usermodel "github.com/go-auth-microservice/pkg/model/userModel"
+	"github.com/go-auth-microservice/pkg/utils/logger"
+	"github.com/go-auth-microservice/pkg/utils/mailer"
+	"github.com/go-auth-microservice/pkg/utils/validation"
+)
+
+// sendSignupVerification mails a link to verify the email to a user who has
+// signed up and is pending verification.
+func sendSignupVerification(user *usermodel.UserData) error {
+	token, err := user.RequestVerification()
+	if err != nil {
+		return err
+	}
+	body := fmt.Sprintf("Please verify your email address to activate your account by opening the following link:\n\n%s\n", emailLink("/signup/verify", token))
+	return mailer.GetMailer().Send(user.Email, "Verify your email address", body)
+}
+
+// VerifySignup activates a pending user with the token of the link sent on
+// signup.
+func VerifySignup(w http.ResponseWriter, r *http.Request) {
+	log := logger.InitializeAuditLogger()
+	var data emailToken
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	if err := validation.Validator.Struct(data); err != nil {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+	user, err := usermodel.ConfirmVerification(data.Token)
+	if errors.Is(err, usermodel.ErrVerificationNotFound) || errors.Is(err, usermodel.ErrNotPending) {
+		http.Error(w, "invalid or expired token", http.StatusBadRequest)
+		log.Error("signup verification with invalid token")
+		return
+	}
+	if err != nil {
+		http.Error(w, "unable to verify user", http.StatusInternalServerError)
+		log.Error("unable to confirm signup verification ", err)
+		return
+	}
+	recordAuditEvent(r, user.Id, auditmodel.ActionAccountVerified, nil)
+	if _, err := w.Write([]byte("email has been verified, please login")); err != nil {
+		log.Errorf("unable to write response %s", err)
+	}
+	log.Infof("user %v has verified the email", user.Id)
+}
@@ -0,0 +1,145 @@
+package extauthz
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	typev3 "github.com/envoyproxy/go-control-plane/envoy/type/v3"
+	"github.com/go-auth-microservice/pkg/controller"
+	"github.com/go-auth-microservice/pkg/utils/logger"
+	"google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+)
+
+// identityHeaders are the headers of the forward auth endpoint which are
+// passed on to the upstream of an allowed request.
+var identityHeaders = []string{"X-User-Id", "X-User-Email", "X-User-Roles"}
+
+// authorizationServer answers the ext_authz checks of Envoy.
+type authorizationServer struct {
+	authv3.UnimplementedAuthorizationServer
+}
+
+// NewServer returns a gRPC server implementing
+// envoy.service.auth.v3.Authorization.
+func NewServer() *grpc.Server {
+	server := grpc.NewServer()
+	authv3.RegisterAuthorizationServer(server, &authorizationServer{})
+	return server
+}
+
+// responseRecorder collects the response of the forward auth endpoint.
+type responseRecorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (rec *responseRecorder) Header() http.Header {
+	return rec.header
+}
+
+func (rec *responseRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	return rec.body.Write(b)
+}
+
+func (rec *responseRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+}
+
+// checkRequest turns the attributes of the request Envoy is about to forward
+// into an HTTP request to the forward auth endpoint, carrying its headers and
+// the address of the downstream client.
+func checkRequest(ctx context.Context, check *authv3.CheckRequest) (*http.Request, error) {
+	attributes := check.GetAttributes().GetRequest().GetHttp()
+	r, err := http.NewRequestWithContext(ctx, http.MethodGet, "/api/v1/auth/verify", nil)
+	if err != nil {
+		return nil, err
+	}
+	for name, value := range attributes.GetHeaders() {
+		if !strings.HasPrefix(name, ":") {
+			r.Header.Set(name, value)
+		}
+	}
+	for _, header := range attributes.GetHeaderMap().GetHeaders() {
+		if strings.HasPrefix(header.GetKey(), ":") {
+			continue
+		}
+		value := header.GetValue()
+		if len(header.GetRawValue()) > 0 {
+			value = string(header.GetRawValue())
+		}
+		r.Header.Set(header.GetKey(), value)
+	}
+	if address := check.GetAttributes().GetSource().GetAddress().GetSocketAddress(); address != nil {
+		r.RemoteAddr = net.JoinHostPort(address.GetAddress(), strconv.FormatUint(uint64(address.GetPortValue()), 10))
+	}
+	return r, nil
+}
+
+// deniedResponse answers Envoy with the status, the content type and the
+// body of the forward auth endpoint for the downstream client.
+func deniedResponse(rec *responseRecorder) *authv3.DeniedHttpResponse {
+	headers := []*corev3.HeaderValueOption{}
+	if contentType := rec.header.Get("Content-Type"); contentType != "" {
+		headers = append(headers, &corev3.HeaderValueOption{Header: &corev3.HeaderValue{Key: "content-type", Value: contentType}})
+	}
+	if rec.status == http.StatusUnauthorized {
+		headers = append(headers, &corev3.HeaderValueOption{Header: &corev3.HeaderValue{Key: "www-authenticate", Value: "Bearer"}})
+	}
+	return &authv3.DeniedHttpResponse{
+		Status:  &typev3.HttpStatus{Code: typev3.StatusCode(rec.status)},
+		Headers: headers,
+		Body:    rec.body.String(),
+	}
+}
+
+// Check verifies the credentials of a request like the forward auth endpoint,
+// so both accept the same tokens and share its cache. Allowed requests get
+// the identity headers of the user, overwriting any sent by the client.
+func (s *authorizationServer) Check(ctx context.Context, check *authv3.CheckRequest) (*authv3.CheckResponse, error) {
+	log := logger.InitializeAuditLogger()
+	r, err := checkRequest(ctx, check)
+	if err != nil {
+		log.Errorf("unable to read ext_authz check request %v", err)
+		return nil, err
+	}
+	rec := &responseRecorder{header: http.Header{}}
+	controller.VerifyForwardAuth(rec, r)
+	switch rec.status {
+	case http.StatusOK:
+		headers := make([]*corev3.HeaderValueOption, 0, len(identityHeaders))
+		for _, name := range identityHeaders {
+			headers = append(headers, &corev3.HeaderValueOption{
+				Header:       &corev3.HeaderValue{Key: strings.ToLower(name), Value: rec.header.Get(name)},
+				AppendAction: corev3.HeaderValueOption_OVERWRITE_IF_EXISTS_OR_ADD,
+			})
+		}
+		return &authv3.CheckResponse{
+			Status:       &status.Status{Code: int32(codes.OK)},
+			HttpResponse: &authv3.CheckResponse_OkResponse{OkResponse: &authv3.OkHttpResponse{Headers: headers}},
+		}, nil
+	case http.StatusUnauthorized:
+		return &authv3.CheckResponse{
+			Status:       &status.Status{Code: int32(codes.Unauthenticated), Message: strings.TrimSpace(rec.body.String())},
+			HttpResponse: &authv3.CheckResponse_DeniedResponse{DeniedResponse: deniedResponse(rec)},
+		}, nil
+	default:
+		return &authv3.CheckResponse{
+			Status:       &status.Status{Code: int32(codes.Internal), Message: strings.TrimSpace(rec.body.String())},
+			HttpResponse: &authv3.CheckResponse_ErrorResponse{ErrorResponse: deniedResponse(rec)},
+		}, nil
+	}
+}
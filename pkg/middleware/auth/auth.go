@@ -2,10 +2,13 @@ package authMiddleware
 
 import (
 	"context"
+	"encoding/json"
 	"net/http"
 	"strings"
+	"time"
 
 	tokencache "github.com/go-auth-microservice/pkg/model/tokenCache"
+	usermodel "github.com/go-auth-microservice/pkg/model/userModel"
 	jwtauth "github.com/go-auth-microservice/pkg/utils/jwtAuth"
 	"github.com/go-auth-microservice/pkg/utils/logger"
 )
@@ -13,17 +16,32 @@ import (
 // contextKey is a custom type for context keys to avoid collisions
 type contextKey string
 
+const (
+	userIdKey   contextKey = "userId"
+	userRoleKey contextKey = "role"
+	authTimeKey contextKey = "authTime"
+	issuedAtKey contextKey = "issuedAt"
+	expiryKey   contextKey = "expiry"
+)
+
+// AccessTokenVerify authenticates requests by the JWT access token or the
+// personal access token in the Authorization header, or by the access token
+// cookie of a browser session.
 func AccessTokenVerify(next http.Handler) http.Handler {
 	var blackListedToken tokencache.BlackListedToken = tokencache.GetBlacklistTokenCache()
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		log := logger.InitializeAuditLogger()
 		accessTokenHandler := jwtauth.GetAccessTokenHandler()
-		accessToken := r.Header.Get("Authorization")
+		accessToken := GetAccessToken(r)
 		if accessToken == "" || !strings.HasPrefix(accessToken, "Bearer ") {
 			http.Error(w, "missing or invalid access token", http.StatusUnauthorized)
 			log.Error("access token not present or invalid token")
 			return
 		}
+		if credential := strings.TrimPrefix(accessToken, "Bearer "); usermodel.IsAPIToken(credential) {
+			serveAPIToken(next, w, r, credential)
+			return
+		}
 		if blackListedToken.IsPresent(accessToken) {
 			http.Error(w, "token expired or user account has been updated", http.StatusUnauthorized)
 			log.Error("token expired or user account has been updated")
@@ -36,7 +54,151 @@ func AccessTokenVerify(next http.Handler) http.Handler {
 			return
 		}
 		userId, _ := claims["userId"].(float64)
-		ctx := context.WithValue(r.Context(), contextKey("userId"), uint64(userId))
+		issuedAt := jwtauth.IssuedAt(claims)
+		var revokedUsers tokencache.RevokedUsers = tokencache.GetRevokedUserTokens()
+		if revokedUsers.IsRevoked(uint64(userId), issuedAt) {
+			http.Error(w, "token expired or user account has been updated", http.StatusUnauthorized)
+			log.Errorf("token of user %d has been revoked", uint64(userId))
+			return
+		}
+		state, err := usermodel.GetAccountStateByID(uint64(userId))
+		if err != nil {
+			http.Error(w, "unable to verify user", http.StatusInternalServerError)
+			log.Errorf("unable to load account status of user %d %v", uint64(userId), err)
+			return
+		}
+		if !state.AllowsSessions() {
+			AccountUnavailable(w, state)
+			log.Errorf("token of user %d denied, account is %s", uint64(userId), state.Status)
+			return
+		}
+		actorId := actorID(claims)
+		if actorId != 0 {
+			actorState, err := usermodel.GetAccountStateByID(actorId)
+			if err != nil || !actorState.AllowsSessions() {
+				http.Error(w, "token expired or user account has been updated", http.StatusUnauthorized)
+				log.Errorf("impersonation token of admin %d for user %d denied", actorId, uint64(userId))
+				return
+			}
+		}
+		role, _ := claims["role"].(string)
+		authTime, _ := claims["auth_time"].(float64)
+		ctx := context.WithValue(r.Context(), userIdKey, uint64(userId))
+		ctx = context.WithValue(ctx, userRoleKey, role)
+		ctx = context.WithValue(ctx, authTimeKey, time.Unix(int64(authTime), 0))
+		ctx = context.WithValue(ctx, issuedAtKey, issuedAt)
+		if expiresAt, ok := claims["exp"].(float64); ok {
+			ctx = context.WithValue(ctx, expiryKey, time.Unix(int64(expiresAt), 0))
+		}
+		if actorId != 0 {
+			ctx = context.WithValue(ctx, actorIdKey, actorId)
+			serveImpersonated(next, w, r.WithContext(ctx), uint64(userId), actorId)
+			return
+		}
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
+
+// accountStatusErrors are the error codes and messages for accounts which can
+// not be used.
+type accountStatusError struct {
+	code    string
+	message string
+}
+
+var accountStatusErrors = map[usermodel.AccountStatus]accountStatusError{
+	usermodel.StatusPending:     {"account_pending", "user has not verified the email yet, a new link has been sent on login"},
+	usermodel.StatusDeactivated: {"account_deactivated", "user has been deactivated, reactivate it at /api/v1/auth/reactivate"},
+	usermodel.StatusSuspended:   {"account_suspended", "user has been suspended please contact admin"},
+	usermodel.StatusLocked:      {"account_locked", "user has been locked after too many failed logins"},
+	usermodel.StatusDeleted:     {"account_deleted", "user has been deleted"},
+}
+
+// AccountUnavailable answers a request of a user whose account status does
+// not allow it with an error code for the status, e.g. account_suspended.
+func AccountUnavailable(w http.ResponseWriter, state usermodel.AccountState) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusForbidden)
+	statusError, ok := accountStatusErrors[state.Status]
+	if !ok {
+		statusError = accountStatusError{"account_unavailable", "user can not be used"}
+	}
+	res := map[string]interface{}{}
+	res["error"] = statusError.code
+	res["message"] = statusError.message
+	if state.Reason != "" {
+		res["reason"] = state.Reason
+	}
+	if state.Until != nil {
+		res["until"] = state.Until
+	}
+	if err := json.NewEncoder(w).Encode(res); err != nil {
+		logger.InitializeAuditLogger().Errorf("unable to encode json response %s", err)
+	}
+}
+
+// RequireRole only lets requests through whose access token carries the given
+// role. It has to be chained after AccessTokenVerify.
+func RequireRole(role string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			log := logger.InitializeAuditLogger()
+			if GetUserRole(r.Context()) != role {
+				http.Error(w, "insufficient permissions", http.StatusForbidden)
+				log.Errorf("user %d denied access to %s, role %s required", GetUserID(r.Context()), r.URL.Path, role)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RequireRecentAuth only lets requests through whose user has entered their
+// credentials within maxAge. Refreshed access tokens keep the auth_time of the
+// original login, so a long lived session has to reauthenticate first.
+// It has to be chained after AccessTokenVerify.
+func RequireRecentAuth(maxAge time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			log := logger.InitializeAuditLogger()
+			if time.Since(GetAuthTime(r.Context())) > maxAge {
+				http.Error(w, "recent authentication required", http.StatusUnauthorized)
+				log.Errorf("user %d denied access to %s, authentication is older than %v", GetUserID(r.Context()), r.URL.Path, maxAge)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// GetUserID returns the id of the authenticated user stored by AccessTokenVerify.
+func GetUserID(ctx context.Context) uint64 {
+	userId, _ := ctx.Value(userIdKey).(uint64)
+	return userId
+}
+
+// GetUserRole returns the role of the authenticated user stored by AccessTokenVerify.
+func GetUserRole(ctx context.Context) string {
+	role, _ := ctx.Value(userRoleKey).(string)
+	return role
+}
+
+// GetAuthTime returns when the authenticated user has last entered their credentials.
+func GetAuthTime(ctx context.Context) time.Time {
+	authTime, _ := ctx.Value(authTimeKey).(time.Time)
+	return authTime
+}
+
+// GetIssuedAt returns when the JWT the request has been authenticated with
+// has been issued, zero for personal access tokens.
+func GetIssuedAt(ctx context.Context) time.Time {
+	issuedAt, _ := ctx.Value(issuedAtKey).(time.Time)
+	return issuedAt
+}
+
+// GetExpiry returns when the token the request has been authenticated with
+// expires, zero for tokens without expiry.
+func GetExpiry(ctx context.Context) time.Time {
+	expiresAt, _ := ctx.Value(expiryKey).(time.Time)
+	return expiresAt
+}
@@ -0,0 +1,80 @@
+package authMiddleware
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+
+	auditmodel "github.com/go-auth-microservice/pkg/model/auditModel"
+	clientip "github.com/go-auth-microservice/pkg/utils/clientIP"
+	"github.com/go-auth-microservice/pkg/utils/logger"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const actorIdKey contextKey = "actorId"
+
+// actorID returns the id of the admin in the act claim of an impersonation
+// token, 0 for tokens of the user itself.
+func actorID(claims jwt.MapClaims) uint64 {
+	act, ok := claims["act"].(map[string]interface{})
+	if !ok {
+		return 0
+	}
+	sub, _ := act["sub"].(string)
+	actorId, err := strconv.ParseUint(sub, 10, 64)
+	if err != nil {
+		return 0
+	}
+	return actorId
+}
+
+// GetActorID returns the id of the admin impersonating the authenticated
+// user, 0 if the user is not impersonated.
+func GetActorID(ctx context.Context) uint64 {
+	actorId, _ := ctx.Value(actorIdKey).(uint64)
+	return actorId
+}
+
+// DenyImpersonation rejects sensitive requests made with an impersonation
+// token, e.g. changing the password or deleting the user.
+// It has to be chained after AccessTokenVerify.
+func DenyImpersonation(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		log := logger.InitializeAuditLogger()
+		if actorId := GetActorID(r.Context()); actorId != 0 {
+			http.Error(w, "not allowed while impersonating", http.StatusForbidden)
+			log.Errorf("admin %d denied access to %s while impersonating user %d", actorId, r.URL.Path, GetUserID(r.Context()))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// statusRecorder remembers the status code written by a handler
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (rec *statusRecorder) WriteHeader(status int) {
+	rec.status = status
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+// serveImpersonated serves a request made with an impersonation token and
+// records it as an audit event of the user with the admin as actor.
+func serveImpersonated(next http.Handler, w http.ResponseWriter, r *http.Request, userId uint64, actorId uint64) {
+	rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+	next.ServeHTTP(rec, r)
+	event := &auditmodel.AuditEvent{
+		UserId:    &userId,
+		ActorId:   &actorId,
+		Action:    auditmodel.ActionImpersonatedRequest,
+		IP:        clientip.FromRequest(r),
+		UserAgent: r.UserAgent(),
+		Details:   map[string]interface{}{"method": r.Method, "path": r.URL.Path, "status": rec.status},
+	}
+	if err := auditmodel.Record(event); err != nil {
+		logger.InitializeAuditLogger().Errorf("unable to record impersonated request of admin %d %v", actorId, err)
+	}
+}
@@ -0,0 +1,42 @@
+package authMiddleware
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-auth-microservice/pkg/utils/logger"
+	"github.com/go-auth-microservice/pkg/utils/scim"
+)
+
+const scimTenantKey contextKey = "scimTenant"
+
+// SCIMTenantVerify authenticates SCIM requests by the bearer token of a
+// tenant. Unlike user tokens, the token is never taken from a cookie.
+func SCIMTenantVerify(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		log := logger.InitializeAuditLogger()
+		credential, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		tenant, err := scim.Authenticate(credential)
+		if !found || err != nil {
+			w.Header().Set("Content-Type", scim.ContentType)
+			w.Header().Set("WWW-Authenticate", `Bearer realm="scim"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			body := scim.Error{Schemas: []string{scim.SchemaError}, Status: strconv.Itoa(http.StatusUnauthorized), Detail: "missing or invalid bearer token"}
+			if err := json.NewEncoder(w).Encode(body); err != nil {
+				log.Errorf("unable to encode json response %s", err)
+			}
+			log.Errorf("scim request to %s with an invalid bearer token", r.URL.Path)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), scimTenantKey, tenant)))
+	})
+}
+
+// GetSCIMTenant returns the tenant a SCIM request has been authenticated as.
+func GetSCIMTenant(ctx context.Context) *scim.Tenant {
+	tenant, _ := ctx.Value(scimTenantKey).(*scim.Tenant)
+	return tenant
+}
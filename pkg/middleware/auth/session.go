@@ -0,0 +1,193 @@
+package authMiddleware
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-auth-microservice/pkg/config"
+	"github.com/go-auth-microservice/pkg/utils/logger"
+	randomtoken "github.com/go-auth-microservice/pkg/utils/randomToken"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Cookies of a browser session. The token cookies are HttpOnly, the CSRF
+// cookie has to be readable by the frontend, which sends it back in the
+// X-CSRF-Token header.
+const (
+	AccessTokenCookie  = "access_token"
+	RefreshTokenCookie = "refresh_token"
+	CSRFTokenCookie    = "csrf_token"
+	CSRFTokenHeader    = "X-CSRF-Token"
+	// FederationStateCookie binds a social login to the browser which started it
+	FederationStateCookie = "federation_state"
+	// SAMLStateCookie binds a SAML login to the browser which started it
+	SAMLStateCookie = "saml_state"
+)
+
+var sameSiteModes = map[string]http.SameSite{
+	"strict": http.SameSiteStrictMode,
+	"lax":    http.SameSiteLaxMode,
+	"none":   http.SameSiteNoneMode,
+}
+
+func sessionCookie(name string, value string, expires time.Time, httpOnly bool) *http.Cookie {
+	appConfig := config.GetConfig()
+	sameSite, ok := sameSiteModes[strings.ToLower(appConfig.GetCookieSameSite())]
+	if !ok {
+		sameSite = http.SameSiteStrictMode
+	}
+	return &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     "/",
+		Domain:   appConfig.GetCookieDomain(),
+		Expires:  expires,
+		Secure:   appConfig.GetCookieSecure(),
+		HttpOnly: httpOnly,
+		SameSite: sameSite,
+	}
+}
+
+// setTokenCookie stores a "Bearer " prefixed JWT without the prefix in a
+// cookie which expires together with the token.
+func setTokenCookie(w http.ResponseWriter, name string, token string) {
+	token = strings.TrimPrefix(token, "Bearer ")
+	var expires time.Time
+	claims := jwt.MapClaims{}
+	if _, _, err := jwt.NewParser().ParseUnverified(token, claims); err == nil {
+		if exp, err := claims.GetExpirationTime(); err == nil && exp != nil {
+			expires = exp.Time
+		}
+	}
+	http.SetCookie(w, sessionCookie(name, token, expires, true))
+}
+
+// SetAccessTokenCookie stores the access token of a browser session in an
+// HttpOnly cookie.
+func SetAccessTokenCookie(w http.ResponseWriter, accessToken string) {
+	setTokenCookie(w, AccessTokenCookie, accessToken)
+}
+
+// SetRefreshTokenCookie stores the refresh token of a browser session in an
+// HttpOnly cookie.
+func SetRefreshTokenCookie(w http.ResponseWriter, refreshToken string) {
+	setTokenCookie(w, RefreshTokenCookie, refreshToken)
+}
+
+// SetCSRFTokenCookie starts the double submit of a new CSRF token and returns it.
+func SetCSRFTokenCookie(w http.ResponseWriter) (string, error) {
+	token, err := randomtoken.Generate()
+	if err != nil {
+		return "", err
+	}
+	http.SetCookie(w, sessionCookie(CSRFTokenCookie, token, time.Time{}, false))
+	return token, nil
+}
+
+// ClearSessionCookies removes the cookies of a browser session.
+func ClearSessionCookies(w http.ResponseWriter) {
+	for _, name := range []string{AccessTokenCookie, RefreshTokenCookie, CSRFTokenCookie} {
+		cookie := sessionCookie(name, "", time.Unix(0, 0), name != CSRFTokenCookie)
+		cookie.MaxAge = -1
+		http.SetCookie(w, cookie)
+	}
+}
+
+// SetFederationStateCookie stores the state of a social login. It is
+// SameSite=Lax at most since the identity provider redirects back cross-site.
+func SetFederationStateCookie(w http.ResponseWriter, state string, expires time.Time) {
+	cookie := sessionCookie(FederationStateCookie, state, expires, true)
+	if cookie.SameSite == http.SameSiteStrictMode {
+		cookie.SameSite = http.SameSiteLaxMode
+	}
+	http.SetCookie(w, cookie)
+}
+
+// ClearFederationStateCookie removes the state of a finished social login.
+func ClearFederationStateCookie(w http.ResponseWriter) {
+	cookie := sessionCookie(FederationStateCookie, "", time.Unix(0, 0), true)
+	cookie.MaxAge = -1
+	http.SetCookie(w, cookie)
+}
+
+// SetSAMLStateCookie stores the state of a SAML login. The identity provider
+// posts back cross-site, which only SameSite=None cookies survive, and those
+// have to be Secure.
+func SetSAMLStateCookie(w http.ResponseWriter, state string, expires time.Time) {
+	cookie := sessionCookie(SAMLStateCookie, state, expires, true)
+	cookie.SameSite = http.SameSiteNoneMode
+	cookie.Secure = true
+	http.SetCookie(w, cookie)
+}
+
+// ClearSAMLStateCookie removes the state of a finished SAML login.
+func ClearSAMLStateCookie(w http.ResponseWriter) {
+	cookie := sessionCookie(SAMLStateCookie, "", time.Unix(0, 0), true)
+	cookie.SameSite = http.SameSiteNoneMode
+	cookie.Secure = true
+	cookie.MaxAge = -1
+	http.SetCookie(w, cookie)
+}
+
+func cookieToken(r *http.Request, name string) string {
+	cookie, err := r.Cookie(name)
+	if err != nil || cookie.Value == "" {
+		return ""
+	}
+	return "Bearer " + cookie.Value
+}
+
+// GetAccessToken returns the access token of the request from the
+// Authorization header, or from the cookie of a browser session.
+func GetAccessToken(r *http.Request) string {
+	if token := r.Header.Get("Authorization"); token != "" {
+		return token
+	}
+	return cookieToken(r, AccessTokenCookie)
+}
+
+// GetRefreshToken returns the refresh token of the request from the
+// RefreshToken header, or from the cookie of a browser session.
+func GetRefreshToken(r *http.Request) string {
+	if token := r.Header.Get("RefreshToken"); token != "" {
+		return token
+	}
+	return cookieToken(r, RefreshTokenCookie)
+}
+
+// UsesSessionCookies tells if the request is authenticated by the cookies of
+// a browser session rather than by headers.
+func UsesSessionCookies(r *http.Request) bool {
+	if r.Header.Get("Authorization") != "" || r.Header.Get("RefreshToken") != "" {
+		return false
+	}
+	return cookieToken(r, AccessTokenCookie) != "" || cookieToken(r, RefreshTokenCookie) != ""
+}
+
+// CSRFProtect rejects state changing requests authenticated by session cookies
+// unless the X-CSRF-Token header matches the CSRF cookie. Requests with the
+// tokens in headers can not be forged cross-site and pass.
+func CSRFProtect(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		log := logger.InitializeAuditLogger()
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			next.ServeHTTP(w, r)
+			return
+		}
+		if !UsesSessionCookies(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		cookie, err := r.Cookie(CSRFTokenCookie)
+		header := r.Header.Get(CSRFTokenHeader)
+		if err != nil || cookie.Value == "" || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(header)) != 1 {
+			http.Error(w, "missing or invalid csrf token", http.StatusForbidden)
+			log.Errorf("csrf check failed for %s %s", r.Method, r.URL.Path)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,57 @@
+package ipPolicyMiddleware
+
+import (
+	"net/http"
+
+	authMiddleware "github.com/go-auth-microservice/pkg/middleware/auth"
+	ippolicymodel "github.com/go-auth-microservice/pkg/model/ipPolicyModel"
+	clientip "github.com/go-auth-microservice/pkg/utils/clientIP"
+	"github.com/go-auth-microservice/pkg/utils/logger"
+)
+
+func checkPolicy(w http.ResponseWriter, r *http.Request, role string) bool {
+	log := logger.InitializeAuditLogger()
+	policy, err := ippolicymodel.GetPolicy()
+	if err != nil && policy == nil {
+		http.Error(w, "unable to check ip policy", http.StatusInternalServerError)
+		log.Errorf("unable to load ip policy %v", err)
+		return false
+	}
+	if err != nil {
+		log.Errorf("unable to reload ip policy, keeping the previous rules %v", err)
+	}
+	ip := clientip.FromRequest(r)
+	if !policy.Allows(ip, role) {
+		http.Error(w, "access denied from this ip address", http.StatusForbidden)
+		if role == "" {
+			log.Errorf("ip %s denied access to %s by the global ip policy", ip, r.URL.Path)
+		} else {
+			log.Errorf("ip %s of user %d denied access to %s by the ip policy of role %s", ip, authMiddleware.GetUserID(r.Context()), r.URL.Path, role)
+		}
+		return false
+	}
+	return true
+}
+
+// GlobalPolicy rejects requests from IP addresses the global IP rules, those
+// without a role, do not allow.
+func GlobalPolicy(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !checkPolicy(w, r, "") {
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RolePolicy rejects requests of authenticated users from IP addresses the IP
+// rules of their role do not allow. It has to run after AccessTokenVerify.
+func RolePolicy(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		role := authMiddleware.GetUserRole(r.Context())
+		if role != "" && !checkPolicy(w, r, role) {
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,35 @@
+package rateLimitMiddleware
+
+import (
+	"time"
+
+	"github.com/go-auth-microservice/pkg/config"
+	"github.com/go-auth-microservice/pkg/utils/db"
+	"github.com/go-auth-microservice/pkg/utils/logger"
+)
+
+// Store keeps the token buckets. Take consumes one token of the bucket
+// identified by key and returns whether the request is allowed and, if not,
+// after which delay it should be retried.
+type Store interface {
+	Take(key string, limit Limit) (bool, time.Duration, error)
+}
+
+var store Store
+
+func GetStore() Store {
+	if store != nil {
+		return store
+	}
+	switch config.GetConfig().GetRateLimitStore() {
+	case "database":
+		dbStore, err := NewDBStore(db.GetDBConn())
+		if err != nil {
+			logger.InitializeAppLogger().Fatalf("unable to initialize rate limit store %v", err)
+		}
+		store = dbStore
+	default:
+		store = NewMemoryStore()
+	}
+	return store
+}
@@ -0,0 +1,119 @@
+package rateLimitMiddleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	authMiddleware "github.com/go-auth-microservice/pkg/middleware/auth"
+	clientip "github.com/go-auth-microservice/pkg/utils/clientIP"
+	jwtauth "github.com/go-auth-microservice/pkg/utils/jwtAuth"
+	"github.com/go-auth-microservice/pkg/utils/logger"
+)
+
+// KeyFunc extracts the value a request is limited by. An empty key means the
+// request can not be attributed and is not limited by this rule.
+type KeyFunc func(r *http.Request) string
+
+// ByIP keys requests by the address of the client, see clientip.FromRequest.
+func ByIP(r *http.Request) string {
+	return clientip.FromRequest(r)
+}
+
+// maxEmailBodySize limits the body ByEmail reads, the endpoints it is used on
+// are unauthenticated and only take a few small fields
+const maxEmailBodySize = 64 * 1024
+
+// ByEmail keys requests by the email submitted in the JSON body. The body is
+// restored so that the handler can decode it again. Larger bodies than
+// maxEmailBodySize are not keyed and fail to decode in the handler as well.
+func ByEmail(r *http.Request) string {
+	if r.Body == nil {
+		return ""
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(nil, r.Body, maxEmailBodySize))
+	r.Body = io.NopCloser(io.MultiReader(bytes.NewReader(body), errReader{err}))
+	if err != nil {
+		return ""
+	}
+	var data struct {
+		Email string `json:"email"`
+	}
+	if err := json.Unmarshal(body, &data); err != nil {
+		return ""
+	}
+	return strings.ToLower(strings.TrimSpace(data.Email))
+}
+
+// errReader fails with the error reading the original body ended with, EOF
+// for a completely read body.
+type errReader struct {
+	err error
+}
+
+func (e errReader) Read([]byte) (int, error) {
+	if e.err == nil {
+		return 0, io.EOF
+	}
+	return 0, e.err
+}
+
+// ByUserID keys requests by the authenticated user. Outside of the protected
+// routes the user is taken from a valid refresh token or refresh token cookie.
+func ByUserID(r *http.Request) string {
+	if userId := authMiddleware.GetUserID(r.Context()); userId != 0 {
+		return strconv.FormatUint(userId, 10)
+	}
+	refreshToken := authMiddleware.GetRefreshToken(r)
+	if refreshToken == "" {
+		return ""
+	}
+	claims, err := jwtauth.GetRefreshTokenHandler().VerifyToken(refreshToken)
+	if err != nil {
+		return ""
+	}
+	userId, ok := claims["userId"].(float64)
+	if !ok {
+		return ""
+	}
+	return strconv.FormatUint(uint64(userId), 10)
+}
+
+// Rule limits the requests sharing the same key.
+type Rule struct {
+	Name  string
+	Key   KeyFunc
+	Limit Limit
+}
+
+// RateLimit rejects a request with 429 as soon as one of the rules has no
+// tokens left. Errors of the store are logged and let the request through.
+func RateLimit(store Store, route string, rules ...Rule) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			log := logger.InitializeAuditLogger()
+			for _, rule := range rules {
+				key := rule.Key(r)
+				if key == "" {
+					continue
+				}
+				allowed, wait, err := store.Take(route+":"+rule.Name+":"+key, rule.Limit)
+				if err != nil {
+					log.Errorf("rate limit store failed for %s %v", route, err)
+					continue
+				}
+				if !allowed {
+					w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+					http.Error(w, "too many requests", http.StatusTooManyRequests)
+					log.Errorf("rate limit %s exceeded on %s for %s", rule.Name, route, key)
+					return
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,153 @@
+package rateLimitMiddleware
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/go-auth-microservice/pkg/utils/db"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Limit describes a token bucket which holds up to Requests tokens and is
+// refilled completely over the duration Per.
+type Limit struct {
+	Requests int
+	Per      time.Duration
+}
+
+func (l Limit) rate() float64 {
+	return float64(l.Requests) / l.Per.Seconds()
+}
+
+// take refills the bucket for the time elapsed since the last request and
+// consumes one token. When the bucket is empty it returns how long the client
+// has to wait for the next token.
+func (l Limit) take(tokens float64, last time.Time, now time.Time) (float64, bool, time.Duration) {
+	tokens = math.Min(float64(l.Requests), tokens+now.Sub(last).Seconds()*l.rate())
+	if tokens >= 1 {
+		return tokens - 1, true, 0
+	}
+	wait := time.Duration((1 - tokens) / l.rate() * float64(time.Second))
+	return tokens, false, wait
+}
+
+// fullAt returns when a bucket holding tokens at now will have been refilled.
+func (l Limit) fullAt(tokens float64, now time.Time) time.Time {
+	return now.Add(time.Duration((float64(l.Requests) - tokens) / l.rate() * float64(time.Second)))
+}
+
+// full reports whether a bucket last used at the given time has been refilled.
+func (l Limit) full(tokens float64, last time.Time, now time.Time) bool {
+	return tokens+now.Sub(last).Seconds()*l.rate() >= float64(l.Requests)
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+	limit  Limit
+}
+
+type memoryStore struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	calls   int
+}
+
+func (m *memoryStore) Take(key string, limit Limit) (bool, time.Duration, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	m.calls++
+	if m.calls%1000 == 0 {
+		m.clean(now)
+	}
+	b, ok := m.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(limit.Requests), last: now, limit: limit}
+		m.buckets[key] = b
+	}
+	tokens, allowed, wait := limit.take(b.tokens, b.last, now)
+	b.tokens = tokens
+	b.last = now
+	return allowed, wait, nil
+}
+
+// clean drops buckets which have been refilled, they behave exactly like new ones.
+func (m *memoryStore) clean(now time.Time) {
+	for key, b := range m.buckets {
+		if b.limit.full(b.tokens, b.last, now) {
+			delete(m.buckets, key)
+		}
+	}
+}
+
+func NewMemoryStore() Store {
+	return &memoryStore{
+		buckets: make(map[string]*bucket),
+	}
+}
+
+// RateLimitBucket is the persisted state of a token bucket shared between
+// all instances of the service. FullAt is when the bucket has been refilled,
+// from then on it behaves exactly like a new one and can be deleted.
+type RateLimitBucket struct {
+	BucketKey string    `gorm:"primaryKey"`
+	Tokens    float64   `gorm:"not null"`
+	UpdatedAt time.Time `gorm:"not null;index"`
+	FullAt    time.Time `gorm:"index"`
+}
+
+type dbStore struct {
+	dbConn db.DB
+	mu     sync.Mutex
+	calls  int
+}
+
+func (d *dbStore) Take(key string, limit Limit) (bool, time.Duration, error) {
+	d.mu.Lock()
+	d.calls++
+	clean := d.calls%1000 == 0
+	d.mu.Unlock()
+	if clean {
+		if err := d.Clean(time.Now()); err != nil {
+			return false, 0, err
+		}
+	}
+	var allowed bool
+	var wait time.Duration
+	err := d.dbConn.GetDB().Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		var b RateLimitBucket
+		query := tx
+		if tx.Dialector.Name() == "postgres" {
+			query = tx.Clauses(clause.Locking{Strength: "UPDATE"})
+		}
+		result := query.Where("bucket_key = ?", key).Limit(1).Find(&b)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			b = RateLimitBucket{BucketKey: key, Tokens: float64(limit.Requests), UpdatedAt: now}
+		}
+		b.Tokens, allowed, wait = limit.take(b.Tokens, b.UpdatedAt, now)
+		b.UpdatedAt = now
+		b.FullAt = limit.fullAt(b.Tokens, now)
+		return tx.Save(&b).Error
+	})
+	return allowed, wait, err
+}
+
+// Clean deletes the buckets which have been refilled, it runs on every
+// thousandth request.
+func (d *dbStore) Clean(now time.Time) error {
+	return d.dbConn.GetDB().Where("full_at < ?", now).Delete(&RateLimitBucket{}).Error
+}
+
+func NewDBStore(dbConn db.DB) (Store, error) {
+	if err := dbConn.AutoMigrate(&RateLimitBucket{}); err != nil {
+		return nil, err
+	}
+	return &dbStore{dbConn: dbConn}, nil
+}
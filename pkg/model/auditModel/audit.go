@@ -0,0 +1,85 @@
+package auditmodel
+
+import (
+	"time"
+
+	"github.com/go-auth-microservice/pkg/utils/db"
+	"gorm.io/gorm"
+)
+
+// AuditEvent is a security relevant action stored for the user it concerns.
+// Unlike the audit log file it can be exported and anonymized per user.
+type AuditEvent struct {
+	Id        uint64                 `gorm:"primaryKey,autoIncrement" json:"id"`
+	UserId    *uint64                `gorm:"index" json:"userId,omitempty"`
+	ActorId   *uint64                `gorm:"index" json:"actorId,omitempty"`
+	Action    string                 `gorm:"not null;index" json:"action"`
+	IP        string                 `json:"ip,omitempty"`
+	UserAgent string                 `json:"userAgent,omitempty"`
+	Details   map[string]interface{} `gorm:"serializer:json" json:"details,omitempty"`
+	CreatedAt time.Time              `gorm:"not null;index" json:"createdAt"`
+}
+
+const (
+	ActionLoginSucceeded           = "login_succeeded"
+	ActionLoginFailed              = "login_failed"
+	ActionPasswordChanged          = "password_changed"
+	ActionAccountDeactivated       = "account_deactivated"
+	ActionAccountUnlocked          = "account_unlocked"
+	ActionEmailChangeRequested     = "email_change_requested"
+	ActionEmailChanged             = "email_changed"
+	ActionDataExported             = "data_exported"
+	ActionAccountDeletionScheduled = "account_deletion_scheduled"
+	ActionAccountReactivated       = "account_reactivated"
+	ActionAccountSuspended         = "account_suspended"
+	ActionAccountUnsuspended       = "account_unsuspended"
+	ActionInvitationCreated        = "invitation_created"
+	ActionInvitationAccepted       = "invitation_accepted"
+	ActionImpersonationStarted     = "impersonation_started"
+	ActionImpersonatedRequest      = "impersonated_request"
+	ActionAPITokenCreated          = "api_token_created"
+	ActionAPITokenRevoked          = "api_token_revoked"
+	ActionLoginRiskAssessed        = "login_risk_assessed"
+	ActionIPRuleCreated            = "ip_rule_created"
+	ActionIPRuleDeleted            = "ip_rule_deleted"
+	ActionIdentityLinked           = "identity_linked"
+	ActionIdentityUnlinked         = "identity_unlinked"
+	ActionAccountProvisioned       = "account_provisioned"
+	ActionAccountVerified          = "account_verified"
+)
+
+// Record stores an audit event. The actor is the user who acted, if it is
+// someone other than the user the event concerns, e.g. an admin.
+func Record(event *AuditEvent) error {
+	dbConn := db.GetDBConn()
+	if err := dbConn.AutoMigrate(&AuditEvent{}); err != nil {
+		return err
+	}
+	if event.CreatedAt.IsZero() {
+		event.CreatedAt = time.Now()
+	}
+	return dbConn.GetDB().Create(event).Error
+}
+
+// FindEventsByUserID returns all events concerning the user, oldest first.
+func FindEventsByUserID(userId uint64) ([]AuditEvent, error) {
+	dbConn := db.GetDBConn()
+	if err := dbConn.AutoMigrate(&AuditEvent{}); err != nil {
+		return nil, err
+	}
+	var events []AuditEvent
+	result := dbConn.GetDB().Where("user_id = ?", userId).Order("id").Find(&events)
+	return events, result.Error
+}
+
+// AnonymizeUser detaches the events from a deleted user and drops the personal
+// data they carry, the actions themselves are kept for the security record.
+// It runs on the given transaction so that it commits with the deletion.
+func AnonymizeUser(tx *gorm.DB, userId uint64) error {
+	result := tx.Model(&AuditEvent{}).Where("user_id = ?", userId).
+		Updates(map[string]interface{}{"user_id": nil, "ip": "", "user_agent": "", "details": nil})
+	if result.Error != nil {
+		return result.Error
+	}
+	return tx.Model(&AuditEvent{}).Where("actor_id = ?", userId).Update("actor_id", nil).Error
+}
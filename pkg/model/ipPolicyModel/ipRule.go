@@ -0,0 +1,183 @@
+package ippolicymodel
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/go-auth-microservice/pkg/config"
+	clientip "github.com/go-auth-microservice/pkg/utils/clientIP"
+	"github.com/go-auth-microservice/pkg/utils/db"
+)
+
+const (
+	ActionAllow = "allow"
+	ActionDeny  = "deny"
+)
+
+var (
+	ErrRuleNotFound  = errors.New("ip rule not found")
+	ErrInvalidRule   = errors.New("invalid ip rule")
+	ErrInvalidAction = errors.New("ip rule action must be allow or deny")
+)
+
+// IPRule allows or denies access from a network. Rules without a role apply
+// to every request, rules with a role to the requests of authenticated users
+// with that role.
+type IPRule struct {
+	Id          uint64    `gorm:"primaryKey,autoIncrement" json:"id"`
+	Action      string    `gorm:"not null" json:"action"`
+	CIDR        string    `gorm:"not null" json:"cidr"`
+	Role        string    `gorm:"not null;default:'';index" json:"role,omitempty"`
+	Description string    `json:"description,omitempty"`
+	CreatedBy   *uint64   `json:"createdBy,omitempty"`
+	CreatedAt   time.Time `gorm:"not null" json:"createdAt"`
+}
+
+type compiledRule struct {
+	network *net.IPNet
+	prefix  int
+	action  string
+	role    string
+}
+
+// Policy decides about access by the IP rules.
+type Policy struct {
+	rules []compiledRule
+}
+
+// NewPolicy compiles the rules.
+func NewPolicy(rules []IPRule) (*Policy, error) {
+	policy := &Policy{}
+	for _, rule := range rules {
+		network, err := clientip.ParseNetwork(rule.CIDR)
+		if err != nil {
+			return nil, err
+		}
+		prefix, _ := network.Mask.Size()
+		policy.rules = append(policy.rules, compiledRule{network: network, prefix: prefix, action: rule.Action, role: rule.Role})
+	}
+	return policy, nil
+}
+
+// Allows tells if the rules of the role, "" for the global rules, allow access
+// from the IP address. The most specific matching network decides, deny wins
+// between equally specific ones. If no rule matches, access is only allowed
+// if the role has no allow rules.
+func (p *Policy) Allows(ip string, role string) bool {
+	address := net.ParseIP(ip)
+	hasAllowRules := false
+	var best *compiledRule
+	for i, rule := range p.rules {
+		if rule.role != role {
+			continue
+		}
+		if rule.action == ActionAllow {
+			hasAllowRules = true
+		}
+		if address == nil || !rule.network.Contains(address) {
+			continue
+		}
+		if best == nil || rule.prefix > best.prefix || (rule.prefix == best.prefix && rule.action == ActionDeny) {
+			best = &p.rules[i]
+		}
+	}
+	if best != nil {
+		return best.action == ActionAllow
+	}
+	return !hasAllowRules
+}
+
+var policy *Policy
+var policyLoadedAt time.Time
+var policyLock sync.Mutex
+
+// GetPolicy returns the policy of the stored rules. It is reloaded every
+// IP_POLICY_RELOAD_INTERVAL seconds and right after a change. If reloading
+// fails the previous policy stays in place.
+func GetPolicy() (*Policy, error) {
+	policyLock.Lock()
+	defer policyLock.Unlock()
+	interval := time.Second * time.Duration(config.GetConfig().GetIPPolicyReloadInterval())
+	if policy != nil && time.Since(policyLoadedAt) < interval {
+		return policy, nil
+	}
+	rules, err := FindRules()
+	if err == nil {
+		var loaded *Policy
+		if loaded, err = NewPolicy(rules); err == nil {
+			policy = loaded
+		}
+	}
+	if policy == nil {
+		return nil, err
+	}
+	policyLoadedAt = time.Now()
+	return policy, err
+}
+
+func invalidatePolicy() {
+	policyLock.Lock()
+	defer policyLock.Unlock()
+	policyLoadedAt = time.Time{}
+}
+
+// FindRules returns all IP rules, oldest first.
+func FindRules() ([]IPRule, error) {
+	dbConn := db.GetDBConn()
+	if err := dbConn.AutoMigrate(&IPRule{}); err != nil {
+		return nil, err
+	}
+	rules := []IPRule{}
+	result := dbConn.GetDB().Order("id").Find(&rules)
+	return rules, result.Error
+}
+
+// NormalizeRule checks the action of the rule and rewrites its network in
+// CIDR notation, e.g. 192.0.2.7 becomes 192.0.2.7/32.
+func NormalizeRule(rule *IPRule) error {
+	if rule.Action != ActionAllow && rule.Action != ActionDeny {
+		return ErrInvalidAction
+	}
+	network, err := clientip.ParseNetwork(rule.CIDR)
+	if err != nil {
+		return ErrInvalidRule
+	}
+	rule.CIDR = network.String()
+	return nil
+}
+
+// CreateRule stores a new IP rule, which takes effect right away.
+func CreateRule(rule *IPRule) error {
+	if err := NormalizeRule(rule); err != nil {
+		return err
+	}
+	dbConn := db.GetDBConn()
+	if err := dbConn.AutoMigrate(&IPRule{}); err != nil {
+		return err
+	}
+	rule.CreatedAt = time.Now()
+	if err := dbConn.GetDB().Create(rule).Error; err != nil {
+		return err
+	}
+	invalidatePolicy()
+	return nil
+}
+
+// DeleteRule removes an IP rule, which takes effect right away.
+func DeleteRule(id uint64) error {
+	dbConn := db.GetDBConn()
+	if err := dbConn.AutoMigrate(&IPRule{}); err != nil {
+		return err
+	}
+	result := dbConn.GetDB().Delete(&IPRule{}, id)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrRuleNotFound
+	}
+	invalidatePolicy()
+	return nil
+}
@@ -1,8 +1,15 @@
 package tokencache
 
+import "time"
+
 type BlackListedToken interface {
 	Set(string, int64)
 	Remove(string)
 	IsPresent(string) bool
 	GetExpTime(string) (int64, error)
 }
+
+type RevokedUsers interface {
+	Revoke(uint64, time.Time)
+	IsRevoked(uint64, time.Time) bool
+}
@@ -0,0 +1,28 @@
+package tokencache
+
+import (
+	"sync"
+	"time"
+)
+
+// sweepInterval is how often expired entries are dropped from the caches,
+// lookups already ignore them in between.
+const sweepInterval = time.Minute
+
+var sweepOnce sync.Once
+
+// startSweep cleans the caches every sweepInterval in the background instead
+// of scanning them on every request.
+func startSweep() {
+	sweepOnce.Do(func() {
+		go func() {
+			ticker := time.NewTicker(sweepInterval)
+			defer ticker.Stop()
+			for range ticker.C {
+				GetBlacklistTokenCache().Clean()
+				GetRevokedUserTokens().Clean()
+				GetVerifiedTokens().Clean()
+			}
+		}()
+	})
+}
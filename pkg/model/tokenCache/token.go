@@ -2,24 +2,34 @@ package tokencache
 
 import (
 	"fmt"
+	"sync"
 	"time"
 )
 
 type BlacklistedToken struct {
+	mu     sync.RWMutex
 	tokens map[string]int64
 }
 
 func (b *BlacklistedToken) Set(token string, expTime int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
 	b.tokens[token] = expTime
 }
 func (b *BlacklistedToken) Remove(token string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
 	delete(b.tokens, token)
 }
 func (b *BlacklistedToken) IsPresent(token string) bool {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
 	_, ok := b.tokens[token]
 	return ok
 }
 func (b *BlacklistedToken) GetExpTime(token string) (int64, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
 	expTime, ok := b.tokens[token]
 	if !ok {
 		return 0, fmt.Errorf("unable to find token on cache")
@@ -27,23 +37,28 @@ func (b *BlacklistedToken) GetExpTime(token string) (int64, error) {
 	return expTime, nil
 }
 
+// Clean forgets blacklisted tokens which have expired, they are rejected
+// anyway.
 func (b *BlacklistedToken) Clean() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now().Unix()
 	for key, value := range b.tokens {
-		if value < (time.Now().Unix()) {
+		if value < now {
 			delete(b.tokens, key)
 		}
 	}
 }
 
 var blacklistedTokenCache *BlacklistedToken
+var blacklistedTokenCacheOnce sync.Once
 
 func GetBlacklistTokenCache() *BlacklistedToken {
-	if blacklistedTokenCache != nil {
-		blacklistedTokenCache.Clean()
-		return blacklistedTokenCache
-	}
-	blacklistedTokenCache = &BlacklistedToken{
-		tokens: make(map[string]int64),
-	}
+	blacklistedTokenCacheOnce.Do(func() {
+		blacklistedTokenCache = &BlacklistedToken{
+			tokens: make(map[string]int64),
+		}
+		startSweep()
+	})
 	return blacklistedTokenCache
 }
@@ -0,0 +1,57 @@
+package tokencache
+
+import (
+	"sync"
+	"time"
+
+	"github.com/go-auth-microservice/pkg/config"
+)
+
+// RevokedUserTokens remembers users whose tokens issued before a point in
+// time must no longer be accepted, e.g. after their email has changed.
+type RevokedUserTokens struct {
+	mu    sync.Mutex
+	users map[uint64]time.Time
+}
+
+func (r *RevokedUserTokens) Revoke(userId uint64, revokedAt time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.users[userId] = revokedAt
+}
+
+// IsRevoked tells if a token of the user issued at issuedAt has been revoked.
+// Tokens carry their issue time with sub-second precision, so that a token
+// issued in the same second as the revocation is told apart.
+func (r *RevokedUserTokens) IsRevoked(userId uint64, issuedAt time.Time) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	revokedAt, ok := r.users[userId]
+	return ok && issuedAt.Before(revokedAt)
+}
+
+// Clean forgets revocations older than the access token lifetime, every
+// token issued before them has expired by then.
+func (r *RevokedUserTokens) Clean() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	expiry := time.Now().Add(-time.Minute * time.Duration(config.GetConfig().GetAccessTokenExpiry()))
+	for userId, revokedAt := range r.users {
+		if revokedAt.Before(expiry) {
+			delete(r.users, userId)
+		}
+	}
+}
+
+var revokedUserTokens *RevokedUserTokens
+var revokedUserTokensOnce sync.Once
+
+func GetRevokedUserTokens() *RevokedUserTokens {
+	revokedUserTokensOnce.Do(func() {
+		revokedUserTokens = &RevokedUserTokens{
+			users: make(map[uint64]time.Time),
+		}
+		startSweep()
+	})
+	return revokedUserTokens
+}
@@ -0,0 +1,92 @@
+package tokencache
+
+import (
+	"sync"
+	"time"
+)
+
+// VerifiedToken is the identity a token has been verified as by the forward
+// auth endpoint. ActorId is the admin of an impersonation token, APITokenId
+// the id of a personal access token, both are 0 otherwise. IssuedAt is when a
+// JWT has been issued, ExpiresAt is the expiry of the token, zero if it does
+// not expire.
+type VerifiedToken struct {
+	UserId     uint64
+	Email      string
+	Role       string
+	ActorId    uint64
+	APITokenId uint64
+	IssuedAt   time.Time
+	ExpiresAt  time.Time
+	expiresAt  time.Time
+}
+
+// VerifiedTokens remembers tokens accepted by the forward auth endpoint for a
+// few seconds, keyed by the hash of the token, so that a reverse proxy asking
+// for every request does not verify the same token over and over.
+type VerifiedTokens struct {
+	mu     sync.Mutex
+	tokens map[string]VerifiedToken
+}
+
+// Set remembers the token for ttl, but not beyond the expiry of the token.
+func (v *VerifiedTokens) Set(key string, token VerifiedToken, ttl time.Duration) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	token.expiresAt = time.Now().Add(ttl)
+	if !token.ExpiresAt.IsZero() && token.ExpiresAt.Before(token.expiresAt) {
+		token.expiresAt = token.ExpiresAt
+	}
+	v.tokens[key] = token
+}
+
+func (v *VerifiedTokens) Get(key string) (VerifiedToken, bool) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	token, ok := v.tokens[key]
+	if !ok || !time.Now().Before(token.expiresAt) {
+		return VerifiedToken{}, false
+	}
+	return token, true
+}
+
+func (v *VerifiedTokens) Remove(key string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	delete(v.tokens, key)
+}
+
+// RemoveAPIToken forgets a personal access token once it has been revoked.
+func (v *VerifiedTokens) RemoveAPIToken(tokenId uint64) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	for key, token := range v.tokens {
+		if token.APITokenId == tokenId {
+			delete(v.tokens, key)
+		}
+	}
+}
+
+func (v *VerifiedTokens) Clean() {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	now := time.Now()
+	for key, token := range v.tokens {
+		if !now.Before(token.expiresAt) {
+			delete(v.tokens, key)
+		}
+	}
+}
+
+var verifiedTokens *VerifiedTokens
+var verifiedTokensOnce sync.Once
+
+func GetVerifiedTokens() *VerifiedTokens {
+	verifiedTokensOnce.Do(func() {
+		verifiedTokens = &VerifiedTokens{
+			tokens: make(map[string]VerifiedToken),
+		}
+		startSweep()
+	})
+	return verifiedTokens
+}
@@ -0,0 +1,152 @@
+package usermodel
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/go-auth-microservice/pkg/utils/db"
+	randomtoken "github.com/go-auth-microservice/pkg/utils/randomToken"
+)
+
+// APITokenPrefix starts every personal access token, so that they can be told
+// apart from JWTs and found by secret scanners.
+const APITokenPrefix = "gam_"
+
+const (
+	ScopeUserRead  = "user:read"
+	ScopeUserWrite = "user:write"
+	ScopeAdmin     = "admin"
+)
+
+var (
+	ErrAPITokenNotFound = errors.New("api token not found")
+	ErrInvalidScope     = errors.New("invalid scope")
+)
+
+// apiTokenLastUsedInterval limits how often LastUsedAt is written
+const apiTokenLastUsedInterval = time.Minute
+
+// APIToken is a personal access token of a user for scripts and CI jobs. Only
+// the hash of the token is stored, it is shown once on creation.
+type APIToken struct {
+	Id         uint64     `gorm:"primaryKey,autoIncrement" json:"id"`
+	UserId     uint64     `gorm:"not null;index" json:"-"`
+	Name       string     `gorm:"not null" json:"name"`
+	Prefix     string     `gorm:"not null" json:"prefix"`
+	TokenHash  string     `gorm:"not null;uniqueIndex" json:"-"`
+	Scopes     []string   `gorm:"serializer:json" json:"scopes"`
+	CreatedAt  time.Time  `gorm:"not null" json:"createdAt"`
+	ExpiresAt  *time.Time `json:"expiresAt,omitempty"`
+	LastUsedAt *time.Time `json:"lastUsedAt,omitempty"`
+	RevokedAt  *time.Time `json:"revokedAt,omitempty"`
+}
+
+func (token *APIToken) isValid() bool {
+	return token.RevokedAt == nil && (token.ExpiresAt == nil || time.Now().Before(*token.ExpiresAt))
+}
+
+// HasScope tells if the token has been granted the scope.
+func (token *APIToken) HasScope(scope string) bool {
+	for _, granted := range token.Scopes {
+		if granted == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// IsAPIToken tells if the credential is a personal access token rather than a JWT.
+func IsAPIToken(credential string) bool {
+	return strings.HasPrefix(credential, APITokenPrefix)
+}
+
+// CreateAPIToken creates a personal access token of the user and returns it
+// together with the token, which can not be recovered later. Only admins can
+// grant the admin scope.
+func (user *UserData) CreateAPIToken(name string, scopes []string, expiresAt *time.Time) (*APIToken, string, error) {
+	for _, scope := range scopes {
+		if scope != ScopeUserRead && scope != ScopeUserWrite && scope != ScopeAdmin {
+			return nil, "", ErrInvalidScope
+		}
+		if scope == ScopeAdmin && user.Role != RoleAdmin {
+			return nil, "", ErrInvalidScope
+		}
+	}
+	dbConn := db.GetDBConn()
+	if err := dbConn.AutoMigrate(&APIToken{}); err != nil {
+		return nil, "", err
+	}
+	secret, err := randomtoken.Generate()
+	if err != nil {
+		return nil, "", err
+	}
+	token := APITokenPrefix + secret
+	apiToken := APIToken{
+		UserId:    user.Id,
+		Name:      name,
+		Prefix:    token[:len(APITokenPrefix)+6],
+		TokenHash: randomtoken.Hash(token),
+		Scopes:    scopes,
+		CreatedAt: time.Now(),
+		ExpiresAt: expiresAt,
+	}
+	if err := dbConn.GetDB().Create(&apiToken).Error; err != nil {
+		return nil, "", err
+	}
+	return &apiToken, token, nil
+}
+
+// FindAPITokens returns the tokens of the user which have not been revoked.
+func (user *UserData) FindAPITokens() ([]APIToken, error) {
+	dbConn := db.GetDBConn()
+	if err := dbConn.AutoMigrate(&APIToken{}); err != nil {
+		return nil, err
+	}
+	tokens := []APIToken{}
+	result := dbConn.GetDB().Where("user_id = ? AND revoked_at IS NULL", user.Id).Order("id").Find(&tokens)
+	return tokens, result.Error
+}
+
+// RevokeAPIToken revokes a token of the user.
+func (user *UserData) RevokeAPIToken(tokenId uint64) error {
+	dbConn := db.GetDBConn()
+	if err := dbConn.AutoMigrate(&APIToken{}); err != nil {
+		return err
+	}
+	result := dbConn.GetDB().Model(&APIToken{}).
+		Where("id = ? AND user_id = ? AND revoked_at IS NULL", tokenId, user.Id).
+		Update("revoked_at", time.Now())
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrAPITokenNotFound
+	}
+	return nil
+}
+
+// VerifyAPIToken returns the valid token matching the credential and records
+// that it has been used.
+func VerifyAPIToken(credential string) (*APIToken, error) {
+	dbConn := db.GetDBConn()
+	if err := dbConn.AutoMigrate(&APIToken{}); err != nil {
+		return nil, err
+	}
+	var token APIToken
+	result := dbConn.GetDB().Where("token_hash = ?", randomtoken.Hash(credential)).Limit(1).Find(&token)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 || !token.isValid() {
+		return nil, ErrAPITokenNotFound
+	}
+	now := time.Now()
+	if token.LastUsedAt == nil || now.Sub(*token.LastUsedAt) > apiTokenLastUsedInterval {
+		token.LastUsedAt = &now
+		if err := dbConn.GetDB().Model(&token).UpdateColumn("last_used_at", now).Error; err != nil {
+			return nil, err
+		}
+	}
+	return &token, nil
+}
@@ -0,0 +1,215 @@
+package usermodel
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-auth-microservice/pkg/config"
+	"github.com/go-auth-microservice/pkg/utils/db"
+	ldapauth "github.com/go-auth-microservice/pkg/utils/ldapAuth"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrUnknownCredentials = errors.New("no credential verifier knows the login")
+	ErrInvalidCredentials = errors.New("invalid email or password")
+)
+
+// Sources of the credentials of a user, see UserData.AuthSource.
+const (
+	AuthSourceLocal = "local"
+	AuthSourceLDAP  = "ldap"
+)
+
+// Names of the credential verifiers in CREDENTIAL_VERIFIERS.
+const (
+	VerifierDatabase = "database"
+	VerifierLDAP     = "ldap"
+)
+
+// CredentialVerifier checks the password of a login. The verifiers of
+// CREDENTIAL_VERIFIERS are asked in order, the first one which knows the
+// login decides.
+type CredentialVerifier interface {
+	// Source is the AuthSource of the users whose passwords the verifier checks.
+	Source() string
+	// Verify checks the password of the email. User is the local user with
+	// the email or nil if there is none. It returns the user who has logged
+	// in, ErrUnknownCredentials if the login is not the verifier's to check,
+	// and ErrInvalidCredentials if the password is wrong.
+	Verify(ctx context.Context, email string, password string, user *UserData) (*UserData, error)
+}
+
+var verifiers []CredentialVerifier
+var verifiersErr error
+var verifiersOnce sync.Once
+
+// GetCredentialVerifiers returns the verifiers of CREDENTIAL_VERIFIERS.
+func GetCredentialVerifiers() ([]CredentialVerifier, error) {
+	verifiersOnce.Do(func() {
+		verifiers, verifiersErr = NewCredentialVerifiers(config.GetConfig().GetCredentialVerifiers())
+	})
+	return verifiers, verifiersErr
+}
+
+// NewCredentialVerifiers creates the verifiers of a comma separated list of names.
+func NewCredentialVerifiers(names string) ([]CredentialVerifier, error) {
+	list := []CredentialVerifier{}
+	for _, name := range strings.Split(names, ",") {
+		switch strings.TrimSpace(name) {
+		case "":
+		case VerifierDatabase:
+			list = append(list, databaseVerifier{})
+		case VerifierLDAP:
+			directory, err := ldapauth.NewDirectory()
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, &ldapVerifier{directory: directory})
+		default:
+			return nil, fmt.Errorf("unknown credential verifier %q", name)
+		}
+	}
+	return list, nil
+}
+
+// VerifyCredentials checks the password of the email with the configured
+// verifiers. A local user is only checked by the verifier of its AuthSource,
+// so that a directory can not take over accounts with a local password.
+func VerifyCredentials(ctx context.Context, email string, password string, user *UserData) (*UserData, error) {
+	list, err := GetCredentialVerifiers()
+	if err != nil {
+		return nil, err
+	}
+	for _, verifier := range list {
+		if user != nil && user.GetAuthSource() != verifier.Source() {
+			continue
+		}
+		verified, err := verifier.Verify(ctx, email, password, user)
+		if errors.Is(err, ErrUnknownCredentials) {
+			continue
+		}
+		return verified, err
+	}
+	return nil, ErrUnknownCredentials
+}
+
+// VerifyPassword checks the password of the user with the verifier of its
+// AuthSource, for re-entering the password on sensitive changes.
+func (user *UserData) VerifyPassword(ctx context.Context, password string) error {
+	verified, err := VerifyCredentials(ctx, user.Email, password, user)
+	if errors.Is(err, ErrUnknownCredentials) {
+		return ErrInvalidCredentials
+	}
+	if err != nil {
+		return err
+	}
+	if verified.Id != user.Id {
+		return ErrInvalidCredentials
+	}
+	return nil
+}
+
+// GetAuthSource returns where the password of the user is checked. Users
+// created before AuthSource existed are local users.
+func (user *UserData) GetAuthSource() string {
+	if user.AuthSource == "" {
+		return AuthSourceLocal
+	}
+	return user.AuthSource
+}
+
+// databaseVerifier checks the password hash stored with the user.
+type databaseVerifier struct{}
+
+func (databaseVerifier) Source() string {
+	return AuthSourceLocal
+}
+
+func (databaseVerifier) Verify(ctx context.Context, email string, password string, user *UserData) (*UserData, error) {
+	if user == nil {
+		return nil, ErrUnknownCredentials
+	}
+	err := user.ValidatePassword(ctx, password)
+	if errors.Is(err, ErrHashingUnavailable) {
+		return nil, err
+	}
+	if err != nil {
+		return nil, ErrInvalidCredentials
+	}
+	return user, nil
+}
+
+// ldapVerifier binds to the directory as the user. Directory users are
+// provisioned on their first login and their role follows their groups.
+type ldapVerifier struct {
+	directory *ldapauth.Directory
+}
+
+func (v *ldapVerifier) Source() string {
+	return AuthSourceLDAP
+}
+
+func (v *ldapVerifier) Verify(ctx context.Context, email string, password string, user *UserData) (*UserData, error) {
+	entry, err := v.directory.Authenticate(ctx, email, password)
+	if errors.Is(err, ldapauth.ErrEntryNotFound) {
+		return nil, ErrUnknownCredentials
+	}
+	if errors.Is(err, ldapauth.ErrInvalidPassword) {
+		return nil, ErrInvalidCredentials
+	}
+	if err != nil {
+		return nil, err
+	}
+	return ProvisionDirectoryUser(entry, v.directory.RoleOf(entry, RoleUser))
+}
+
+// ProvisionDirectoryUser returns the local user of a directory entry which
+// has just been authenticated, creating it on the first login. The role is
+// synced to the one the groups of the entry map to. A local user with the
+// same email is never taken over.
+func ProvisionDirectoryUser(entry *ldapauth.Entry, role string) (*UserData, error) {
+	if err := migrateUsers(); err != nil {
+		return nil, err
+	}
+	dbConn := db.GetDBConn()
+	var user UserData
+	err := dbConn.GetDB().Transaction(func(tx *gorm.DB) error {
+		result := tx.Where("lower(email) = ?", strings.ToLower(entry.Email)).Limit(1).Find(&user)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected > 0 {
+			if user.GetAuthSource() != AuthSourceLDAP {
+				return ErrUnknownCredentials
+			}
+			return nil
+		}
+		now := time.Now()
+		user = *CreateUser(entry.Email)
+		user.AuthSource = AuthSourceLDAP
+		user.Role = role
+		// the directory vouches for the email of its users
+		user.EmailVerifiedAt = &now
+		if name := []rune(entry.Name); len(name) > 100 {
+			user.DisplayName = string(name[:100])
+		} else {
+			user.DisplayName = entry.Name
+		}
+		if err := tx.Create(&user).Error; err != nil {
+			return emailTakenError(err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := user.SyncRole(role); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
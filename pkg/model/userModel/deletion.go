@@ -0,0 +1,110 @@
+package usermodel
+
+import (
+	"errors"
+	"time"
+
+	auditmodel "github.com/go-auth-microservice/pkg/model/auditModel"
+	"github.com/go-auth-microservice/pkg/utils/db"
+	"gorm.io/gorm"
+)
+
+var ErrDeletionScheduled = errors.New("user deletion has already been scheduled")
+
+// DeletionRequestedByUser is the status reason of deletions requested by the
+// user, only these can be undone by the user with Reactivate.
+const DeletionRequestedByUser = "deleted by the user"
+
+// ScheduleDeletion moves the account to the deleted status on request of the
+// user and marks it to be erased once the grace period is over. Saving it
+// invalidates the refresh tokens of the user.
+func (user *UserData) ScheduleDeletion(gracePeriod time.Duration) error {
+	reason := DeletionRequestedByUser
+	// deleting a pending or suspended account must not activate it through a
+	// reactivation
+	if status := user.GetAccountState().Status; status == StatusPending || status == StatusSuspended {
+		reason = "deleted while " + string(status)
+	}
+	return user.scheduleDeletion(gracePeriod, reason)
+}
+
+// scheduleDeletion moves the account to the deleted status for the reason.
+func (user *UserData) scheduleDeletion(gracePeriod time.Duration, reason string) error {
+	if user.DeletionScheduledAt != nil {
+		return ErrDeletionScheduled
+	}
+	if err := user.ChangeStatus(StatusDeleted, reason, nil); err != nil {
+		return err
+	}
+	deleteAt := time.Now().Add(gracePeriod)
+	user.DeletionScheduledAt = &deleteAt
+	return user.Save()
+}
+
+func (user *UserData) GetDeletionScheduledAt() *time.Time {
+	return user.DeletionScheduledAt
+}
+
+// PurgeDeletedUsers erases every user whose deletion is due together with the
+// data stored for it. Audit events are anonymized instead of deleted.
+func PurgeDeletedUsers(now time.Time) (int, error) {
+	dbConn := db.GetDBConn()
+	if err := migrateUsers(); err != nil {
+		return 0, err
+	}
+	if err := dbConn.AutoMigrate(&PasswordHistory{}, &EmailChange{}, &ReactivationRequest{}, &SignupVerification{}, &Invitation{}, &APIToken{}, &LoginEvent{}, &LoginChallenge{}, &UserIdentity{}, &SCIMUser{}, &SCIMGroupMember{}, &auditmodel.AuditEvent{}); err != nil {
+		return 0, err
+	}
+	var users []UserData
+	result := dbConn.GetDB().Where("status = ? AND deletion_scheduled_at <= ?", StatusDeleted, now).Find(&users)
+	if result.Error != nil {
+		return 0, result.Error
+	}
+	purged := 0
+	for _, user := range users {
+		err := dbConn.GetDB().Transaction(func(tx *gorm.DB) error {
+			if err := tx.Where("user_id = ?", user.Id).Delete(&PasswordHistory{}).Error; err != nil {
+				return err
+			}
+			if err := tx.Where("user_id = ?", user.Id).Delete(&EmailChange{}).Error; err != nil {
+				return err
+			}
+			if err := tx.Where("user_id = ?", user.Id).Delete(&ReactivationRequest{}).Error; err != nil {
+				return err
+			}
+			if err := tx.Where("user_id = ?", user.Id).Delete(&SignupVerification{}).Error; err != nil {
+				return err
+			}
+			if err := tx.Where("user_id = ?", user.Id).Delete(&APIToken{}).Error; err != nil {
+				return err
+			}
+			if err := tx.Where("user_id = ?", user.Id).Delete(&LoginEvent{}).Error; err != nil {
+				return err
+			}
+			if err := tx.Where("user_id = ?", user.Id).Delete(&LoginChallenge{}).Error; err != nil {
+				return err
+			}
+			if err := tx.Where("user_id = ?", user.Id).Delete(&UserIdentity{}).Error; err != nil {
+				return err
+			}
+			if err := tx.Where("user_id = ?", user.Id).Delete(&SCIMUser{}).Error; err != nil {
+				return err
+			}
+			if err := tx.Where("user_id = ?", user.Id).Delete(&SCIMGroupMember{}).Error; err != nil {
+				return err
+			}
+			if err := tx.Model(&Invitation{}).Where("user_id = ?", user.Id).Update("user_id", nil).Error; err != nil {
+				return err
+			}
+			if err := auditmodel.AnonymizeUser(tx, user.Id); err != nil {
+				return err
+			}
+			return tx.Delete(&UserData{}, user.Id).Error
+		})
+		if err != nil {
+			return purged, err
+		}
+		purged++
+	}
+	return purged, nil
+}
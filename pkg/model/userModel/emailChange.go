@@ -0,0 +1,172 @@
+package usermodel
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/go-auth-microservice/pkg/config"
+	"github.com/go-auth-microservice/pkg/utils/db"
+	randomtoken "github.com/go-auth-microservice/pkg/utils/randomToken"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrEmailTaken          = errors.New("email is already in use")
+	ErrEmailChangeNotFound = errors.New("email change not found or expired")
+)
+
+// emailTakenError maps the violation of the unique index on email, e.g. by a
+// concurrent signup with the same address, to ErrEmailTaken and returns other
+// errors unchanged.
+func emailTakenError(err error) error {
+	if errors.Is(err, gorm.ErrDuplicatedKey) {
+		return ErrEmailTaken
+	}
+	return err
+}
+
+// EmailChange is a pending change of the email of a user. It is applied only
+// once the new address has been confirmed and can be cancelled from the old
+// address until then. Only hashes of the confirm and cancel tokens are stored.
+type EmailChange struct {
+	Id          uint64     `gorm:"primaryKey,autoIncrement" json:"-"`
+	UserId      uint64     `gorm:"not null;index" json:"-"`
+	OldEmail    string     `gorm:"not null" json:"oldEmail"`
+	NewEmail    string     `gorm:"not null;index" json:"newEmail"`
+	ConfirmHash string     `gorm:"not null;uniqueIndex" json:"-"`
+	CancelHash  string     `gorm:"not null;uniqueIndex" json:"-"`
+	CreatedAt   time.Time  `gorm:"not null" json:"createdAt"`
+	ExpiresAt   time.Time  `gorm:"not null" json:"expiresAt"`
+	ConfirmedAt *time.Time `json:"confirmedAt,omitempty"`
+	CancelledAt *time.Time `json:"cancelledAt,omitempty"`
+}
+
+func (change *EmailChange) isPending() bool {
+	return change.ConfirmedAt == nil && change.CancelledAt == nil && time.Now().Before(change.ExpiresAt)
+}
+
+func emailInUse(tx *gorm.DB, email string) (bool, error) {
+	var count int64
+	result := tx.Model(&UserData{}).Where("lower(email) = ?", strings.ToLower(email)).Count(&count)
+	return count > 0, result.Error
+}
+
+// RequestEmailChange records a pending email change and returns the tokens
+// for the confirm link sent to the new and the cancel link sent to the old
+// address. Earlier pending changes of the user are superseded.
+func (user *UserData) RequestEmailChange(newEmail string) (string, string, error) {
+	dbConn := db.GetDBConn()
+	if err := dbConn.AutoMigrate(&EmailChange{}); err != nil {
+		return "", "", err
+	}
+	inUse, err := emailInUse(dbConn.GetDB(), newEmail)
+	if err != nil {
+		return "", "", err
+	}
+	if inUse {
+		return "", "", ErrEmailTaken
+	}
+	confirmToken, err := randomtoken.Generate()
+	if err != nil {
+		return "", "", err
+	}
+	cancelToken, err := randomtoken.Generate()
+	if err != nil {
+		return "", "", err
+	}
+	now := time.Now()
+	change := EmailChange{
+		UserId:      user.Id,
+		OldEmail:    user.Email,
+		NewEmail:    newEmail,
+		ConfirmHash: randomtoken.Hash(confirmToken),
+		CancelHash:  randomtoken.Hash(cancelToken),
+		CreatedAt:   now,
+		ExpiresAt:   now.Add(time.Minute * time.Duration(config.GetConfig().GetEmailChangeExpiry())),
+	}
+	err = dbConn.GetDB().Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&EmailChange{}).
+			Where("user_id = ? AND confirmed_at IS NULL AND cancelled_at IS NULL", user.Id).
+			Update("cancelled_at", now)
+		if result.Error != nil {
+			return result.Error
+		}
+		return tx.Create(&change).Error
+	})
+	if err != nil {
+		return "", "", err
+	}
+	return confirmToken, cancelToken, nil
+}
+
+// ConfirmEmailChange swaps the email of the user once the new address has
+// been confirmed. Bumping TokensValidAfter invalidates the refresh tokens
+// issued before the change.
+func ConfirmEmailChange(confirmToken string) (*UserData, *EmailChange, error) {
+	dbConn := db.GetDBConn()
+	if err := dbConn.AutoMigrate(&EmailChange{}); err != nil {
+		return nil, nil, err
+	}
+	var user UserData
+	var change EmailChange
+	err := dbConn.GetDB().Transaction(func(tx *gorm.DB) error {
+		result := tx.Where("confirm_hash = ?", randomtoken.Hash(confirmToken)).Limit(1).Find(&change)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 || !change.isPending() {
+			return ErrEmailChangeNotFound
+		}
+		if err := tx.Where("id = ?", change.UserId).First(&user).Error; err != nil {
+			return err
+		}
+		// the email has been changed in another way since the request
+		if user.Email != change.OldEmail {
+			return ErrEmailChangeNotFound
+		}
+		inUse, err := emailInUse(tx, change.NewEmail)
+		if err != nil {
+			return err
+		}
+		if inUse {
+			return ErrEmailTaken
+		}
+		now := time.Now()
+		user.Email = change.NewEmail
+		user.EmailVerifiedAt = &now
+		user.UpdatedAt = now
+		user.TokensValidAfter = now
+		if err := tx.Save(&user).Error; err != nil {
+			return emailTakenError(err)
+		}
+		change.ConfirmedAt = &now
+		return tx.Save(&change).Error
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return &user, &change, nil
+}
+
+// CancelEmailChange cancels a pending email change from the link sent to the old address.
+func CancelEmailChange(cancelToken string) (*EmailChange, error) {
+	dbConn := db.GetDBConn()
+	if err := dbConn.AutoMigrate(&EmailChange{}); err != nil {
+		return nil, err
+	}
+	var change EmailChange
+	result := dbConn.GetDB().Where("cancel_hash = ?", randomtoken.Hash(cancelToken)).Limit(1).Find(&change)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 || !change.isPending() {
+		return nil, ErrEmailChangeNotFound
+	}
+	now := time.Now()
+	change.CancelledAt = &now
+	if err := dbConn.GetDB().Save(&change).Error; err != nil {
+		return nil, err
+	}
+	return &change, nil
+}
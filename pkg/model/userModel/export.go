@@ -0,0 +1,110 @@
+package usermodel
+
+import (
+	"time"
+
+	auditmodel "github.com/go-auth-microservice/pkg/model/auditModel"
+	"github.com/go-auth-microservice/pkg/utils/db"
+)
+
+// UserExport is everything stored about a user, the data PurgeDeletedUsers
+// erases. Password hashes and token hashes are left out, only when the
+// password was changed is exported.
+// API tokens include revoked ones.
+// The login history is exported in full, as are the linked identities of
+// social login, the login challenges, reactivation requests and signup
+// verifications.
+type UserExport struct {
+	ExportedAt           time.Time               `json:"exportedAt"`
+	User                 *UserData               `json:"user"`
+	PasswordChanges      []time.Time             `json:"passwordChanges"`
+	EmailChanges         []EmailChange           `json:"emailChanges"`
+	APITokens            []APIToken              `json:"apiTokens"`
+	LoginHistory         []LoginEvent            `json:"loginHistory"`
+	LoginChallenges      []LoginChallenge        `json:"loginChallenges"`
+	Identities           []UserIdentity          `json:"identities"`
+	ReactivationRequests []ReactivationRequest   `json:"reactivationRequests"`
+	SignupVerifications  []SignupVerification    `json:"signupVerifications"`
+	Invitations          []Invitation            `json:"invitations"`
+	SCIMLinks            []SCIMUser              `json:"scimLinks"`
+	SCIMGroups           []SCIMGroupMembership   `json:"scimGroups"`
+	AuditEvents          []auditmodel.AuditEvent `json:"auditEvents"`
+}
+
+// SCIMGroupMembership is a group of a SCIM tenant the user is a member of.
+type SCIMGroupMembership struct {
+	Tenant      string `json:"tenant"`
+	DisplayName string `json:"displayName"`
+}
+
+// Export collects the data stored about the user.
+func (user *UserData) Export() (*UserExport, error) {
+	if err := migrateSCIM(); err != nil {
+		return nil, err
+	}
+	dbConn := db.GetDBConn()
+	if err := dbConn.AutoMigrate(&PasswordHistory{}, &EmailChange{}, &APIToken{}, &LoginEvent{}, &LoginChallenge{}, &UserIdentity{}, &ReactivationRequest{}, &SignupVerification{}, &Invitation{}); err != nil {
+		return nil, err
+	}
+	export := &UserExport{
+		ExportedAt:           time.Now(),
+		User:                 user,
+		PasswordChanges:      []time.Time{},
+		EmailChanges:         []EmailChange{},
+		APITokens:            []APIToken{},
+		LoginHistory:         []LoginEvent{},
+		LoginChallenges:      []LoginChallenge{},
+		Identities:           []UserIdentity{},
+		ReactivationRequests: []ReactivationRequest{},
+		SignupVerifications:  []SignupVerification{},
+		Invitations:          []Invitation{},
+		SCIMLinks:            []SCIMUser{},
+		SCIMGroups:           []SCIMGroupMembership{},
+	}
+	var history []PasswordHistory
+	if err := dbConn.GetDB().Where("user_id = ?", user.Id).Order("id").Find(&history).Error; err != nil {
+		return nil, err
+	}
+	for _, entry := range history {
+		export.PasswordChanges = append(export.PasswordChanges, entry.CreatedAt)
+	}
+	if err := dbConn.GetDB().Where("user_id = ?", user.Id).Order("id").Find(&export.EmailChanges).Error; err != nil {
+		return nil, err
+	}
+	if err := dbConn.GetDB().Where("user_id = ?", user.Id).Order("id").Find(&export.APITokens).Error; err != nil {
+		return nil, err
+	}
+	if err := dbConn.GetDB().Where("user_id = ?", user.Id).Order("id").Find(&export.LoginHistory).Error; err != nil {
+		return nil, err
+	}
+	if err := dbConn.GetDB().Where("user_id = ?", user.Id).Order("id").Find(&export.LoginChallenges).Error; err != nil {
+		return nil, err
+	}
+	if err := dbConn.GetDB().Where("user_id = ?", user.Id).Order("id").Find(&export.Identities).Error; err != nil {
+		return nil, err
+	}
+	if err := dbConn.GetDB().Where("user_id = ?", user.Id).Order("id").Find(&export.ReactivationRequests).Error; err != nil {
+		return nil, err
+	}
+	if err := dbConn.GetDB().Where("user_id = ?", user.Id).Order("id").Find(&export.SignupVerifications).Error; err != nil {
+		return nil, err
+	}
+	if err := dbConn.GetDB().Where("user_id = ?", user.Id).Order("id").Find(&export.Invitations).Error; err != nil {
+		return nil, err
+	}
+	if err := dbConn.GetDB().Where("user_id = ?", user.Id).Order("id").Find(&export.SCIMLinks).Error; err != nil {
+		return nil, err
+	}
+	err := dbConn.GetDB().Model(&SCIMGroup{}).Select("scim_groups.tenant, scim_groups.display_name").
+		Joins("JOIN scim_group_members ON scim_group_members.group_id = scim_groups.id").
+		Where("scim_group_members.user_id = ?", user.Id).Order("scim_groups.id").Scan(&export.SCIMGroups).Error
+	if err != nil {
+		return nil, err
+	}
+	events, err := auditmodel.FindEventsByUserID(user.Id)
+	if err != nil {
+		return nil, err
+	}
+	export.AuditEvents = events
+	return export, nil
+}
@@ -0,0 +1,123 @@
+package usermodel
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"github.com/go-auth-microservice/pkg/config"
+)
+
+// ErrHashingUnavailable is returned when password hashing could not be done
+// because the worker pool is saturated or the request has been cancelled.
+var ErrHashingUnavailable = errors.New("password hashing is currently unavailable")
+
+type hashJob struct {
+	ctx  context.Context
+	work func() error
+	done chan error
+}
+
+// hashPool runs the CPU heavy password hashing on a fixed number of workers
+// so that a burst of logins can not starve the rest of the service. Jobs which
+// do not fit into the queue are rejected right away.
+type hashPool struct {
+	workers    int
+	queueDepth int
+	jobs       chan *hashJob
+	inFlight   atomic.Int64
+	completed  atomic.Uint64
+	rejected   atomic.Uint64
+	cancelled  atomic.Uint64
+}
+
+// HashPoolStats is a snapshot of the password hashing pool metrics.
+type HashPoolStats struct {
+	Workers    int    `json:"workers"`
+	QueueDepth int    `json:"queueDepth"`
+	Queued     int    `json:"queued"`
+	InFlight   int64  `json:"inFlight"`
+	Completed  uint64 `json:"completed"`
+	Rejected   uint64 `json:"rejected"`
+	Cancelled  uint64 `json:"cancelled"`
+}
+
+func (p *hashPool) start() {
+	for i := 0; i < p.workers; i++ {
+		go p.worker()
+	}
+}
+
+func (p *hashPool) worker() {
+	for job := range p.jobs {
+		// the caller has already given up, don't burn CPU for nothing
+		if job.ctx.Err() != nil {
+			p.cancelled.Add(1)
+			continue
+		}
+		p.inFlight.Add(1)
+		err := job.work()
+		p.inFlight.Add(-1)
+		p.completed.Add(1)
+		job.done <- err
+	}
+}
+
+func (p *hashPool) run(ctx context.Context, work func() error) error {
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("%w: %v", ErrHashingUnavailable, err)
+	}
+	job := &hashJob{ctx: ctx, work: work, done: make(chan error, 1)}
+	select {
+	case p.jobs <- job:
+	default:
+		p.rejected.Add(1)
+		return fmt.Errorf("%w: queue is full", ErrHashingUnavailable)
+	}
+	select {
+	case err := <-job.done:
+		return err
+	case <-ctx.Done():
+		return fmt.Errorf("%w: %v", ErrHashingUnavailable, ctx.Err())
+	}
+}
+
+func (p *hashPool) stats() HashPoolStats {
+	return HashPoolStats{
+		Workers:    p.workers,
+		QueueDepth: p.queueDepth,
+		Queued:     len(p.jobs),
+		InFlight:   p.inFlight.Load(),
+		Completed:  p.completed.Load(),
+		Rejected:   p.rejected.Load(),
+		Cancelled:  p.cancelled.Load(),
+	}
+}
+
+func newHashPool(workers int, queueDepth int) *hashPool {
+	p := &hashPool{
+		workers:    workers,
+		queueDepth: queueDepth,
+		jobs:       make(chan *hashJob, queueDepth),
+	}
+	p.start()
+	return p
+}
+
+var pool *hashPool
+var poolOnce sync.Once
+
+func getHashPool() *hashPool {
+	poolOnce.Do(func() {
+		appConfig := config.GetConfig()
+		pool = newHashPool(appConfig.GetHashPoolWorkers(), appConfig.GetHashPoolQueueDepth())
+	})
+	return pool
+}
+
+// GetHashPoolStats returns the current metrics of the password hashing pool.
+func GetHashPoolStats() HashPoolStats {
+	return getHashPool().stats()
+}
@@ -0,0 +1,44 @@
+package usermodel
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestHashPoolSaturation tests that jobs which do not fit into the queue are
+// rejected right away instead of waiting for a worker
+func TestHashPoolSaturation(t *testing.T) {
+	p := newHashPool(1, 1)
+	release := make(chan struct{})
+	started := make(chan struct{})
+	running := make(chan error, 2)
+
+	go func() {
+		running <- p.run(context.Background(), func() error {
+			close(started)
+			<-release
+			return nil
+		})
+	}()
+	<-started
+	go func() {
+		running <- p.run(context.Background(), func() error { return nil })
+	}()
+	assert.Eventually(t, func() bool { return len(p.jobs) == 1 }, time.Second, time.Millisecond)
+
+	err := p.run(context.Background(), func() error { return nil })
+	assert.ErrorIs(t, err, ErrHashingUnavailable)
+	assert.Contains(t, err.Error(), "queue is full")
+	stats := p.stats()
+	assert.Equal(t, uint64(1), stats.Rejected)
+	assert.Equal(t, int64(1), stats.InFlight)
+	assert.Equal(t, 1, stats.Queued)
+
+	close(release)
+	assert.NoError(t, <-running)
+	assert.NoError(t, <-running)
+	assert.Equal(t, uint64(2), p.stats().Completed)
+}
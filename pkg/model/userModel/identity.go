@@ -0,0 +1,296 @@
+package usermodel
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/go-auth-microservice/pkg/config"
+	"github.com/go-auth-microservice/pkg/utils/db"
+	randomtoken "github.com/go-auth-microservice/pkg/utils/randomToken"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrIdentityNotFound        = errors.New("identity not found")
+	ErrIdentityTaken           = errors.New("identity is linked to another user")
+	ErrIdentityEmailUnverified = errors.New("identity provider has not verified the email")
+	ErrIdentityAccountExists   = errors.New("an account with the email exists but its email is not verified")
+	ErrIdentitySignupClosed    = errors.New("signup is by invitation only")
+	ErrLastCredential          = errors.New("the only way to log in can not be removed")
+	ErrFederationStateNotFound = errors.New("federation state not found, expired or already used")
+)
+
+// How an external identity has been resolved to a user.
+const (
+	IdentityKnown   = "known"
+	IdentityLinked  = "linked"
+	IdentityCreated = "created"
+)
+
+// UserIdentity links a user to their account at an upstream identity
+// provider. Subject is the stable id of the user at the provider.
+type UserIdentity struct {
+	Id          uint64     `gorm:"primaryKey,autoIncrement" json:"id"`
+	UserId      uint64     `gorm:"not null;index" json:"-"`
+	Provider    string     `gorm:"not null;uniqueIndex:idx_user_identities_subject" json:"provider"`
+	Subject     string     `gorm:"not null;uniqueIndex:idx_user_identities_subject" json:"subject"`
+	Email       string     `json:"email"`
+	CreatedAt   time.Time  `gorm:"not null" json:"createdAt"`
+	LastLoginAt *time.Time `json:"lastLoginAt,omitempty"`
+}
+
+// ExternalIdentity is a user as an upstream identity provider knows them.
+type ExternalIdentity struct {
+	Provider      string
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+}
+
+// FederationState is a social login waiting for the callback of the identity
+// provider. Only the hash of the state sent through the browser is stored,
+// the nonce and PKCE verifier never leave the server. UserId is set when a
+// logged in user links an identity instead of logging in.
+type FederationState struct {
+	Id           uint64 `gorm:"primaryKey,autoIncrement"`
+	StateHash    string `gorm:"not null;uniqueIndex"`
+	Provider     string `gorm:"not null"`
+	UserId       *uint64
+	Nonce        string    `gorm:"not null"`
+	CodeVerifier string    `gorm:"not null"`
+	Cookies      bool      `gorm:"not null;default:false"`
+	CreatedAt    time.Time `gorm:"not null"`
+	ExpiresAt    time.Time `gorm:"not null"`
+	UsedAt       *time.Time
+}
+
+// CreateFederationState starts a social login with the provider, or linking
+// an identity to the user if userId is not nil, and returns the state to send
+// through the browser. Cookies tells if the login asked for a cookie session.
+func CreateFederationState(provider string, userId *uint64, cookies bool) (string, *FederationState, error) {
+	dbConn := db.GetDBConn()
+	if err := dbConn.AutoMigrate(&FederationState{}); err != nil {
+		return "", nil, err
+	}
+	token, err := randomtoken.Generate()
+	if err != nil {
+		return "", nil, err
+	}
+	nonce, err := randomtoken.Generate()
+	if err != nil {
+		return "", nil, err
+	}
+	verifier, err := randomtoken.Generate()
+	if err != nil {
+		return "", nil, err
+	}
+	now := time.Now()
+	state := FederationState{
+		StateHash:    randomtoken.Hash(token),
+		Provider:     provider,
+		UserId:       userId,
+		Nonce:        nonce,
+		CodeVerifier: verifier,
+		Cookies:      cookies,
+		CreatedAt:    now,
+		ExpiresAt:    now.Add(time.Minute * time.Duration(config.GetConfig().GetFederationStateExpiry())),
+	}
+	if err := dbConn.GetDB().Create(&state).Error; err != nil {
+		return "", nil, err
+	}
+	return token, &state, nil
+}
+
+// ConsumeFederationState returns the pending social login of the state with
+// the provider and marks it as used, a state can only be redeemed once.
+func ConsumeFederationState(token string, provider string) (*FederationState, error) {
+	dbConn := db.GetDBConn()
+	if err := dbConn.AutoMigrate(&FederationState{}); err != nil {
+		return nil, err
+	}
+	var state FederationState
+	result := dbConn.GetDB().Where("state_hash = ?", randomtoken.Hash(token)).Limit(1).Find(&state)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 || state.UsedAt != nil || !time.Now().Before(state.ExpiresAt) || state.Provider != provider {
+		return nil, ErrFederationStateNotFound
+	}
+	now := time.Now()
+	result = dbConn.GetDB().Model(&FederationState{}).Where("id = ? AND used_at IS NULL", state.Id).Update("used_at", now)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, ErrFederationStateNotFound
+	}
+	state.UsedAt = &now
+	return &state, nil
+}
+
+// FederateIdentity returns the user an external identity logs in as, and how
+// it has been resolved. A known identity logs in as its user. Otherwise the
+// identity is linked to the user with the same email if both the provider and
+// this service have verified the email, or a new user is created for it if
+// createUsers allows. Accounts are never linked by an unverified email, which
+// would let anyone claim them at a provider.
+func FederateIdentity(identity ExternalIdentity, createUsers bool) (*UserData, string, error) {
+	if err := migrateUsers(); err != nil {
+		return nil, "", err
+	}
+	dbConn := db.GetDBConn()
+	if err := dbConn.AutoMigrate(&UserIdentity{}); err != nil {
+		return nil, "", err
+	}
+	var user *UserData
+	outcome := IdentityKnown
+	now := time.Now()
+	err := dbConn.GetDB().Transaction(func(tx *gorm.DB) error {
+		var known UserIdentity
+		result := tx.Where("provider = ? AND subject = ?", identity.Provider, identity.Subject).Limit(1).Find(&known)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected > 0 {
+			user = &UserData{}
+			if err := tx.Where("id = ?", known.UserId).First(user).Error; err != nil {
+				return err
+			}
+			return tx.Model(&UserIdentity{}).Where("id = ?", known.Id).
+				Updates(map[string]interface{}{"email": identity.Email, "last_login_at": now}).Error
+		}
+		if identity.Email == "" || !identity.EmailVerified {
+			return ErrIdentityEmailUnverified
+		}
+		var existing UserData
+		result = tx.Where("lower(email) = ?", strings.ToLower(identity.Email)).Limit(1).Find(&existing)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected > 0 {
+			if existing.EmailVerifiedAt == nil {
+				return ErrIdentityAccountExists
+			}
+			user = &existing
+			outcome = IdentityLinked
+		} else {
+			if !createUsers {
+				return ErrIdentitySignupClosed
+			}
+			// without a password the user can only log in through the provider
+			user = CreateUser(identity.Email)
+			user.EmailVerifiedAt = &now
+			if name := []rune(identity.Name); len(name) > 100 {
+				user.DisplayName = string(name[:100])
+			} else {
+				user.DisplayName = identity.Name
+			}
+			if err := tx.Create(user).Error; err != nil {
+				return emailTakenError(err)
+			}
+			outcome = IdentityCreated
+		}
+		return tx.Create(&UserIdentity{
+			UserId:      user.Id,
+			Provider:    identity.Provider,
+			Subject:     identity.Subject,
+			Email:       identity.Email,
+			CreatedAt:   now,
+			LastLoginAt: &now,
+		}).Error
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	return user, outcome, nil
+}
+
+// LinkIdentity links an external identity to the user. Linking an identity
+// the user has already linked again is a no-op.
+func (user *UserData) LinkIdentity(identity ExternalIdentity) (*UserIdentity, error) {
+	dbConn := db.GetDBConn()
+	if err := dbConn.AutoMigrate(&UserIdentity{}); err != nil {
+		return nil, err
+	}
+	var linked UserIdentity
+	err := dbConn.GetDB().Transaction(func(tx *gorm.DB) error {
+		result := tx.Where("provider = ? AND subject = ?", identity.Provider, identity.Subject).Limit(1).Find(&linked)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected > 0 {
+			if linked.UserId != user.Id {
+				return ErrIdentityTaken
+			}
+			return nil
+		}
+		linked = UserIdentity{
+			UserId:    user.Id,
+			Provider:  identity.Provider,
+			Subject:   identity.Subject,
+			Email:     identity.Email,
+			CreatedAt: time.Now(),
+		}
+		// the unique index catches the identity being linked concurrently
+		if err := tx.Create(&linked).Error; err != nil {
+			return ErrIdentityTaken
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &linked, nil
+}
+
+// FindIdentities returns the external identities linked to the user.
+func (user *UserData) FindIdentities() ([]UserIdentity, error) {
+	dbConn := db.GetDBConn()
+	if err := dbConn.AutoMigrate(&UserIdentity{}); err != nil {
+		return nil, err
+	}
+	identities := []UserIdentity{}
+	result := dbConn.GetDB().Where("user_id = ?", user.Id).Order("id").Find(&identities)
+	return identities, result.Error
+}
+
+// HasPassword tells if the user can log in with a password. Users created by
+// a social login have none, directory users log in with the directory password.
+func (user *UserData) HasPassword() bool {
+	return user.Password != "" || user.GetAuthSource() == AuthSourceLDAP
+}
+
+// UnlinkIdentity removes an external identity of the user. The last identity
+// of a user without a password can not be removed.
+func (user *UserData) UnlinkIdentity(id uint64) (*UserIdentity, error) {
+	dbConn := db.GetDBConn()
+	if err := dbConn.AutoMigrate(&UserIdentity{}); err != nil {
+		return nil, err
+	}
+	var identity UserIdentity
+	err := dbConn.GetDB().Transaction(func(tx *gorm.DB) error {
+		result := tx.Where("id = ? AND user_id = ?", id, user.Id).Limit(1).Find(&identity)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrIdentityNotFound
+		}
+		if !user.HasPassword() {
+			var count int64
+			if err := tx.Model(&UserIdentity{}).Where("user_id = ?", user.Id).Count(&count).Error; err != nil {
+				return err
+			}
+			if count <= 1 {
+				return ErrLastCredential
+			}
+		}
+		return tx.Delete(&UserIdentity{}, identity.Id).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &identity, nil
+}
@@ -0,0 +1,153 @@
+package usermodel
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/go-auth-microservice/pkg/utils/db"
+	passwordhash "github.com/go-auth-microservice/pkg/utils/passwordHash"
+	"github.com/go-auth-microservice/pkg/utils/validation"
+	"gorm.io/gorm"
+)
+
+// ImportRecord is a user exported from another system. The salt is only set
+// for Firebase exports, every other supported hash carries its own salt.
+type ImportRecord struct {
+	Email        string `json:"email" validate:"required,email"`
+	PasswordHash string `json:"passwordHash" validate:"required"`
+	Salt         string `json:"salt"`
+}
+
+// ImportResult counts the outcome of a bulk import. Failed records are keyed
+// by their position in the import starting at 1, 0 if the import as a whole
+// failed.
+type ImportResult struct {
+	Imported int
+	Skipped  int
+	Failed   map[int]error
+}
+
+// ReadImportRecords reads users either from a CSV file with the columns
+// email,password_hash,salt or from JSON, which can be a plain list of records
+// or a Firebase auth export of the form {"users": [...]}.
+func ReadImportRecords(r io.Reader, format string) ([]ImportRecord, error) {
+	switch format {
+	case "csv":
+		return readImportCSV(r)
+	case "json":
+		return readImportJSON(r)
+	default:
+		return nil, fmt.Errorf("unsupported import format %q", format)
+	}
+}
+
+func readImportCSV(r io.Reader) ([]ImportRecord, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	rows, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, nil
+	}
+	columns := map[string]int{}
+	for i, name := range rows[0] {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	emailColumn, ok := columns["email"]
+	if !ok {
+		return nil, fmt.Errorf("csv header misses the email column")
+	}
+	hashColumn, ok := columns["password_hash"]
+	if !ok {
+		return nil, fmt.Errorf("csv header misses the password_hash column")
+	}
+	saltColumn, hasSalt := columns["salt"]
+	records := make([]ImportRecord, 0, len(rows)-1)
+	for _, row := range rows[1:] {
+		var record ImportRecord
+		if emailColumn < len(row) {
+			record.Email = row[emailColumn]
+		}
+		if hashColumn < len(row) {
+			record.PasswordHash = row[hashColumn]
+		}
+		if hasSalt && saltColumn < len(row) {
+			record.Salt = row[saltColumn]
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+func readImportJSON(r io.Reader) ([]ImportRecord, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	var records []ImportRecord
+	if err := json.Unmarshal(data, &records); err == nil {
+		return records, nil
+	}
+	var export struct {
+		Users []ImportRecord `json:"users"`
+	}
+	if err := json.Unmarshal(data, &export); err != nil {
+		return nil, err
+	}
+	return export.Users, nil
+}
+
+// storedHash converts the hash of a record into the format ValidatePassword understands.
+func (record ImportRecord) storedHash() string {
+	if record.Salt != "" {
+		return passwordhash.FirebaseScryptHash(record.Salt, record.PasswordHash)
+	}
+	return record.PasswordHash
+}
+
+// ImportUser creates a user with a password hash of a foreign system. The hash
+// is replaced by one of the configured algorithm on the first successful login.
+func ImportUser(record ImportRecord) error {
+	record.Email = strings.TrimSpace(record.Email)
+	if err := validation.Validator.Struct(record); err != nil {
+		return err
+	}
+	hash := record.storedHash()
+	if !passwordhash.Supported(hash) {
+		return passwordhash.ErrUnknownHash
+	}
+	user := CreateUser(record.Email)
+	user.Password = hash
+	return user.Save()
+}
+
+// ImportUsers imports all records, users whose email already exists are skipped.
+func ImportUsers(records []ImportRecord) ImportResult {
+	result := ImportResult{Failed: map[int]error{}}
+	dbConn := db.GetDBConn()
+	if err := dbConn.AutoMigrate(&UserData{}); err != nil {
+		result.Failed[0] = err
+		return result
+	}
+	for i, record := range records {
+		if _, err := FindUserByEmail(record.Email); err == nil {
+			result.Skipped++
+			continue
+		} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+			result.Failed[i+1] = err
+			continue
+		}
+		if err := ImportUser(record); err != nil {
+			result.Failed[i+1] = err
+			continue
+		}
+		result.Imported++
+	}
+	return result
+}
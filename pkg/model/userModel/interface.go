@@ -1,22 +1,78 @@
 package usermodel
 
-import "time"
+import (
+	"context"
+	"time"
+
+	passwordpolicy "github.com/go-auth-microservice/pkg/utils/passwordPolicy"
+)
 
 type UserSignUp interface {
-	SetPassword(string) error
+	CheckPasswordPolicy(context.Context, string) ([]passwordpolicy.Violation, error)
+	SetPassword(context.Context, string) error
 	Save() error
+	SavePasswordHistory() error
 }
 
 type UserLogin interface {
-	ValidatePassword(string) error
+	VerifyPassword(context.Context, string) error
+	HasPassword() bool
+	GetAuthSource() string
+	RehashPassword(context.Context, string) error
 	GetUserID() uint64
-	GetUserStatus() bool
+	GetUserEmail() string
+	GetAccountState() AccountState
+	GetUserRole() string
 	GetUserLastUpdated() time.Time
+	GetTokensValidAfter() time.Time
+	IsLocked() bool
+	RegisterFailedLogin() error
+	ResetFailedLogins() error
+	RecordLogin(string, string, string, string) (bool, error)
+	GetLoginSignals(string, string, time.Time) (*LoginSignals, error)
+	CreateLoginChallenge(string, bool) (string, string, error)
+}
+
+type UserFederation interface {
+	FindIdentities() ([]UserIdentity, error)
+	SyncRole(string) error
+	LinkIdentity(ExternalIdentity) (*UserIdentity, error)
+	HasPassword() bool
+	UnlinkIdentity(uint64) (*UserIdentity, error)
+}
+
+type UserLoginHistory interface {
+	FindLoginHistory(int, int) (*LoginHistory, error)
 }
 
 type UserStatus interface {
-	GetUserStatus() bool
-	Disable() error
-	Enable() error
+	GetAccountState() AccountState
+	Deactivate() error
+	Suspend(string, *time.Time) error
+	Unsuspend() error
 	Save() error
 }
+
+type UserReactivation interface {
+	UserLogin
+	CanReactivate() bool
+	Reactivate() error
+	RequestReactivation() (string, error)
+}
+
+type UserLockout interface {
+	IsLocked() bool
+	Unlock() error
+}
+
+type UserProfileUpdate interface {
+	ApplyProfilePatch([]byte) error
+	SaveProfile(string) error
+	GetETag() string
+}
+
+type UserDeletion interface {
+	Export() (*UserExport, error)
+	ScheduleDeletion(time.Duration) error
+	GetDeletionScheduledAt() *time.Time
+}
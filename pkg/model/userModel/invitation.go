@@ -0,0 +1,139 @@
+package usermodel
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/go-auth-microservice/pkg/config"
+	"github.com/go-auth-microservice/pkg/utils/db"
+	randomtoken "github.com/go-auth-microservice/pkg/utils/randomToken"
+	"gorm.io/gorm"
+)
+
+var ErrInvitationNotFound = errors.New("invitation not found or expired")
+
+// Invitation lets an admin onboard a user with a given role. The invited user
+// chooses the password when accepting it. Only the hash of the token is stored.
+type Invitation struct {
+	Id         uint64     `gorm:"primaryKey,autoIncrement" json:"id"`
+	Email      string     `gorm:"not null;index" json:"email"`
+	Role       string     `gorm:"not null" json:"role"`
+	InviterId  uint64     `gorm:"not null;index" json:"inviterId"`
+	TokenHash  string     `gorm:"not null;uniqueIndex" json:"-"`
+	CreatedAt  time.Time  `gorm:"not null" json:"createdAt"`
+	ExpiresAt  time.Time  `gorm:"not null" json:"expiresAt"`
+	AcceptedAt *time.Time `json:"acceptedAt,omitempty"`
+	UserId     *uint64    `json:"userId,omitempty"`
+}
+
+func (invitation *Invitation) isPending() bool {
+	return invitation.AcceptedAt == nil && time.Now().Before(invitation.ExpiresAt)
+}
+
+// CreateInvitation records an invitation and returns it with the token for
+// the link sent to the invited email. Earlier pending invitations for the
+// same email expire.
+func CreateInvitation(email string, role string, inviterId uint64) (*Invitation, string, error) {
+	dbConn := db.GetDBConn()
+	if err := migrateUsers(); err != nil {
+		return nil, "", err
+	}
+	if err := dbConn.AutoMigrate(&Invitation{}); err != nil {
+		return nil, "", err
+	}
+	inUse, err := emailInUse(dbConn.GetDB(), email)
+	if err != nil {
+		return nil, "", err
+	}
+	if inUse {
+		return nil, "", ErrEmailTaken
+	}
+	token, err := randomtoken.Generate()
+	if err != nil {
+		return nil, "", err
+	}
+	now := time.Now()
+	invitation := Invitation{
+		Email:     email,
+		Role:      role,
+		InviterId: inviterId,
+		TokenHash: randomtoken.Hash(token),
+		CreatedAt: now,
+		ExpiresAt: now.Add(time.Minute * time.Duration(config.GetConfig().GetInvitationExpiry())),
+	}
+	err = dbConn.GetDB().Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&Invitation{}).
+			Where("lower(email) = ? AND accepted_at IS NULL AND expires_at > ?", strings.ToLower(email), now).
+			Update("expires_at", now)
+		if result.Error != nil {
+			return result.Error
+		}
+		return tx.Create(&invitation).Error
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	return &invitation, token, nil
+}
+
+// FindPendingInvitation returns the invitation of the token if it can still be accepted.
+func FindPendingInvitation(token string) (*Invitation, error) {
+	dbConn := db.GetDBConn()
+	if err := dbConn.AutoMigrate(&Invitation{}); err != nil {
+		return nil, err
+	}
+	var invitation Invitation
+	result := dbConn.GetDB().Where("token_hash = ?", randomtoken.Hash(token)).Limit(1).Find(&invitation)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 || !invitation.isPending() {
+		return nil, ErrInvitationNotFound
+	}
+	return &invitation, nil
+}
+
+// NewUser returns the user the invitation creates. The email counts as
+// verified since the token has been delivered to it.
+func (invitation *Invitation) NewUser() *UserData {
+	user := CreateUser(invitation.Email)
+	user.Role = invitation.Role
+	now := time.Now()
+	user.EmailVerifiedAt = &now
+	return user
+}
+
+// Accept creates the user of the invitation, whose password has been set,
+// and marks the invitation as used. An invitation can be accepted only once.
+func (invitation *Invitation) Accept(user *UserData) error {
+	if err := migrateUsers(); err != nil {
+		return err
+	}
+	dbConn := db.GetDBConn()
+	return dbConn.GetDB().Transaction(func(tx *gorm.DB) error {
+		inUse, err := emailInUse(tx, user.Email)
+		if err != nil {
+			return err
+		}
+		if inUse {
+			return ErrEmailTaken
+		}
+		if err := tx.Create(user).Error; err != nil {
+			return emailTakenError(err)
+		}
+		now := time.Now()
+		result := tx.Model(&Invitation{}).
+			Where("id = ? AND accepted_at IS NULL AND expires_at > ?", invitation.Id, now).
+			Updates(map[string]interface{}{"accepted_at": now, "user_id": user.Id})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrInvitationNotFound
+		}
+		invitation.AcceptedAt = &now
+		invitation.UserId = &user.Id
+		return nil
+	})
+}
@@ -0,0 +1,162 @@
+package usermodel
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/go-auth-microservice/pkg/config"
+	"github.com/go-auth-microservice/pkg/utils/db"
+	passwordhash "github.com/go-auth-microservice/pkg/utils/passwordHash"
+	"gorm.io/gorm"
+)
+
+var ErrStatusChanged = errors.New("account status has been changed in the meantime")
+
+// dummyHash is compared against when a login is attempted for an unknown
+// email so that the response time does not reveal whether the account exists.
+var dummyHash string
+var dummyHashOnce sync.Once
+
+// CompareDummyPassword burns the same amount of time as ValidatePassword.
+func CompareDummyPassword(ctx context.Context, plainPassword string) {
+	_ = getHashPool().run(ctx, func() error {
+		dummyHashOnce.Do(func() {
+			dummyHash, _ = passwordhash.Hash("dummy-password")
+		})
+		return passwordhash.Verify(dummyHash, plainPassword)
+	})
+}
+
+// IsLocked reports whether the account is currently locked. An expired lock
+// is treated as unlocked, the counters are reset on the next login.
+func (user *UserData) IsLocked() bool {
+	return user.LockedUntil != nil && time.Now().Before(*user.LockedUntil)
+}
+
+// RegisterFailedLogin counts a failed login attempt and locks the account once
+// the configured number of failures has been reached inside the failure window.
+// Each consecutive lockout doubles the lock duration up to the configured maximum.
+// The attempt is counted in SQL, which also locks the row for the rest of the
+// transaction, so that parallel failed logins can not overwrite each other.
+func (user *UserData) RegisterFailedLogin() error {
+	appConfig := config.GetConfig()
+	now := time.Now()
+	windowStart := now.Add(-time.Minute * time.Duration(appConfig.GetLoginFailureWindow()))
+	dbConn := db.GetDBConn()
+	var stateErr error
+	err := dbConn.GetDB().Transaction(func(tx *gorm.DB) error {
+		expired := "first_failed_login IS NULL OR first_failed_login < ?"
+		err := tx.Model(&UserData{}).Where("id = ?", user.Id).UpdateColumns(map[string]interface{}{
+			"failed_login_count": gorm.Expr("CASE WHEN "+expired+" THEN 1 ELSE failed_login_count + 1 END", windowStart),
+			"first_failed_login": gorm.Expr("CASE WHEN "+expired+" THEN ? ELSE first_failed_login END", windowStart, now),
+		}).Error
+		if err != nil {
+			return err
+		}
+		if err := tx.First(user, user.Id).Error; err != nil {
+			return err
+		}
+		if user.FailedLoginCount < appConfig.GetLoginMaxFailures() {
+			return nil
+		}
+		previousStatus := user.Status
+		lockout := time.Minute * time.Duration(appConfig.GetLoginLockoutDuration())
+		maxLockout := time.Minute * time.Duration(appConfig.GetLoginLockoutMax())
+		for i := 0; i < user.LockoutCount && lockout < maxLockout; i++ {
+			lockout *= 2
+		}
+		if lockout > maxLockout {
+			lockout = maxLockout
+		}
+		lockedUntil := now.Add(lockout)
+		user.LockedUntil = &lockedUntil
+		user.LockoutCount++
+		// only active accounts change status, a suspension is kept
+		if canTransition(user.GetAccountState().Status, StatusLocked) {
+			if err := user.ChangeStatus(StatusLocked, "too many failed logins", &lockedUntil); err != nil {
+				return err
+			}
+		}
+		user.FailedLoginCount = 0
+		user.FirstFailedLogin = nil
+		// the lock is kept even if the status change is dropped
+		stateErr = user.saveLoginState(tx, previousStatus)
+		if errors.Is(stateErr, ErrStatusChanged) {
+			return nil
+		}
+		return stateErr
+	})
+	if err != nil {
+		return err
+	}
+	forgetAccountState(user.Id)
+	return stateErr
+}
+
+// ResetFailedLogins clears the failure counters after a successful login.
+func (user *UserData) ResetFailedLogins() error {
+	if user.FailedLoginCount == 0 && user.LockoutCount == 0 && user.LockedUntil == nil && user.Status != StatusLocked {
+		return nil
+	}
+	previousStatus := user.Status
+	user.clearLoginState()
+	err := user.saveLoginState(db.GetDBConn().GetDB(), previousStatus)
+	forgetAccountState(user.Id)
+	return err
+}
+
+// Unlock lifts a lockout before its cooldown has expired.
+func (user *UserData) Unlock() error {
+	previousStatus := user.Status
+	user.clearLoginState()
+	err := user.saveLoginState(db.GetDBConn().GetDB(), previousStatus)
+	forgetAccountState(user.Id)
+	return err
+}
+
+func (user *UserData) clearLoginState() {
+	user.FailedLoginCount = 0
+	user.FirstFailedLogin = nil
+	user.LockoutCount = 0
+	user.LockedUntil = nil
+	if user.Status == StatusLocked {
+		user.Status = StatusActive
+		user.StatusReason = ""
+		user.StatusUntil = nil
+		now := time.Now()
+		user.StatusChangedAt = &now
+	}
+}
+
+// saveLoginState persists only the lockout and status columns. It deliberately
+// does not touch TokensValidAfter, otherwise failed attempts by a third party
+// would invalidate the refresh tokens of the legitimate user. A status change
+// is only written if the status has not been changed in the meantime,
+// ErrStatusChanged reports that it has been dropped. The counters are saved
+// either way.
+func (user *UserData) saveLoginState(tx *gorm.DB, previousStatus AccountStatus) error {
+	err := tx.Model(user).UpdateColumns(map[string]interface{}{
+		"failed_login_count": user.FailedLoginCount,
+		"first_failed_login": user.FirstFailedLogin,
+		"lockout_count":      user.LockoutCount,
+		"locked_until":       user.LockedUntil,
+	}).Error
+	if err != nil || user.Status == previousStatus {
+		return err
+	}
+	result := tx.Model(user).Where("status = ?", previousStatus).UpdateColumns(map[string]interface{}{
+		"status":            user.Status,
+		"status_reason":     user.StatusReason,
+		"status_until":      user.StatusUntil,
+		"status_changed_at": user.StatusChangedAt,
+	})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrStatusChanged
+	}
+	return nil
+}
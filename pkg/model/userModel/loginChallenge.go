@@ -0,0 +1,118 @@
+package usermodel
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/go-auth-microservice/pkg/config"
+	"github.com/go-auth-microservice/pkg/utils/db"
+	randomtoken "github.com/go-auth-microservice/pkg/utils/randomToken"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrLoginChallengeNotFound = errors.New("login challenge not found or expired")
+	ErrLoginChallengeCode     = errors.New("invalid login challenge code")
+)
+
+// loginChallengeMaxAttempts limits the guesses of a code before the
+// challenge is given up.
+const loginChallengeMaxAttempts = 5
+
+// LoginChallenge is a risky login with the right password which has to be
+// confirmed with a code sent to the email of the user. Only the hashes of the
+// token and the code are stored.
+type LoginChallenge struct {
+	Id          uint64     `gorm:"primaryKey,autoIncrement" json:"-"`
+	UserId      uint64     `gorm:"not null;index" json:"-"`
+	TokenHash   string     `gorm:"not null;uniqueIndex" json:"-"`
+	CodeHash    string     `gorm:"not null" json:"-"`
+	Method      string     `gorm:"not null;default:password" json:"method"`
+	Cookies     bool       `gorm:"not null;default:false" json:"-"`
+	Attempts    int        `gorm:"not null;default:0" json:"attempts"`
+	CreatedAt   time.Time  `gorm:"not null" json:"createdAt"`
+	ExpiresAt   time.Time  `gorm:"not null" json:"expiresAt"`
+	CompletedAt *time.Time `json:"completedAt,omitempty"`
+}
+
+func generateLoginCode() (string, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(1000000))
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%06d", n.Int64()), nil
+}
+
+// CreateLoginChallenge records a challenged login with the login method and
+// returns the token the client completes it with and the code for the email.
+// Cookies tells if the login asked for a cookie session.
+func (user *UserData) CreateLoginChallenge(method string, cookies bool) (string, string, error) {
+	dbConn := db.GetDBConn()
+	if err := dbConn.AutoMigrate(&LoginChallenge{}); err != nil {
+		return "", "", err
+	}
+	token, err := randomtoken.Generate()
+	if err != nil {
+		return "", "", err
+	}
+	code, err := generateLoginCode()
+	if err != nil {
+		return "", "", err
+	}
+	now := time.Now()
+	challenge := LoginChallenge{
+		UserId:    user.Id,
+		TokenHash: randomtoken.Hash(token),
+		CodeHash:  randomtoken.Hash(code),
+		Method:    method,
+		Cookies:   cookies,
+		CreatedAt: now,
+		ExpiresAt: now.Add(time.Minute * time.Duration(config.GetConfig().GetLoginChallengeExpiry())),
+	}
+	if err := dbConn.GetDB().Create(&challenge).Error; err != nil {
+		return "", "", err
+	}
+	return token, code, nil
+}
+
+// CompleteLoginChallenge checks the code of a pending challenge. A challenge
+// can only be completed once and is given up after too many wrong codes.
+func CompleteLoginChallenge(token string, code string) (*LoginChallenge, error) {
+	dbConn := db.GetDBConn()
+	if err := dbConn.AutoMigrate(&LoginChallenge{}); err != nil {
+		return nil, err
+	}
+	var challenge LoginChallenge
+	result := dbConn.GetDB().Where("token_hash = ?", randomtoken.Hash(token)).Limit(1).Find(&challenge)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 || challenge.CompletedAt != nil || !time.Now().Before(challenge.ExpiresAt) ||
+		challenge.Attempts >= loginChallengeMaxAttempts {
+		return nil, ErrLoginChallengeNotFound
+	}
+	if subtle.ConstantTimeCompare([]byte(randomtoken.Hash(code)), []byte(challenge.CodeHash)) != 1 {
+		err := dbConn.GetDB().Model(&LoginChallenge{}).Where("id = ?", challenge.Id).
+			UpdateColumn("attempts", gorm.Expr("attempts + 1")).Error
+		if err != nil {
+			return nil, err
+		}
+		return nil, ErrLoginChallengeCode
+	}
+	now := time.Now()
+	result = dbConn.GetDB().Model(&LoginChallenge{}).
+		Where("id = ? AND completed_at IS NULL AND attempts < ?", challenge.Id, loginChallengeMaxAttempts).
+		Update("completed_at", now)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, ErrLoginChallengeNotFound
+	}
+	challenge.CompletedAt = &now
+	return &challenge, nil
+}
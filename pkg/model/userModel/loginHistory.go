@@ -0,0 +1,187 @@
+package usermodel
+
+import (
+	"strings"
+	"time"
+
+	"github.com/go-auth-microservice/pkg/utils/db"
+)
+
+// Login methods. Social and SAML logins are recorded with the name of the
+// identity provider or tenant, see FederatedLoginMethod and SAMLProvider.
+const (
+	LoginMethodPassword  = "password"
+	LoginMethodFederated = "federated"
+	LoginMethodSAML      = "saml"
+)
+
+// FederatedLoginMethod returns the login method of a social login with the
+// identity provider, e.g. federated:google.
+func FederatedLoginMethod(provider string) string {
+	return LoginMethodFederated + ":" + provider
+}
+
+// SAMLProvider returns the provider of the identities, login state and login
+// method of SAML logins through the tenant, e.g. saml:acme.
+func SAMLProvider(tenant string) string {
+	return LoginMethodSAML + ":" + tenant
+}
+
+// Login outcomes. A denied login had the right credentials but the account
+// status does not allow it, e.g. a suspended account. Challenged and blocked
+// logins had the right credentials but were too risky.
+const (
+	LoginSucceeded  = "succeeded"
+	LoginFailed     = "failed"
+	LoginDenied     = "denied"
+	LoginChallenged = "challenged"
+	LoginBlocked    = "blocked"
+)
+
+// maxUserAgentLength bounds the user agents stored with login events, which
+// are part of the device index.
+const maxUserAgentLength = 512
+
+// truncateUserAgent cuts the user agent to the length stored with login events.
+func truncateUserAgent(userAgent string) string {
+	if len(userAgent) <= maxUserAgentLength {
+		return userAgent
+	}
+	return strings.ToValidUTF8(userAgent[:maxUserAgentLength], "")
+}
+
+// LoginEvent is a login attempt of a user.
+type LoginEvent struct {
+	Id        uint64    `gorm:"primaryKey,autoIncrement" json:"id"`
+	UserId    uint64    `gorm:"not null;index:idx_login_events_device" json:"-"`
+	IP        string    `gorm:"index:idx_login_events_device" json:"ip"`
+	UserAgent string    `gorm:"index:idx_login_events_device" json:"userAgent"`
+	Method    string    `gorm:"not null" json:"method"`
+	Outcome   string    `gorm:"not null" json:"outcome"`
+	CreatedAt time.Time `gorm:"not null;index" json:"createdAt"`
+}
+
+// LoginHistory is a page of the login events of a user, newest first.
+type LoginHistory struct {
+	Events  []LoginEvent `json:"events"`
+	Page    int          `json:"page"`
+	PerPage int          `json:"perPage"`
+	Total   int64        `json:"total"`
+}
+
+// LoginSignals are what the login history tells about a new login.
+type LoginSignals struct {
+	NewDevice      bool
+	RecentFailures int64
+	LastLogin      *LoginEvent
+}
+
+// isNewDevice tells if the user has never logged in successfully from the
+// device and IP. The first login of a user does not count as a new device.
+func (user *UserData) isNewDevice(ip string, userAgent string) (bool, error) {
+	dbConn := db.GetDBConn()
+	var known, succeeded int64
+	if err := dbConn.GetDB().Model(&LoginEvent{}).
+		Where("user_id = ? AND outcome = ? AND ip = ? AND user_agent = ?", user.Id, LoginSucceeded, ip, userAgent).
+		Count(&known).Error; err != nil {
+		return false, err
+	}
+	if known > 0 {
+		return false, nil
+	}
+	if err := dbConn.GetDB().Model(&LoginEvent{}).
+		Where("user_id = ? AND outcome = ?", user.Id, LoginSucceeded).
+		Count(&succeeded).Error; err != nil {
+		return false, err
+	}
+	return succeeded > 0, nil
+}
+
+// GetLoginSignals looks up whether a login from the device and IP is from a
+// new device, how many logins failed since the given time and the last
+// successful login.
+func (user *UserData) GetLoginSignals(ip string, userAgent string, failedSince time.Time) (*LoginSignals, error) {
+	userAgent = truncateUserAgent(userAgent)
+	dbConn := db.GetDBConn()
+	if err := dbConn.AutoMigrate(&LoginEvent{}); err != nil {
+		return nil, err
+	}
+	newDevice, err := user.isNewDevice(ip, userAgent)
+	if err != nil {
+		return nil, err
+	}
+	signals := &LoginSignals{NewDevice: newDevice}
+	if err := dbConn.GetDB().Model(&LoginEvent{}).
+		Where("user_id = ? AND outcome = ? AND created_at >= ?", user.Id, LoginFailed, failedSince).
+		Count(&signals.RecentFailures).Error; err != nil {
+		return nil, err
+	}
+	var last LoginEvent
+	result := dbConn.GetDB().Where("user_id = ? AND outcome = ?", user.Id, LoginSucceeded).Order("id desc").Limit(1).Find(&last)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected > 0 {
+		signals.LastLogin = &last
+	}
+	return signals, nil
+}
+
+// RecordLogin stores a login attempt of the user. For a successful login it
+// tells if it came from a device and IP the user has never logged in from
+// before. The first login of a user does not count as a new device.
+func (user *UserData) RecordLogin(ip string, userAgent string, method string, outcome string) (bool, error) {
+	userAgent = truncateUserAgent(userAgent)
+	dbConn := db.GetDBConn()
+	if err := dbConn.AutoMigrate(&LoginEvent{}); err != nil {
+		return false, err
+	}
+	newDevice := false
+	if outcome == LoginSucceeded {
+		var err error
+		if newDevice, err = user.isNewDevice(ip, userAgent); err != nil {
+			return false, err
+		}
+	}
+	event := LoginEvent{
+		UserId:    user.Id,
+		IP:        ip,
+		UserAgent: userAgent,
+		Method:    method,
+		Outcome:   outcome,
+		CreatedAt: time.Now(),
+	}
+	if err := dbConn.GetDB().Create(&event).Error; err != nil {
+		return false, err
+	}
+	return newDevice, nil
+}
+
+// FindLoginHistory returns a page of the login events of the user, newest
+// first. Pages start at 1.
+func (user *UserData) FindLoginHistory(page int, perPage int) (*LoginHistory, error) {
+	dbConn := db.GetDBConn()
+	if err := dbConn.AutoMigrate(&LoginEvent{}); err != nil {
+		return nil, err
+	}
+	history := &LoginHistory{Events: []LoginEvent{}, Page: page, PerPage: perPage}
+	if err := dbConn.GetDB().Model(&LoginEvent{}).Where("user_id = ?", user.Id).Count(&history.Total).Error; err != nil {
+		return nil, err
+	}
+	err := dbConn.GetDB().Where("user_id = ?", user.Id).Order("id desc").
+		Offset((page - 1) * perPage).Limit(perPage).Find(&history.Events).Error
+	if err != nil {
+		return nil, err
+	}
+	return history, nil
+}
+
+// PurgeLoginEvents deletes the login events recorded before the given time.
+func PurgeLoginEvents(before time.Time) (int64, error) {
+	dbConn := db.GetDBConn()
+	if err := dbConn.AutoMigrate(&LoginEvent{}); err != nil {
+		return 0, err
+	}
+	result := dbConn.GetDB().Where("created_at < ?", before).Delete(&LoginEvent{})
+	return result.RowsAffected, result.Error
+}
@@ -0,0 +1,89 @@
+package usermodel
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/go-auth-microservice/pkg/utils/db"
+	passwordhash "github.com/go-auth-microservice/pkg/utils/passwordHash"
+	passwordpolicy "github.com/go-auth-microservice/pkg/utils/passwordPolicy"
+)
+
+// PasswordHistory keeps the hashes of the previous passwords of a user so
+// that they can not be reused.
+type PasswordHistory struct {
+	Id        uint64    `gorm:"primaryKey,autoIncrement"`
+	UserId    uint64    `gorm:"not null;index"`
+	Password  string    `gorm:"not null"`
+	CreatedAt time.Time `gorm:"not null"`
+}
+
+// CheckPasswordPolicy returns every rule of the password policy the new
+// password violates, including the reuse of one of the recent passwords.
+func (user *UserData) CheckPasswordPolicy(ctx context.Context, plainPassword string) ([]passwordpolicy.Violation, error) {
+	policy := passwordpolicy.GetPolicy()
+	violations, err := policy.Check(plainPassword, user.Email)
+	if err != nil {
+		return nil, err
+	}
+	if policy.HistorySize > 0 && user.Id != 0 {
+		reused, err := user.isPasswordReused(ctx, plainPassword, policy.HistorySize)
+		if err != nil {
+			return nil, err
+		}
+		if reused {
+			violations = append(violations, policy.RecentlyUsed())
+		}
+	}
+	return violations, nil
+}
+
+func (user *UserData) isPasswordReused(ctx context.Context, plainPassword string, historySize int) (bool, error) {
+	dbConn := db.GetDBConn()
+	if err := dbConn.AutoMigrate(&PasswordHistory{}); err != nil {
+		return false, err
+	}
+	var history []PasswordHistory
+	result := dbConn.GetDB().Where("user_id = ?", user.Id).Order("id desc").Limit(historySize).Find(&history)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	hashes := []string{user.Password}
+	for _, entry := range history {
+		if entry.Password != user.Password {
+			hashes = append(hashes, entry.Password)
+		}
+	}
+	for _, hash := range hashes {
+		err := getHashPool().run(ctx, func() error {
+			return passwordhash.Verify(hash, plainPassword)
+		})
+		if err == nil {
+			return true, nil
+		}
+		if errors.Is(err, ErrHashingUnavailable) {
+			return false, err
+		}
+	}
+	return false, nil
+}
+
+// SavePasswordHistory records the current password hash and drops the ones
+// which are no longer part of the configured history.
+func (user *UserData) SavePasswordHistory() error {
+	historySize := passwordpolicy.GetPolicy().HistorySize
+	if historySize <= 0 {
+		return nil
+	}
+	dbConn := db.GetDBConn()
+	if err := dbConn.AutoMigrate(&PasswordHistory{}); err != nil {
+		return err
+	}
+	entry := PasswordHistory{UserId: user.Id, Password: user.Password, CreatedAt: time.Now()}
+	if err := dbConn.GetDB().Create(&entry).Error; err != nil {
+		return err
+	}
+	recent := dbConn.GetDB().Model(&PasswordHistory{}).Select("id").Where("user_id = ?", user.Id).Order("id desc").Limit(historySize)
+	return dbConn.GetDB().Where("user_id = ? AND id NOT IN (?)", user.Id, recent).Delete(&PasswordHistory{}).Error
+}
@@ -0,0 +1,128 @@
+package usermodel
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/go-auth-microservice/pkg/utils/db"
+	"github.com/go-auth-microservice/pkg/utils/validation"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const maxProfileMetadataSize = 16 * 1024
+
+var (
+	ErrInvalidProfile  = errors.New("invalid profile")
+	ErrProfileModified = errors.New("profile has been modified")
+)
+
+// UserProfile holds the attributes a user can change about themselves.
+type UserProfile struct {
+	DisplayName string                 `gorm:"size:100" json:"displayName" validate:"omitempty,max=100"`
+	GivenName   string                 `gorm:"size:100" json:"givenName" validate:"omitempty,max=100"`
+	FamilyName  string                 `gorm:"size:100" json:"familyName" validate:"omitempty,max=100"`
+	Locale      string                 `gorm:"size:35" json:"locale" validate:"omitempty,bcp47_language_tag"`
+	Timezone    string                 `gorm:"size:64" json:"timezone" validate:"omitempty,timezone"`
+	AvatarURL   string                 `gorm:"size:2048" json:"avatarUrl" validate:"omitempty,http_url,max=2048"`
+	Metadata    map[string]interface{} `gorm:"serializer:json" json:"metadata"`
+}
+
+// GetETag returns the entity tag of the user, which changes with every update.
+func (user *UserData) GetETag() string {
+	return `"` + strconv.FormatInt(user.UpdatedAt.UnixMicro(), 10) + `"`
+}
+
+// ApplyProfilePatch applies a JSON merge patch (RFC 7396) to the profile.
+// Members set to null are removed, unknown members are rejected.
+func (user *UserData) ApplyProfilePatch(patch []byte) error {
+	var patchValue interface{}
+	if err := json.Unmarshal(patch, &patchValue); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidProfile, err)
+	}
+	if _, ok := patchValue.(map[string]interface{}); !ok {
+		return fmt.Errorf("%w: patch must be a JSON object", ErrInvalidProfile)
+	}
+	current, err := json.Marshal(user.UserProfile)
+	if err != nil {
+		return err
+	}
+	var currentValue interface{}
+	if err := json.Unmarshal(current, &currentValue); err != nil {
+		return err
+	}
+	merged, err := json.Marshal(mergePatch(currentValue, patchValue))
+	if err != nil {
+		return err
+	}
+	var profile UserProfile
+	decoder := json.NewDecoder(bytes.NewReader(merged))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&profile); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidProfile, err)
+	}
+	if err := validation.Validator.Struct(profile); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidProfile, err)
+	}
+	metadata, err := json.Marshal(profile.Metadata)
+	if err != nil {
+		return err
+	}
+	if len(metadata) > maxProfileMetadataSize {
+		return fmt.Errorf("%w: metadata exceeds %d bytes", ErrInvalidProfile, maxProfileMetadataSize)
+	}
+	user.UserProfile = profile
+	return nil
+}
+
+func mergePatch(target interface{}, patch interface{}) interface{} {
+	patchObject, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+	targetObject, ok := target.(map[string]interface{})
+	if !ok {
+		targetObject = map[string]interface{}{}
+	}
+	for key, value := range patchObject {
+		if value == nil {
+			delete(targetObject, key)
+			continue
+		}
+		targetObject[key] = mergePatch(targetObject[key], value)
+	}
+	return targetObject
+}
+
+// SaveProfile stores the profile if the user has not been modified since the
+// given entity tag was issued. An empty tag skips the check.
+func (user *UserData) SaveProfile(ifMatch string) error {
+	dbConn := db.GetDBConn()
+	if err := dbConn.AutoMigrate(&UserData{}); err != nil {
+		return err
+	}
+	return dbConn.GetDB().Transaction(func(tx *gorm.DB) error {
+		var stored UserData
+		query := tx
+		if tx.Dialector.Name() == "postgres" {
+			query = tx.Clauses(clause.Locking{Strength: "UPDATE"})
+		}
+		if err := query.Where("id = ?", user.Id).First(&stored).Error; err != nil {
+			return err
+		}
+		if ifMatch != "" && ifMatch != "*" && ifMatch != stored.GetETag() {
+			return ErrProfileModified
+		}
+		user.UpdatedAt = time.Now()
+		err := tx.Model(user).Select("display_name", "given_name", "family_name", "locale", "timezone", "avatar_url", "metadata", "updated_at").Updates(user).Error
+		if err != nil {
+			return err
+		}
+		// reload so that the entity tag matches the precision kept by the database
+		return tx.Where("id = ?", user.Id).First(user).Error
+	})
+}
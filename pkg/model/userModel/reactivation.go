@@ -0,0 +1,116 @@
+package usermodel
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/go-auth-microservice/pkg/config"
+	"github.com/go-auth-microservice/pkg/utils/db"
+	randomtoken "github.com/go-auth-microservice/pkg/utils/randomToken"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrNotSelfDeactivated   = errors.New("user has not been deactivated by the user")
+	ErrReactivationNotFound = errors.New("reactivation not found or expired")
+)
+
+// ReactivationRequest is a pending reactivation of a self-deactivated user
+// waiting for the confirmation from the email of the user. Only the hash of
+// the token is stored.
+type ReactivationRequest struct {
+	Id          uint64     `gorm:"primaryKey,autoIncrement" json:"-"`
+	UserId      uint64     `gorm:"not null;index" json:"-"`
+	TokenHash   string     `gorm:"not null;uniqueIndex" json:"-"`
+	CreatedAt   time.Time  `gorm:"not null" json:"createdAt"`
+	ExpiresAt   time.Time  `gorm:"not null" json:"expiresAt"`
+	ConfirmedAt *time.Time `json:"confirmedAt,omitempty"`
+}
+
+// CanReactivate tells if the user has deactivated or deleted the account and
+// can undo it. Suspended users and accounts deleted by someone else, e.g. a
+// SCIM tenant, can not be reactivated by the user.
+func (user *UserData) CanReactivate() bool {
+	status := user.GetAccountState().Status
+	return status == StatusDeactivated ||
+		(status == StatusDeleted && user.DeletionScheduledAt != nil && user.StatusReason == DeletionRequestedByUser)
+}
+
+// Reactivate activates a deactivated account again and cancels a scheduled
+// deletion.
+func (user *UserData) Reactivate() error {
+	if !user.CanReactivate() {
+		return ErrNotSelfDeactivated
+	}
+	if err := user.ChangeStatus(StatusActive, "", nil); err != nil {
+		return err
+	}
+	user.DeletionScheduledAt = nil
+	return user.Save()
+}
+
+// RequestReactivation records a pending reactivation and returns the token
+// for the confirmation link. Earlier pending requests of the user expire.
+func (user *UserData) RequestReactivation() (string, error) {
+	if !user.CanReactivate() {
+		return "", ErrNotSelfDeactivated
+	}
+	dbConn := db.GetDBConn()
+	if err := dbConn.AutoMigrate(&ReactivationRequest{}); err != nil {
+		return "", err
+	}
+	token, err := randomtoken.Generate()
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	request := ReactivationRequest{
+		UserId:    user.Id,
+		TokenHash: randomtoken.Hash(token),
+		CreatedAt: now,
+		ExpiresAt: now.Add(time.Minute * time.Duration(config.GetConfig().GetReactivationExpiry())),
+	}
+	err = dbConn.GetDB().Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&ReactivationRequest{}).
+			Where("user_id = ? AND confirmed_at IS NULL AND expires_at > ?", user.Id, now).
+			Update("expires_at", now)
+		if result.Error != nil {
+			return result.Error
+		}
+		return tx.Create(&request).Error
+	})
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// ConfirmReactivation reactivates the user of a pending reactivation request.
+func ConfirmReactivation(token string) (*UserData, error) {
+	dbConn := db.GetDBConn()
+	if err := dbConn.AutoMigrate(&ReactivationRequest{}); err != nil {
+		return nil, err
+	}
+	var request ReactivationRequest
+	result := dbConn.GetDB().Where("token_hash = ?", randomtoken.Hash(token)).Limit(1).Find(&request)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 || request.ConfirmedAt != nil || !time.Now().Before(request.ExpiresAt) {
+		return nil, ErrReactivationNotFound
+	}
+	user, err := FindUserByID(request.UserId)
+	if err != nil {
+		return nil, fmt.Errorf("unable to find user of reactivation %v %w", request.Id, err)
+	}
+	if err := user.Reactivate(); err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	request.ConfirmedAt = &now
+	if err := dbConn.GetDB().Save(&request).Error; err != nil {
+		return nil, err
+	}
+	return user, nil
+}
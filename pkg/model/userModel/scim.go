@@ -0,0 +1,464 @@
+package usermodel
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/go-auth-microservice/pkg/utils/db"
+	"github.com/go-auth-microservice/pkg/utils/scim"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrSCIMResourceNotFound = errors.New("scim resource not found")
+	ErrSCIMGroupExists      = errors.New("a scim group with the display name exists")
+	ErrSCIMUnknownMember    = errors.New("scim group member is not a user of the tenant")
+)
+
+// SCIMUser links a user to the SCIM tenant which has provisioned it. A user
+// belongs to one tenant, ExternalId is its id at the identity provider.
+type SCIMUser struct {
+	Id         uint64    `gorm:"primaryKey,autoIncrement" json:"-"`
+	UserId     uint64    `gorm:"not null;uniqueIndex" json:"-"`
+	Tenant     string    `gorm:"not null;index" json:"tenant"`
+	ExternalId string    `gorm:"not null;default:''" json:"externalId"`
+	CreatedAt  time.Time `gorm:"not null" json:"createdAt"`
+}
+
+// SCIMGroup is a group a SCIM tenant has provisioned. The roles of its
+// members follow the group roles of the tenant.
+type SCIMGroup struct {
+	Id          uint64            `gorm:"primaryKey,autoIncrement"`
+	Tenant      string            `gorm:"not null;uniqueIndex:idx_scim_groups_name"`
+	DisplayName string            `gorm:"not null;uniqueIndex:idx_scim_groups_name"`
+	ExternalId  string            `gorm:"not null;default:''"`
+	CreatedAt   time.Time         `gorm:"not null"`
+	UpdatedAt   time.Time         `gorm:"not null"`
+	Members     []SCIMGroupMember `gorm:"foreignKey:GroupId"`
+}
+
+type SCIMGroupMember struct {
+	GroupId uint64 `gorm:"primaryKey"`
+	UserId  uint64 `gorm:"primaryKey;index"`
+}
+
+// ProvisionedUser is a user as the SCIM tenant which has provisioned it knows
+// it. Groups are the groups of the tenant the user is a member of, without
+// their members.
+type ProvisionedUser struct {
+	*UserData
+	ExternalId string
+	Groups     []SCIMGroup
+}
+
+func migrateSCIM() error {
+	if err := migrateUsers(); err != nil {
+		return err
+	}
+	dbConn := db.GetDBConn()
+	return dbConn.AutoMigrate(&SCIMUser{}, &SCIMGroup{}, &SCIMGroupMember{})
+}
+
+// provisioned adds the links and groups of the tenant to the users.
+func provisioned(tx *gorm.DB, tenant string, users []UserData) ([]ProvisionedUser, error) {
+	ids := make([]uint64, 0, len(users))
+	for _, user := range users {
+		ids = append(ids, user.Id)
+	}
+	var links []SCIMUser
+	if err := tx.Where("tenant = ? AND user_id IN ?", tenant, ids).Find(&links).Error; err != nil {
+		return nil, err
+	}
+	externalIds := map[uint64]string{}
+	for _, link := range links {
+		externalIds[link.UserId] = link.ExternalId
+	}
+	var groups []SCIMGroup
+	if err := tx.Where("tenant = ?", tenant).Preload("Members", "user_id IN ?", ids).Order("id").Find(&groups).Error; err != nil {
+		return nil, err
+	}
+	groupsOf := map[uint64][]SCIMGroup{}
+	for _, group := range groups {
+		for _, member := range group.Members {
+			groupsOf[member.UserId] = append(groupsOf[member.UserId], SCIMGroup{Id: group.Id, Tenant: group.Tenant, DisplayName: group.DisplayName})
+		}
+	}
+	list := make([]ProvisionedUser, 0, len(users))
+	for i := range users {
+		list = append(list, ProvisionedUser{UserData: &users[i], ExternalId: externalIds[users[i].Id], Groups: groupsOf[users[i].Id]})
+	}
+	return list, nil
+}
+
+// tenantUsers selects the users the tenant has provisioned which have not
+// been deleted.
+func tenantUsers(tx *gorm.DB, tenant string) *gorm.DB {
+	return tx.Model(&UserData{}).
+		Joins("JOIN scim_users ON scim_users.user_id = user_data.id").
+		Where("scim_users.tenant = ? AND user_data.status <> ?", tenant, StatusDeleted)
+}
+
+// SCIMQuery selects a page of the resources of a tenant. Offset counts from
+// 0, a negative Limit returns all resources. Values which are not empty have
+// to equal the attribute of a resource, case insensitive like SCIM filters
+// compare strings.
+type SCIMQuery struct {
+	Offset     int
+	Limit      int
+	UserName   string
+	ExternalId string
+	// DisplayName narrows groups down
+	DisplayName string
+}
+
+// page applies the offset and limit of the query.
+func (query SCIMQuery) page(tx *gorm.DB) *gorm.DB {
+	if query.Limit < 0 {
+		return tx
+	}
+	return tx.Offset(query.Offset).Limit(query.Limit)
+}
+
+// FindProvisionedUsers returns a page of the users the tenant has provisioned
+// ordered by id together with the number of users on all pages. Deleted users
+// are left out.
+func FindProvisionedUsers(tenant string, query SCIMQuery) ([]ProvisionedUser, int64, error) {
+	if err := migrateSCIM(); err != nil {
+		return nil, 0, err
+	}
+	dbConn := db.GetDBConn()
+	selected := tenantUsers(dbConn.GetDB(), tenant)
+	if query.UserName != "" {
+		selected = selected.Where("lower(user_data.email) = ?", strings.ToLower(query.UserName))
+	}
+	if query.ExternalId != "" {
+		selected = selected.Where("lower(scim_users.external_id) = ?", strings.ToLower(query.ExternalId))
+	}
+	var total int64
+	if err := selected.Session(&gorm.Session{}).Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	users := []UserData{}
+	if query.Limit != 0 && total > int64(query.Offset) {
+		if err := query.page(selected.Session(&gorm.Session{})).Order("user_data.id").Find(&users).Error; err != nil {
+			return nil, 0, err
+		}
+	}
+	list, err := provisioned(dbConn.GetDB(), tenant, users)
+	if err != nil {
+		return nil, 0, err
+	}
+	return list, total, nil
+}
+
+// FindProvisionedUser returns a user the tenant has provisioned.
+func FindProvisionedUser(tenant string, id uint64) (*ProvisionedUser, error) {
+	if err := migrateSCIM(); err != nil {
+		return nil, err
+	}
+	dbConn := db.GetDBConn()
+	var users []UserData
+	if err := tenantUsers(dbConn.GetDB(), tenant).Where("user_data.id = ?", id).Limit(1).Find(&users).Error; err != nil {
+		return nil, err
+	}
+	if len(users) == 0 {
+		return nil, ErrSCIMResourceNotFound
+	}
+	list, err := provisioned(dbConn.GetDB(), tenant, users)
+	if err != nil {
+		return nil, err
+	}
+	return &list[0], nil
+}
+
+// ProvisionUser creates a user for the tenant. The identity provider vouches
+// for the email, the user has no password and logs in through the identity
+// provider. A user the tenant has deleted within the deletion grace period is
+// restored with the attributes of the tenant, while its password, role and
+// the data of other sources are kept. Other existing users with the email are
+// never taken over.
+func ProvisionUser(tenant string, user *UserData, externalId string) (*ProvisionedUser, error) {
+	if err := migrateSCIM(); err != nil {
+		return nil, err
+	}
+	dbConn := db.GetDBConn()
+	now := time.Now()
+	user.EmailVerifiedAt = &now
+	err := dbConn.GetDB().Transaction(func(tx *gorm.DB) error {
+		var existing UserData
+		result := tx.Where("lower(email) = ?", strings.ToLower(user.Email)).Limit(1).Find(&existing)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			if err := tx.Create(user).Error; err != nil {
+				return emailTakenError(err)
+			}
+			return tx.Create(&SCIMUser{UserId: user.Id, Tenant: tenant, ExternalId: externalId, CreatedAt: now}).Error
+		}
+		var link SCIMUser
+		result = tx.Where("user_id = ? AND tenant = ?", existing.Id, tenant).Limit(1).Find(&link)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 || existing.Status != StatusDeleted {
+			return ErrEmailTaken
+		}
+		user.Id = existing.Id
+		user.StatusChangedAt = &now
+		user.TokensValidAfter = now
+		user.DeletionScheduledAt = nil
+		err := tx.Model(user).Select("email", "email_verified_at", "display_name", "given_name", "family_name", "locale", "timezone",
+			"status", "status_reason", "status_until", "status_changed_at", "updated_at", "tokens_valid_after", "deletion_scheduled_at").Updates(user).Error
+		if err != nil {
+			return err
+		}
+		if err := tx.Where("id = ?", user.Id).First(user).Error; err != nil {
+			return err
+		}
+		return tx.Model(&link).Update("external_id", externalId).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	forgetAccountState(user.Id)
+	return &ProvisionedUser{UserData: user, ExternalId: externalId}, nil
+}
+
+// SaveProvisioned stores the changes of the tenant to the user. A changed
+// email or account status revokes the refresh tokens of the user, while
+// profile changes, which identity providers push on every sync, leave them
+// alone.
+func (p *ProvisionedUser) SaveProvisioned() error {
+	dbConn := db.GetDBConn()
+	err := dbConn.GetDB().Transaction(func(tx *gorm.DB) error {
+		var stored UserData
+		if err := tx.Where("id = ?", p.Id).First(&stored).Error; err != nil {
+			return err
+		}
+		now := time.Now()
+		p.UpdatedAt = now
+		if !strings.EqualFold(stored.Email, p.Email) {
+			var taken int64
+			if err := tx.Model(&UserData{}).Where("lower(email) = ? AND id <> ?", strings.ToLower(p.Email), p.Id).Count(&taken).Error; err != nil {
+				return err
+			}
+			if taken > 0 {
+				return ErrEmailTaken
+			}
+			p.EmailVerifiedAt = &now
+			p.TokensValidAfter = now
+		}
+		if stored.Status != p.Status {
+			p.TokensValidAfter = now
+		}
+		err := tx.Model(p.UserData).Select("email", "email_verified_at", "display_name", "given_name", "family_name", "locale", "timezone",
+			"status", "status_reason", "status_until", "status_changed_at", "updated_at", "tokens_valid_after").Updates(p.UserData).Error
+		if err != nil {
+			return err
+		}
+		return tx.Model(&SCIMUser{}).Where("user_id = ?", p.Id).Update("external_id", p.ExternalId).Error
+	})
+	if err != nil {
+		return err
+	}
+	forgetAccountState(p.Id)
+	return nil
+}
+
+// Deprovision schedules the deletion of the user for the reason and removes
+// it from the groups of the tenant. Unlike a deletion requested by the user it
+// can only be undone by the tenant provisioning the user again.
+func (p *ProvisionedUser) Deprovision(gracePeriod time.Duration, reason string) error {
+	if err := p.scheduleDeletion(gracePeriod, reason); err != nil {
+		return err
+	}
+	dbConn := db.GetDBConn()
+	p.Groups = nil
+	return dbConn.GetDB().Where("user_id = ?", p.Id).Delete(&SCIMGroupMember{}).Error
+}
+
+// FindSCIMGroups returns a page of the groups of the tenant with their
+// members ordered by id together with the number of groups on all pages.
+func FindSCIMGroups(tenant string, query SCIMQuery) ([]SCIMGroup, int64, error) {
+	if err := migrateSCIM(); err != nil {
+		return nil, 0, err
+	}
+	dbConn := db.GetDBConn()
+	selected := dbConn.GetDB().Model(&SCIMGroup{}).Where("tenant = ?", tenant)
+	if query.DisplayName != "" {
+		selected = selected.Where("lower(display_name) = ?", strings.ToLower(query.DisplayName))
+	}
+	if query.ExternalId != "" {
+		selected = selected.Where("lower(external_id) = ?", strings.ToLower(query.ExternalId))
+	}
+	var total int64
+	if err := selected.Session(&gorm.Session{}).Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	groups := []SCIMGroup{}
+	if query.Limit != 0 && total > int64(query.Offset) {
+		if err := query.page(selected.Session(&gorm.Session{})).Preload("Members").Order("id").Find(&groups).Error; err != nil {
+			return nil, 0, err
+		}
+	}
+	return groups, total, nil
+}
+
+// FindSCIMGroup returns a group of the tenant with its members.
+func FindSCIMGroup(tenant string, id uint64) (*SCIMGroup, error) {
+	if err := migrateSCIM(); err != nil {
+		return nil, err
+	}
+	dbConn := db.GetDBConn()
+	var groups []SCIMGroup
+	if err := dbConn.GetDB().Where("tenant = ? AND id = ?", tenant, id).Preload("Members").Limit(1).Find(&groups).Error; err != nil {
+		return nil, err
+	}
+	if len(groups) == 0 {
+		return nil, ErrSCIMResourceNotFound
+	}
+	return &groups[0], nil
+}
+
+// MemberIds returns the ids of the users in the group.
+func (group *SCIMGroup) MemberIds() []uint64 {
+	ids := make([]uint64, 0, len(group.Members))
+	for _, member := range group.Members {
+		ids = append(ids, member.UserId)
+	}
+	return ids
+}
+
+// Save creates the group or replaces its name and members. Members have to
+// be users of the tenant. It returns the ids of the users whose roles may have
+// changed, which are the users who joined or left, or all members if the
+// group has been renamed.
+func (group *SCIMGroup) Save() ([]uint64, error) {
+	if err := migrateSCIM(); err != nil {
+		return nil, err
+	}
+	members := map[uint64]bool{}
+	for _, id := range group.MemberIds() {
+		members[id] = true
+	}
+	group.Members = make([]SCIMGroupMember, 0, len(members))
+	for id := range members {
+		group.Members = append(group.Members, SCIMGroupMember{GroupId: group.Id, UserId: id})
+	}
+	affected := []uint64{}
+	dbConn := db.GetDBConn()
+	err := dbConn.GetDB().Transaction(func(tx *gorm.DB) error {
+		var known int64
+		if err := tenantUsers(tx, group.Tenant).Where("user_data.id IN ?", group.MemberIds()).Count(&known).Error; err != nil {
+			return err
+		}
+		if known != int64(len(members)) {
+			return ErrSCIMUnknownMember
+		}
+		var taken int64
+		if err := tx.Model(&SCIMGroup{}).Where("tenant = ? AND display_name = ? AND id <> ?", group.Tenant, group.DisplayName, group.Id).Count(&taken).Error; err != nil {
+			return err
+		}
+		if taken > 0 {
+			return ErrSCIMGroupExists
+		}
+		now := time.Now()
+		group.UpdatedAt = now
+		renamed := false
+		previous := map[uint64]bool{}
+		if group.Id == 0 {
+			group.CreatedAt = now
+			if err := tx.Omit("Members").Create(group).Error; err != nil {
+				return err
+			}
+		} else {
+			var stored SCIMGroup
+			if err := tx.Where("id = ?", group.Id).Preload("Members").First(&stored).Error; err != nil {
+				return err
+			}
+			renamed = stored.DisplayName != group.DisplayName
+			for _, id := range stored.MemberIds() {
+				previous[id] = true
+			}
+			err := tx.Model(group).Omit("Members").Select("display_name", "external_id", "updated_at").Updates(group).Error
+			if err != nil {
+				return err
+			}
+			if err := tx.Where("group_id = ?", group.Id).Delete(&SCIMGroupMember{}).Error; err != nil {
+				return err
+			}
+		}
+		for i := range group.Members {
+			group.Members[i].GroupId = group.Id
+		}
+		if len(group.Members) > 0 {
+			if err := tx.Create(&group.Members).Error; err != nil {
+				return err
+			}
+		}
+		for id := range previous {
+			if renamed || !members[id] {
+				affected = append(affected, id)
+			}
+		}
+		for id := range members {
+			if renamed || !previous[id] {
+				affected = append(affected, id)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return affected, nil
+}
+
+// Delete removes the group and returns the ids of its former members.
+func (group *SCIMGroup) Delete() ([]uint64, error) {
+	dbConn := db.GetDBConn()
+	members := group.MemberIds()
+	err := dbConn.GetDB().Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("group_id = ?", group.Id).Delete(&SCIMGroupMember{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&SCIMGroup{}, group.Id).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return members, nil
+}
+
+// SyncProvisionedRoles sets the roles of the users to the ones their groups
+// at the tenant map to. Tenants without group roles leave roles alone.
+func SyncProvisionedRoles(tenant *scim.Tenant, userIds []uint64) error {
+	if _, ok := tenant.RoleOf(nil, RoleUser); !ok {
+		return nil
+	}
+	dbConn := db.GetDBConn()
+	for _, userId := range userIds {
+		var groups []string
+		err := dbConn.GetDB().Model(&SCIMGroup{}).
+			Joins("JOIN scim_group_members ON scim_group_members.group_id = scim_groups.id").
+			Where("scim_groups.tenant = ? AND scim_group_members.user_id = ?", tenant.Name(), userId).
+			Pluck("scim_groups.display_name", &groups).Error
+		if err != nil {
+			return err
+		}
+		user, err := FindUserByID(userId)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		role, _ := tenant.RoleOf(groups, RoleUser)
+		if err := user.SyncRole(role); err != nil {
+			return err
+		}
+	}
+	return nil
+}
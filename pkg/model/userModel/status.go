@@ -0,0 +1,233 @@
+package usermodel
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/go-auth-microservice/pkg/config"
+	"github.com/go-auth-microservice/pkg/utils/db"
+	"gorm.io/gorm"
+)
+
+// AccountStatus is the state of the account of a user.
+type AccountStatus string
+
+const (
+	// StatusPending accounts have not been activated yet
+	StatusPending AccountStatus = "pending"
+	StatusActive  AccountStatus = "active"
+	// StatusDeactivated accounts have been deactivated by the user, who can reactivate them
+	StatusDeactivated AccountStatus = "deactivated"
+	// StatusSuspended accounts have been suspended by an admin, optionally until StatusUntil
+	StatusSuspended AccountStatus = "suspended"
+	// StatusLocked accounts are locked after too many failed logins until StatusUntil.
+	// The lock only prevents new logins, existing sessions are kept so that
+	// guessing passwords does not log the legitimate user out.
+	StatusLocked AccountStatus = "locked"
+	// StatusDeleted accounts are erased once DeletionScheduledAt has passed
+	StatusDeleted AccountStatus = "deleted"
+)
+
+var ErrInvalidStatusTransition = errors.New("invalid account status transition")
+
+// statusTransitions lists the statuses an account can change to from each status.
+var statusTransitions = map[AccountStatus][]AccountStatus{
+	StatusPending:     {StatusActive, StatusSuspended, StatusDeleted},
+	StatusActive:      {StatusDeactivated, StatusSuspended, StatusLocked, StatusDeleted},
+	StatusDeactivated: {StatusActive, StatusSuspended, StatusDeleted},
+	StatusSuspended:   {StatusActive, StatusDeleted},
+	StatusLocked:      {StatusActive, StatusSuspended, StatusDeleted},
+	StatusDeleted:     {StatusActive},
+}
+
+// AccountState is the status of an account together with why and until when
+// it has been set.
+type AccountState struct {
+	Status AccountStatus `json:"status"`
+	Reason string        `json:"reason,omitempty"`
+	Until  *time.Time    `json:"until,omitempty"`
+}
+
+// AllowsLogin tells if the user can log in.
+func (state AccountState) AllowsLogin() bool {
+	return state.Status == StatusActive
+}
+
+// AllowsSessions tells if the tokens issued to the user can be used.
+func (state AccountState) AllowsSessions() bool {
+	return state.Status == StatusActive || state.Status == StatusLocked
+}
+
+// GetAccountState returns the effective state of the account. Suspensions and
+// locks whose expiry has passed are reported as active. An account without a
+// status has not been migrated from the is_active flag and is reported as
+// suspended, it may have been disabled.
+func (user *UserData) GetAccountState() AccountState {
+	status := user.Status
+	if status == "" {
+		status = StatusSuspended
+	}
+	if (status == StatusSuspended || status == StatusLocked) && user.StatusUntil != nil && !time.Now().Before(*user.StatusUntil) {
+		return AccountState{Status: StatusActive}
+	}
+	return AccountState{Status: status, Reason: user.StatusReason, Until: user.StatusUntil}
+}
+
+func canTransition(from AccountStatus, to AccountStatus) bool {
+	for _, allowed := range statusTransitions[from] {
+		if allowed == to {
+			return true
+		}
+	}
+	return false
+}
+
+// ChangeStatus moves the account to another status if the transition is
+// allowed from the effective status. It is not saved, the cached state of the
+// account is forgotten once the change has been saved.
+func (user *UserData) ChangeStatus(to AccountStatus, reason string, until *time.Time) error {
+	if !canTransition(user.GetAccountState().Status, to) {
+		return ErrInvalidStatusTransition
+	}
+	user.Status = to
+	user.StatusReason = reason
+	user.StatusUntil = until
+	now := time.Now()
+	user.StatusChangedAt = &now
+	return nil
+}
+
+// Deactivate disables the account on request of the user, unlike a
+// suspension it can be undone by the user with Reactivate.
+func (user *UserData) Deactivate() error {
+	if user.GetAccountState().Status == StatusDeactivated {
+		return errors.New("user has already been deactivated")
+	}
+	return user.ChangeStatus(StatusDeactivated, "", nil)
+}
+
+// Suspend disables the account by an admin, until it expires or is lifted
+// with Unsuspend. A nil until suspends the account indefinitely.
+func (user *UserData) Suspend(reason string, until *time.Time) error {
+	return user.ChangeStatus(StatusSuspended, reason, until)
+}
+
+// Unsuspend lifts a suspension before it expires.
+func (user *UserData) Unsuspend() error {
+	if user.GetAccountState().Status != StatusSuspended {
+		return ErrInvalidStatusTransition
+	}
+	return user.ChangeStatus(StatusActive, "", nil)
+}
+
+// cachedAccountState is a state read by GetAccountStateByID. States changed
+// by this instance are forgotten as soon as the change is saved, others when
+// they expire.
+type cachedAccountState struct {
+	state     AccountState
+	expiresAt time.Time
+}
+
+// maxCachedAccountStates bounds the cache, it is pruned of expired states
+// once it is full and cleared if that does not free any space.
+const maxCachedAccountStates = 10000
+
+var accountStates = map[uint64]cachedAccountState{}
+var accountStatesMu sync.Mutex
+
+// cacheAccountState must be called with accountStatesMu held.
+func cacheAccountState(userId uint64, state cachedAccountState) {
+	if _, ok := accountStates[userId]; !ok && len(accountStates) >= maxCachedAccountStates {
+		now := time.Now()
+		for id, cached := range accountStates {
+			if !now.Before(cached.expiresAt) {
+				delete(accountStates, id)
+			}
+		}
+		if len(accountStates) >= maxCachedAccountStates {
+			clear(accountStates)
+		}
+	}
+	accountStates[userId] = state
+}
+
+func forgetAccountState(userId uint64) {
+	accountStatesMu.Lock()
+	defer accountStatesMu.Unlock()
+	delete(accountStates, userId)
+}
+
+// GetAccountStateByID returns the effective state of the account of the user,
+// cached for ACCOUNT_STATUS_CACHE_TTL seconds. Users which do not exist any
+// more are reported as deleted.
+func GetAccountStateByID(userId uint64) (AccountState, error) {
+	accountStatesMu.Lock()
+	cached, ok := accountStates[userId]
+	accountStatesMu.Unlock()
+	if ok && time.Now().Before(cached.expiresAt) {
+		return cached.state, nil
+	}
+	var state AccountState
+	user, err := FindUserByID(userId)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		state = AccountState{Status: StatusDeleted}
+	} else if err != nil {
+		return AccountState{}, err
+	} else {
+		state = user.GetAccountState()
+	}
+	ttl := time.Second * time.Duration(config.GetConfig().GetAccountStatusCacheTTL())
+	if state.Until != nil && state.Until.Before(time.Now().Add(ttl)) {
+		ttl = time.Until(*state.Until)
+	}
+	accountStatesMu.Lock()
+	cacheAccountState(userId, cachedAccountState{state: state, expiresAt: time.Now().Add(ttl)})
+	accountStatesMu.Unlock()
+	return state, nil
+}
+
+// Migrate creates the user table and converts the accounts of databases
+// created before the account status existed. It is run at startup, before
+// any account is read.
+func Migrate() error {
+	return migrateUsers()
+}
+
+var migrateUsersOnce sync.Once
+var migrateUsersErr error
+
+// migrateUsers creates the user table and converts the is_active flag of
+// databases created before the account status existed.
+func migrateUsers() error {
+	migrateUsersOnce.Do(func() {
+		dbConn := db.GetDBConn()
+		if migrateUsersErr = dbConn.AutoMigrate(&UserData{}); migrateUsersErr != nil {
+			return
+		}
+		migrator := dbConn.GetDB().Migrator()
+		if !migrator.HasColumn(&UserData{}, "is_active") {
+			return
+		}
+		migrateUsersErr = dbConn.GetDB().Transaction(func(tx *gorm.DB) error {
+			if tx.Migrator().HasColumn(&UserData{}, "deactivated_by") {
+				if err := tx.Exec("UPDATE user_data SET status = ? WHERE is_active = ? AND deactivated_by = ?", StatusDeactivated, false, "self").Error; err != nil {
+					return err
+				}
+			}
+			if err := tx.Exec("UPDATE user_data SET status = ?, status_reason = ? WHERE is_active = ? AND deletion_scheduled_at IS NOT NULL", StatusDeleted, DeletionRequestedByUser, false).Error; err != nil {
+				return err
+			}
+			if err := tx.Exec("UPDATE user_data SET status = ? WHERE is_active = ? AND (status = ? OR status = ? OR status IS NULL)", StatusSuspended, false, StatusActive, "").Error; err != nil {
+				return err
+			}
+			if tx.Migrator().HasColumn(&UserData{}, "deactivated_by") {
+				if err := tx.Migrator().DropColumn(&UserData{}, "deactivated_by"); err != nil {
+					return err
+				}
+			}
+			return tx.Migrator().DropColumn(&UserData{}, "is_active")
+		})
+	})
+	return migrateUsersErr
+}
@@ -0,0 +1,41 @@
+package usermodel
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestAccountStateCacheBound tests that the account state cache does not grow
+// beyond its bound
+func TestAccountStateCacheBound(t *testing.T) {
+	accountStatesMu.Lock()
+	defer accountStatesMu.Unlock()
+	clear(accountStates)
+	defer clear(accountStates)
+
+	expired := cachedAccountState{state: AccountState{Status: StatusActive}, expiresAt: time.Now().Add(-time.Second)}
+	fresh := cachedAccountState{state: AccountState{Status: StatusActive}, expiresAt: time.Now().Add(time.Minute)}
+	for id := uint64(1); id <= maxCachedAccountStates; id++ {
+		if id%2 == 0 {
+			cacheAccountState(id, expired)
+		} else {
+			cacheAccountState(id, fresh)
+		}
+	}
+	assert.Len(t, accountStates, maxCachedAccountStates)
+
+	cacheAccountState(maxCachedAccountStates+1, fresh)
+	assert.Len(t, accountStates, maxCachedAccountStates/2+1, "Expired states should be pruned")
+	assert.Contains(t, accountStates, uint64(1))
+	assert.NotContains(t, accountStates, uint64(2))
+
+	for id := uint64(maxCachedAccountStates + 2); len(accountStates) < maxCachedAccountStates; id++ {
+		cacheAccountState(id, fresh)
+	}
+	cacheAccountState(1, fresh)
+	assert.Len(t, accountStates, maxCachedAccountStates, "Updating a cached state should not prune")
+	cacheAccountState(3*maxCachedAccountStates, fresh)
+	assert.Len(t, accountStates, 1, "A cache full of fresh states should be cleared")
+}
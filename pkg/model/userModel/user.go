@@ -1,11 +1,11 @@
 package usermodel
 
 import (
-	"fmt"
+	"context"
 	"time"
 
 	"github.com/go-auth-microservice/pkg/utils/db"
-	"golang.org/x/crypto/bcrypt"
+	passwordhash "github.com/go-auth-microservice/pkg/utils/passwordHash"
 )
 
 type UserData struct {
@@ -14,65 +14,130 @@ type UserData struct {
 	Password  string    `gorm:"not null" json:"-" validate:"required"`
 	CreatedAt time.Time `gorm:"not null" json:"createdAt" validate:"required"`
 	UpdatedAt time.Time `gorm:"not null" json:"updatedAt" validate:"required"`
-	IsActive  bool      `gorm:"not null" json:"isActive" validate:"required"`
+	Role      string    `gorm:"not null;default:user" json:"role"`
+	// AuthSource tells which credential verifier checks the password
+	AuthSource string `gorm:"not null;default:local" json:"authSource"`
+	// Status only changes through ChangeStatus, see statusTransitions
+	Status          AccountStatus `gorm:"not null;default:active;index" json:"status"`
+	StatusReason    string        `json:"statusReason,omitempty"`
+	StatusUntil     *time.Time    `json:"statusUntil,omitempty"`
+	StatusChangedAt *time.Time    `json:"statusChangedAt,omitempty"`
+	// TokensValidAfter is bumped by every change of the account, refresh
+	// tokens issued before it are rejected. Profile updates leave it alone.
+	TokensValidAfter time.Time `gorm:"not null;default:CURRENT_TIMESTAMP" json:"-"`
+
+	// EmailVerifiedAt is set once the user has proven to receive mails at the email
+	EmailVerifiedAt *time.Time `json:"emailVerifiedAt,omitempty"`
+
+	UserProfile `gorm:"embedded"`
+
+	FailedLoginCount int        `gorm:"not null;default:0" json:"-"`
+	FirstFailedLogin *time.Time `json:"-"`
+	LockoutCount     int        `gorm:"not null;default:0" json:"-"`
+	LockedUntil      *time.Time `json:"lockedUntil,omitempty"`
+
+	DeletionScheduledAt *time.Time `gorm:"index" json:"deletionScheduledAt,omitempty"`
 }
 
-func (user *UserData) SetPassword(plainPassword string) error {
-	bytes, err := bcrypt.GenerateFromPassword([]byte(plainPassword), bcrypt.DefaultCost)
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+)
+
+func (user *UserData) SetPassword(ctx context.Context, plainPassword string) error {
+	var hash string
+	err := getHashPool().run(ctx, func() error {
+		var err error
+		hash, err = passwordhash.Hash(plainPassword)
+		return err
+	})
 	if err != nil {
 		return err
 	}
-	user.Password = string(bytes)
+	user.Password = hash
 	return nil
 }
 
-func (user *UserData) ValidatePassword(plainPassword string) error {
-	err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(plainPassword))
-	return err
+func (user *UserData) ValidatePassword(ctx context.Context, plainPassword string) error {
+	return getHashPool().run(ctx, func() error {
+		return passwordhash.Verify(user.Password, plainPassword)
+	})
 }
 
-func (user *UserData) Save() error {
-	dbConn := db.GetDBConn()
-	if err := dbConn.AutoMigrate(&UserData{}); err != nil {
+// RehashPassword upgrades the stored hash to the configured algorithm and
+// parameters. It must only be called with a password which has just been
+// validated. TokensValidAfter is left untouched so that existing sessions stay valid.
+func (user *UserData) RehashPassword(ctx context.Context, plainPassword string) error {
+	if !passwordhash.NeedsRehash(user.Password) {
+		return nil
+	}
+	if err := user.SetPassword(ctx, plainPassword); err != nil {
 		return err
 	}
-	user.UpdatedAt = time.Now()
-	result := dbConn.GetDB().Save(user)
-	return result.Error
+	dbConn := db.GetDBConn()
+	return dbConn.GetDB().Model(user).UpdateColumn("password", user.Password).Error
 }
 
-func (user *UserData) Disable() error {
-	if !user.IsActive {
-		return fmt.Errorf("user has already been deactivated")
+func (user *UserData) Save() error {
+	if err := migrateUsers(); err != nil {
+		return err
+	}
+	dbConn := db.GetDBConn()
+	user.UpdatedAt = time.Now()
+	user.TokensValidAfter = user.UpdatedAt
+	if err := dbConn.GetDB().Save(user).Error; err != nil {
+		return err
 	}
-	user.IsActive = false
+	forgetAccountState(user.Id)
 	return nil
 }
-func (user *UserData) Enable() error {
-	if user.IsActive {
-		return fmt.Errorf("user is already active")
+
+// SyncRole sets the role an external source like a directory or a SAML
+// identity provider assigns the user. A changed role revokes the refresh
+// tokens issued with the previous role.
+func (user *UserData) SyncRole(role string) error {
+	if user.Role == role {
+		return nil
 	}
-	user.IsActive = true
-	return nil
+	now := time.Now()
+	user.Role = role
+	user.UpdatedAt = now
+	user.TokensValidAfter = now
+	dbConn := db.GetDBConn()
+	return dbConn.GetDB().Model(user).Updates(map[string]interface{}{
+		"role": role, "updated_at": now, "tokens_valid_after": now,
+	}).Error
 }
+
 func (user *UserData) GetUserID() uint64 {
 	return user.Id
 }
 
-func (user *UserData) GetUserStatus() bool {
-	return user.IsActive
-}
-
 func (user *UserData) GetUserLastUpdated() time.Time {
 	return user.UpdatedAt
 }
 
+func (user *UserData) GetTokensValidAfter() time.Time {
+	return user.TokensValidAfter
+}
+
+func (user *UserData) GetUserRole() string {
+	return user.Role
+}
+
+func (user *UserData) GetUserEmail() string {
+	return user.Email
+}
+
 func CreateUser(email string) *UserData {
 	return &UserData{
-		Email:     email,
-		CreatedAt: time.Now(),
-		UpdatedAt: time.Now(),
-		IsActive:  true,
+		Email:            email,
+		CreatedAt:        time.Now(),
+		UpdatedAt:        time.Now(),
+		TokensValidAfter: time.Now(),
+		Status:           StatusActive,
+		Role:             RoleUser,
+		AuthSource:       AuthSourceLocal,
 	}
 }
 func FindUserByID(id uint64) (*UserData, error) {
@@ -0,0 +1,108 @@
+package usermodel
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/go-auth-microservice/pkg/config"
+	"github.com/go-auth-microservice/pkg/utils/db"
+	randomtoken "github.com/go-auth-microservice/pkg/utils/randomToken"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrNotPending           = errors.New("user is not pending verification")
+	ErrVerificationNotFound = errors.New("verification not found or expired")
+)
+
+// SignupVerification is a pending verification of the email of a user who
+// signed up with SIGNUP_REQUIRE_VERIFICATION. Only the hash of the token is
+// stored.
+type SignupVerification struct {
+	Id         uint64     `gorm:"primaryKey,autoIncrement" json:"-"`
+	UserId     uint64     `gorm:"not null;index" json:"-"`
+	TokenHash  string     `gorm:"not null;uniqueIndex" json:"-"`
+	CreatedAt  time.Time  `gorm:"not null" json:"createdAt"`
+	ExpiresAt  time.Time  `gorm:"not null" json:"expiresAt"`
+	VerifiedAt *time.Time `json:"verifiedAt,omitempty"`
+}
+
+// RequestVerification records a pending verification of a user who has not
+// been activated yet and returns the token for the verification link.
+// Earlier pending verifications of the user expire.
+func (user *UserData) RequestVerification() (string, error) {
+	if user.GetAccountState().Status != StatusPending {
+		return "", ErrNotPending
+	}
+	dbConn := db.GetDBConn()
+	if err := dbConn.AutoMigrate(&SignupVerification{}); err != nil {
+		return "", err
+	}
+	token, err := randomtoken.Generate()
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	verification := SignupVerification{
+		UserId:    user.Id,
+		TokenHash: randomtoken.Hash(token),
+		CreatedAt: now,
+		ExpiresAt: now.Add(time.Minute * time.Duration(config.GetConfig().GetSignupVerificationExpiry())),
+	}
+	err = dbConn.GetDB().Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&SignupVerification{}).
+			Where("user_id = ? AND verified_at IS NULL AND expires_at > ?", user.Id, now).
+			Update("expires_at", now)
+		if result.Error != nil {
+			return result.Error
+		}
+		return tx.Create(&verification).Error
+	})
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// ConfirmVerification activates the user of a pending verification, whose
+// email counts as verified from now on.
+func ConfirmVerification(token string) (*UserData, error) {
+	dbConn := db.GetDBConn()
+	if err := dbConn.AutoMigrate(&SignupVerification{}); err != nil {
+		return nil, err
+	}
+	var verification SignupVerification
+	result := dbConn.GetDB().Where("token_hash = ?", randomtoken.Hash(token)).Limit(1).Find(&verification)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 || verification.VerifiedAt != nil || !time.Now().Before(verification.ExpiresAt) {
+		return nil, ErrVerificationNotFound
+	}
+	user, err := FindUserByID(verification.UserId)
+	if err != nil {
+		return nil, fmt.Errorf("unable to find user of verification %v %w", verification.Id, err)
+	}
+	if user.GetAccountState().Status != StatusPending {
+		return nil, ErrNotPending
+	}
+	if err := user.ChangeStatus(StatusActive, "", nil); err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	user.EmailVerifiedAt = &now
+	user.UpdatedAt = now
+	err = dbConn.GetDB().Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(user).Error; err != nil {
+			return err
+		}
+		verification.VerifiedAt = &now
+		return tx.Save(&verification).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	forgetAccountState(user.Id)
+	return user, nil
+}
@@ -3,6 +3,7 @@ package router
 import (
 	"net/http"
 
+	ipPolicyMiddleware "github.com/go-auth-microservice/pkg/middleware/ipPolicy"
 	v1router "github.com/go-auth-microservice/pkg/routes/v1"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
@@ -11,6 +12,7 @@ import (
 func MainRouter() http.Handler {
 	r := chi.NewRouter()
 	r.Use(middleware.Logger)
+	r.Use(ipPolicyMiddleware.GlobalPolicy)
 	r.Mount("/api", registerRouterVersions())
 	return r
 }
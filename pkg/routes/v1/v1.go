@@ -2,35 +2,146 @@ package v1router
 
 import (
 	"net/http"
+	"time"
 
+	"github.com/go-auth-microservice/pkg/config"
 	"github.com/go-auth-microservice/pkg/controller"
 	authMiddleware "github.com/go-auth-microservice/pkg/middleware/auth"
+	ipPolicyMiddleware "github.com/go-auth-microservice/pkg/middleware/ipPolicy"
+	rateLimitMiddleware "github.com/go-auth-microservice/pkg/middleware/rateLimit"
+	usermodel "github.com/go-auth-microservice/pkg/model/userModel"
 	"github.com/go-chi/chi/v5"
 )
 
 func V1Router() http.Handler {
 	r := chi.NewRouter()
 	r.Mount("/auth", authRouter())
+	r.Mount("/admin", adminRouter())
+	r.Mount("/scim/v2", scimRouter())
 	r.Mount("/", protectedRouter())
 	return r
 }
 
 func authRouter() http.Handler {
 	r := chi.NewRouter()
-	r.Post("/signup", controller.Signup)
-	r.Post("/login", controller.Login)
-	r.Get("/token", controller.RefreshAccessToken)
+	store := rateLimitMiddleware.GetStore()
+	r.With(rateLimitMiddleware.RateLimit(store, "signup",
+		rateLimitMiddleware.Rule{Name: "ip", Key: rateLimitMiddleware.ByIP, Limit: rateLimitMiddleware.Limit{Requests: 10, Per: time.Hour}},
+	)).Post("/signup", controller.Signup)
+	r.Post("/signup/verify", controller.VerifySignup)
+	r.With(rateLimitMiddleware.RateLimit(store, "login",
+		rateLimitMiddleware.Rule{Name: "ip", Key: rateLimitMiddleware.ByIP, Limit: rateLimitMiddleware.Limit{Requests: 30, Per: time.Minute}},
+		rateLimitMiddleware.Rule{Name: "email", Key: rateLimitMiddleware.ByEmail, Limit: rateLimitMiddleware.Limit{Requests: 10, Per: time.Minute}},
+	)).Post("/login", controller.Login)
+	r.With(rateLimitMiddleware.RateLimit(store, "login-challenge",
+		rateLimitMiddleware.Rule{Name: "ip", Key: rateLimitMiddleware.ByIP, Limit: rateLimitMiddleware.Limit{Requests: 10, Per: time.Minute}},
+	)).Post("/login/challenge", controller.CompleteLoginChallenge)
+	r.With(rateLimitMiddleware.RateLimit(store, "token",
+		rateLimitMiddleware.Rule{Name: "ip", Key: rateLimitMiddleware.ByIP, Limit: rateLimitMiddleware.Limit{Requests: 60, Per: time.Minute}},
+		rateLimitMiddleware.Rule{Name: "user", Key: rateLimitMiddleware.ByUserID, Limit: rateLimitMiddleware.Limit{Requests: 20, Per: time.Minute}},
+	)).Get("/token", controller.RefreshAccessToken)
+	r.With(authMiddleware.CSRFProtect).Post("/logout", controller.Logout)
+	r.Get("/verify", controller.VerifyForwardAuth)
+	r.Get("/federation", controller.GetFederationProviders)
+	r.With(rateLimitMiddleware.RateLimit(store, "federation",
+		rateLimitMiddleware.Rule{Name: "ip", Key: rateLimitMiddleware.ByIP, Limit: rateLimitMiddleware.Limit{Requests: 30, Per: time.Minute}},
+	)).Group(func(r chi.Router) {
+		r.Get("/federation/{provider}", controller.StartFederatedLogin)
+		r.Get("/federation/{provider}/callback", controller.FederatedLoginCallback)
+	})
+	r.Get("/saml", controller.GetSAMLTenants)
+	r.Get("/saml/{tenant}/metadata", controller.GetSAMLMetadata)
+	r.With(rateLimitMiddleware.RateLimit(store, "saml",
+		rateLimitMiddleware.Rule{Name: "ip", Key: rateLimitMiddleware.ByIP, Limit: rateLimitMiddleware.Limit{Requests: 30, Per: time.Minute}},
+	)).Group(func(r chi.Router) {
+		r.Get("/saml/{tenant}", controller.StartSAMLLogin)
+		r.Post("/saml/{tenant}/acs", controller.SAMLAssertionConsumer)
+	})
+	r.Post("/email/confirm", controller.ConfirmEmailChange)
+	r.Post("/email/cancel", controller.CancelEmailChange)
+	r.With(rateLimitMiddleware.RateLimit(store, "reactivate",
+		rateLimitMiddleware.Rule{Name: "ip", Key: rateLimitMiddleware.ByIP, Limit: rateLimitMiddleware.Limit{Requests: 10, Per: time.Minute}},
+		rateLimitMiddleware.Rule{Name: "email", Key: rateLimitMiddleware.ByEmail, Limit: rateLimitMiddleware.Limit{Requests: 5, Per: time.Minute}},
+	)).Post("/reactivate", controller.Reactivate)
+	r.Post("/reactivate/confirm", controller.ConfirmReactivation)
+	r.With(rateLimitMiddleware.RateLimit(store, "invitation",
+		rateLimitMiddleware.Rule{Name: "ip", Key: rateLimitMiddleware.ByIP, Limit: rateLimitMiddleware.Limit{Requests: 10, Per: time.Hour}},
+	)).Post("/invitations/accept", controller.AcceptInvitation)
 	return r
 }
 
 func protectedRouter() http.Handler {
 	r := chi.NewRouter()
 	r.Route("/", func(r chi.Router) {
+		r.Use(authMiddleware.CSRFProtect)
 		r.Use(authMiddleware.AccessTokenVerify)
-		r.Get("/me", controller.CheckIfSessionValid)
-		r.Get("/user", controller.GetUserData)
-		r.Patch("/deactivate", controller.DeActivateUser)
-		r.Patch("/changePassword", controller.ChangePassword)
+		r.Use(ipPolicyMiddleware.RolePolicy)
+		r.With(authMiddleware.RequireScope(usermodel.ScopeUserRead)).Get("/me", controller.CheckIfSessionValid)
+		r.With(authMiddleware.RequireScope(usermodel.ScopeUserRead)).Get("/user", controller.GetUserData)
+		r.With(authMiddleware.RequireScope(usermodel.ScopeUserWrite)).Patch("/user", controller.UpdateUserProfile)
+		r.With(authMiddleware.RequireScope(usermodel.ScopeUserRead)).Get("/user/export", controller.ExportUserData)
+		r.With(authMiddleware.RequireScope(usermodel.ScopeUserRead)).Get("/user/login-history", controller.GetLoginHistory)
+		r.With(authMiddleware.RequireScope(usermodel.ScopeUserRead)).Get("/user/identities", controller.GetIdentities)
+		r.Group(func(r chi.Router) {
+			r.Use(authMiddleware.DenyAPIToken)
+			r.With(authMiddleware.DenyImpersonation).Post("/reauthenticate", controller.Reauthenticate)
+			r.Group(func(r chi.Router) {
+				r.Use(authMiddleware.DenyImpersonation)
+				r.Use(authMiddleware.RequireRecentAuth(time.Minute * time.Duration(config.GetConfig().GetRecentAuthMaxAge())))
+				r.Patch("/deactivate", controller.DeActivateUser)
+				r.Patch("/changePassword", controller.ChangePassword)
+				r.Post("/user/email", controller.RequestEmailChange)
+				r.Delete("/user", controller.DeleteUser)
+				r.Get("/user/tokens", controller.GetAPITokens)
+				r.Post("/user/tokens", controller.CreateAPIToken)
+				r.Delete("/user/tokens/{id}", controller.RevokeAPIToken)
+				r.Post("/user/identities/{provider}", controller.LinkIdentity)
+				r.Delete("/user/identities/{id}", controller.UnlinkIdentity)
+			})
+		})
 	})
 	return r
 }
+
+func adminRouter() http.Handler {
+	r := chi.NewRouter()
+	r.Use(authMiddleware.CSRFProtect)
+	r.Use(authMiddleware.AccessTokenVerify)
+	r.Use(ipPolicyMiddleware.RolePolicy)
+	r.Use(authMiddleware.RequireRole(usermodel.RoleAdmin))
+	r.Use(authMiddleware.RequireScope(usermodel.ScopeAdmin))
+	r.Post("/users/{id}/unlock", controller.UnlockUser)
+	r.Post("/users/{id}/suspend", controller.SuspendUser)
+	r.Post("/users/{id}/unsuspend", controller.UnsuspendUser)
+	r.Get("/users/{id}/login-history", controller.GetUserLoginHistory)
+	r.Post("/invitations", controller.CreateInvitation)
+	r.With(authMiddleware.RequireRecentAuth(time.Minute*time.Duration(config.GetConfig().GetRecentAuthMaxAge()))).
+		Post("/users/{id}/impersonate", controller.ImpersonateUser)
+	r.Get("/metrics/password-hashing", controller.GetHashPoolStats)
+	r.Get("/ip-rules", controller.GetIPRules)
+	r.Post("/ip-rules", controller.CreateIPRule)
+	r.Delete("/ip-rules/{id}", controller.DeleteIPRule)
+	return r
+}
+
+// scimRouter serves the SCIM 2.0 API identity providers provision users and
+// groups through, authenticated by the bearer tokens of the tenants.
+func scimRouter() http.Handler {
+	r := chi.NewRouter()
+	r.Use(authMiddleware.SCIMTenantVerify)
+	r.Get("/ServiceProviderConfig", controller.GetSCIMServiceProviderConfig)
+	r.Get("/ResourceTypes", controller.GetSCIMResourceTypes)
+	r.Get("/Users", controller.GetSCIMUsers)
+	r.Post("/Users", controller.CreateSCIMUser)
+	r.Get("/Users/{id}", controller.GetSCIMUser)
+	r.Put("/Users/{id}", controller.ReplaceSCIMUser)
+	r.Patch("/Users/{id}", controller.PatchSCIMUser)
+	r.Delete("/Users/{id}", controller.DeleteSCIMUser)
+	r.Get("/Groups", controller.GetSCIMGroups)
+	r.Post("/Groups", controller.CreateSCIMGroup)
+	r.Get("/Groups/{id}", controller.GetSCIMGroup)
+	r.Put("/Groups/{id}", controller.ReplaceSCIMGroup)
+	r.Patch("/Groups/{id}", controller.PatchSCIMGroup)
+	r.Delete("/Groups/{id}", controller.DeleteSCIMGroup)
+	return r
+}
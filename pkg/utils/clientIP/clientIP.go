@@ -0,0 +1,86 @@
+package clientip
+
+import (
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/go-auth-microservice/pkg/config"
+	"github.com/go-auth-microservice/pkg/utils/logger"
+)
+
+var trustedProxies []*net.IPNet
+var trustedProxiesOnce sync.Once
+
+// ParseNetwork parses a CIDR network or a single IP address, which becomes a
+// network of only that address.
+func ParseNetwork(value string) (*net.IPNet, error) {
+	value = strings.TrimSpace(value)
+	if !strings.Contains(value, "/") {
+		if strings.Contains(value, ":") {
+			value += "/128"
+		} else {
+			value += "/32"
+		}
+	}
+	_, network, err := net.ParseCIDR(value)
+	return network, err
+}
+
+func getTrustedProxies() []*net.IPNet {
+	trustedProxiesOnce.Do(func() {
+		for _, value := range strings.Split(config.GetConfig().GetTrustedProxies(), ",") {
+			if strings.TrimSpace(value) == "" {
+				continue
+			}
+			network, err := ParseNetwork(value)
+			if err != nil {
+				logger.InitializeAppLogger().Errorf("invalid trusted proxy %q %v", value, err)
+				continue
+			}
+			trustedProxies = append(trustedProxies, network)
+		}
+	})
+	return trustedProxies
+}
+
+func isTrustedProxy(ip net.IP) bool {
+	for _, network := range getTrustedProxies() {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// FromRequest returns the IP address of the client of the request. If the
+// request comes from one of the TRUSTED_PROXIES the X-Forwarded-For header is
+// read from the right, the first address which is not a trusted proxy is the
+// client. Addresses further left can be forged by the client.
+func FromRequest(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+	remote := net.ParseIP(ip)
+	if remote == nil || !isTrustedProxy(remote) {
+		return ip
+	}
+	var forwarded []string
+	for _, header := range r.Header.Values("X-Forwarded-For") {
+		forwarded = append(forwarded, strings.Split(header, ",")...)
+	}
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		hop := net.ParseIP(strings.TrimSpace(forwarded[i]))
+		if hop == nil {
+			// an unparsable hop can not be trusted, the proxy in front of it is the client
+			return ip
+		}
+		ip = hop.String()
+		if !isTrustedProxy(hop) {
+			return ip
+		}
+	}
+	return ip
+}
@@ -51,7 +51,7 @@ func (sdb *postgressDB) createConnectionString() {
 func (sdb *postgressDB) Initialize() {
 	sdb.log = logger.InitializeAppLogger()
 	sdb.createConnectionString()
-	pgDB, err := gorm.Open(postgres.Open(sdb.connString), &gorm.Config{})
+	pgDB, err := gorm.Open(postgres.Open(sdb.connString), &gorm.Config{TranslateError: true})
 	sdb.db = pgDB
 	if err != nil {
 		sdb.log.Fatalf("❌ Failed to connect to database: %v", err)
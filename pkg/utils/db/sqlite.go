@@ -29,14 +29,14 @@ func (sdb *sqliteDB) Initialize() {
 	sdb.log = logger.InitializeAppLogger()
 	if _, err := os.Stat("users.db"); err == nil {
 		sdb.log.Info("Database already exists. Skipping initialization.")
-		sdb.db, err = gorm.Open(sqlite.Open("users.db"), &gorm.Config{})
+		sdb.db, err = gorm.Open(sqlite.Open("users.db"), &gorm.Config{TranslateError: true})
 		if err != nil {
 			sdb.log.Fatalf("Failed to connect to the database: %v", err)
 		}
 		sdb.log.Info("connected to sqlite database")
 	} else if os.IsNotExist(err) {
 		sdb.log.Info("Database does not exist. Initializing database.")
-		sdb.db, err = gorm.Open(sqlite.Open("users.db"), &gorm.Config{})
+		sdb.db, err = gorm.Open(sqlite.Open("users.db"), &gorm.Config{TranslateError: true})
 		if err != nil {
 			sdb.log.Fatalf("Failed to connect to the database: %v", err)
 		}
@@ -0,0 +1,112 @@
+package federation
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/endpoints"
+)
+
+const gitHubAPIURL = "https://api.github.com"
+
+// gitHubProvider signs users in with GitHub, which speaks OAuth2 but not
+// OpenID Connect. The identity is read from the REST API.
+type gitHubProvider struct {
+	oauthConfig *oauth2.Config
+	apiURL      string
+}
+
+func newGitHubProvider(config ProviderConfig, redirectURL string) *gitHubProvider {
+	endpoint := endpoints.GitHub
+	if config.AuthURL != "" {
+		endpoint.AuthURL = config.AuthURL
+	}
+	if config.TokenURL != "" {
+		endpoint.TokenURL = config.TokenURL
+	}
+	apiURL := gitHubAPIURL
+	if config.APIURL != "" {
+		apiURL = strings.TrimSuffix(config.APIURL, "/")
+	}
+	scopes := config.Scopes
+	if len(scopes) == 0 {
+		scopes = []string{"read:user", "user:email"}
+	}
+	return &gitHubProvider{
+		oauthConfig: &oauth2.Config{
+			ClientID:     config.ClientID,
+			ClientSecret: config.ClientSecret,
+			Endpoint:     endpoint,
+			RedirectURL:  redirectURL,
+			Scopes:       scopes,
+		},
+		apiURL: apiURL,
+	}
+}
+
+// AuthCodeURL ignores the nonce, it is an OpenID Connect feature.
+func (p *gitHubProvider) AuthCodeURL(ctx context.Context, state string, nonce string, verifier string) (string, error) {
+	return p.oauthConfig.AuthCodeURL(state, oauth2.S256ChallengeOption(verifier)), nil
+}
+
+func (p *gitHubProvider) get(ctx context.Context, client *http.Client, path string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.apiURL+path, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("github api %s answered %s", path, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// Identify reads the user and its primary email from the GitHub API.
+func (p *gitHubProvider) Identify(ctx context.Context, code string, nonce string, verifier string) (*Identity, error) {
+	ctx = clientContext(ctx)
+	token, err := p.oauthConfig.Exchange(ctx, code, oauth2.VerifierOption(verifier))
+	if err != nil {
+		return nil, err
+	}
+	client := p.oauthConfig.Client(ctx, token)
+	var user struct {
+		Id    int64  `json:"id"`
+		Login string `json:"login"`
+		Name  string `json:"name"`
+	}
+	if err := p.get(ctx, client, "/user", &user); err != nil {
+		return nil, err
+	}
+	if user.Id == 0 {
+		return nil, ErrInvalidIdentity
+	}
+	var emails []struct {
+		Email    string `json:"email"`
+		Primary  bool   `json:"primary"`
+		Verified bool   `json:"verified"`
+	}
+	if err := p.get(ctx, client, "/user/emails", &emails); err != nil {
+		return nil, err
+	}
+	identity := &Identity{Subject: strconv.FormatInt(user.Id, 10), Name: user.Name}
+	if identity.Name == "" {
+		identity.Name = user.Login
+	}
+	for _, email := range emails {
+		if email.Primary {
+			identity.Email = email.Email
+			identity.EmailVerified = email.Verified
+		}
+	}
+	return identity, nil
+}
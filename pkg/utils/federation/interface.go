@@ -0,0 +1,145 @@
+package federation
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-auth-microservice/pkg/config"
+	"github.com/go-auth-microservice/pkg/utils/logger"
+	"golang.org/x/oauth2"
+)
+
+// Provider types
+const (
+	TypeOIDC   = "oidc"
+	TypeGitHub = "github"
+)
+
+var (
+	ErrUnknownProvider = errors.New("unknown identity provider")
+	ErrInvalidIdentity = errors.New("identity provider returned no valid identity")
+)
+
+var providerNamePattern = regexp.MustCompile(`^[a-z0-9-]{1,32}$`)
+
+// httpClient talks to the identity providers
+var httpClient = &http.Client{Timeout: 10 * time.Second}
+
+// Identity is a user as an upstream identity provider knows them. Subject is
+// the stable id of the user at the provider, emails can change.
+type Identity struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+}
+
+// Provider is an upstream identity provider users can sign in with. The
+// nonce and PKCE verifier are generated per sign in and passed to both calls.
+type Provider interface {
+	// AuthCodeURL returns the URL to send the user to for signing in.
+	AuthCodeURL(ctx context.Context, state string, nonce string, verifier string) (string, error)
+	// Identify redeems the authorization code of the callback.
+	Identify(ctx context.Context, code string, nonce string, verifier string) (*Identity, error)
+}
+
+// ProviderConfig is an entry of the FEDERATION_PROVIDERS file. AuthURL,
+// TokenURL and APIURL override the endpoints of GitHub, e.g. for GitHub
+// Enterprise. Environment variables in the file like ${GOOGLE_CLIENT_SECRET}
+// are expanded.
+type ProviderConfig struct {
+	Name         string   `json:"name"`
+	Type         string   `json:"type"`
+	Issuer       string   `json:"issuer"`
+	ClientID     string   `json:"clientId"`
+	ClientSecret string   `json:"clientSecret"`
+	Scopes       []string `json:"scopes"`
+	AuthURL      string   `json:"authUrl"`
+	TokenURL     string   `json:"tokenUrl"`
+	APIURL       string   `json:"apiUrl"`
+}
+
+var providers map[string]Provider
+var providersOnce sync.Once
+
+// LoadProviders reads the provider configurations from a file.
+func LoadProviders(path string) (map[string]Provider, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var configs []ProviderConfig
+	if err := json.Unmarshal([]byte(os.ExpandEnv(string(content))), &configs); err != nil {
+		return nil, err
+	}
+	callbackURL := strings.TrimSuffix(config.GetConfig().GetFederationCallbackURL(), "/")
+	loaded := map[string]Provider{}
+	for _, providerConfig := range configs {
+		if !providerNamePattern.MatchString(providerConfig.Name) {
+			return nil, fmt.Errorf("invalid identity provider name %q", providerConfig.Name)
+		}
+		if _, ok := loaded[providerConfig.Name]; ok {
+			return nil, fmt.Errorf("identity provider %q is configured twice", providerConfig.Name)
+		}
+		redirectURL := callbackURL + "/" + providerConfig.Name + "/callback"
+		switch providerConfig.Type {
+		case TypeOIDC:
+			loaded[providerConfig.Name] = newOIDCProvider(providerConfig, redirectURL)
+		case TypeGitHub:
+			loaded[providerConfig.Name] = newGitHubProvider(providerConfig, redirectURL)
+		default:
+			return nil, fmt.Errorf("identity provider %q has unknown type %q", providerConfig.Name, providerConfig.Type)
+		}
+	}
+	return loaded, nil
+}
+
+func getProviders() map[string]Provider {
+	providersOnce.Do(func() {
+		providers = map[string]Provider{}
+		path := config.GetConfig().GetFederationProviders()
+		if path == "" {
+			return
+		}
+		loaded, err := LoadProviders(path)
+		if err != nil {
+			logger.InitializeAppLogger().Errorf("unable to load identity providers from %s %v", path, err)
+			return
+		}
+		providers = loaded
+	})
+	return providers
+}
+
+// GetProvider returns the configured identity provider of the name.
+func GetProvider(name string) (Provider, error) {
+	provider, ok := getProviders()[name]
+	if !ok {
+		return nil, ErrUnknownProvider
+	}
+	return provider, nil
+}
+
+// GetProviderNames returns the names of the configured identity providers.
+func GetProviderNames() []string {
+	names := []string{}
+	for name := range getProviders() {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// clientContext makes the oauth2 and OIDC clients use httpClient.
+func clientContext(ctx context.Context) context.Context {
+	return context.WithValue(ctx, oauth2.HTTPClient, httpClient)
+}
@@ -0,0 +1,107 @@
+package federation
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+)
+
+// oidcProvider signs users in with OpenID Connect, e.g. Google or Microsoft.
+// The endpoints are discovered from the issuer on first use.
+type oidcProvider struct {
+	config      ProviderConfig
+	redirectURL string
+	lock        sync.Mutex
+	provider    *oidc.Provider
+}
+
+func newOIDCProvider(config ProviderConfig, redirectURL string) *oidcProvider {
+	if len(config.Scopes) == 0 {
+		config.Scopes = []string{oidc.ScopeOpenID, "email", "profile"}
+	}
+	return &oidcProvider{config: config, redirectURL: redirectURL}
+}
+
+// discover fetches the discovery document of the issuer. A failed discovery
+// is retried on the next sign in.
+func (p *oidcProvider) discover(ctx context.Context) (*oidc.Provider, *oauth2.Config, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.provider == nil {
+		provider, err := oidc.NewProvider(clientContext(ctx), p.config.Issuer)
+		if err != nil {
+			return nil, nil, err
+		}
+		p.provider = provider
+	}
+	return p.provider, &oauth2.Config{
+		ClientID:     p.config.ClientID,
+		ClientSecret: p.config.ClientSecret,
+		Endpoint:     p.provider.Endpoint(),
+		RedirectURL:  p.redirectURL,
+		Scopes:       p.config.Scopes,
+	}, nil
+}
+
+func (p *oidcProvider) AuthCodeURL(ctx context.Context, state string, nonce string, verifier string) (string, error) {
+	_, oauthConfig, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+	return oauthConfig.AuthCodeURL(state, oidc.Nonce(nonce), oauth2.S256ChallengeOption(verifier)), nil
+}
+
+// Identify takes the identity from the verified ID token, or from the
+// userinfo endpoint if the token has no email.
+func (p *oidcProvider) Identify(ctx context.Context, code string, nonce string, verifier string) (*Identity, error) {
+	provider, oauthConfig, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ctx = clientContext(ctx)
+	token, err := oauthConfig.Exchange(ctx, code, oauth2.VerifierOption(verifier))
+	if err != nil {
+		return nil, err
+	}
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return nil, ErrInvalidIdentity
+	}
+	idToken, err := provider.Verifier(&oidc.Config{ClientID: p.config.ClientID}).Verify(ctx, rawIDToken)
+	if err != nil {
+		return nil, err
+	}
+	if idToken.Nonce != nonce {
+		return nil, errors.New("id token nonce does not match")
+	}
+	var claims struct {
+		Email         string `json:"email"`
+		EmailVerified *bool  `json:"email_verified"`
+		Name          string `json:"name"`
+	}
+	if err := idToken.Claims(&claims); err != nil {
+		return nil, err
+	}
+	identity := &Identity{Subject: idToken.Subject, Email: claims.Email, Name: claims.Name}
+	if claims.EmailVerified != nil {
+		identity.EmailVerified = *claims.EmailVerified
+	}
+	if identity.Email == "" && provider.UserInfoEndpoint() != "" {
+		userInfo, err := provider.UserInfo(ctx, oauth2.StaticTokenSource(token))
+		if err != nil {
+			return nil, err
+		}
+		if userInfo.Subject != idToken.Subject {
+			return nil, ErrInvalidIdentity
+		}
+		identity.Email = userInfo.Email
+		identity.EmailVerified = userInfo.EmailVerified
+	}
+	if identity.Subject == "" {
+		return nil, ErrInvalidIdentity
+	}
+	return identity, nil
+}
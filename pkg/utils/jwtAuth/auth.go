@@ -2,9 +2,11 @@ package jwtauth
 
 import (
 	"fmt"
+	"math"
 	"strings"
 	"time"
 
+	randomtoken "github.com/go-auth-microservice/pkg/utils/randomToken"
 	"github.com/golang-jwt/jwt/v5"
 )
 
@@ -13,9 +15,29 @@ type JWTManager struct {
 	expiryTime int64
 }
 
+// CreateToken signs the claims with the configured expiry. The random jti
+// keeps tokens issued within the same second apart, so that blacklisting one
+// on logout does not end the other sessions.
 func (j *JWTManager) CreateToken(claims jwt.MapClaims) (string, error) {
-	claims["exp"] = j.expiryTime
-	claims["iat"] = time.Now().Unix()
+	return j.sign(claims, j.expiryTime)
+}
+
+// CreateTokenExpiringAt signs the claims with another expiry than the
+// configured one, e.g. for impersonation tokens.
+func (j *JWTManager) CreateTokenExpiringAt(claims jwt.MapClaims, expiresAt time.Time) (string, error) {
+	return j.sign(claims, expiresAt.Unix())
+}
+
+func (j *JWTManager) sign(claims jwt.MapClaims, exp int64) (string, error) {
+	claims["exp"] = exp
+	jti, err := randomtoken.Generate()
+	if err != nil {
+		return "", err
+	}
+	claims["jti"] = jti
+	// iat has microseconds, so that revocations within the second a token
+	// has been issued in can be told apart from it
+	claims["iat"] = float64(time.Now().UnixMicro()) / 1e6
 	claims["iss"] = "Auth-Server-1"
 	accessToken := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 	token, err := accessToken.SignedString(j.secret)
@@ -25,6 +47,14 @@ func (j *JWTManager) CreateToken(claims jwt.MapClaims) (string, error) {
 	token = "Bearer " + token
 	return token, nil
 }
+
+// IssuedAt returns when a token has been issued, with sub-second precision
+// for tokens which carry it.
+func IssuedAt(claims jwt.MapClaims) time.Time {
+	iat, _ := claims["iat"].(float64)
+	return time.UnixMicro(int64(math.Round(iat * 1e6)))
+}
+
 func (j *JWTManager) VerifyToken(jwtToken string) (jwt.MapClaims, error) {
 	if jwtToken != "" && strings.HasPrefix(jwtToken, "Bearer ") {
 		jwtToken = jwtToken[7:]
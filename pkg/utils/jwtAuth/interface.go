@@ -9,6 +9,7 @@ import (
 
 type JWT interface {
 	CreateToken(jwt.MapClaims) (string, error)
+	CreateTokenExpiringAt(jwt.MapClaims, time.Time) (string, error)
 	VerifyToken(string) (jwt.MapClaims, error)
 }
 
@@ -0,0 +1,193 @@
+package ldapauth
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/go-auth-microservice/pkg/config"
+	"github.com/go-ldap/ldap/v3"
+)
+
+var (
+	ErrNotConfigured   = errors.New("ldap is not configured")
+	ErrEntryNotFound   = errors.New("no directory entry matches the login")
+	ErrInvalidPassword = errors.New("directory rejected the password")
+)
+
+// Entry is a user as the directory knows them. Groups are the DNs of the
+// groups the user is a member of.
+type Entry struct {
+	DN     string
+	Email  string
+	Name   string
+	Groups []string
+}
+
+// GroupRole maps the members of a directory group to a role.
+type GroupRole struct {
+	Role  string
+	Group *ldap.DN
+}
+
+// Directory authenticates users by binding to an LDAP server or Active
+// Directory with their password. A service account searches the DN of the
+// user first, so that users log in with their email and not their DN.
+type Directory struct {
+	URL            string
+	StartTLS       bool
+	TLSConfig      *tls.Config
+	BindDN         string
+	BindPassword   string
+	BaseDN         string
+	UserFilter     string
+	EmailAttribute string
+	NameAttribute  string
+	GroupAttribute string
+	GroupRoles     []GroupRole
+	Timeout        time.Duration
+}
+
+// NewDirectory configures the directory from LDAP_* variables.
+func NewDirectory() (*Directory, error) {
+	conf := config.GetConfig()
+	if conf.GetLDAPURL() == "" || conf.GetLDAPBaseDN() == "" {
+		return nil, ErrNotConfigured
+	}
+	if !strings.Contains(conf.GetLDAPUserFilter(), "%s") {
+		return nil, fmt.Errorf("LDAP_USER_FILTER %q has no %%s for the login", conf.GetLDAPUserFilter())
+	}
+	groupRoles, err := ParseGroupRoles(conf.GetLDAPGroupRoles())
+	if err != nil {
+		return nil, err
+	}
+	directory := &Directory{
+		URL:            conf.GetLDAPURL(),
+		StartTLS:       conf.GetLDAPStartTLS(),
+		BindDN:         conf.GetLDAPBindDN(),
+		BindPassword:   conf.GetLDAPBindPassword(),
+		BaseDN:         conf.GetLDAPBaseDN(),
+		UserFilter:     conf.GetLDAPUserFilter(),
+		EmailAttribute: conf.GetLDAPEmailAttribute(),
+		NameAttribute:  conf.GetLDAPNameAttribute(),
+		GroupAttribute: conf.GetLDAPGroupAttribute(),
+		GroupRoles:     groupRoles,
+		Timeout:        time.Second * time.Duration(conf.GetLDAPTimeout()),
+	}
+	if u, err := url.Parse(directory.URL); err == nil {
+		directory.TLSConfig = &tls.Config{ServerName: u.Hostname(), MinVersion: tls.VersionTLS12}
+	}
+	return directory, nil
+}
+
+// ParseGroupRoles parses role=group DN pairs separated by semicolons, e.g.
+// admin=CN=Auth Admins,OU=Groups,DC=example,DC=com. The first pair matching
+// a group of the user decides its role.
+func ParseGroupRoles(value string) ([]GroupRole, error) {
+	groupRoles := []GroupRole{}
+	for _, pair := range strings.Split(value, ";") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		role, group, ok := strings.Cut(pair, "=")
+		if !ok || strings.TrimSpace(role) == "" {
+			return nil, fmt.Errorf("invalid group role mapping %q", pair)
+		}
+		dn, err := ldap.ParseDN(strings.TrimSpace(group))
+		if err != nil {
+			return nil, fmt.Errorf("invalid group DN in mapping %q %w", pair, err)
+		}
+		groupRoles = append(groupRoles, GroupRole{Role: strings.TrimSpace(role), Group: dn})
+	}
+	return groupRoles, nil
+}
+
+// RoleOf returns the role of the first mapping the user is a member of, or
+// defaultRole if the user is in none of the mapped groups.
+func (d *Directory) RoleOf(entry *Entry, defaultRole string) string {
+	for _, groupRole := range d.GroupRoles {
+		for _, group := range entry.Groups {
+			dn, err := ldap.ParseDN(group)
+			if err == nil && groupRole.Group.EqualFold(dn) {
+				return groupRole.Role
+			}
+		}
+	}
+	return defaultRole
+}
+
+func (d *Directory) dial(ctx context.Context) (*ldap.Conn, error) {
+	dialer := &net.Dialer{Timeout: d.Timeout}
+	if deadline, ok := ctx.Deadline(); ok {
+		dialer.Deadline = deadline
+	}
+	conn, err := ldap.DialURL(d.URL, ldap.DialWithDialer(dialer), ldap.DialWithTLSConfig(d.TLSConfig))
+	if err != nil {
+		return nil, err
+	}
+	conn.SetTimeout(d.Timeout)
+	if d.StartTLS {
+		if err := conn.StartTLS(d.TLSConfig); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+// Authenticate searches the entry of the email and binds as it with the
+// password. It returns ErrEntryNotFound if no single entry matches and
+// ErrInvalidPassword if the directory rejects the password.
+func (d *Directory) Authenticate(ctx context.Context, email string, password string) (*Entry, error) {
+	// an empty password would be an unauthenticated bind, which servers
+	// accept for any DN
+	if password == "" {
+		return nil, ErrInvalidPassword
+	}
+	conn, err := d.dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if d.BindDN != "" {
+		if err := conn.Bind(d.BindDN, d.BindPassword); err != nil {
+			return nil, fmt.Errorf("service account bind failed %w", err)
+		}
+	}
+	attributes := []string{d.EmailAttribute, d.NameAttribute, d.GroupAttribute}
+	search := ldap.NewSearchRequest(
+		d.BaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 2, int(d.Timeout.Seconds()), false,
+		fmt.Sprintf(d.UserFilter, ldap.EscapeFilter(email)), attributes, nil,
+	)
+	result, err := conn.Search(search)
+	if err != nil && !ldap.IsErrorWithCode(err, ldap.LDAPResultSizeLimitExceeded) {
+		return nil, err
+	}
+	// an ambiguous filter must not let one user log in as another
+	if result == nil || len(result.Entries) != 1 {
+		return nil, ErrEntryNotFound
+	}
+	found := result.Entries[0]
+	if err := conn.Bind(found.DN, password); err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return nil, ErrInvalidPassword
+		}
+		return nil, err
+	}
+	entry := &Entry{
+		DN:     found.DN,
+		Email:  found.GetAttributeValue(d.EmailAttribute),
+		Name:   found.GetAttributeValue(d.NameAttribute),
+		Groups: found.GetAttributeValues(d.GroupAttribute),
+	}
+	if entry.Email == "" {
+		entry.Email = email
+	}
+	return entry, nil
+}
@@ -0,0 +1,39 @@
+package mailer
+
+import (
+	"sync"
+
+	"github.com/go-auth-microservice/pkg/config"
+)
+
+// Mailer delivers plain text emails to users.
+type Mailer interface {
+	Send(to string, subject string, body string) error
+}
+
+var mailer Mailer
+var mailerLock sync.Mutex
+
+// GetMailer returns the SMTP mailer when SMTP_HOST is configured and a mailer
+// which only writes the emails to the app log otherwise.
+func GetMailer() Mailer {
+	mailerLock.Lock()
+	defer mailerLock.Unlock()
+	if mailer != nil {
+		return mailer
+	}
+	appConfig := config.GetConfig()
+	if appConfig.GetSMTPHost() != "" {
+		mailer = NewSMTPMailer(appConfig.GetSMTPHost(), appConfig.GetSMTPPort(), appConfig.GetSMTPUser(), appConfig.GetSMTPPassword(), appConfig.GetMailFrom())
+	} else {
+		mailer = NewLogMailer()
+	}
+	return mailer
+}
+
+// SetMailer replaces the mailer, e.g. with a fake one in tests.
+func SetMailer(m Mailer) {
+	mailerLock.Lock()
+	defer mailerLock.Unlock()
+	mailer = m
+}
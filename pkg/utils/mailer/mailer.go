@@ -0,0 +1,62 @@
+package mailer
+
+import (
+	"fmt"
+	"net/smtp"
+	"regexp"
+	"strings"
+
+	"github.com/go-auth-microservice/pkg/utils/logger"
+)
+
+type smtpMailer struct {
+	addr string
+	auth smtp.Auth
+	from string
+}
+
+func (s *smtpMailer) Send(to string, subject string, body string) error {
+	if strings.ContainsAny(to+subject, "\r\n") {
+		return fmt.Errorf("invalid mail header")
+	}
+	message := "From: " + s.from + "\r\n" +
+		"To: " + to + "\r\n" +
+		"Subject: " + subject + "\r\n" +
+		"Content-Type: text/plain; charset=UTF-8\r\n" +
+		"\r\n" + body
+	return smtp.SendMail(s.addr, s.auth, s.from, []string{to}, []byte(message))
+}
+
+func NewSMTPMailer(host string, port string, user string, password string, from string) Mailer {
+	var auth smtp.Auth
+	if user != "" {
+		auth = smtp.PlainAuth("", user, password, host)
+	}
+	return &smtpMailer{
+		addr: host + ":" + port,
+		auth: auth,
+		from: from,
+	}
+}
+
+// mailSecretPattern matches the tokens of links and the login codes in emails.
+var mailSecretPattern = regexp.MustCompile(`token=[^&\s]+|\b[0-9]{6}\b`)
+
+// logMailer is used when no SMTP server is configured, e.g. during development.
+// Tokens and codes are redacted, as anyone reading the logs could use them.
+type logMailer struct{}
+
+func (l *logMailer) Send(to string, subject string, body string) error {
+	body = mailSecretPattern.ReplaceAllStringFunc(body, func(secret string) string {
+		if strings.HasPrefix(secret, "token=") {
+			return "token=[redacted]"
+		}
+		return "[redacted]"
+	})
+	logger.InitializeAppLogger().Infof("mail to %s: %s\n%s", to, subject, body)
+	return nil
+}
+
+func NewLogMailer() Mailer {
+	return &logMailer{}
+}
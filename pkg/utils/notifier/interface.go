@@ -0,0 +1,84 @@
+package notifier
+
+import (
+	"sync"
+
+	"github.com/go-auth-microservice/pkg/config"
+	"github.com/go-auth-microservice/pkg/utils/logger"
+)
+
+const (
+	EventNewDeviceLogin = "new_device_login"
+)
+
+// Notification tells a user about a security relevant event of their account.
+type Notification struct {
+	Event   string                 `json:"event"`
+	UserId  uint64                 `json:"userId"`
+	Email   string                 `json:"email"`
+	Subject string                 `json:"subject"`
+	Body    string                 `json:"body"`
+	Data    map[string]interface{} `json:"data,omitempty"`
+}
+
+// Notifier delivers notifications to users.
+type Notifier interface {
+	Notify(notification Notification) error
+}
+
+var notifier Notifier
+var notifierLock sync.Mutex
+
+// GetNotifier returns the webhook notifier when NOTIFICATION_WEBHOOK_URL is
+// configured and a notifier which emails the user otherwise.
+func GetNotifier() Notifier {
+	notifierLock.Lock()
+	defer notifierLock.Unlock()
+	if notifier != nil {
+		return notifier
+	}
+	if url := config.GetConfig().GetNotificationWebhookURL(); url != "" {
+		notifier = NewWebhookNotifier(url)
+	} else {
+		notifier = NewMailNotifier()
+	}
+	return notifier
+}
+
+// SetNotifier replaces the notifier, e.g. with a fake one in tests.
+func SetNotifier(n Notifier) {
+	notifierLock.Lock()
+	defer notifierLock.Unlock()
+	notifier = n
+}
+
+// queueSize bounds the notifications waiting to be sent in the background.
+const queueSize = 1000
+
+var queue chan Notification
+var queueOnce sync.Once
+
+// Enqueue sends the notification in the background, so that a slow webhook
+// or mail server does not hold up the request. It reports false if the
+// notification has been dropped because too many are waiting.
+func Enqueue(notification Notification) bool {
+	queueOnce.Do(func() {
+		queue = make(chan Notification, queueSize)
+		go sendQueued()
+	})
+	select {
+	case queue <- notification:
+		return true
+	default:
+		return false
+	}
+}
+
+func sendQueued() {
+	log := logger.InitializeAppLogger()
+	for notification := range queue {
+		if err := GetNotifier().Notify(notification); err != nil {
+			log.Errorf("unable to notify user %v of %s %v", notification.UserId, notification.Event, err)
+		}
+	}
+}
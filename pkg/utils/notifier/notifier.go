@@ -0,0 +1,51 @@
+package notifier
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/go-auth-microservice/pkg/utils/mailer"
+)
+
+type mailNotifier struct{}
+
+func (m *mailNotifier) Notify(notification Notification) error {
+	return mailer.GetMailer().Send(notification.Email, notification.Subject, notification.Body)
+}
+
+func NewMailNotifier() Notifier {
+	return &mailNotifier{}
+}
+
+// webhookNotifier posts notifications as JSON, e.g. to a service sending push
+// notifications.
+type webhookNotifier struct {
+	url    string
+	client *http.Client
+}
+
+func (n *webhookNotifier) Notify(notification Notification) error {
+	body, err := json.Marshal(notification)
+	if err != nil {
+		return err
+	}
+	res, err := n.client.Post(n.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return fmt.Errorf("notification webhook answered with status %d", res.StatusCode)
+	}
+	return nil
+}
+
+func NewWebhookNotifier(url string) Notifier {
+	return &webhookNotifier{
+		url:    url,
+		client: &http.Client{Timeout: 5 * time.Second},
+	}
+}
@@ -0,0 +1,134 @@
+package passwordhash
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+)
+
+const (
+	argon2SaltLength = 16
+	argon2KeyLength  = 32
+	// argon2MaxIterations bounds the iterations of stored hashes unless more
+	// are configured, a tampered or imported hash could burn CPU otherwise
+	argon2MaxIterations = 64
+)
+
+type argon2Params struct {
+	memory      uint32
+	iterations  uint32
+	parallelism uint8
+}
+
+type argon2idHasher struct {
+	params        argon2Params
+	maxMemory     uint32
+	maxIterations uint32
+}
+
+// Hash returns the password in the PHC string format
+// $argon2id$v=19$m=<memory>,t=<iterations>,p=<parallelism>$<salt>$<key>
+func (a *argon2idHasher) Hash(password string) (string, error) {
+	salt := make([]byte, argon2SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, a.params.iterations, a.params.memory, a.params.parallelism, argon2KeyLength)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, a.params.memory, a.params.iterations, a.params.parallelism,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+func (a *argon2idHasher) Verify(hash string, password string) error {
+	params, salt, key, err := a.decode(hash)
+	if err != nil {
+		return err
+	}
+	otherKey := argon2.IDKey([]byte(password), salt, params.iterations, params.memory, params.parallelism, uint32(len(key)))
+	if subtle.ConstantTimeCompare(key, otherKey) != 1 {
+		return ErrMismatchedPassword
+	}
+	return nil
+}
+
+func (a *argon2idHasher) Supports(hash string) bool {
+	return strings.HasPrefix(hash, "$argon2id$")
+}
+
+func (a *argon2idHasher) NeedsRehash(hash string) bool {
+	params, _, _, err := a.decode(hash)
+	return err != nil || params != a.params
+}
+
+// decode decodes a hash and rejects parameters outside of the bounds of the
+// hasher, argon2 panics without parallelism and allocates the memory of the
+// hash for every verification.
+func (a *argon2idHasher) decode(hash string) (argon2Params, []byte, []byte, error) {
+	params, salt, key, err := decodeArgon2Hash(hash)
+	if err != nil {
+		return params, nil, nil, err
+	}
+	if params.parallelism < 1 {
+		return params, nil, nil, fmt.Errorf("argon2 parallelism %d out of range", params.parallelism)
+	}
+	if params.iterations < 1 || params.iterations > a.maxIterations {
+		return params, nil, nil, fmt.Errorf("argon2 iterations %d out of range", params.iterations)
+	}
+	if params.memory > a.maxMemory {
+		return params, nil, nil, fmt.Errorf("argon2 memory %d KiB exceeds the maximum of %d KiB", params.memory, a.maxMemory)
+	}
+	if len(key) == 0 || len(key) > 1024 {
+		return params, nil, nil, fmt.Errorf("argon2 key length %d out of range", len(key))
+	}
+	return params, salt, key, nil
+}
+
+func decodeArgon2Hash(hash string) (argon2Params, []byte, []byte, error) {
+	var params argon2Params
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return params, nil, nil, ErrUnknownHash
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil {
+		return params, nil, nil, err
+	}
+	if version != argon2.Version {
+		return params, nil, nil, fmt.Errorf("unsupported argon2 version %d", version)
+	}
+	var parallelism uint32
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.memory, &params.iterations, &parallelism); err != nil {
+		return params, nil, nil, err
+	}
+	if parallelism > 255 {
+		return params, nil, nil, fmt.Errorf("argon2 parallelism %d out of range", parallelism)
+	}
+	params.parallelism = uint8(parallelism)
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return params, nil, nil, err
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return params, nil, nil, err
+	}
+	return params, salt, key, nil
+}
+
+// NewArgon2idHasher returns a hasher with the given parameters which verifies
+// hashes using up to maxMemory KiB, and at least the configured memory.
+func NewArgon2idHasher(memory uint32, iterations uint32, parallelism uint8, maxMemory uint32) Hasher {
+	return &argon2idHasher{
+		params: argon2Params{
+			memory:      memory,
+			iterations:  iterations,
+			parallelism: parallelism,
+		},
+		maxMemory:     max(maxMemory, memory),
+		maxIterations: max(argon2MaxIterations, iterations),
+	}
+}
@@ -0,0 +1,88 @@
+package passwordhash
+
+import (
+	"encoding/base64"
+	"errors"
+	"sync"
+
+	"github.com/go-auth-microservice/pkg/config"
+)
+
+var (
+	ErrMismatchedPassword = errors.New("password does not match hash")
+	ErrUnknownHash        = errors.New("unknown password hash format")
+)
+
+// Hasher hashes passwords into self describing strings. The algorithm and its
+// parameters are encoded in the hash so that it can be verified and upgraded
+// later on, even after the configured algorithm has changed.
+type Hasher interface {
+	Hash(string) (string, error)
+	Verify(hash string, password string) error
+	Supports(hash string) bool
+	NeedsRehash(hash string) bool
+}
+
+var hasher Hasher
+var hashers []Hasher
+var hasherOnce sync.Once
+
+func initHashers() {
+	hasherOnce.Do(func() {
+		appConfig := config.GetConfig()
+		bcryptHasher := NewBcryptHasher(appConfig.GetBcryptCost())
+		argon2Hasher := NewArgon2idHasher(uint32(appConfig.GetArgon2Memory()), uint32(appConfig.GetArgon2Iterations()), uint8(appConfig.GetArgon2Parallelism()), uint32(appConfig.GetArgon2MaxMemory()))
+		switch appConfig.GetPasswordHashAlgorithm() {
+		case "bcrypt":
+			hasher = bcryptHasher
+		default:
+			hasher = argon2Hasher
+		}
+		signerKey, _ := base64.StdEncoding.DecodeString(appConfig.GetFirebaseSignerKey())
+		saltSeparator, _ := base64.StdEncoding.DecodeString(appConfig.GetFirebaseSaltSeparator())
+		firebaseHasher := NewFirebaseScryptHasher(signerKey, saltSeparator, appConfig.GetFirebaseRounds(), appConfig.GetFirebaseMemCost())
+		// the remaining hashers only verify passwords of imported users, the
+		// hash gets upgraded to the configured algorithm on the first login
+		hashers = []Hasher{argon2Hasher, bcryptHasher, NewDjangoHasher(), NewMD5CryptHasher(), firebaseHasher}
+	})
+}
+
+// GetHasher returns the hasher new passwords are hashed with.
+func GetHasher() Hasher {
+	initHashers()
+	return hasher
+}
+
+// Hash hashes the password with the configured algorithm.
+func Hash(password string) (string, error) {
+	return GetHasher().Hash(password)
+}
+
+// Verify checks the password against a hash of any supported algorithm.
+func Verify(hash string, password string) error {
+	initHashers()
+	for _, h := range hashers {
+		if h.Supports(hash) {
+			return h.Verify(hash, password)
+		}
+	}
+	return ErrUnknownHash
+}
+
+// Supported reports whether a hash can be verified by one of the hashers.
+func Supported(hash string) bool {
+	initHashers()
+	for _, h := range hashers {
+		if h.Supports(hash) {
+			return true
+		}
+	}
+	return false
+}
+
+// NeedsRehash reports whether the hash uses another algorithm or other
+// parameters than the configured ones.
+func NeedsRehash(hash string) bool {
+	current := GetHasher()
+	return !current.Supports(hash) || current.NeedsRehash(hash)
+}
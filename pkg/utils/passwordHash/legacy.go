@@ -0,0 +1,256 @@
+package passwordhash
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/md5"
+	"crypto/pbkdf2"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// ErrHashingNotSupported is returned by hashers which only verify passwords
+// imported from other systems.
+var ErrHashingNotSupported = errors.New("hashing is not supported by this algorithm")
+
+// pbkdf2MaxIterations bounds the iterations of imported Django hashes, above
+// the current Django default but low enough that a crafted hash can not tie
+// up a hashing worker for long.
+const pbkdf2MaxIterations = 2_000_000
+
+// djangoHasher verifies hashes of Django's default PBKDF2PasswordHasher in the
+// format pbkdf2_sha256$<iterations>$<salt>$<base64 hash>.
+type djangoHasher struct{}
+
+// djangoIterations returns the iterations of a Django hash, which have to be
+// between 1 and pbkdf2MaxIterations.
+func djangoIterations(parts []string) (int, error) {
+	iterations, err := strconv.Atoi(parts[1])
+	if err != nil || iterations <= 0 || iterations > pbkdf2MaxIterations {
+		return 0, fmt.Errorf("invalid pbkdf2 iterations %q", parts[1])
+	}
+	return iterations, nil
+}
+
+func (d *djangoHasher) Hash(password string) (string, error) {
+	return "", ErrHashingNotSupported
+}
+
+func (d *djangoHasher) Verify(hash string, password string) error {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 4 {
+		return ErrUnknownHash
+	}
+	iterations, err := djangoIterations(parts)
+	if err != nil {
+		return err
+	}
+	key, err := base64.StdEncoding.DecodeString(parts[3])
+	if err != nil {
+		return err
+	}
+	otherKey, err := pbkdf2.Key(sha256.New, password, []byte(parts[2]), iterations, len(key))
+	if err != nil {
+		return err
+	}
+	if subtle.ConstantTimeCompare(key, otherKey) != 1 {
+		return ErrMismatchedPassword
+	}
+	return nil
+}
+
+// Supports rejects hashes with too many iterations, so that they are not
+// imported in the first place.
+func (d *djangoHasher) Supports(hash string) bool {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 4 || parts[0] != "pbkdf2_sha256" {
+		return false
+	}
+	_, err := djangoIterations(parts)
+	return err == nil
+}
+
+func (d *djangoHasher) NeedsRehash(hash string) bool {
+	return true
+}
+
+// md5CryptHasher verifies the $1$<salt>$<hash> MD5-crypt hashes of old
+// PHP and unix systems.
+type md5CryptHasher struct{}
+
+func (m *md5CryptHasher) Hash(password string) (string, error) {
+	return "", ErrHashingNotSupported
+}
+
+func (m *md5CryptHasher) Verify(hash string, password string) error {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 4 {
+		return ErrUnknownHash
+	}
+	otherHash := md5Crypt([]byte(password), []byte(parts[2]))
+	if subtle.ConstantTimeCompare([]byte(hash), []byte(otherHash)) != 1 {
+		return ErrMismatchedPassword
+	}
+	return nil
+}
+
+func (m *md5CryptHasher) Supports(hash string) bool {
+	return strings.HasPrefix(hash, "$1$")
+}
+
+func (m *md5CryptHasher) NeedsRehash(hash string) bool {
+	return true
+}
+
+const cryptAlphabet = "./0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+// md5Crypt implements the FreeBSD MD5-crypt algorithm by Poul-Henning Kamp.
+func md5Crypt(password []byte, salt []byte) string {
+	if len(salt) > 8 {
+		salt = salt[:8]
+	}
+	magic := []byte("$1$")
+
+	alternate := md5.New()
+	alternate.Write(password)
+	alternate.Write(salt)
+	alternate.Write(password)
+	alternateSum := alternate.Sum(nil)
+
+	ctx := md5.New()
+	ctx.Write(password)
+	ctx.Write(magic)
+	ctx.Write(salt)
+	for i := len(password); i > 0; i -= 16 {
+		ctx.Write(alternateSum[:min(i, 16)])
+	}
+	for i := len(password); i > 0; i >>= 1 {
+		if i&1 == 1 {
+			ctx.Write([]byte{0})
+		} else {
+			ctx.Write(password[:1])
+		}
+	}
+	sum := ctx.Sum(nil)
+
+	for i := 0; i < 1000; i++ {
+		round := md5.New()
+		if i&1 == 1 {
+			round.Write(password)
+		} else {
+			round.Write(sum)
+		}
+		if i%3 != 0 {
+			round.Write(salt)
+		}
+		if i%7 != 0 {
+			round.Write(password)
+		}
+		if i&1 == 1 {
+			round.Write(sum)
+		} else {
+			round.Write(password)
+		}
+		sum = round.Sum(nil)
+	}
+
+	var encoded strings.Builder
+	encode := func(a, b, c byte, n int) {
+		v := uint(a)<<16 | uint(b)<<8 | uint(c)
+		for ; n > 0; n-- {
+			encoded.WriteByte(cryptAlphabet[v&0x3f])
+			v >>= 6
+		}
+	}
+	encode(sum[0], sum[6], sum[12], 4)
+	encode(sum[1], sum[7], sum[13], 4)
+	encode(sum[2], sum[8], sum[14], 4)
+	encode(sum[3], sum[9], sum[15], 4)
+	encode(sum[4], sum[10], sum[5], 4)
+	encode(0, 0, sum[11], 2)
+	return string(magic) + string(salt) + "$" + encoded.String()
+}
+
+// firebaseScryptHasher verifies the modified scrypt hashes exported from
+// Firebase Authentication. They are stored as $firebase-scrypt$<salt>$<hash>
+// with the base64 salt and hash of the export, the signer key, salt separator,
+// rounds and memory cost are the hash parameters of the Firebase project.
+type firebaseScryptHasher struct {
+	signerKey     []byte
+	saltSeparator []byte
+	rounds        int
+	memCost       int
+}
+
+func (f *firebaseScryptHasher) Hash(password string) (string, error) {
+	return "", ErrHashingNotSupported
+}
+
+func (f *firebaseScryptHasher) Verify(hash string, password string) error {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 4 {
+		return ErrUnknownHash
+	}
+	if len(f.signerKey) == 0 {
+		return fmt.Errorf("firebase scrypt parameters are not configured")
+	}
+	salt, err := base64.StdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return err
+	}
+	key, err := base64.StdEncoding.DecodeString(parts[3])
+	if err != nil {
+		return err
+	}
+	derivedKey, err := scrypt.Key([]byte(password), append(salt, f.saltSeparator...), 1<<f.memCost, f.rounds, 1, 32)
+	if err != nil {
+		return err
+	}
+	block, err := aes.NewCipher(derivedKey)
+	if err != nil {
+		return err
+	}
+	otherKey := make([]byte, len(f.signerKey))
+	cipher.NewCTR(block, make([]byte, aes.BlockSize)).XORKeyStream(otherKey, f.signerKey)
+	if subtle.ConstantTimeCompare(key, otherKey) != 1 {
+		return ErrMismatchedPassword
+	}
+	return nil
+}
+
+func (f *firebaseScryptHasher) Supports(hash string) bool {
+	return strings.HasPrefix(hash, "$firebase-scrypt$")
+}
+
+func (f *firebaseScryptHasher) NeedsRehash(hash string) bool {
+	return true
+}
+
+// FirebaseScryptHash builds the stored hash string of a Firebase export entry.
+func FirebaseScryptHash(salt string, passwordHash string) string {
+	return "$firebase-scrypt$" + salt + "$" + passwordHash
+}
+
+func NewDjangoHasher() Hasher {
+	return &djangoHasher{}
+}
+
+func NewMD5CryptHasher() Hasher {
+	return &md5CryptHasher{}
+}
+
+func NewFirebaseScryptHasher(signerKey []byte, saltSeparator []byte, rounds int, memCost int) Hasher {
+	return &firebaseScryptHasher{
+		signerKey:     signerKey,
+		saltSeparator: saltSeparator,
+		rounds:        rounds,
+		memCost:       memCost,
+	}
+}
@@ -0,0 +1,65 @@
+package passwordpolicy
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// BreachedCorpus tells whether a password is known from a data breach.
+type BreachedCorpus interface {
+	Contains(password string) (bool, error)
+}
+
+// hibpRangeCorpus looks passwords up in a local copy of the Have I Been Pwned
+// range files. Every file is named after the first five hex characters of the
+// SHA-1 hash and holds one SUFFIX:COUNT line per breached password.
+type hibpRangeCorpus struct {
+	dir      string
+	minCount int
+}
+
+func (h *hibpRangeCorpus) Contains(password string) (bool, error) {
+	sum := sha1.Sum([]byte(password)) // #nosec G401 -- the corpus is keyed by SHA-1
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	prefix, suffix := hash[:5], hash[5:]
+
+	file, err := os.Open(filepath.Join(h.dir, prefix))
+	if errors.Is(err, fs.ErrNotExist) {
+		file, err = os.Open(filepath.Join(h.dir, prefix+".txt"))
+	}
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		lineSuffix, count, _ := strings.Cut(strings.TrimSpace(scanner.Text()), ":")
+		if !strings.EqualFold(lineSuffix, suffix) {
+			continue
+		}
+		n, err := strconv.Atoi(count)
+		if err != nil {
+			n = 1
+		}
+		return n >= h.minCount, nil
+	}
+	return false, scanner.Err()
+}
+
+func NewHIBPRangeCorpus(dir string, minCount int) BreachedCorpus {
+	if minCount < 1 {
+		minCount = 1
+	}
+	return &hibpRangeCorpus{dir: dir, minCount: minCount}
+}
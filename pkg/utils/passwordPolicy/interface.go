@@ -0,0 +1,31 @@
+package passwordpolicy
+
+import (
+	"sync"
+
+	"github.com/go-auth-microservice/pkg/config"
+)
+
+var policy *Policy
+var policyOnce sync.Once
+
+// GetPolicy returns the password policy built from the configuration.
+func GetPolicy() *Policy {
+	policyOnce.Do(func() {
+		appConfig := config.GetConfig()
+		policy = &Policy{
+			MinLength:     appConfig.GetPasswordMinLength(),
+			MaxLength:     appConfig.GetPasswordMaxLength(),
+			RequireUpper:  appConfig.GetPasswordRequireUpper(),
+			RequireLower:  appConfig.GetPasswordRequireLower(),
+			RequireDigit:  appConfig.GetPasswordRequireDigit(),
+			RequireSymbol: appConfig.GetPasswordRequireSymbol(),
+			DisallowEmail: appConfig.GetPasswordDisallowEmail(),
+			HistorySize:   appConfig.GetPasswordHistorySize(),
+		}
+		if dir := appConfig.GetBreachedPasswordsDir(); dir != "" {
+			policy.BreachedCorpus = NewHIBPRangeCorpus(dir, appConfig.GetBreachedPasswordsMinCount())
+		}
+	})
+	return policy
+}
@@ -0,0 +1,112 @@
+package passwordpolicy
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Violation is a reason why a password has been rejected. The code is stable
+// so that clients can show their own translated message.
+type Violation struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+const (
+	CodeTooShort      = "too_short"
+	CodeTooLong       = "too_long"
+	CodeMissingUpper  = "missing_uppercase"
+	CodeMissingLower  = "missing_lowercase"
+	CodeMissingDigit  = "missing_digit"
+	CodeMissingSymbol = "missing_symbol"
+	CodeContainsEmail = "contains_email"
+	CodeBreached      = "breached"
+	CodeRecentlyUsed  = "recently_used"
+)
+
+// minEmailPartLength avoids rejecting passwords for containing a very short
+// local part of the email like "al".
+const minEmailPartLength = 3
+
+type Policy struct {
+	MinLength      int
+	MaxLength      int
+	RequireUpper   bool
+	RequireLower   bool
+	RequireDigit   bool
+	RequireSymbol  bool
+	DisallowEmail  bool
+	HistorySize    int
+	BreachedCorpus BreachedCorpus
+}
+
+// Check returns all rules the password violates. The password history is
+// checked by the user model as it needs the stored hashes.
+func (p *Policy) Check(password string, email string) ([]Violation, error) {
+	violations := []Violation{}
+	length := utf8.RuneCountInString(password)
+	if length < p.MinLength {
+		violations = append(violations, Violation{CodeTooShort, fmt.Sprintf("password must be at least %d characters long", p.MinLength)})
+	}
+	if p.MaxLength > 0 && length > p.MaxLength {
+		violations = append(violations, Violation{CodeTooLong, fmt.Sprintf("password must be at most %d characters long", p.MaxLength)})
+	}
+	var hasUpper, hasLower, hasDigit, hasSymbol bool
+	for _, c := range password {
+		switch {
+		case unicode.IsUpper(c):
+			hasUpper = true
+		case unicode.IsLower(c):
+			hasLower = true
+		case unicode.IsDigit(c):
+			hasDigit = true
+		case unicode.IsPunct(c) || unicode.IsSymbol(c) || unicode.IsSpace(c):
+			hasSymbol = true
+		}
+	}
+	if p.RequireUpper && !hasUpper {
+		violations = append(violations, Violation{CodeMissingUpper, "password must contain an uppercase letter"})
+	}
+	if p.RequireLower && !hasLower {
+		violations = append(violations, Violation{CodeMissingLower, "password must contain a lowercase letter"})
+	}
+	if p.RequireDigit && !hasDigit {
+		violations = append(violations, Violation{CodeMissingDigit, "password must contain a digit"})
+	}
+	if p.RequireSymbol && !hasSymbol {
+		violations = append(violations, Violation{CodeMissingSymbol, "password must contain a symbol"})
+	}
+	if p.DisallowEmail && containsEmail(password, email) {
+		violations = append(violations, Violation{CodeContainsEmail, "password must not contain the email address"})
+	}
+	if p.BreachedCorpus != nil {
+		breached, err := p.BreachedCorpus.Contains(password)
+		if err != nil {
+			return violations, err
+		}
+		if breached {
+			violations = append(violations, Violation{CodeBreached, "password has appeared in a data breach"})
+		}
+	}
+	return violations, nil
+}
+
+// RecentlyUsed is the violation reported when the password is in the history.
+func (p *Policy) RecentlyUsed() Violation {
+	return Violation{CodeRecentlyUsed, fmt.Sprintf("password must differ from the last %d passwords", p.HistorySize)}
+}
+
+func containsEmail(password string, email string) bool {
+	password = strings.ToLower(password)
+	email = strings.ToLower(email)
+	if email == "" {
+		return false
+	}
+	if strings.Contains(password, email) {
+		return true
+	}
+	localPart, _, _ := strings.Cut(email, "@")
+	return len(localPart) >= minEmailPartLength && strings.Contains(password, localPart)
+}
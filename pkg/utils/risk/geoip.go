@@ -0,0 +1,57 @@
+package risk
+
+import (
+	"net"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+type unknownLocator struct{}
+
+func (unknownLocator) Locate(ip string) (*Location, error) {
+	return nil, nil
+}
+
+// maxMindLocator looks IP addresses up in an offline database in the MaxMind
+// DB format, e.g. GeoLite2 City.
+type maxMindLocator struct {
+	reader *maxminddb.Reader
+}
+
+type maxMindRecord struct {
+	Country struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+	Location struct {
+		Latitude  *float64 `maxminddb:"latitude"`
+		Longitude *float64 `maxminddb:"longitude"`
+	} `maxminddb:"location"`
+}
+
+func (m *maxMindLocator) Locate(ip string) (*Location, error) {
+	address := net.ParseIP(ip)
+	if address == nil {
+		return nil, nil
+	}
+	var record maxMindRecord
+	_, ok, err := m.reader.LookupNetwork(address, &record)
+	if err != nil || !ok {
+		return nil, err
+	}
+	if record.Location.Latitude == nil || record.Location.Longitude == nil {
+		return nil, nil
+	}
+	return &Location{
+		Country:   record.Country.ISOCode,
+		Latitude:  *record.Location.Latitude,
+		Longitude: *record.Location.Longitude,
+	}, nil
+}
+
+func NewMaxMindLocator(path string) (Locator, error) {
+	reader, err := maxminddb.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return &maxMindLocator{reader: reader}, nil
+}
@@ -0,0 +1,69 @@
+package risk
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/go-auth-microservice/pkg/config"
+	"github.com/go-auth-microservice/pkg/utils/logger"
+)
+
+// Location is where an IP address is located.
+type Location struct {
+	Country   string
+	Latitude  float64
+	Longitude float64
+}
+
+// Locator finds the location of IP addresses.
+type Locator interface {
+	// Locate returns nil if the location of the IP address is unknown.
+	Locate(ip string) (*Location, error)
+}
+
+// ReputationList tells whether an IP address is known for abuse.
+type ReputationList interface {
+	Contains(ip string) bool
+}
+
+var locator Locator
+var locatorOnce sync.Once
+var reputationList ReputationList
+var reputationListOnce sync.Once
+
+// GetLocator returns the locator of the GEOIP_DATABASE, or one which knows no
+// locations if there is none.
+func GetLocator() Locator {
+	locatorOnce.Do(func() {
+		locator = unknownLocator{}
+		path := config.GetConfig().GetGeoIPDatabase()
+		if path == "" {
+			return
+		}
+		db, err := NewMaxMindLocator(path)
+		if err != nil {
+			logger.InitializeAppLogger().Errorf("unable to open GeoIP database %s %v", path, err)
+			return
+		}
+		locator = db
+	})
+	return locator
+}
+
+// GetReputationList returns the networks of all IP_REPUTATION_LISTS.
+func GetReputationList() ReputationList {
+	reputationListOnce.Do(func() {
+		var paths []string
+		for _, path := range strings.Split(config.GetConfig().GetIPReputationLists(), ",") {
+			if path = strings.TrimSpace(path); path != "" {
+				paths = append(paths, path)
+			}
+		}
+		list, err := NewFileReputationList(paths...)
+		if err != nil {
+			logger.InitializeAppLogger().Errorf("unable to load IP reputation lists %v", err)
+		}
+		reputationList = list
+	})
+	return reputationList
+}
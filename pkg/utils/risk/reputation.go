@@ -0,0 +1,66 @@
+package risk
+
+import (
+	"bufio"
+	"net"
+	"os"
+	"strings"
+
+	clientip "github.com/go-auth-microservice/pkg/utils/clientIP"
+)
+
+// fileReputationList holds the networks of plain text lists with one IP
+// address or CIDR network per line, e.g. FireHOL or Spamhaus DROP. Anything
+// after a # or ; is a comment.
+type fileReputationList struct {
+	networks []*net.IPNet
+}
+
+func (f *fileReputationList) Contains(ip string) bool {
+	address := net.ParseIP(ip)
+	if address == nil {
+		return false
+	}
+	for _, network := range f.networks {
+		if network.Contains(address) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *fileReputationList) load(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		line, _, _ = strings.Cut(line, ";")
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		network, err := clientip.ParseNetwork(line)
+		if err != nil {
+			continue
+		}
+		f.networks = append(f.networks, network)
+	}
+	return scanner.Err()
+}
+
+// NewFileReputationList loads the lists. Lists which can not be read are
+// skipped and reported in the error.
+func NewFileReputationList(paths ...string) (ReputationList, error) {
+	list := &fileReputationList{}
+	var failed error
+	for _, path := range paths {
+		if err := list.load(path); err != nil {
+			failed = err
+		}
+	}
+	return list, failed
+}
@@ -0,0 +1,109 @@
+package risk
+
+import "math"
+
+// Decisions on a login
+const (
+	Allow     = "allow"
+	Challenge = "challenge"
+	Block     = "block"
+)
+
+// Reasons adding to the risk score of a login
+const (
+	ReasonListedIP         = "listed_ip"
+	ReasonImpossibleTravel = "impossible_travel"
+	ReasonFailedLogins     = "failed_logins"
+	ReasonNewDevice        = "new_device"
+)
+
+const (
+	listedIPScore         = 50
+	impossibleTravelScore = 40
+	newDeviceScore        = 20
+	failedLoginScore      = 10
+	maxFailedLoginScore   = 30
+	// minTravelDistance in km ignores the inaccuracy of GeoIP databases
+	minTravelDistance = 100
+)
+
+// Signals are what is known about a login with the right credentials.
+type Signals struct {
+	ListedIP       bool
+	NewDevice      bool
+	RecentFailures int
+	// From and To are the locations of the previous and this login, nil if unknown
+	From    *Location
+	To      *Location
+	Elapsed float64 // hours since the previous login
+}
+
+// Policy holds the thresholds of the decisions.
+type Policy struct {
+	ChallengeScore int
+	BlockScore     int
+	MaxTravelSpeed float64 // km/h
+}
+
+// Assessment is the risk score of a login and the decision taken on it.
+type Assessment struct {
+	Score    int      `json:"score"`
+	Decision string   `json:"decision"`
+	Reasons  []string `json:"reasons"`
+}
+
+// Distance returns the great circle distance between two locations in km.
+func Distance(from *Location, to *Location) float64 {
+	const earthRadius = 6371
+	lat1, lat2 := from.Latitude*math.Pi/180, to.Latitude*math.Pi/180
+	dLat := lat2 - lat1
+	dLon := (to.Longitude - from.Longitude) * math.Pi / 180
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadius * math.Asin(math.Min(1, math.Sqrt(a)))
+}
+
+// isImpossibleTravel tells if the user would have had to travel faster than
+// the policy allows between the two logins.
+func isImpossibleTravel(signals Signals, maxSpeed float64) bool {
+	if signals.From == nil || signals.To == nil {
+		return false
+	}
+	distance := Distance(signals.From, signals.To)
+	if distance < minTravelDistance {
+		return false
+	}
+	// a minute keeps logins within the same moment from dividing by zero
+	elapsed := math.Max(signals.Elapsed, 1.0/60)
+	return distance/elapsed > maxSpeed
+}
+
+// Assess scores the signals of a login and decides whether it is allowed,
+// has to be challenged or is blocked.
+func Assess(signals Signals, policy Policy) Assessment {
+	assessment := Assessment{Reasons: []string{}}
+	if signals.ListedIP {
+		assessment.Score += listedIPScore
+		assessment.Reasons = append(assessment.Reasons, ReasonListedIP)
+	}
+	if isImpossibleTravel(signals, policy.MaxTravelSpeed) {
+		assessment.Score += impossibleTravelScore
+		assessment.Reasons = append(assessment.Reasons, ReasonImpossibleTravel)
+	}
+	if signals.RecentFailures > 0 {
+		assessment.Score += min(signals.RecentFailures*failedLoginScore, maxFailedLoginScore)
+		assessment.Reasons = append(assessment.Reasons, ReasonFailedLogins)
+	}
+	if signals.NewDevice {
+		assessment.Score += newDeviceScore
+		assessment.Reasons = append(assessment.Reasons, ReasonNewDevice)
+	}
+	switch {
+	case assessment.Score >= policy.BlockScore:
+		assessment.Decision = Block
+	case assessment.Score >= policy.ChallengeScore:
+		assessment.Decision = Challenge
+	default:
+		assessment.Decision = Allow
+	}
+	return assessment
+}
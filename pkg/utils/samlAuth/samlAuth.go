@@ -0,0 +1,377 @@
+package samlauth
+
+import (
+	"context"
+	"crypto"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/crewjam/saml"
+	"github.com/crewjam/saml/samlsp"
+	"github.com/go-auth-microservice/pkg/config"
+	"github.com/go-auth-microservice/pkg/utils/logger"
+	dsig "github.com/russellhaering/goxmldsig"
+)
+
+var (
+	ErrUnknownTenant   = errors.New("unknown saml tenant")
+	ErrInvalidIdentity = errors.New("saml assertion has no valid identity")
+)
+
+var tenantNamePattern = regexp.MustCompile(`^[a-z0-9-]{1,32}$`)
+
+// httpClient fetches the metadata of the identity providers
+var httpClient = &http.Client{Timeout: 10 * time.Second}
+
+// metadataMaxAge is how long metadata fetched from a URL is used before it
+// is fetched again, so that rotated IdP certificates are picked up.
+const metadataMaxAge = 24 * time.Hour
+
+// Identity is a user as the identity provider of a tenant asserts them.
+// Subject is the NameID of the assertion.
+type Identity struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+	Groups        []string
+}
+
+// AttributeMapping names the assertion attributes which are mapped onto the
+// user. Attributes are matched by Name or FriendlyName.
+type AttributeMapping struct {
+	Email       string `json:"email"`
+	DisplayName string `json:"displayName"`
+	Groups      string `json:"groups"`
+}
+
+// GroupRole maps the members of a group of the identity provider to a role.
+type GroupRole struct {
+	Group string `json:"group"`
+	Role  string `json:"role"`
+}
+
+// TenantConfig is an entry of the SAML_TENANTS file. The IdP metadata is
+// read from MetadataFile or fetched from MetadataURL. Emails are only
+// trusted as verified within EmailDomains. Environment variables in the file
+// are expanded.
+type TenantConfig struct {
+	Name         string           `json:"name"`
+	MetadataURL  string           `json:"metadataUrl"`
+	MetadataFile string           `json:"metadataFile"`
+	EmailDomains []string         `json:"emailDomains"`
+	CreateUsers  bool             `json:"createUsers"`
+	Attributes   AttributeMapping `json:"attributes"`
+	GroupRoles   []GroupRole      `json:"groupRoles"`
+}
+
+// Tenant is a customer whose users log in through their SAML identity
+// provider. Every tenant is its own service provider with its own entity ID
+// and assertion consumer service URL.
+type Tenant struct {
+	config   TenantConfig
+	sp       saml.ServiceProvider
+	lock     sync.Mutex
+	loadedAt time.Time
+}
+
+var tenants map[string]*Tenant
+var tenantsOnce sync.Once
+
+// LoadTenants reads the tenant configurations from a file. The service
+// provider signs its requests with the key pair of SAML_SP_KEY and
+// SAML_SP_CERTIFICATE.
+func LoadTenants(path string) (map[string]*Tenant, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var configs []TenantConfig
+	if err := json.Unmarshal([]byte(os.ExpandEnv(string(content))), &configs); err != nil {
+		return nil, err
+	}
+	conf := config.GetConfig()
+	keyPair, err := tls.LoadX509KeyPair(conf.GetSAMLSPCertificate(), conf.GetSAMLSPKey())
+	if err != nil {
+		return nil, fmt.Errorf("unable to load saml service provider key pair %w", err)
+	}
+	certificate, err := x509.ParseCertificate(keyPair.Certificate[0])
+	if err != nil {
+		return nil, err
+	}
+	key, ok := keyPair.PrivateKey.(crypto.Signer)
+	if !ok {
+		return nil, errors.New("saml service provider key can not sign")
+	}
+	baseURL := strings.TrimSuffix(conf.GetSAMLBaseURL(), "/")
+	loaded := map[string]*Tenant{}
+	for _, tenantConfig := range configs {
+		if !tenantNamePattern.MatchString(tenantConfig.Name) {
+			return nil, fmt.Errorf("invalid saml tenant name %q", tenantConfig.Name)
+		}
+		if _, ok := loaded[tenantConfig.Name]; ok {
+			return nil, fmt.Errorf("saml tenant %q is configured twice", tenantConfig.Name)
+		}
+		if (tenantConfig.MetadataURL == "") == (tenantConfig.MetadataFile == "") {
+			return nil, fmt.Errorf("saml tenant %q needs either metadataUrl or metadataFile", tenantConfig.Name)
+		}
+		if tenantConfig.Attributes.Email == "" {
+			tenantConfig.Attributes.Email = "email"
+		}
+		if tenantConfig.Attributes.DisplayName == "" {
+			tenantConfig.Attributes.DisplayName = "displayName"
+		}
+		metadataURL, err := url.Parse(baseURL + "/" + tenantConfig.Name + "/metadata")
+		if err != nil {
+			return nil, err
+		}
+		acsURL, err := url.Parse(baseURL + "/" + tenantConfig.Name + "/acs")
+		if err != nil {
+			return nil, err
+		}
+		loaded[tenantConfig.Name] = &Tenant{
+			config: tenantConfig,
+			sp: saml.ServiceProvider{
+				EntityID:        metadataURL.String(),
+				Key:             key,
+				Certificate:     certificate,
+				MetadataURL:     *metadataURL,
+				AcsURL:          *acsURL,
+				SignatureMethod: dsig.RSASHA256SignatureMethod,
+			},
+		}
+	}
+	return loaded, nil
+}
+
+func getTenants() map[string]*Tenant {
+	tenantsOnce.Do(func() {
+		tenants = map[string]*Tenant{}
+		path := config.GetConfig().GetSAMLTenants()
+		if path == "" {
+			return
+		}
+		loaded, err := LoadTenants(path)
+		if err != nil {
+			logger.InitializeAppLogger().Errorf("unable to load saml tenants from %s %v", path, err)
+			return
+		}
+		tenants = loaded
+	})
+	return tenants
+}
+
+// GetTenant returns the configured tenant of the name.
+func GetTenant(name string) (*Tenant, error) {
+	tenant, ok := getTenants()[name]
+	if !ok {
+		return nil, ErrUnknownTenant
+	}
+	return tenant, nil
+}
+
+// GetTenantNames returns the names of the configured tenants.
+func GetTenantNames() []string {
+	names := []string{}
+	for name := range getTenants() {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (t *Tenant) Name() string {
+	return t.config.Name
+}
+
+// CreateUsers tells if users unknown to this service are created on their
+// first login through the tenant.
+func (t *Tenant) CreateUsers() bool {
+	return t.config.CreateUsers
+}
+
+// serviceProvider returns the service provider with the current metadata of
+// the identity provider. A failed fetch is retried on the next login, while
+// metadata which could not be refreshed stays in use.
+func (t *Tenant) serviceProvider(ctx context.Context) (*saml.ServiceProvider, error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.sp.IDPMetadata != nil && (t.config.MetadataURL == "" || time.Since(t.loadedAt) < metadataMaxAge) {
+		sp := t.sp
+		return &sp, nil
+	}
+	metadata, err := t.loadMetadata(ctx)
+	if err != nil {
+		if t.sp.IDPMetadata != nil {
+			logger.InitializeAppLogger().Errorf("unable to refresh metadata of saml tenant %s %v", t.config.Name, err)
+			sp := t.sp
+			return &sp, nil
+		}
+		return nil, err
+	}
+	t.sp.IDPMetadata = metadata
+	t.loadedAt = time.Now()
+	sp := t.sp
+	return &sp, nil
+}
+
+func (t *Tenant) loadMetadata(ctx context.Context) (*saml.EntityDescriptor, error) {
+	if t.config.MetadataFile != "" {
+		content, err := os.ReadFile(t.config.MetadataFile)
+		if err != nil {
+			return nil, err
+		}
+		return samlsp.ParseMetadata(content)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, t.config.MetadataURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("saml metadata %s answered %s", t.config.MetadataURL, resp.Status)
+	}
+	content, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	return samlsp.ParseMetadata(content)
+}
+
+// Metadata returns the service provider metadata to register at the
+// identity provider of the tenant.
+func (t *Tenant) Metadata() ([]byte, error) {
+	t.lock.Lock()
+	sp := t.sp
+	t.lock.Unlock()
+	return xml.MarshalIndent(sp.Metadata(), "", "  ")
+}
+
+// AuthnRequestURL returns the URL of the identity provider with a signed
+// AuthnRequest of the id, using the HTTP-Redirect binding.
+func (t *Tenant) AuthnRequestURL(ctx context.Context, requestID string, relayState string) (string, error) {
+	sp, err := t.serviceProvider(ctx)
+	if err != nil {
+		return "", err
+	}
+	request, err := sp.MakeAuthenticationRequest(sp.GetSSOBindingLocation(saml.HTTPRedirectBinding), saml.HTTPRedirectBinding, saml.HTTPPostBinding)
+	if err != nil {
+		return "", err
+	}
+	request.ID = requestID
+	redirectURL, err := request.Redirect(url.QueryEscape(relayState), sp)
+	if err != nil {
+		return "", err
+	}
+	return redirectURL.String(), nil
+}
+
+// ParseResponse verifies the base64 encoded SAMLResponse posted to the
+// assertion consumer service. The signature, issuer, audience, validity and
+// InResponseTo of the assertion are checked by the service provider.
+func (t *Tenant) ParseResponse(ctx context.Context, samlResponse string, requestID string) (*Identity, error) {
+	sp, err := t.serviceProvider(ctx)
+	if err != nil {
+		return nil, err
+	}
+	response, err := base64.StdEncoding.DecodeString(samlResponse)
+	if err != nil {
+		return nil, err
+	}
+	assertion, err := sp.ParseXMLResponse(response, []string{requestID}, sp.AcsURL)
+	if err != nil {
+		var invalid *saml.InvalidResponseError
+		if errors.As(err, &invalid) {
+			return nil, fmt.Errorf("invalid saml response %w", invalid.PrivateErr)
+		}
+		return nil, err
+	}
+	if assertion.Subject == nil || assertion.Subject.NameID == nil || assertion.Subject.NameID.Value == "" {
+		return nil, ErrInvalidIdentity
+	}
+	identity := &Identity{
+		Subject: assertion.Subject.NameID.Value,
+		Email:   t.attributeValue(assertion, t.config.Attributes.Email),
+		Name:    t.attributeValue(assertion, t.config.Attributes.DisplayName),
+	}
+	identity.EmailVerified = t.trustsEmail(identity.Email)
+	if t.config.Attributes.Groups != "" {
+		identity.Groups = t.attributeValues(assertion, t.config.Attributes.Groups)
+	}
+	return identity, nil
+}
+
+func (t *Tenant) attributeValues(assertion *saml.Assertion, name string) []string {
+	values := []string{}
+	for _, statement := range assertion.AttributeStatements {
+		for _, attribute := range statement.Attributes {
+			if attribute.Name != name && attribute.FriendlyName != name {
+				continue
+			}
+			for _, value := range attribute.Values {
+				values = append(values, value.Value)
+			}
+		}
+	}
+	return values
+}
+
+func (t *Tenant) attributeValue(assertion *saml.Assertion, name string) string {
+	values := t.attributeValues(assertion, name)
+	if len(values) == 0 {
+		return ""
+	}
+	return strings.TrimSpace(values[0])
+}
+
+// trustsEmail tells if the email is in one of the domains of the tenant.
+// The identity provider of a tenant can assert any email, it is only
+// trusted for the domains the tenant owns.
+func (t *Tenant) trustsEmail(email string) bool {
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return false
+	}
+	domain := email[at+1:]
+	for _, allowed := range t.config.EmailDomains {
+		if strings.EqualFold(domain, allowed) {
+			return true
+		}
+	}
+	return false
+}
+
+// RoleOf returns the role of the first mapping the identity is a member of,
+// or defaultRole if it is in none of the mapped groups. It returns false if
+// the tenant does not map groups to roles.
+func (t *Tenant) RoleOf(identity *Identity, defaultRole string) (string, bool) {
+	if t.config.Attributes.Groups == "" || len(t.config.GroupRoles) == 0 {
+		return "", false
+	}
+	for _, groupRole := range t.config.GroupRoles {
+		for _, group := range identity.Groups {
+			if group == groupRole.Group {
+				return groupRole.Role, true
+			}
+		}
+	}
+	return defaultRole, true
+}
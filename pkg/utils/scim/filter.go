@@ -0,0 +1,386 @@
+package scim
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Filter is a parsed SCIM filter expression (RFC 7644 section 3.4.2.2).
+type Filter interface {
+	// Matches tells if the resource in its generic JSON form matches.
+	Matches(resource map[string]interface{}) bool
+}
+
+type logicalFilter struct {
+	and         bool
+	left, right Filter
+}
+
+func (f logicalFilter) Matches(resource map[string]interface{}) bool {
+	if f.and {
+		return f.left.Matches(resource) && f.right.Matches(resource)
+	}
+	return f.left.Matches(resource) || f.right.Matches(resource)
+}
+
+type notFilter struct {
+	filter Filter
+}
+
+func (f notFilter) Matches(resource map[string]interface{}) bool {
+	return !f.filter.Matches(resource)
+}
+
+// valuePathFilter matches complex multi-valued attributes like
+// emails[type eq "work"], an element has to match the inner filter.
+type valuePathFilter struct {
+	attribute string
+	filter    Filter
+}
+
+func (f valuePathFilter) Matches(resource map[string]interface{}) bool {
+	for _, element := range elements(attributeValue(resource, f.attribute)) {
+		if m, ok := element.(map[string]interface{}); ok && f.filter.Matches(m) {
+			return true
+		}
+	}
+	return false
+}
+
+// attributeFilter compares an attribute with a value. The attribute path
+// may name a sub-attribute, multi-valued attributes match if any value does.
+type attributeFilter struct {
+	path     []string
+	operator string
+	value    interface{}
+}
+
+func (f attributeFilter) Matches(resource map[string]interface{}) bool {
+	values := []interface{}{resource}
+	for _, name := range f.path {
+		next := []interface{}{}
+		for _, value := range values {
+			for _, element := range elements(value) {
+				if m, ok := element.(map[string]interface{}); ok {
+					if attribute := attributeValue(m, name); attribute != nil {
+						next = append(next, elements(attribute)...)
+					}
+				}
+			}
+		}
+		values = next
+	}
+	if f.operator == "pr" {
+		for _, value := range values {
+			if value != nil && value != "" {
+				return true
+			}
+		}
+		return false
+	}
+	for _, value := range values {
+		if compare(value, f.operator, f.value) {
+			return true
+		}
+	}
+	return f.operator == "ne" && len(values) == 0 && f.value != nil
+}
+
+// EqualValue returns the string a filter like userName eq "alice@example.com"
+// requires the attribute to equal. It reports false for every other filter,
+// which has to be matched against the resources instead.
+func EqualValue(filter Filter, attribute string) (string, bool) {
+	f, ok := filter.(attributeFilter)
+	if !ok || f.operator != "eq" || len(f.path) != 1 || !strings.EqualFold(f.path[0], attribute) {
+		return "", false
+	}
+	value, ok := f.value.(string)
+	return value, ok
+}
+
+func attributeValue(m map[string]interface{}, name string) interface{} {
+	if key, ok := findKey(m, name); ok {
+		return m[key]
+	}
+	return nil
+}
+
+func elements(value interface{}) []interface{} {
+	if list, ok := value.([]interface{}); ok {
+		return list
+	}
+	if value == nil {
+		return nil
+	}
+	return []interface{}{value}
+}
+
+// compare applies the operator to an attribute value and the value of the
+// filter. Strings are compared case insensitive.
+func compare(attribute interface{}, operator string, value interface{}) bool {
+	switch a := attribute.(type) {
+	case string:
+		v, ok := value.(string)
+		if !ok {
+			return operator == "ne"
+		}
+		a, v = strings.ToLower(a), strings.ToLower(v)
+		switch operator {
+		case "eq":
+			return a == v
+		case "ne":
+			return a != v
+		case "co":
+			return strings.Contains(a, v)
+		case "sw":
+			return strings.HasPrefix(a, v)
+		case "ew":
+			return strings.HasSuffix(a, v)
+		case "gt":
+			return a > v
+		case "ge":
+			return a >= v
+		case "lt":
+			return a < v
+		case "le":
+			return a <= v
+		}
+	case bool:
+		v, ok := value.(bool)
+		switch operator {
+		case "eq":
+			return ok && a == v
+		case "ne":
+			return !ok || a != v
+		}
+	case float64:
+		v, ok := value.(float64)
+		if !ok {
+			return operator == "ne"
+		}
+		switch operator {
+		case "eq":
+			return a == v
+		case "ne":
+			return a != v
+		case "gt":
+			return a > v
+		case "ge":
+			return a >= v
+		case "lt":
+			return a < v
+		case "le":
+			return a <= v
+		}
+	}
+	return false
+}
+
+var operators = map[string]bool{
+	"eq": true, "ne": true, "co": true, "sw": true, "ew": true,
+	"gt": true, "ge": true, "lt": true, "le": true, "pr": true,
+}
+
+// ParseFilter parses a filter like userName eq "alice@example.com" or
+// members[value eq "1"] and not (displayName sw "test").
+func ParseFilter(filter string) (Filter, error) {
+	tokens, err := tokenize(filter)
+	if err != nil {
+		return nil, err
+	}
+	p := &filterParser{tokens: tokens}
+	parsed, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos != len(p.tokens) {
+		return nil, fmt.Errorf("%w: unexpected %q", ErrInvalidFilter, p.tokens[p.pos])
+	}
+	return parsed, nil
+}
+
+func tokenize(filter string) ([]string, error) {
+	tokens := []string{}
+	for i := 0; i < len(filter); {
+		c := filter[i]
+		switch {
+		case c == ' ' || c == '\t':
+			i++
+		case c == '(' || c == ')' || c == '[' || c == ']':
+			tokens = append(tokens, string(c))
+			i++
+		case c == '"':
+			end := i + 1
+			for end < len(filter) && filter[end] != '"' {
+				if filter[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(filter) {
+				return nil, fmt.Errorf("%w: unterminated string", ErrInvalidFilter)
+			}
+			tokens = append(tokens, filter[i:end+1])
+			i = end + 1
+		default:
+			end := i
+			for end < len(filter) && !strings.ContainsRune(" \t()[]\"", rune(filter[end])) {
+				end++
+			}
+			tokens = append(tokens, filter[i:end])
+			i = end
+		}
+	}
+	return tokens, nil
+}
+
+type filterParser struct {
+	tokens []string
+	pos    int
+}
+
+func (p *filterParser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return ""
+}
+
+func (p *filterParser) next() string {
+	token := p.peek()
+	p.pos++
+	return token
+}
+
+func (p *filterParser) expect(token string) error {
+	if next := p.next(); next != token {
+		return fmt.Errorf("%w: expected %q instead of %q", ErrInvalidFilter, token, next)
+	}
+	return nil
+}
+
+func (p *filterParser) parseOr() (Filter, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for strings.EqualFold(p.peek(), "or") {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = logicalFilter{left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *filterParser) parseAnd() (Filter, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for strings.EqualFold(p.peek(), "and") {
+		p.next()
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = logicalFilter{and: true, left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *filterParser) parseUnary() (Filter, error) {
+	token := p.next()
+	if strings.EqualFold(token, "not") {
+		if err := p.expect("("); err != nil {
+			return nil, err
+		}
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(")"); err != nil {
+			return nil, err
+		}
+		return notFilter{filter: inner}, nil
+	}
+	if token == "(" {
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(")"); err != nil {
+			return nil, err
+		}
+		return inner, nil
+	}
+	if !isAttributePath(token) {
+		return nil, fmt.Errorf("%w: expected an attribute instead of %q", ErrInvalidFilter, token)
+	}
+	attribute := stripSchema(token)
+	if p.peek() == "[" {
+		p.next()
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect("]"); err != nil {
+			return nil, err
+		}
+		return valuePathFilter{attribute: attribute, filter: inner}, nil
+	}
+	operator := strings.ToLower(p.next())
+	if !operators[operator] {
+		return nil, fmt.Errorf("%w: unknown operator %q", ErrInvalidFilter, operator)
+	}
+	filter := attributeFilter{path: strings.Split(attribute, "."), operator: operator}
+	if operator == "pr" {
+		return filter, nil
+	}
+	value, err := parseValue(p.next())
+	if err != nil {
+		return nil, err
+	}
+	filter.value = value
+	return filter, nil
+}
+
+func isAttributePath(token string) bool {
+	if token == "" || !unicode.IsLetter(rune(token[0])) {
+		return false
+	}
+	for _, c := range token {
+		if !unicode.IsLetter(c) && !unicode.IsDigit(c) && !strings.ContainsRune(".:-_$", c) {
+			return false
+		}
+	}
+	return true
+}
+
+func parseValue(token string) (interface{}, error) {
+	switch strings.ToLower(token) {
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	case "null":
+		return nil, nil
+	}
+	if strings.HasPrefix(token, `"`) {
+		var value string
+		if err := json.Unmarshal([]byte(token), &value); err != nil {
+			return nil, fmt.Errorf("%w: invalid string %s", ErrInvalidFilter, token)
+		}
+		return value, nil
+	}
+	number, err := strconv.ParseFloat(token, 64)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid value %q", ErrInvalidFilter, token)
+	}
+	return number, nil
+}
@@ -0,0 +1,227 @@
+package scim
+
+import (
+	"fmt"
+	"strings"
+)
+
+// PatchRequest is the body of a PATCH request.
+type PatchRequest struct {
+	Schemas    []string         `json:"schemas"`
+	Operations []PatchOperation `json:"Operations"`
+}
+
+// PatchOperation is an add, replace or remove of the value at the path.
+type PatchOperation struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	Value interface{} `json:"value"`
+}
+
+// patchPath is a parsed path like name.givenName or
+// members[value eq "1"].display.
+type patchPath struct {
+	attribute    string
+	filter       Filter
+	subAttribute string
+}
+
+func parsePatchPath(path string) (*patchPath, error) {
+	path = stripSchema(strings.TrimSpace(path))
+	parsed := &patchPath{}
+	if open := strings.Index(path, "["); open >= 0 {
+		end := strings.LastIndex(path, "]")
+		if end < open {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidPath, path)
+		}
+		filter, err := ParseFilter(path[open+1 : end])
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidPath, err)
+		}
+		parsed.attribute = path[:open]
+		parsed.filter = filter
+		rest := path[end+1:]
+		if rest != "" {
+			if !strings.HasPrefix(rest, ".") {
+				return nil, fmt.Errorf("%w: %q", ErrInvalidPath, path)
+			}
+			parsed.subAttribute = rest[1:]
+		}
+	} else if attribute, subAttribute, found := strings.Cut(path, "."); found {
+		parsed.attribute = attribute
+		parsed.subAttribute = subAttribute
+	} else {
+		parsed.attribute = path
+	}
+	if !isAttributePath(parsed.attribute) || strings.Contains(parsed.subAttribute, ".") {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidPath, path)
+	}
+	return parsed, nil
+}
+
+// ApplyPatch applies the operations of a PATCH request to the generic JSON
+// form of a resource (RFC 7644 section 3.5.2). Complex values are merged into
+// complex attributes, values added to multi-valued attributes are appended.
+func ApplyPatch(resource map[string]interface{}, operations []PatchOperation) error {
+	for _, operation := range operations {
+		op := strings.ToLower(operation.Op)
+		if op != "add" && op != "replace" && op != "remove" {
+			return fmt.Errorf("%w: unknown operation %q", ErrInvalidValue, operation.Op)
+		}
+		if operation.Path == "" {
+			if op == "remove" {
+				return fmt.Errorf("%w: remove needs a path", ErrNoTarget)
+			}
+			values, ok := operation.Value.(map[string]interface{})
+			if !ok {
+				return fmt.Errorf("%w: operation without path needs an object", ErrInvalidValue)
+			}
+			for name, value := range values {
+				if err := applyOperation(resource, op, name, value); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		if err := applyOperation(resource, op, operation.Path, operation.Value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func applyOperation(resource map[string]interface{}, op string, path string, value interface{}) error {
+	parsed, err := parsePatchPath(path)
+	if err != nil {
+		return err
+	}
+	key, _ := findKey(resource, parsed.attribute)
+	if parsed.filter != nil {
+		return applyFiltered(resource, key, parsed, op, value)
+	}
+	if parsed.subAttribute != "" {
+		parent, ok := resource[key].(map[string]interface{})
+		if !ok {
+			if op == "remove" {
+				return nil
+			}
+			parent = map[string]interface{}{}
+			resource[key] = parent
+		}
+		return applyOperation(parent, op, parsed.subAttribute, value)
+	}
+	current := resource[key]
+	switch op {
+	case "remove":
+		// remove with a value removes the listed elements of a multi-valued
+		// attribute, e.g. members with their values
+		list, isList := current.([]interface{})
+		if removed, ok := value.([]interface{}); ok && isList {
+			resource[key] = removeElements(list, removed)
+			return nil
+		}
+		delete(resource, key)
+	case "add":
+		if list, ok := current.([]interface{}); ok {
+			resource[key] = appendElements(list, elements(value))
+			return nil
+		}
+		resource[key] = merge(current, value)
+	case "replace":
+		resource[key] = merge(current, value)
+	}
+	return nil
+}
+
+func applyFiltered(resource map[string]interface{}, key string, parsed *patchPath, op string, value interface{}) error {
+	list, _ := resource[key].([]interface{})
+	kept := []interface{}{}
+	matched := false
+	for _, element := range list {
+		m, ok := element.(map[string]interface{})
+		if !ok || !parsed.filter.Matches(m) {
+			kept = append(kept, element)
+			continue
+		}
+		matched = true
+		switch {
+		case parsed.subAttribute != "" && op == "remove":
+			if subKey, ok := findKey(m, parsed.subAttribute); ok {
+				delete(m, subKey)
+			}
+			kept = append(kept, m)
+		case parsed.subAttribute != "":
+			subKey, _ := findKey(m, parsed.subAttribute)
+			m[subKey] = merge(m[subKey], value)
+			kept = append(kept, m)
+		case op == "remove":
+		default:
+			kept = append(kept, merge(m, value))
+		}
+	}
+	if !matched {
+		// removing what is not there is done already
+		if op == "remove" {
+			return nil
+		}
+		return fmt.Errorf("%w: %s", ErrNoTarget, parsed.attribute)
+	}
+	resource[key] = kept
+	return nil
+}
+
+// merge returns the value replacing the current one, objects are merged
+// into objects.
+func merge(current interface{}, value interface{}) interface{} {
+	currentObject, ok := current.(map[string]interface{})
+	valueObject, isObject := value.(map[string]interface{})
+	if !ok || !isObject {
+		return value
+	}
+	for name, v := range valueObject {
+		key, _ := findKey(currentObject, name)
+		currentObject[key] = merge(currentObject[key], v)
+	}
+	return currentObject
+}
+
+// appendElements adds the values to a multi-valued attribute, skipping
+// elements with a value which is already there.
+func appendElements(list []interface{}, values []interface{}) []interface{} {
+	for _, value := range values {
+		if elementValue(value) != "" && indexOfValue(list, elementValue(value)) >= 0 {
+			continue
+		}
+		list = append(list, value)
+	}
+	return list
+}
+
+func removeElements(list []interface{}, removed []interface{}) []interface{} {
+	for _, value := range removed {
+		if index := indexOfValue(list, elementValue(value)); index >= 0 {
+			list = append(list[:index], list[index+1:]...)
+		}
+	}
+	return list
+}
+
+func indexOfValue(list []interface{}, value string) int {
+	for i, element := range list {
+		if elementValue(element) == value {
+			return i
+		}
+	}
+	return -1
+}
+
+// elementValue returns the value sub-attribute of an element of a
+// multi-valued attribute.
+func elementValue(element interface{}) string {
+	if m, ok := element.(map[string]interface{}); ok {
+		if value, ok := attributeValue(m, "value").(string); ok {
+			return value
+		}
+	}
+	return ""
+}
@@ -0,0 +1,327 @@
+package scim
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/go-auth-microservice/pkg/config"
+	"github.com/go-auth-microservice/pkg/utils/logger"
+)
+
+// Schemas of the SCIM 2.0 resources and messages (RFC 7643, RFC 7644).
+const (
+	SchemaUser                  = "urn:ietf:params:scim:schemas:core:2.0:User"
+	SchemaGroup                 = "urn:ietf:params:scim:schemas:core:2.0:Group"
+	SchemaListResponse          = "urn:ietf:params:scim:api:messages:2.0:ListResponse"
+	SchemaPatchOp               = "urn:ietf:params:scim:api:messages:2.0:PatchOp"
+	SchemaError                 = "urn:ietf:params:scim:api:messages:2.0:Error"
+	SchemaServiceProviderConfig = "urn:ietf:params:scim:schemas:core:2.0:ServiceProviderConfig"
+	SchemaResourceType          = "urn:ietf:params:scim:schemas:core:2.0:ResourceType"
+)
+
+// ContentType is the media type of SCIM requests and responses.
+const ContentType = "application/scim+json"
+
+// Values of scimType in error responses.
+const (
+	ErrorInvalidFilter = "invalidFilter"
+	ErrorInvalidPath   = "invalidPath"
+	ErrorInvalidValue  = "invalidValue"
+	ErrorInvalidSyntax = "invalidSyntax"
+	ErrorNoTarget      = "noTarget"
+	ErrorUniqueness    = "uniqueness"
+	ErrorMutability    = "mutability"
+)
+
+var (
+	ErrUnknownTenant = errors.New("unknown scim tenant")
+	ErrInvalidFilter = errors.New("invalid scim filter")
+	ErrInvalidPath   = errors.New("invalid scim path")
+	ErrInvalidValue  = errors.New("invalid scim value")
+	ErrNoTarget      = errors.New("scim path matches no value")
+)
+
+var tenantNamePattern = regexp.MustCompile(`^[a-z0-9-]{1,32}$`)
+
+// minTokenLength is the length a bearer token of a tenant needs at least
+const minTokenLength = 32
+
+// GroupRole maps the members of a group of the tenant to a role.
+type GroupRole struct {
+	Group string `json:"group"`
+	Role  string `json:"role"`
+}
+
+// TenantConfig is an entry of the SCIM_TENANTS file. Token is the bearer
+// token the identity provider of the tenant authenticates with, environment
+// variables in the file are expanded.
+type TenantConfig struct {
+	Name       string      `json:"name"`
+	Token      string      `json:"token"`
+	GroupRoles []GroupRole `json:"groupRoles"`
+}
+
+// Tenant is a customer whose identity provider provisions users through
+// SCIM. A tenant only sees the users and groups it has provisioned itself.
+type Tenant struct {
+	config    TenantConfig
+	tokenHash [sha256.Size]byte
+}
+
+var tenants []*Tenant
+var tenantsOnce sync.Once
+
+// LoadTenants reads the tenant configurations from a file.
+func LoadTenants(path string) ([]*Tenant, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var configs []TenantConfig
+	if err := json.Unmarshal([]byte(os.ExpandEnv(string(content))), &configs); err != nil {
+		return nil, err
+	}
+	loaded := []*Tenant{}
+	names := map[string]bool{}
+	for _, tenantConfig := range configs {
+		if !tenantNamePattern.MatchString(tenantConfig.Name) {
+			return nil, fmt.Errorf("invalid scim tenant name %q", tenantConfig.Name)
+		}
+		if names[tenantConfig.Name] {
+			return nil, fmt.Errorf("scim tenant %q is configured twice", tenantConfig.Name)
+		}
+		if len(tenantConfig.Token) < minTokenLength {
+			return nil, fmt.Errorf("token of scim tenant %q needs at least %d characters", tenantConfig.Name, minTokenLength)
+		}
+		names[tenantConfig.Name] = true
+		loaded = append(loaded, &Tenant{config: tenantConfig, tokenHash: sha256.Sum256([]byte(tenantConfig.Token))})
+	}
+	return loaded, nil
+}
+
+func getTenants() []*Tenant {
+	tenantsOnce.Do(func() {
+		tenants = []*Tenant{}
+		path := config.GetConfig().GetSCIMTenants()
+		if path == "" {
+			return
+		}
+		loaded, err := LoadTenants(path)
+		if err != nil {
+			logger.InitializeAppLogger().Errorf("unable to load scim tenants from %s %v", path, err)
+			return
+		}
+		tenants = loaded
+	})
+	return tenants
+}
+
+// Authenticate returns the tenant of the bearer token.
+func Authenticate(token string) (*Tenant, error) {
+	hash := sha256.Sum256([]byte(token))
+	var found *Tenant
+	// compare with every tenant so that the time does not tell which one matched
+	for _, tenant := range getTenants() {
+		if subtle.ConstantTimeCompare(hash[:], tenant.tokenHash[:]) == 1 {
+			found = tenant
+		}
+	}
+	if found == nil {
+		return nil, ErrUnknownTenant
+	}
+	return found, nil
+}
+
+func (t *Tenant) Name() string {
+	return t.config.Name
+}
+
+// RoleOf returns the role of the first group role of the tenant a group of
+// the user is mapped to, or the default role. It reports false if the tenant
+// maps no groups to roles, then the roles of its users are left alone.
+func (t *Tenant) RoleOf(groups []string, defaultRole string) (string, bool) {
+	if len(t.config.GroupRoles) == 0 {
+		return "", false
+	}
+	for _, groupRole := range t.config.GroupRoles {
+		for _, group := range groups {
+			if group == groupRole.Group {
+				return groupRole.Role, true
+			}
+		}
+	}
+	return defaultRole, true
+}
+
+// Boolean is a SCIM boolean. Some identity providers send booleans as the
+// strings "True" and "False".
+type Boolean bool
+
+func (b *Boolean) UnmarshalJSON(data []byte) error {
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+	switch v := value.(type) {
+	case bool:
+		*b = Boolean(v)
+	case string:
+		switch strings.ToLower(v) {
+		case "true":
+			*b = true
+		case "false":
+			*b = false
+		default:
+			return fmt.Errorf("%w: %q is not a boolean", ErrInvalidValue, v)
+		}
+	default:
+		return fmt.Errorf("%w: %s is not a boolean", ErrInvalidValue, data)
+	}
+	return nil
+}
+
+// Meta is the metadata of a resource.
+type Meta struct {
+	ResourceType string `json:"resourceType"`
+	Created      string `json:"created,omitempty"`
+	LastModified string `json:"lastModified,omitempty"`
+	Location     string `json:"location,omitempty"`
+	Version      string `json:"version,omitempty"`
+}
+
+// MultiValue is an element of a multi-valued attribute like emails or members.
+type MultiValue struct {
+	Value   string `json:"value"`
+	Display string `json:"display,omitempty"`
+	Type    string `json:"type,omitempty"`
+	Primary bool   `json:"primary,omitempty"`
+	Ref     string `json:"$ref,omitempty"`
+}
+
+// Name is the name of a user.
+type Name struct {
+	Formatted  string `json:"formatted,omitempty"`
+	GivenName  string `json:"givenName,omitempty"`
+	FamilyName string `json:"familyName,omitempty"`
+}
+
+// User is the SCIM representation of a user. UserName is the email of the
+// user. Groups and roles are read only.
+type User struct {
+	Schemas     []string     `json:"schemas"`
+	Id          string       `json:"id,omitempty"`
+	ExternalId  string       `json:"externalId,omitempty"`
+	UserName    string       `json:"userName"`
+	Name        *Name        `json:"name,omitempty"`
+	DisplayName string       `json:"displayName,omitempty"`
+	Locale      string       `json:"locale,omitempty"`
+	Timezone    string       `json:"timezone,omitempty"`
+	Active      *Boolean     `json:"active,omitempty"`
+	Emails      []MultiValue `json:"emails,omitempty"`
+	Groups      []MultiValue `json:"groups,omitempty"`
+	Roles       []MultiValue `json:"roles,omitempty"`
+	Meta        *Meta        `json:"meta,omitempty"`
+}
+
+// Group is the SCIM representation of a group. The values of its members are
+// ids of users.
+type Group struct {
+	Schemas     []string     `json:"schemas"`
+	Id          string       `json:"id,omitempty"`
+	ExternalId  string       `json:"externalId,omitempty"`
+	DisplayName string       `json:"displayName"`
+	Members     []MultiValue `json:"members"`
+	Meta        *Meta        `json:"meta,omitempty"`
+}
+
+// ListResponse is a page of the resources matching a query.
+type ListResponse struct {
+	Schemas      []string                 `json:"schemas"`
+	TotalResults int                      `json:"totalResults"`
+	StartIndex   int                      `json:"startIndex"`
+	ItemsPerPage int                      `json:"itemsPerPage"`
+	Resources    []map[string]interface{} `json:"Resources"`
+}
+
+// Error is a SCIM error response.
+type Error struct {
+	Schemas  []string `json:"schemas"`
+	Status   string   `json:"status"`
+	ScimType string   `json:"scimType,omitempty"`
+	Detail   string   `json:"detail,omitempty"`
+}
+
+// ToMap converts a resource to its generic JSON form, which filters and
+// patches work on.
+func ToMap(resource interface{}) (map[string]interface{}, error) {
+	content, err := json.Marshal(resource)
+	if err != nil {
+		return nil, err
+	}
+	var m map[string]interface{}
+	if err := json.Unmarshal(content, &m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// FromMap converts the generic JSON form of a resource back into the
+// resource.
+func FromMap(m map[string]interface{}, resource interface{}) error {
+	content, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(content, resource); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidValue, err)
+	}
+	return nil
+}
+
+// ExcludeAttributes removes the comma separated top level attributes of
+// excludedAttributes from the resource. Id and schemas are always returned.
+func ExcludeAttributes(resource map[string]interface{}, excludedAttributes string) {
+	for _, attribute := range strings.Split(excludedAttributes, ",") {
+		attribute = stripSchema(strings.TrimSpace(attribute))
+		if strings.EqualFold(attribute, "id") || strings.EqualFold(attribute, "schemas") {
+			continue
+		}
+		if key, ok := findKey(resource, attribute); ok {
+			delete(resource, key)
+		}
+	}
+}
+
+// findKey returns the key of the map matching the attribute name, attribute
+// names are case insensitive.
+func findKey(m map[string]interface{}, name string) (string, bool) {
+	if _, ok := m[name]; ok {
+		return name, true
+	}
+	for key := range m {
+		if strings.EqualFold(key, name) {
+			return key, true
+		}
+	}
+	return name, false
+}
+
+// stripSchema removes the schema URN of a fully qualified attribute path
+// like urn:ietf:params:scim:schemas:core:2.0:User:userName.
+func stripSchema(path string) string {
+	if !strings.HasPrefix(strings.ToLower(path), "urn:") {
+		return path
+	}
+	attribute := path
+	if index := strings.Index(attribute, "["); index >= 0 {
+		attribute = attribute[:index]
+	}
+	return path[strings.LastIndex(attribute, ":")+1:]
+}
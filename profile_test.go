@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// patchProfile sends a merge patch to the profile endpoint
+func patchProfile(router http.Handler, token string, ifMatch string, patch string) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest("PATCH", "/api/v1/user", bytes.NewBufferString(patch))
+	req.Header.Set("Content-Type", "application/merge-patch+json")
+	req.Header.Set("Authorization", token)
+	if ifMatch != "" {
+		req.Header.Set("If-Match", ifMatch)
+	}
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	return rr
+}
+
+// TestUserProfile tests partial profile updates with optimistic concurrency
+func TestUserProfile(t *testing.T) {
+	testRouter := setupTestRouter()
+	protectedRouter := setupProtectedTestRouter()
+
+	user := TestUser{Email: "profile@example.com", Password: "password123"}
+	assert.Equal(t, http.StatusOK, signupTestUser(testRouter, user).Code)
+	tokens := loginTokens(t, testRouter, user)
+
+	rr := protectedRequest(protectedRouter, "GET", "/api/v1/user", tokens.AccessToken, nil)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	etag := rr.Header().Get("ETag")
+	assert.NotEmpty(t, etag, "ETag header should be set")
+
+	t.Run("Update profile", func(t *testing.T) {
+		patch := `{"displayName": "Jane", "locale": "en-US", "timezone": "Europe/Berlin", "metadata": {"team": "core", "prefs": {"theme": "dark", "beta": true}}}`
+		rr := patchProfile(protectedRouter, tokens.AccessToken, etag, patch)
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.NotEqual(t, etag, rr.Header().Get("ETag"), "ETag should change")
+
+		var response map[string]interface{}
+		assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+		assert.Equal(t, "Jane", response["displayName"])
+		assert.Equal(t, "Europe/Berlin", response["timezone"])
+
+		getRR := protectedRequest(protectedRouter, "GET", "/api/v1/user", tokens.AccessToken, nil)
+		assert.Equal(t, rr.Header().Get("ETag"), getRR.Header().Get("ETag"), "ETag should match the stored user")
+	})
+
+	t.Run("Stale ETag is rejected", func(t *testing.T) {
+		rr := patchProfile(protectedRouter, tokens.AccessToken, etag, `{"displayName": "John"}`)
+		assert.Equal(t, http.StatusPreconditionFailed, rr.Code)
+	})
+
+	t.Run("Merge patch removes null members", func(t *testing.T) {
+		rr := patchProfile(protectedRouter, tokens.AccessToken, "", `{"displayName": null, "metadata": {"prefs": {"beta": null}}}`)
+		assert.Equal(t, http.StatusOK, rr.Code)
+		var response map[string]interface{}
+		assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+		assert.Equal(t, "", response["displayName"])
+		assert.Equal(t, "Europe/Berlin", response["timezone"], "Members missing in the patch should be kept")
+		assert.Equal(t, map[string]interface{}{"team": "core", "prefs": map[string]interface{}{"theme": "dark"}}, response["metadata"])
+	})
+
+	t.Run("Invalid values are rejected", func(t *testing.T) {
+		assert.Equal(t, http.StatusBadRequest, patchProfile(protectedRouter, tokens.AccessToken, "", `{"timezone": "Mars/Olympus"}`).Code)
+		assert.Equal(t, http.StatusBadRequest, patchProfile(protectedRouter, tokens.AccessToken, "", `{"avatarUrl": "not a url"}`).Code)
+		assert.Equal(t, http.StatusBadRequest, patchProfile(protectedRouter, tokens.AccessToken, "", `{"avatarUrl": "javascript:alert(1)"}`).Code)
+		assert.Equal(t, http.StatusBadRequest, patchProfile(protectedRouter, tokens.AccessToken, "", `{"avatarUrl": "data:image/png;base64,AAAA"}`).Code)
+		assert.Equal(t, http.StatusBadRequest, patchProfile(protectedRouter, tokens.AccessToken, "", `{"email": "other@example.com"}`).Code)
+		assert.Equal(t, http.StatusBadRequest, patchProfile(protectedRouter, tokens.AccessToken, "", `["displayName"]`).Code)
+	})
+
+	t.Run("Profile update keeps sessions valid", func(t *testing.T) {
+		req, _ := http.NewRequest("GET", "/api/v1/auth/token", nil)
+		req.Header.Set("RefreshToken", tokens.RefreshToken)
+		rr := httptest.NewRecorder()
+		testRouter.ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusOK, rr.Code)
+	})
+}
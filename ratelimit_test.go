@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	rateLimitMiddleware "github.com/go-auth-microservice/pkg/middleware/rateLimit"
+	"github.com/go-auth-microservice/pkg/utils/db"
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+)
+
+// setupRateLimitRouter returns a router with a rate limited login route
+func setupRateLimitRouter(store rateLimitMiddleware.Store) *chi.Mux {
+	router := chi.NewRouter()
+	router.With(rateLimitMiddleware.RateLimit(store, "login",
+		rateLimitMiddleware.Rule{Name: "ip", Key: rateLimitMiddleware.ByIP, Limit: rateLimitMiddleware.Limit{Requests: 4, Per: time.Minute}},
+		rateLimitMiddleware.Rule{Name: "email", Key: rateLimitMiddleware.ByEmail, Limit: rateLimitMiddleware.Limit{Requests: 2, Per: time.Minute}},
+	)).Post("/login", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	return router
+}
+
+func rateLimitedRequest(router http.Handler, ip string, email string) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest("POST", "/login", bytes.NewBufferString(`{"email":"`+email+`"}`))
+	req.RemoteAddr = ip + ":1234"
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	return rr
+}
+
+// TestRateLimit tests the token bucket limits of the in-memory and database stores
+func TestRateLimit(t *testing.T) {
+	dbStore, err := rateLimitMiddleware.NewDBStore(db.GetDBConn())
+	assert.NoError(t, err)
+	stores := map[string]rateLimitMiddleware.Store{
+		"memory":   rateLimitMiddleware.NewMemoryStore(),
+		"database": dbStore,
+	}
+
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			router := setupRateLimitRouter(store)
+			ip := "10.0.0.1"
+			if name == "database" {
+				ip = "10.0.0.2"
+			}
+
+			t.Run("Limit by email", func(t *testing.T) {
+				assert.Equal(t, http.StatusOK, rateLimitedRequest(router, ip, "a@example.com").Code)
+				assert.Equal(t, http.StatusOK, rateLimitedRequest(router, ip, "A@example.com").Code)
+				rr := rateLimitedRequest(router, ip, "a@example.com")
+				assert.Equal(t, http.StatusTooManyRequests, rr.Code)
+				assert.NotEmpty(t, rr.Header().Get("Retry-After"), "Retry-After header should be set")
+			})
+
+			t.Run("Limit by IP", func(t *testing.T) {
+				assert.Equal(t, http.StatusOK, rateLimitedRequest(router, ip, "b@example.com").Code)
+				assert.Equal(t, http.StatusTooManyRequests, rateLimitedRequest(router, ip, "c@example.com").Code)
+				assert.Equal(t, http.StatusOK, rateLimitedRequest(router, ip+"0", "c@example.com").Code, "Other IPs should not be limited")
+			})
+		})
+	}
+}
+
+// TestRateLimitBuckets tests that the database store prunes refilled buckets
+func TestRateLimitBuckets(t *testing.T) {
+	store, err := rateLimitMiddleware.NewDBStore(db.GetDBConn())
+	assert.NoError(t, err)
+	limit := rateLimitMiddleware.Limit{Requests: 2, Per: time.Minute}
+	for _, key := range []string{"prune:refilled", "prune:used"} {
+		allowed, _, err := store.Take(key, limit)
+		assert.NoError(t, err)
+		assert.True(t, allowed)
+	}
+	refilledAt := time.Now().Add(-time.Hour)
+	assert.NoError(t, db.GetDBConn().GetDB().Model(&rateLimitMiddleware.RateLimitBucket{}).
+		Where("bucket_key = ?", "prune:refilled").Updates(map[string]interface{}{"updated_at": refilledAt, "full_at": refilledAt}).Error)
+
+	cleaner, ok := store.(interface{ Clean(time.Time) error })
+	if assert.True(t, ok) {
+		assert.NoError(t, cleaner.Clean(time.Now()))
+	}
+	var keys []string
+	assert.NoError(t, db.GetDBConn().GetDB().Model(&rateLimitMiddleware.RateLimitBucket{}).
+		Where("bucket_key LIKE ?", "prune:%").Pluck("bucket_key", &keys).Error)
+	assert.Equal(t, []string{"prune:used"}, keys)
+}
+
+// TestRateLimitByEmailBodySize tests that ByEmail does not read large bodies
+func TestRateLimitByEmailBodySize(t *testing.T) {
+	body := `{"email":"large@example.com","padding":"` + strings.Repeat("a", 128*1024) + `"}`
+	req, _ := http.NewRequest("POST", "/login", bytes.NewBufferString(body))
+	assert.Empty(t, rateLimitMiddleware.ByEmail(req))
+	_, err := io.ReadAll(req.Body)
+	assert.Error(t, err, "The handler should not be able to read the body either")
+
+	req, _ = http.NewRequest("POST", "/login", bytes.NewBufferString(`{"email":"Small@example.com"}`))
+	assert.Equal(t, "small@example.com", rateLimitMiddleware.ByEmail(req))
+	restored, err := io.ReadAll(req.Body)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"email":"Small@example.com"}`, string(restored))
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"regexp"
+	"testing"
+
+	auditmodel "github.com/go-auth-microservice/pkg/model/auditModel"
+	usermodel "github.com/go-auth-microservice/pkg/model/userModel"
+	"github.com/go-auth-microservice/pkg/utils/mailer"
+	"github.com/go-auth-microservice/pkg/utils/risk"
+	"github.com/stretchr/testify/assert"
+)
+
+// testGeoIPNetworks are the networks of the GeoIP test database
+var testGeoIPNetworks = map[string]risk.Location{
+	"10.10.0.0/16": {Country: "DE", Latitude: 52.52, Longitude: 13.40},  // Berlin
+	"10.20.0.0/16": {Country: "US", Latitude: 40.71, Longitude: -74.00}, // New York
+	"10.30.0.0/16": {Country: "DE", Latitude: 52.39, Longitude: 13.06},  // Potsdam
+}
+
+var loginCodePattern = regexp.MustCompile(`\b[0-9]{6}\b`)
+
+// mmdbValue encodes a value of the MaxMind DB data section
+func mmdbValue(buf *bytes.Buffer, value interface{}) {
+	control := func(dataType int, size int) {
+		if dataType > 7 {
+			buf.WriteByte(byte(size))
+			buf.WriteByte(byte(dataType - 7))
+			return
+		}
+		buf.WriteByte(byte(dataType<<5 | size))
+	}
+	switch v := value.(type) {
+	case string:
+		control(2, len(v))
+		buf.WriteString(v)
+	case float64:
+		control(3, 8)
+		_ = binary.Write(buf, binary.BigEndian, math.Float64bits(v))
+	case uint16:
+		control(5, 2)
+		_ = binary.Write(buf, binary.BigEndian, v)
+	case uint32:
+		control(6, 4)
+		_ = binary.Write(buf, binary.BigEndian, v)
+	case uint64:
+		control(9, 8)
+		_ = binary.Write(buf, binary.BigEndian, v)
+	case []string:
+		control(11, len(v))
+		for _, item := range v {
+			mmdbValue(buf, item)
+		}
+	case [][2]interface{}:
+		control(7, len(v))
+		for _, pair := range v {
+			mmdbValue(buf, pair[0])
+			mmdbValue(buf, pair[1])
+		}
+	}
+}
+
+// writeTestGeoIPDatabase writes an IPv4 MaxMind DB with 24 bit records
+func writeTestGeoIPDatabase(path string, networks map[string]risk.Location) error {
+	type node [2]int // child node, -1 for empty, -2-offset for data
+	nodes := []node{{-1, -1}}
+	data := &bytes.Buffer{}
+	for cidr, location := range networks {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return err
+		}
+		offset := data.Len()
+		mmdbValue(data, [][2]interface{}{
+			{"country", [][2]interface{}{{"iso_code", location.Country}}},
+			{"location", [][2]interface{}{{"latitude", location.Latitude}, {"longitude", location.Longitude}}},
+		})
+		ones, _ := network.Mask.Size()
+		ip := network.IP.To4()
+		current := 0
+		for i := 0; i < ones; i++ {
+			bit := int(ip[i/8]>>(7-i%8)) & 1
+			if i == ones-1 {
+				nodes[current][bit] = -2 - offset
+				break
+			}
+			if nodes[current][bit] < 0 {
+				nodes = append(nodes, node{-1, -1})
+				nodes[current][bit] = len(nodes) - 1
+			}
+			current = nodes[current][bit]
+		}
+	}
+	nodeCount := len(nodes)
+	file := &bytes.Buffer{}
+	for _, n := range nodes {
+		for _, record := range n {
+			value := record
+			switch {
+			case record == -1:
+				value = nodeCount
+			case record < -1:
+				value = nodeCount + 16 + (-2 - record)
+			}
+			file.Write([]byte{byte(value >> 16), byte(value >> 8), byte(value)})
+		}
+	}
+	file.Write(make([]byte, 16))
+	file.Write(data.Bytes())
+	file.WriteString("\xab\xcd\xefMaxMind.com")
+	mmdbValue(file, [][2]interface{}{
+		{"binary_format_major_version", uint16(2)},
+		{"binary_format_minor_version", uint16(0)},
+		{"build_epoch", uint64(1700000000)},
+		{"database_type", "Test-City"},
+		{"description", [][2]interface{}{{"en", "test database"}}},
+		{"ip_version", uint16(4)},
+		{"languages", []string{"en"}},
+		{"node_count", uint32(nodeCount)},
+		{"record_size", uint16(24)},
+	})
+	return os.WriteFile(path, file.Bytes(), 0600)
+}
+
+// TestRiskBasedAuth tests challenging and blocking risky logins
+func TestRiskBasedAuth(t *testing.T) {
+	testRouter := setupTestRouter()
+	m := &testMailer{}
+	mailer.SetMailer(m)
+
+	user := TestUser{Email: "traveller@example.com", Password: "password123"}
+	assert.Equal(t, http.StatusOK, signupTestUser(testRouter, user).Code)
+	userData, err := usermodel.FindUserByEmail(user.Email)
+	assert.NoError(t, err)
+
+	t.Run("Locate IP addresses", func(t *testing.T) {
+		location, err := risk.GetLocator().Locate("10.20.1.2")
+		assert.NoError(t, err)
+		if assert.NotNil(t, location) {
+			assert.Equal(t, "US", location.Country)
+		}
+		location, err = risk.GetLocator().Locate("10.40.1.2")
+		assert.NoError(t, err)
+		assert.Nil(t, location)
+		assert.InDelta(t, 6385, risk.Distance(&risk.Location{Latitude: 52.52, Longitude: 13.40}, &risk.Location{Latitude: 40.71, Longitude: -74.00}), 10)
+	})
+
+	t.Run("Usual logins are allowed", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, loginFrom(testRouter, user, "10.10.0.1", "Firefox").Code)
+		// a new device close by is not enough for a challenge
+		assert.Equal(t, http.StatusOK, loginFrom(testRouter, user, "10.30.0.1", "Firefox").Code)
+	})
+
+	var challengeToken string
+	t.Run("Impossible travel is challenged", func(t *testing.T) {
+		rr := loginFrom(testRouter, user, "10.20.0.1", "Firefox")
+		assert.Equal(t, http.StatusUnauthorized, rr.Code)
+		var res map[string]interface{}
+		assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &res))
+		assert.Equal(t, "step_up_required", res["error"])
+		assert.NotContains(t, res, "accesstoken")
+		challengeToken, _ = res["challengeToken"].(string)
+		assert.NotEmpty(t, challengeToken)
+	})
+
+	t.Run("Complete the challenge with the emailed code", func(t *testing.T) {
+		mail := m.lastTo(user.Email)
+		assert.Equal(t, "Confirm your login", mail.Subject)
+		code := loginCodePattern.FindString(mail.Body)
+		assert.Len(t, code, 6)
+
+		complete := func(code string) int {
+			body := map[string]string{"challengeToken": challengeToken, "code": code}
+			return protectedRequest(testRouter, "POST", "/api/v1/auth/login/challenge", "", body).Code
+		}
+		wrong := "000000"
+		if code == wrong {
+			wrong = "111111"
+		}
+		assert.Equal(t, http.StatusUnauthorized, complete(wrong))
+		assert.Equal(t, http.StatusOK, complete(code))
+		assert.Equal(t, http.StatusUnauthorized, complete(code), "a challenge can only be completed once")
+	})
+
+	t.Run("Listed IP with failed logins is blocked", func(t *testing.T) {
+		wrong := TestUser{Email: user.Email, Password: "wrongpassword"}
+		assert.Equal(t, http.StatusUnauthorized, loginFrom(testRouter, wrong, "10.10.0.1", "Firefox").Code)
+		assert.Equal(t, http.StatusUnauthorized, loginFrom(testRouter, wrong, "10.10.0.1", "Firefox").Code)
+		rr := loginFrom(testRouter, user, "198.51.100.7", "curl")
+		assert.Equal(t, http.StatusForbidden, rr.Code)
+		assert.Contains(t, rr.Body.String(), "login_blocked")
+	})
+
+	t.Run("Decisions are audited", func(t *testing.T) {
+		events, err := auditmodel.FindEventsByUserID(userData.Id)
+		assert.NoError(t, err)
+		var decisions []interface{}
+		for _, event := range events {
+			if event.Action == auditmodel.ActionLoginRiskAssessed {
+				decisions = append(decisions, event.Details["decision"])
+			}
+		}
+		assert.Equal(t, []interface{}{risk.Allow, risk.Allow, risk.Challenge, risk.Block}, decisions)
+		last := events[len(events)-1]
+		assert.Equal(t, auditmodel.ActionLoginRiskAssessed, last.Action)
+		assert.Contains(t, last.Details["reasons"], risk.ReasonListedIP)
+		assert.Contains(t, last.Details["reasons"], risk.ReasonFailedLogins)
+
+		history, err := userData.FindLoginHistory(1, 1)
+		assert.NoError(t, err)
+		assert.Equal(t, usermodel.LoginBlocked, history.Events[0].Outcome)
+	})
+}
@@ -0,0 +1,398 @@
+package main
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"encoding/xml"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/beevik/etree"
+	"github.com/crewjam/saml"
+	authMiddleware "github.com/go-auth-microservice/pkg/middleware/auth"
+	usermodel "github.com/go-auth-microservice/pkg/model/userModel"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	testSAMLTenant      = "acme"
+	testSAMLAdminGroup  = "auth-admins"
+	testSAMLIdPEntityID = "https://idp.acme.example.com/metadata"
+)
+
+// testSAMLIdP is the identity provider of the SAML tenant of the tests,
+// started by TestMain
+var testSAMLIdP *mockSAMLIdP
+
+// testSAMLSPCertificate is the certificate the service provider signs its
+// AuthnRequests with
+var testSAMLSPCertificate *x509.Certificate
+
+// newTestCertificate returns a key and a self-signed certificate of it
+func newTestCertificate(commonName string) (*rsa.PrivateKey, *x509.Certificate, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	certificate, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, nil, err
+	}
+	return key, certificate, nil
+}
+
+// writeSAMLServiceProviderKeys writes the key pair of the service provider
+// in PEM files
+func writeSAMLServiceProviderKeys(certificatePath string, keyPath string) error {
+	key, certificate, err := newTestCertificate("auth service provider")
+	if err != nil {
+		return err
+	}
+	testSAMLSPCertificate = certificate
+	certificatePEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certificate.Raw})
+	if err := os.WriteFile(certificatePath, certificatePEM, 0600); err != nil {
+		return err
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	return os.WriteFile(keyPath, keyPEM, 0600)
+}
+
+// mockSAMLIdP answers AuthnRequests of the service provider with assertions
+// signed by a locally generated key.
+type mockSAMLIdP struct {
+	idp *saml.IdentityProvider
+}
+
+func newMockSAMLIdP() (*mockSAMLIdP, error) {
+	key, certificate, err := newTestCertificate("acme identity provider")
+	if err != nil {
+		return nil, err
+	}
+	metadataURL, _ := url.Parse(testSAMLIdPEntityID)
+	ssoURL, _ := url.Parse("https://idp.acme.example.com/sso")
+	mock := &mockSAMLIdP{}
+	mock.idp = &saml.IdentityProvider{
+		Key:                     key,
+		Certificate:             certificate,
+		MetadataURL:             *metadataURL,
+		SSOURL:                  *ssoURL,
+		ServiceProviderProvider: mock,
+		AssertionMaker:          saml.DefaultAssertionMaker{},
+	}
+	return mock, nil
+}
+
+// writeTenants writes the IdP metadata and the SAML_TENANTS file
+func (m *mockSAMLIdP) writeTenants(metadataPath string, tenantsPath string) error {
+	metadata, err := xml.Marshal(m.idp.Metadata())
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(metadataPath, metadata, 0600); err != nil {
+		return err
+	}
+	tenants := []map[string]interface{}{{
+		"name":         testSAMLTenant,
+		"metadataFile": metadataPath,
+		"emailDomains": []string{"acme.example.com"},
+		"createUsers":  true,
+		"attributes":   map[string]string{"email": "email", "displayName": "displayName", "groups": "groups"},
+		"groupRoles":   []map[string]string{{"group": testSAMLAdminGroup, "role": usermodel.RoleAdmin}},
+	}}
+	content, err := json.Marshal(tenants)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(tenantsPath, content, 0600)
+}
+
+// GetServiceProvider reads the metadata of the service provider from its
+// metadata route.
+func (m *mockSAMLIdP) GetServiceProvider(r *http.Request, serviceProviderID string) (*saml.EntityDescriptor, error) {
+	req, _ := http.NewRequest("GET", "/api/v1/auth/saml/"+testSAMLTenant+"/metadata", nil)
+	rr := httptest.NewRecorder()
+	setupTestRouter().ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		return nil, fmt.Errorf("service provider metadata answered %d", rr.Code)
+	}
+	var metadata saml.EntityDescriptor
+	if err := xml.Unmarshal(rr.Body.Bytes(), &metadata); err != nil {
+		return nil, err
+	}
+	if metadata.EntityID != serviceProviderID {
+		return nil, os.ErrNotExist
+	}
+	return &metadata, nil
+}
+
+// samlUser is a user as the mock identity provider asserts them
+type samlUser struct {
+	NameID      string
+	Email       string
+	DisplayName string
+	Groups      []string
+}
+
+// respond answers the AuthnRequest of the redirect URL with a signed
+// assertion of the user and returns the form the browser posts to the
+// assertion consumer service. Modify changes the assertion before it is
+// signed.
+func (m *mockSAMLIdP) respond(t *testing.T, authURL string, user samlUser, modify func(*saml.Assertion)) url.Values {
+	req, err := saml.NewIdpAuthnRequest(m.idp, httptest.NewRequest("GET", authURL, nil))
+	assert.NoError(t, err)
+	assert.NoError(t, req.Validate())
+	groups := []saml.AttributeValue{}
+	for _, group := range user.Groups {
+		groups = append(groups, saml.AttributeValue{Type: "xs:string", Value: group})
+	}
+	session := &saml.Session{
+		NameID:       user.NameID,
+		NameIDFormat: string(saml.PersistentNameIDFormat),
+		CustomAttributes: []saml.Attribute{
+			{Name: "email", Values: []saml.AttributeValue{{Type: "xs:string", Value: user.Email}}},
+			{Name: "displayName", Values: []saml.AttributeValue{{Type: "xs:string", Value: user.DisplayName}}},
+			{Name: "groups", Values: groups},
+		},
+	}
+	assert.NoError(t, m.idp.AssertionMaker.MakeAssertion(req, session))
+	if modify != nil {
+		modify(req.Assertion)
+	}
+	assert.NoError(t, req.MakeResponse())
+	doc := etree.NewDocument()
+	doc.SetRoot(req.ResponseEl)
+	response, err := doc.WriteToBytes()
+	assert.NoError(t, err)
+	form := url.Values{}
+	form.Set("SAMLResponse", base64.StdEncoding.EncodeToString(response))
+	form.Set("RelayState", req.RelayState)
+	return form
+}
+
+// startSAMLLogin starts a SAML login and returns the URL of the identity
+// provider and the state cookie.
+func startSAMLLogin(t *testing.T, router http.Handler, query string) (string, *http.Cookie) {
+	req, _ := http.NewRequest("GET", "/api/v1/auth/saml/"+testSAMLTenant+query, nil)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusFound, rr.Code)
+	return rr.Header().Get("Location"), findCookie(rr.Result().Cookies(), authMiddleware.SAMLStateCookie)
+}
+
+// postSAMLResponse posts the form of the identity provider to the assertion
+// consumer service.
+func postSAMLResponse(router http.Handler, form url.Values, stateCookie *http.Cookie) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest("POST", "/api/v1/auth/saml/"+testSAMLTenant+"/acs", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if stateCookie != nil {
+		req.AddCookie(stateCookie)
+	}
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	return rr
+}
+
+// samlLogin logs the user in through the mock identity provider
+func samlLogin(t *testing.T, router http.Handler, user samlUser, modify func(*saml.Assertion)) *httptest.ResponseRecorder {
+	authURL, stateCookie := startSAMLLogin(t, router, "")
+	return postSAMLResponse(router, testSAMLIdP.respond(t, authURL, user, modify), stateCookie)
+}
+
+func TestSAMLLogin(t *testing.T) {
+	testRouter := setupTestRouter()
+	alice := samlUser{NameID: "acme-0001", Email: "alice@acme.example.com", DisplayName: "Alice Acme", Groups: []string{"staff", testSAMLAdminGroup}}
+
+	t.Run("Service provider metadata", func(t *testing.T) {
+		req, _ := http.NewRequest("GET", "/api/v1/auth/saml/"+testSAMLTenant+"/metadata", nil)
+		rr := httptest.NewRecorder()
+		testRouter.ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.Equal(t, "application/samlmetadata+xml", rr.Header().Get("Content-Type"))
+		var metadata saml.EntityDescriptor
+		assert.NoError(t, xml.Unmarshal(rr.Body.Bytes(), &metadata))
+		assert.Equal(t, "http://localhost:8080/api/v1/auth/saml/acme/metadata", metadata.EntityID)
+		if assert.Len(t, metadata.SPSSODescriptors, 1) {
+			assert.Equal(t, "http://localhost:8080/api/v1/auth/saml/acme/acs", metadata.SPSSODescriptors[0].AssertionConsumerServices[0].Location)
+		}
+
+		req, _ = http.NewRequest("GET", "/api/v1/auth/saml/unknown/metadata", nil)
+		rr = httptest.NewRecorder()
+		testRouter.ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusNotFound, rr.Code)
+	})
+
+	t.Run("List tenants", func(t *testing.T) {
+		req, _ := http.NewRequest("GET", "/api/v1/auth/saml", nil)
+		rr := httptest.NewRecorder()
+		testRouter.ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.Contains(t, rr.Body.String(), testSAMLTenant)
+	})
+
+	t.Run("AuthnRequest is signed", func(t *testing.T) {
+		authURL, stateCookie := startSAMLLogin(t, testRouter, "")
+		assert.True(t, strings.HasPrefix(authURL, "https://idp.acme.example.com/sso?"))
+		if assert.NotNil(t, stateCookie) {
+			assert.Equal(t, http.SameSiteNoneMode, stateCookie.SameSite)
+			assert.True(t, stateCookie.Secure)
+			assert.True(t, stateCookie.HttpOnly)
+		}
+		rawQuery := strings.SplitN(authURL, "?", 2)[1]
+		signed, encodedSignature, found := strings.Cut(rawQuery, "&Signature=")
+		assert.True(t, found)
+		signatureValue, err := url.QueryUnescape(encodedSignature)
+		assert.NoError(t, err)
+		signature, err := base64.StdEncoding.DecodeString(signatureValue)
+		assert.NoError(t, err)
+		digest := sha256.Sum256([]byte(signed))
+		publicKey := testSAMLSPCertificate.PublicKey.(*rsa.PublicKey)
+		assert.NoError(t, rsa.VerifyPKCS1v15(publicKey, crypto.SHA256, digest[:], signature))
+
+		// a changed request does not match the signature
+		tampered := strings.Replace(signed, "RelayState=", "RelayState=x", 1)
+		tamperedDigest := sha256.Sum256([]byte(tampered))
+		assert.Error(t, rsa.VerifyPKCS1v15(publicKey, crypto.SHA256, tamperedDigest[:], signature))
+	})
+
+	t.Run("First login creates the user", func(t *testing.T) {
+		rr := samlLogin(t, testRouter, alice, nil)
+		assert.Equal(t, http.StatusOK, rr.Code)
+		var tokens TestResponse
+		assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &tokens))
+		assert.NotEmpty(t, tokens.RefreshToken)
+		assert.Equal(t, usermodel.RoleAdmin, tokenRole(t, tokens.AccessToken))
+
+		user, err := usermodel.FindUserByEmail(alice.Email)
+		assert.NoError(t, err)
+		assert.Equal(t, alice.DisplayName, user.DisplayName)
+		assert.NotNil(t, user.EmailVerifiedAt)
+		assert.False(t, user.HasPassword())
+		identities, err := user.FindIdentities()
+		assert.NoError(t, err)
+		if assert.Len(t, identities, 1) {
+			assert.Equal(t, usermodel.SAMLProvider(testSAMLTenant), identities[0].Provider)
+			assert.Equal(t, alice.NameID, identities[0].Subject)
+		}
+		history, err := user.FindLoginHistory(1, 1)
+		assert.NoError(t, err)
+		if assert.NotEmpty(t, history.Events) {
+			assert.Equal(t, "saml:acme", history.Events[0].Method)
+		}
+	})
+
+	t.Run("Role follows the groups of the assertion", func(t *testing.T) {
+		demoted := alice
+		demoted.Groups = []string{"staff"}
+		rr := samlLogin(t, testRouter, demoted, nil)
+		assert.Equal(t, http.StatusOK, rr.Code)
+		var tokens TestResponse
+		assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &tokens))
+		assert.Equal(t, usermodel.RoleUser, tokenRole(t, tokens.AccessToken))
+
+		user, err := usermodel.FindUserByEmail(alice.Email)
+		assert.NoError(t, err)
+		assert.Equal(t, usermodel.RoleUser, user.Role)
+	})
+
+	t.Run("Assertion signed by another key", func(t *testing.T) {
+		impostor, err := newMockSAMLIdP()
+		assert.NoError(t, err)
+		authURL, stateCookie := startSAMLLogin(t, testRouter, "")
+		rr := postSAMLResponse(testRouter, impostor.respond(t, authURL, alice, nil), stateCookie)
+		assert.Equal(t, http.StatusUnauthorized, rr.Code)
+		assert.Contains(t, rr.Body.String(), "invalid_response")
+	})
+
+	t.Run("Assertion for another service provider", func(t *testing.T) {
+		rr := samlLogin(t, testRouter, alice, func(assertion *saml.Assertion) {
+			assertion.Conditions.AudienceRestrictions = []saml.AudienceRestriction{{Audience: saml.Audience{Value: "https://other.example.com/saml"}}}
+		})
+		assert.Equal(t, http.StatusUnauthorized, rr.Code)
+		assert.Contains(t, rr.Body.String(), "invalid_response")
+	})
+
+	t.Run("Expired assertion", func(t *testing.T) {
+		rr := samlLogin(t, testRouter, alice, func(assertion *saml.Assertion) {
+			expired := time.Now().Add(-time.Hour)
+			assertion.Conditions.NotOnOrAfter = expired
+			for i := range assertion.Subject.SubjectConfirmations {
+				assertion.Subject.SubjectConfirmations[i].SubjectConfirmationData.NotOnOrAfter = expired
+			}
+		})
+		assert.Equal(t, http.StatusUnauthorized, rr.Code)
+		assert.Contains(t, rr.Body.String(), "invalid_response")
+	})
+
+	t.Run("Assertion not yet valid", func(t *testing.T) {
+		rr := samlLogin(t, testRouter, alice, func(assertion *saml.Assertion) {
+			assertion.Conditions.NotBefore = time.Now().Add(time.Hour)
+		})
+		assert.Equal(t, http.StatusUnauthorized, rr.Code)
+	})
+
+	t.Run("Response to another login", func(t *testing.T) {
+		firstURL, _ := startSAMLLogin(t, testRouter, "")
+		_, secondCookie := startSAMLLogin(t, testRouter, "")
+		form := testSAMLIdP.respond(t, firstURL, alice, nil)
+		form.Set("RelayState", secondCookie.Value)
+		rr := postSAMLResponse(testRouter, form, secondCookie)
+		assert.Equal(t, http.StatusUnauthorized, rr.Code)
+		assert.Contains(t, rr.Body.String(), "invalid_response")
+	})
+
+	t.Run("Response without the state cookie or replayed", func(t *testing.T) {
+		authURL, stateCookie := startSAMLLogin(t, testRouter, "")
+		form := testSAMLIdP.respond(t, authURL, alice, nil)
+		rr := postSAMLResponse(testRouter, form, nil)
+		assert.Equal(t, http.StatusUnauthorized, rr.Code)
+		assert.Contains(t, rr.Body.String(), "invalid_state")
+
+		rr = postSAMLResponse(testRouter, form, stateCookie)
+		assert.Equal(t, http.StatusOK, rr.Code)
+		rr = postSAMLResponse(testRouter, form, stateCookie)
+		assert.Equal(t, http.StatusUnauthorized, rr.Code)
+		assert.Contains(t, rr.Body.String(), "invalid_state")
+	})
+
+	t.Run("Email outside the domains of the tenant", func(t *testing.T) {
+		outsider := samlUser{NameID: "acme-0002", Email: "mallory@example.org", DisplayName: "Mallory"}
+		rr := samlLogin(t, testRouter, outsider, nil)
+		assert.Equal(t, http.StatusForbidden, rr.Code)
+		assert.Contains(t, rr.Body.String(), "email_not_verified")
+		_, err := usermodel.FindUserByEmail(outsider.Email)
+		assert.Error(t, err)
+	})
+
+	t.Run("Cookie session", func(t *testing.T) {
+		authURL, stateCookie := startSAMLLogin(t, testRouter, "?cookies=true")
+		rr := postSAMLResponse(testRouter, testSAMLIdP.respond(t, authURL, alice, nil), stateCookie)
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.NotNil(t, findCookie(rr.Result().Cookies(), authMiddleware.RefreshTokenCookie))
+		assert.NotContains(t, rr.Body.String(), "refreshtoken")
+	})
+}
@@ -0,0 +1,367 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"testing"
+
+	"github.com/go-auth-microservice/pkg/controller"
+	usermodel "github.com/go-auth-microservice/pkg/model/userModel"
+	"github.com/go-auth-microservice/pkg/utils/scim"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	testSCIMToken      = "acme-scim-token-0123456789abcdefghijklmnop"
+	testSCIMOtherToken = "globex-scim-token-0123456789abcdefghijklmno"
+	testSCIMAdminGroup = "Auth Admins"
+)
+
+// writeSCIMTenants writes the SCIM_TENANTS file, the tokens are taken from
+// the environment
+func writeSCIMTenants(path string) error {
+	tenants := []map[string]interface{}{
+		{"name": "acme", "token": "${ACME_SCIM_TOKEN}", "groupRoles": []map[string]string{{"group": testSCIMAdminGroup, "role": usermodel.RoleAdmin}}},
+		{"name": "globex", "token": "${GLOBEX_SCIM_TOKEN}"},
+	}
+	content, err := json.Marshal(tenants)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, content, 0600)
+}
+
+// scimRequest sends a SCIM request authenticated with the bearer token of a tenant
+func scimRequest(router http.Handler, method string, path string, token string, body interface{}) *httptest.ResponseRecorder {
+	var bodyBytes []byte
+	if body != nil {
+		bodyBytes, _ = json.Marshal(body)
+	}
+	req, _ := http.NewRequest(method, "/api/v1/scim/v2"+path, bytes.NewBuffer(bodyBytes))
+	req.Header.Set("Content-Type", scim.ContentType)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	return rr
+}
+
+// createSCIMUser provisions a user through the tenant of the token
+func createSCIMUser(t *testing.T, router http.Handler, token string, userName string) scim.User {
+	rr := scimRequest(router, "POST", "/Users", token, map[string]interface{}{
+		"schemas":     []string{scim.SchemaUser},
+		"userName":    userName,
+		"externalId":  "ext-" + userName,
+		"name":        map[string]string{"givenName": "Test", "familyName": "User"},
+		"displayName": "Test User",
+		"active":      true,
+	})
+	assert.Equal(t, http.StatusCreated, rr.Code)
+	var user scim.User
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &user))
+	return user
+}
+
+func scimList(t *testing.T, router http.Handler, path string, token string) scim.ListResponse {
+	rr := scimRequest(router, "GET", path, token, nil)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var list scim.ListResponse
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &list))
+	return list
+}
+
+func scimPatch(operations ...scim.PatchOperation) scim.PatchRequest {
+	return scim.PatchRequest{Schemas: []string{scim.SchemaPatchOp}, Operations: operations}
+}
+
+func TestSCIMProvisioning(t *testing.T) {
+	testRouter := setupTestRouter()
+	testRouter.Post("/api/v1/auth/reactivate", controller.Reactivate)
+	protectedRouter := setupProtectedTestRouter()
+
+	t.Run("Bearer token of a tenant is required", func(t *testing.T) {
+		rr := scimRequest(testRouter, "GET", "/Users", "", nil)
+		assert.Equal(t, http.StatusUnauthorized, rr.Code)
+		assert.Contains(t, rr.Body.String(), scim.SchemaError)
+		rr = scimRequest(testRouter, "GET", "/Users", "wrong-token-0123456789abcdefghijklmnop", nil)
+		assert.Equal(t, http.StatusUnauthorized, rr.Code)
+
+		rr = scimRequest(testRouter, "GET", "/ServiceProviderConfig", testSCIMToken, nil)
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.Equal(t, scim.ContentType, rr.Header().Get("Content-Type"))
+	})
+
+	t.Run("Create user", func(t *testing.T) {
+		user := createSCIMUser(t, testRouter, testSCIMToken, "dana@acme.example.com")
+		assert.NotEmpty(t, user.Id)
+		assert.Equal(t, "dana@acme.example.com", user.UserName)
+		assert.Equal(t, "ext-dana@acme.example.com", user.ExternalId)
+		if assert.NotNil(t, user.Active) {
+			assert.True(t, bool(*user.Active))
+		}
+		assert.Equal(t, "http://localhost:8080/api/v1/scim/v2/Users/"+user.Id, user.Meta.Location)
+
+		stored, err := usermodel.FindUserByEmail("dana@acme.example.com")
+		assert.NoError(t, err)
+		assert.Equal(t, "Test", stored.GivenName)
+		assert.Equal(t, "Test User", stored.DisplayName)
+		assert.NotNil(t, stored.EmailVerifiedAt)
+		assert.False(t, stored.HasPassword())
+
+		rr := scimRequest(testRouter, "POST", "/Users", testSCIMToken, map[string]interface{}{"userName": "dana@acme.example.com"})
+		assert.Equal(t, http.StatusConflict, rr.Code)
+		assert.Contains(t, rr.Body.String(), scim.ErrorUniqueness)
+
+		rr = scimRequest(testRouter, "POST", "/Users", testSCIMToken, map[string]interface{}{"userName": "not an email"})
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+	})
+
+	t.Run("Existing local users are not taken over", func(t *testing.T) {
+		local := TestUser{Email: "local-scim@example.com", Password: "Str0ng!Passw0rd"}
+		assert.Equal(t, http.StatusOK, signupTestUser(testRouter, local).Code)
+		rr := scimRequest(testRouter, "POST", "/Users", testSCIMToken, map[string]interface{}{"userName": local.Email})
+		assert.Equal(t, http.StatusConflict, rr.Code)
+	})
+
+	t.Run("Filter and paginate users", func(t *testing.T) {
+		for _, userName := range []string{"page1@acme.example.com", "page2@acme.example.com", "page3@acme.example.com"} {
+			createSCIMUser(t, testRouter, testSCIMToken, userName)
+		}
+		list := scimList(t, testRouter, "/Users?filter="+url.QueryEscape(`userName eq "PAGE2@acme.example.com"`), testSCIMToken)
+		assert.Equal(t, 1, list.TotalResults)
+		if assert.Len(t, list.Resources, 1) {
+			assert.Equal(t, "page2@acme.example.com", list.Resources[0]["userName"])
+		}
+
+		list = scimList(t, testRouter, "/Users?filter="+url.QueryEscape(`userName sw "page" and active eq true`)+"&startIndex=2&count=1", testSCIMToken)
+		assert.Equal(t, 3, list.TotalResults)
+		assert.Equal(t, 2, list.StartIndex)
+		assert.Equal(t, 1, list.ItemsPerPage)
+		if assert.Len(t, list.Resources, 1) {
+			assert.Equal(t, "page2@acme.example.com", list.Resources[0]["userName"])
+		}
+
+		list = scimList(t, testRouter, "/Users?filter="+url.QueryEscape(`emails[type eq "work" and value co "page3"]`), testSCIMToken)
+		assert.Equal(t, 1, list.TotalResults)
+
+		list = scimList(t, testRouter, "/Users?filter="+url.QueryEscape(`externalId eq "ext-page3@acme.example.com"`), testSCIMToken)
+		assert.Equal(t, 1, list.TotalResults)
+
+		list = scimList(t, testRouter, "/Users?startIndex=2&count=1", testSCIMToken)
+		assert.Equal(t, 4, list.TotalResults)
+		assert.Equal(t, 1, list.ItemsPerPage)
+		if assert.Len(t, list.Resources, 1) {
+			assert.Equal(t, "page1@acme.example.com", list.Resources[0]["userName"])
+		}
+		list = scimList(t, testRouter, "/Users?count=0", testSCIMToken)
+		assert.Equal(t, 4, list.TotalResults)
+		assert.Empty(t, list.Resources)
+		list = scimList(t, testRouter, "/Users?startIndex=10", testSCIMToken)
+		assert.Equal(t, 4, list.TotalResults)
+		assert.Empty(t, list.Resources)
+
+		rr := scimRequest(testRouter, "GET", "/Users?filter="+url.QueryEscape(`userName eq`), testSCIMToken, nil)
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+		assert.Contains(t, rr.Body.String(), scim.ErrorInvalidFilter)
+	})
+
+	t.Run("Tenants only see their own users", func(t *testing.T) {
+		user := createSCIMUser(t, testRouter, testSCIMToken, "isolated@acme.example.com")
+		list := scimList(t, testRouter, "/Users?filter="+url.QueryEscape(`userName eq "isolated@acme.example.com"`), testSCIMOtherToken)
+		assert.Equal(t, 0, list.TotalResults)
+		rr := scimRequest(testRouter, "GET", "/Users/"+user.Id, testSCIMOtherToken, nil)
+		assert.Equal(t, http.StatusNotFound, rr.Code)
+		rr = scimRequest(testRouter, "DELETE", "/Users/"+user.Id, testSCIMOtherToken, nil)
+		assert.Equal(t, http.StatusNotFound, rr.Code)
+	})
+
+	t.Run("Replace and patch user", func(t *testing.T) {
+		user := createSCIMUser(t, testRouter, testSCIMToken, "erin@acme.example.com")
+		rr := scimRequest(testRouter, "PUT", "/Users/"+user.Id, testSCIMToken, map[string]interface{}{
+			"schemas":     []string{scim.SchemaUser},
+			"userName":    "erin@acme.example.com",
+			"displayName": "Erin Example",
+			"locale":      "de-DE",
+		})
+		assert.Equal(t, http.StatusOK, rr.Code)
+		stored, err := usermodel.FindUserByEmail("erin@acme.example.com")
+		assert.NoError(t, err)
+		assert.Equal(t, "Erin Example", stored.DisplayName)
+		assert.Equal(t, "", stored.GivenName)
+		assert.Equal(t, "de-DE", stored.Locale)
+
+		// the path and value forms of identity providers
+		rr = scimRequest(testRouter, "PATCH", "/Users/"+user.Id, testSCIMToken, scimPatch(
+			scim.PatchOperation{Op: "Replace", Path: "name.familyName", Value: "Example"},
+			scim.PatchOperation{Op: "replace", Path: `emails[type eq "work"].value`, Value: "erin.example@acme.example.com"},
+			scim.PatchOperation{Op: "replace", Path: "userName", Value: "erin.example@acme.example.com"},
+			scim.PatchOperation{Op: "add", Value: map[string]interface{}{"displayName": "Erin E."}},
+		))
+		assert.Equal(t, http.StatusOK, rr.Code)
+		var patched scim.User
+		assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &patched))
+		assert.Equal(t, "erin.example@acme.example.com", patched.UserName)
+		assert.Equal(t, "Erin E.", patched.DisplayName)
+		if assert.NotNil(t, patched.Name) {
+			assert.Equal(t, "Example", patched.Name.FamilyName)
+		}
+
+		rr = scimRequest(testRouter, "PATCH", "/Users/"+user.Id, testSCIMToken, scimPatch(
+			scim.PatchOperation{Op: "replace", Path: `emails[type eq "home"].value`, Value: "x@example.com"},
+		))
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+		assert.Contains(t, rr.Body.String(), scim.ErrorNoTarget)
+
+		rr = scimRequest(testRouter, "PATCH", "/Users/"+user.Id, testSCIMToken, scimPatch(
+			scim.PatchOperation{Op: "replace", Path: "userName", Value: "dana@acme.example.com"},
+		))
+		assert.Equal(t, http.StatusConflict, rr.Code)
+	})
+
+	t.Run("Groups map onto roles", func(t *testing.T) {
+		frank := createSCIMUser(t, testRouter, testSCIMToken, "frank@acme.example.com")
+		grace := createSCIMUser(t, testRouter, testSCIMToken, "grace@acme.example.com")
+		other := createSCIMUser(t, testRouter, testSCIMOtherToken, "heidi@globex.example.com")
+
+		rr := scimRequest(testRouter, "POST", "/Groups", testSCIMToken, map[string]interface{}{
+			"schemas":     []string{scim.SchemaGroup},
+			"displayName": testSCIMAdminGroup,
+			"members":     []map[string]string{{"value": frank.Id}},
+		})
+		assert.Equal(t, http.StatusCreated, rr.Code)
+		var group scim.Group
+		assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &group))
+		assertRole := func(email string, role string) {
+			stored, err := usermodel.FindUserByEmail(email)
+			assert.NoError(t, err)
+			assert.Equal(t, role, stored.Role)
+		}
+		assertRole("frank@acme.example.com", usermodel.RoleAdmin)
+		assertRole("grace@acme.example.com", usermodel.RoleUser)
+
+		rr = scimRequest(testRouter, "GET", "/Users/"+frank.Id, testSCIMToken, nil)
+		var withGroups scim.User
+		assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &withGroups))
+		if assert.Len(t, withGroups.Groups, 1) {
+			assert.Equal(t, testSCIMAdminGroup, withGroups.Groups[0].Display)
+		}
+		stored, err := usermodel.FindUserByEmail("frank@acme.example.com")
+		assert.NoError(t, err)
+		export, err := stored.Export()
+		assert.NoError(t, err)
+		if assert.Len(t, export.SCIMLinks, 1) {
+			assert.Equal(t, "acme", export.SCIMLinks[0].Tenant)
+			assert.Equal(t, "ext-frank@acme.example.com", export.SCIMLinks[0].ExternalId)
+		}
+		assert.Equal(t, []usermodel.SCIMGroupMembership{{Tenant: "acme", DisplayName: testSCIMAdminGroup}}, export.SCIMGroups)
+
+		// Okta adds members with a list, Entra ID removes them with a filter
+		rr = scimRequest(testRouter, "PATCH", "/Groups/"+group.Id, testSCIMToken, scimPatch(
+			scim.PatchOperation{Op: "add", Path: "members", Value: []map[string]string{{"value": grace.Id}}},
+			scim.PatchOperation{Op: "remove", Path: `members[value eq "` + frank.Id + `"]`},
+		))
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assertRole("frank@acme.example.com", usermodel.RoleUser)
+		assertRole("grace@acme.example.com", usermodel.RoleAdmin)
+
+		rr = scimRequest(testRouter, "PATCH", "/Groups/"+group.Id, testSCIMToken, scimPatch(
+			scim.PatchOperation{Op: "add", Path: "members", Value: []map[string]string{{"value": other.Id}}},
+		))
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+		list := scimList(t, testRouter, "/Groups?excludedAttributes=members&filter="+url.QueryEscape(`displayName eq "auth admins"`), testSCIMToken)
+		if assert.Len(t, list.Resources, 1) {
+			assert.NotContains(t, list.Resources[0], "members")
+		}
+		assert.Equal(t, 0, scimList(t, testRouter, "/Groups", testSCIMOtherToken).TotalResults)
+
+		rr = scimRequest(testRouter, "POST", "/Groups", testSCIMToken, map[string]interface{}{"displayName": testSCIMAdminGroup})
+		assert.Equal(t, http.StatusConflict, rr.Code)
+
+		rr = scimRequest(testRouter, "DELETE", "/Groups/"+group.Id, testSCIMToken, nil)
+		assert.Equal(t, http.StatusNoContent, rr.Code)
+		assertRole("grace@acme.example.com", usermodel.RoleUser)
+	})
+
+	t.Run("Deprovisioning disables the user and revokes their tokens", func(t *testing.T) {
+		user := createSCIMUser(t, testRouter, testSCIMToken, "ivan@acme.example.com")
+		// provisioned users log in through the identity provider of the tenant
+		rr := samlLogin(t, testRouter, samlUser{NameID: "acme-ivan", Email: "ivan@acme.example.com", DisplayName: "Ivan"}, nil)
+		assert.Equal(t, http.StatusOK, rr.Code)
+		var tokens TestResponse
+		assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &tokens))
+		assert.Equal(t, http.StatusOK, protectedRequest(protectedRouter, "GET", "/api/v1/me", tokens.AccessToken, nil).Code)
+
+		rr = scimRequest(testRouter, "PATCH", "/Users/"+user.Id, testSCIMToken, scimPatch(
+			scim.PatchOperation{Op: "replace", Value: map[string]interface{}{"active": "False"}},
+		))
+		assert.Equal(t, http.StatusOK, rr.Code)
+		var patched scim.User
+		assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &patched))
+		if assert.NotNil(t, patched.Active) {
+			assert.False(t, bool(*patched.Active))
+		}
+		stored, err := usermodel.FindUserByEmail("ivan@acme.example.com")
+		assert.NoError(t, err)
+		assert.Equal(t, usermodel.StatusSuspended, stored.Status)
+
+		assert.Equal(t, http.StatusUnauthorized, protectedRequest(protectedRouter, "GET", "/api/v1/me", tokens.AccessToken, nil).Code)
+		req, _ := http.NewRequest("GET", "/api/v1/auth/token", nil)
+		req.Header.Set("RefreshToken", tokens.RefreshToken)
+		refresh := httptest.NewRecorder()
+		testRouter.ServeHTTP(refresh, req)
+		assert.NotEqual(t, http.StatusOK, refresh.Code)
+		rr = samlLogin(t, testRouter, samlUser{NameID: "acme-ivan", Email: "ivan@acme.example.com", DisplayName: "Ivan"}, nil)
+		assert.NotEqual(t, http.StatusOK, rr.Code)
+
+		rr = scimRequest(testRouter, "PATCH", "/Users/"+user.Id, testSCIMToken, scimPatch(
+			scim.PatchOperation{Op: "replace", Path: "active", Value: true},
+		))
+		assert.Equal(t, http.StatusOK, rr.Code)
+		rr = samlLogin(t, testRouter, samlUser{NameID: "acme-ivan", Email: "ivan@acme.example.com", DisplayName: "Ivan"}, nil)
+		assert.Equal(t, http.StatusOK, rr.Code)
+	})
+
+	t.Run("Delete user", func(t *testing.T) {
+		user := createSCIMUser(t, testRouter, testSCIMToken, "judy@acme.example.com")
+		stored, err := usermodel.FindUserByEmail("judy@acme.example.com")
+		assert.NoError(t, err)
+		assert.NoError(t, stored.SetPassword(context.Background(), "Str0ng!Passw0rd"))
+		stored.Role = usermodel.RoleAdmin
+		assert.NoError(t, stored.Save())
+		password := stored.Password
+		rr := scimRequest(testRouter, "DELETE", "/Users/"+user.Id, testSCIMToken, nil)
+		assert.Equal(t, http.StatusNoContent, rr.Code)
+		rr = scimRequest(testRouter, "GET", "/Users/"+user.Id, testSCIMToken, nil)
+		assert.Equal(t, http.StatusNotFound, rr.Code)
+		stored, err = usermodel.FindUserByEmail("judy@acme.example.com")
+		assert.NoError(t, err)
+		assert.Equal(t, usermodel.StatusDeleted, stored.Status)
+		assert.NotNil(t, stored.DeletionScheduledAt)
+		rr = protectedRequest(testRouter, "POST", "/api/v1/auth/reactivate", "", TestUser{Email: "judy@acme.example.com", Password: "Str0ng!Passw0rd"})
+		assert.Equal(t, http.StatusForbidden, rr.Code, "Deprovisioning should not be undone by the user")
+		assert.Contains(t, rr.Body.String(), "account_deleted")
+
+		// provisioning the user again within the grace period restores it
+		restored := createSCIMUser(t, testRouter, testSCIMToken, "judy@acme.example.com")
+		assert.Equal(t, user.Id, restored.Id)
+		stored, err = usermodel.FindUserByEmail("judy@acme.example.com")
+		assert.NoError(t, err)
+		assert.Equal(t, usermodel.StatusActive, stored.Status)
+		assert.Nil(t, stored.DeletionScheduledAt)
+		assert.Equal(t, password, stored.Password)
+		assert.Equal(t, usermodel.RoleAdmin, stored.Role)
+		assert.Equal(t, "Test User", stored.DisplayName)
+
+		rr = scimRequest(testRouter, "DELETE", "/Users/"+user.Id, testSCIMToken, nil)
+		assert.Equal(t, http.StatusNoContent, rr.Code)
+		rr = scimRequest(testRouter, "POST", "/Users", testSCIMOtherToken, map[string]interface{}{"userName": "judy@acme.example.com"})
+		assert.Equal(t, http.StatusConflict, rr.Code)
+	})
+}
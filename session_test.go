@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	authMiddleware "github.com/go-auth-microservice/pkg/middleware/auth"
+	"github.com/stretchr/testify/assert"
+)
+
+// cookieRequest sends a request authenticated by the cookies of a browser session
+func cookieRequest(router http.Handler, method string, path string, cookies []*http.Cookie, csrfToken string, body interface{}) *httptest.ResponseRecorder {
+	var bodyBytes []byte
+	if body != nil {
+		bodyBytes, _ = json.Marshal(body)
+	}
+	req, _ := http.NewRequest(method, path, bytes.NewBuffer(bodyBytes))
+	req.Header.Set("Content-Type", "application/json")
+	for _, cookie := range cookies {
+		req.AddCookie(cookie)
+	}
+	if csrfToken != "" {
+		req.Header.Set(authMiddleware.CSRFTokenHeader, csrfToken)
+	}
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	return rr
+}
+
+func findCookie(cookies []*http.Cookie, name string) *http.Cookie {
+	for _, cookie := range cookies {
+		if cookie.Name == name {
+			return cookie
+		}
+	}
+	return nil
+}
+
+// TestCookieSession tests the cookie mode of browser sessions
+func TestCookieSession(t *testing.T) {
+	testRouter := setupTestRouter()
+	protectedRouter := setupProtectedTestRouter()
+
+	user := TestUser{Email: "browser@example.com", Password: "password123"}
+	assert.Equal(t, http.StatusOK, signupTestUser(testRouter, user).Code)
+
+	body, _ := json.Marshal(map[string]interface{}{"email": user.Email, "password": user.Password, "cookies": true})
+	req, _ := http.NewRequest("POST", "/api/v1/auth/login", bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+	rr := httptest.NewRecorder()
+	testRouter.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var res map[string]interface{}
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &res))
+	cookies := rr.Result().Cookies()
+	csrfToken, _ := res["csrftoken"].(string)
+
+	t.Run("Tokens are set in cookies", func(t *testing.T) {
+		assert.NotContains(t, res, "accesstoken")
+		assert.NotContains(t, res, "refreshtoken")
+		assert.NotEmpty(t, csrfToken)
+		for _, name := range []string{authMiddleware.AccessTokenCookie, authMiddleware.RefreshTokenCookie} {
+			cookie := findCookie(cookies, name)
+			if assert.NotNil(t, cookie, name) {
+				assert.True(t, cookie.HttpOnly)
+				assert.True(t, cookie.Secure)
+				assert.Equal(t, http.SameSiteStrictMode, cookie.SameSite)
+				assert.False(t, cookie.Expires.IsZero())
+			}
+		}
+		csrfCookie := findCookie(cookies, authMiddleware.CSRFTokenCookie)
+		if assert.NotNil(t, csrfCookie) {
+			assert.False(t, csrfCookie.HttpOnly)
+			assert.Equal(t, csrfToken, csrfCookie.Value)
+		}
+	})
+
+	t.Run("Authenticate with cookies", func(t *testing.T) {
+		rr := cookieRequest(protectedRouter, "GET", "/api/v1/user", cookies, "", nil)
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.Contains(t, rr.Body.String(), user.Email)
+	})
+
+	t.Run("State changing requests require the CSRF token", func(t *testing.T) {
+		reauth := map[string]string{"password": user.Password}
+		assert.Equal(t, http.StatusForbidden, cookieRequest(protectedRouter, "POST", "/api/v1/reauthenticate", cookies, "", reauth).Code)
+		assert.Equal(t, http.StatusForbidden, cookieRequest(protectedRouter, "POST", "/api/v1/reauthenticate", cookies, "forged", reauth).Code)
+		rr := cookieRequest(protectedRouter, "POST", "/api/v1/reauthenticate", cookies, csrfToken, reauth)
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.NotContains(t, rr.Body.String(), "refreshtoken")
+		assert.NotNil(t, findCookie(rr.Result().Cookies(), authMiddleware.RefreshTokenCookie))
+	})
+
+	t.Run("Refresh from the cookie", func(t *testing.T) {
+		refreshCookie := findCookie(cookies, authMiddleware.RefreshTokenCookie)
+		rr := cookieRequest(testRouter, "GET", "/api/v1/auth/token", []*http.Cookie{refreshCookie}, "", nil)
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.NotContains(t, rr.Body.String(), "accesstoken")
+		accessCookie := findCookie(rr.Result().Cookies(), authMiddleware.AccessTokenCookie)
+		if assert.NotNil(t, accessCookie) {
+			session := []*http.Cookie{accessCookie, refreshCookie}
+			assert.Equal(t, http.StatusOK, cookieRequest(protectedRouter, "GET", "/api/v1/me", session, "", nil).Code)
+		}
+	})
+
+	t.Run("Header tokens do not need the CSRF token", func(t *testing.T) {
+		tokens := loginTokens(t, testRouter, user)
+		assert.NotEmpty(t, tokens.RefreshToken)
+		rr := protectedRequest(protectedRouter, "POST", "/api/v1/reauthenticate", tokens.AccessToken, map[string]string{"password": user.Password})
+		assert.Equal(t, http.StatusOK, rr.Code)
+	})
+
+	t.Run("Logout", func(t *testing.T) {
+		assert.Equal(t, http.StatusForbidden, cookieRequest(testRouter, "POST", "/api/v1/auth/logout", cookies, "", nil).Code)
+		rr := cookieRequest(testRouter, "POST", "/api/v1/auth/logout", cookies, csrfToken, nil)
+		assert.Equal(t, http.StatusNoContent, rr.Code)
+		for _, name := range []string{authMiddleware.AccessTokenCookie, authMiddleware.RefreshTokenCookie, authMiddleware.CSRFTokenCookie} {
+			cookie := findCookie(rr.Result().Cookies(), name)
+			if assert.NotNil(t, cookie, name) {
+				assert.Empty(t, cookie.Value)
+				assert.Less(t, cookie.MaxAge, 0)
+			}
+		}
+		assert.Equal(t, http.StatusUnauthorized, cookieRequest(protectedRouter, "GET", "/api/v1/user", cookies, "", nil).Code)
+		assert.Equal(t, http.StatusUnauthorized, cookieRequest(testRouter, "GET", "/api/v1/auth/token", cookies, "", nil).Code, "Refresh token should be blacklisted")
+	})
+
+	t.Run("Concurrent logouts and refreshes", func(t *testing.T) {
+		sessions := make([]TestResponse, 8)
+		for i := range sessions {
+			sessions[i] = loginTokens(t, testRouter, user)
+		}
+		refresh := func(session TestResponse) int {
+			req, _ := http.NewRequest("GET", "/api/v1/auth/token", nil)
+			req.Header.Set("RefreshToken", session.RefreshToken)
+			rr := httptest.NewRecorder()
+			testRouter.ServeHTTP(rr, req)
+			return rr.Code
+		}
+		codes := make([]int, len(sessions))
+		var wg sync.WaitGroup
+		for i, session := range sessions {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for range 5 {
+					refresh(session)
+				}
+				req, _ := http.NewRequest("POST", "/api/v1/auth/logout", nil)
+				req.Header.Set("Authorization", session.AccessToken)
+				req.Header.Set("RefreshToken", session.RefreshToken)
+				testRouter.ServeHTTP(httptest.NewRecorder(), req)
+				codes[i] = refresh(session)
+			}()
+		}
+		wg.Wait()
+		for _, code := range codes {
+			assert.Equal(t, http.StatusUnauthorized, code)
+		}
+	})
+}